@@ -25,7 +25,8 @@ import { useUserID } from '@/hooks/use-user-id';
 // ── Types ─────────────────────────────────────────────────────────────────────
 
 type TaskStatus = 'pending' | 'in_progress' | 'done';
-type TaskPriority = 'low' | 'medium' | 'high';
+// 0 = low, 1 = medium, 2 = high, 3 = urgent
+type TaskPriority = 0 | 1 | 2 | 3;
 
 type Task = {
   id: number;
@@ -245,18 +246,19 @@ function TaskCard({
 
 // ── Sub-components ────────────────────────────────────────────────────────────
 
-const PRIORITY_COLORS: Record<TaskPriority, { bg: string; text: string }> = {
-  low:    { bg: '#DCFCE7', text: '#166534' },
-  medium: { bg: '#FEF9C3', text: '#713F12' },
-  high:   { bg: '#FEE2E2', text: '#991B1B' },
+const PRIORITY_STYLES: Record<TaskPriority, { label: string; bg: string; text: string }> = {
+  0: { label: 'LOW',    bg: '#DCFCE7', text: '#166534' },
+  1: { label: 'MEDIUM', bg: '#FEF9C3', text: '#713F12' },
+  2: { label: 'HIGH',   bg: '#FEE2E2', text: '#991B1B' },
+  3: { label: 'URGENT', bg: '#FECACA', text: '#7F1D1D' },
 };
 
 function PriorityBadge({ priority }: { priority: TaskPriority }) {
-  const colors = PRIORITY_COLORS[priority] ?? PRIORITY_COLORS.medium;
+  const style = PRIORITY_STYLES[priority] ?? PRIORITY_STYLES[1];
   return (
-    <View style={[styles.badge, { backgroundColor: colors.bg }]}>
-      <Text style={[styles.badgeText, { color: colors.text }]}>
-        {priority.toUpperCase()}
+    <View style={[styles.badge, { backgroundColor: style.bg }]}>
+      <Text style={[styles.badgeText, { color: style.text }]}>
+        {style.label}
       </Text>
     </View>
   );
@@ -2,15 +2,53 @@ CREATE TABLE IF NOT EXISTS tasks (
     id SERIAL PRIMARY KEY,
     title VARCHAR(255) NOT NULL,
     description TEXT,
-    priority VARCHAR(20) DEFAULT 'medium',
+    -- priority: 0 = low, 1 = medium, 2 = high, 3 = urgent.
+    priority SMALLINT NOT NULL DEFAULT 1 CHECK (priority BETWEEN 0 AND 3),
     -- status lifecycle: pending → in_progress → done
     status VARCHAR(50) DEFAULT 'pending',
+    -- recurrence: NULL for one-off tasks, else 'daily' | 'weekly' | 'monthly'.
+    recurrence VARCHAR(20),
+    due_at TIMESTAMP WITH TIME ZONE,
+    -- idempotency_key: client-supplied key (Idempotency-Key header) that makes
+    -- task creation safe to retry; unique per user when set.
+    idempotency_key VARCHAR(255),
     -- user_id ties each task to the device-generated UUID of its owner.
     -- 'admin' is reserved for system-level tasks.
     user_id VARCHAR(255) NOT NULL DEFAULT 'default',
     created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
 );
 
+-- Columns added after the initial schema; keep existing databases in step.
+ALTER TABLE tasks ADD COLUMN IF NOT EXISTS recurrence VARCHAR(20);
+ALTER TABLE tasks ADD COLUMN IF NOT EXISTS due_at TIMESTAMP WITH TIME ZONE;
+ALTER TABLE tasks ADD COLUMN IF NOT EXISTS idempotency_key VARCHAR(255);
+
+-- priority used to be VARCHAR ('low' | 'medium' | 'high'); convert it in
+-- place on databases created before the switch to integers.
+DO $$
+BEGIN
+    IF EXISTS (
+        SELECT 1 FROM information_schema.columns
+        WHERE table_name = 'tasks' AND column_name = 'priority'
+          AND data_type = 'character varying'
+    ) THEN
+        ALTER TABLE tasks ALTER COLUMN priority DROP DEFAULT;
+        ALTER TABLE tasks ALTER COLUMN priority TYPE SMALLINT USING
+            CASE lower(priority)
+                WHEN 'low'    THEN 0
+                WHEN 'high'   THEN 2
+                WHEN 'urgent' THEN 3
+                ELSE 1
+            END;
+        ALTER TABLE tasks ALTER COLUMN priority SET DEFAULT 1;
+        ALTER TABLE tasks ALTER COLUMN priority SET NOT NULL;
+        ALTER TABLE tasks ADD CONSTRAINT tasks_priority_check CHECK (priority BETWEEN 0 AND 3);
+    END IF;
+END $$;
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_tasks_idempotency_key
+    ON tasks (user_id, idempotency_key) WHERE idempotency_key IS NOT NULL;
+
 -- Index for the common per-user list query (GET /api/v1/tasks?user_id=...)
 CREATE INDEX IF NOT EXISTS idx_tasks_user_id ON tasks (user_id);
 
@@ -19,4 +57,59 @@ CREATE TABLE IF NOT EXISTS chat_history (
     role VARCHAR(50) NOT NULL, -- 'user', 'assistant', or 'system'
     content TEXT NOT NULL,
     created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
-);
\ No newline at end of file
+);
+
+-- Server-side chat history. A conversation groups the turns exchanged by one
+-- user; messages are appended by POST /api/v1/chat as each turn completes.
+CREATE TABLE IF NOT EXISTS conversations (
+    id SERIAL PRIMARY KEY,
+    user_id VARCHAR(255) NOT NULL,
+    title VARCHAR(255) NOT NULL DEFAULT '',
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Index for GET /api/v1/conversations?user_id=... (newest activity first)
+CREATE INDEX IF NOT EXISTS idx_conversations_user_id ON conversations (user_id, updated_at DESC);
+
+CREATE TABLE IF NOT EXISTS messages (
+    id SERIAL PRIMARY KEY,
+    conversation_id INTEGER NOT NULL REFERENCES conversations (id) ON DELETE CASCADE,
+    role VARCHAR(50) NOT NULL, -- 'user' or 'assistant'
+    content TEXT NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_messages_conversation_id ON messages (conversation_id);
+
+-- One row per ingest (POST /api/v1/documents, admin document updates). The
+-- chunks live in Qdrant; this is the authoritative list of what was ingested,
+-- by whom, and when, so listing documents doesn't scroll the collection.
+CREATE TABLE IF NOT EXISTS documents (
+    id SERIAL PRIMARY KEY,
+    source VARCHAR(255) NOT NULL,
+    user_id VARCHAR(255) NOT NULL,
+    chunk_count INTEGER NOT NULL DEFAULT 0,
+    byte_size INTEGER NOT NULL DEFAULT 0,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Index for GET /api/v1/documents?user_id=... (newest first)
+CREATE INDEX IF NOT EXISTS idx_documents_user_id ON documents (user_id, created_at DESC);
+
+-- The ingested text and its citation fields, kept so a document can be
+-- re-chunked (POST /api/v1/documents/{source}/rechunk) without the caller
+-- uploading it again. NULL content for rows recorded before it was stored.
+ALTER TABLE documents ADD COLUMN IF NOT EXISTS content TEXT;
+ALTER TABLE documents ADD COLUMN IF NOT EXISTS title VARCHAR(255) NOT NULL DEFAULT '';
+ALTER TABLE documents ADD COLUMN IF NOT EXISTS url TEXT NOT NULL DEFAULT '';
+
+-- Chunk text for deployments with RAG_CHUNK_STORE=postgres, which keeps only
+-- a (document_id, chunk_index) reference in each Qdrant payload to keep the
+-- vector store small. Rows go with their document.
+CREATE TABLE IF NOT EXISTS document_chunks (
+    document_id INTEGER NOT NULL REFERENCES documents(id) ON DELETE CASCADE,
+    chunk_index INTEGER NOT NULL,
+    text TEXT NOT NULL,
+    PRIMARY KEY (document_id, chunk_index)
+);
@@ -0,0 +1,346 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"core-go/internal/agent"
+	"core-go/internal/db"
+	"core-go/internal/llm"
+	"core-go/internal/vector"
+	"core-go/internal/vector/qdranttest"
+)
+
+// stallingEmbedder never answers until ctx ends, like a wedged Ollama.
+type stallingEmbedder struct{ llm.Embedder }
+
+func (stallingEmbedder) Embed(ctx context.Context, _ string) ([]float64, error) {
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+// newTestKB returns a KnowledgeBase on an in-memory Qdrant with the base
+// collection created.
+func newTestKB(t *testing.T, embedder llm.Embedder) (*agent.KnowledgeBase, *qdranttest.Server) {
+	t.Helper()
+	srv := qdranttest.NewServer()
+	t.Cleanup(srv.Close)
+	q := vector.NewQdrantClient(srv.URL)
+	dim, err := agent.CollectionDim()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := q.EnsureCollection(context.Background(), agent.CollectionName(), dim, vector.DistanceCosine); err != nil {
+		t.Fatal(err)
+	}
+	return agent.NewKnowledgeBase(q, embedder, llm.FakeChatProvider{}), srv
+}
+
+func TestFileIngesterTimeout(t *testing.T) {
+	const text = "The Roman Republic was governed by elected magistrates and a senate."
+	tests := []struct {
+		name       string
+		embedder   llm.Embedder
+		wantChunks int
+		wantErr    bool
+	}{
+		{"healthy embedder", llm.NewFakeEmbedder(), 1, false},
+		{"stalled embedder times out", stallingEmbedder{}, 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			kb, _ := newTestKB(t, tt.embedder)
+			fi := fileIngester{kb: kb, timeout: 50 * time.Millisecond}
+
+			start := time.Now()
+			chunks, err := fi.ingest(context.Background(), text, "rome.md")
+			if chunks != tt.wantChunks || (err != nil) != tt.wantErr {
+				t.Fatalf("ingest() = (%d, %v), want %d chunks, wantErr %v", chunks, err, tt.wantChunks, tt.wantErr)
+			}
+			if tt.wantErr {
+				if !errors.Is(err, context.DeadlineExceeded) || !strings.HasPrefix(err.Error(), "timed out") {
+					t.Errorf("ingest() err = %v, want a timed out deadline error", err)
+				}
+				if elapsed := time.Since(start); elapsed > time.Second {
+					t.Errorf("ingest() took %s, want it cut off by the per-file timeout", elapsed)
+				}
+			}
+		})
+	}
+}
+
+// memDocuments is an in-memory db.DocumentRepository covering what reindex
+// calls.
+type memDocuments struct {
+	db.DocumentRepository // unimplemented methods panic
+
+	mu   sync.Mutex
+	next db.DocumentID
+	rows map[db.DocumentID]db.NewDocument
+}
+
+func (m *memDocuments) RecordDocument(_ context.Context, d db.NewDocument) (db.DocumentID, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.rows == nil {
+		m.rows = map[db.DocumentID]db.NewDocument{}
+	}
+	m.next++
+	m.rows[m.next] = d
+	return m.next, nil
+}
+
+func (m *memDocuments) SetChunkCount(context.Context, db.DocumentID, int) error { return nil }
+
+func (m *memDocuments) DeleteDocument(_ context.Context, id db.DocumentID, _ string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.rows, id)
+	return nil
+}
+
+func (m *memDocuments) DeleteSourceExcept(_ context.Context, userID, source string, keep db.DocumentID) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var n int64
+	for id, d := range m.rows {
+		if id != keep && d.UserID == userID && d.Source == source {
+			delete(m.rows, id)
+			n++
+		}
+	}
+	return n, nil
+}
+
+// failingEmbedder fails every embed after the first ok.
+type failingEmbedder struct {
+	llm.Embedder
+	ok    int32
+	calls atomic.Int32
+}
+
+func (e *failingEmbedder) Embed(ctx context.Context, text string) ([]float64, error) {
+	if e.calls.Add(1) > e.ok {
+		return nil, errors.New("ollama down")
+	}
+	return e.Embedder.Embed(ctx, text)
+}
+
+func TestFileIngesterReindex(t *testing.T) {
+	const oldText = "Rome was founded on seven hills beside the river Tiber long ago."
+	// Long enough for two default-sized chunks.
+	newText := strings.Repeat("The Roman Republic was governed by elected magistrates and a senate. ", 8)
+	tests := []struct {
+		name      string
+		failAfter int32 // embeds that succeed during the reindex; -1 never fails
+		wantErr   bool
+	}{
+		{"success replaces the old version", -1, false},
+		{"failure part way keeps the old version", 1, true},
+		{"failure before any chunk keeps the old version", 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			emb := &failingEmbedder{Embedder: llm.NewFakeEmbedder(), ok: 1 << 20}
+			kb, srv := newTestKB(t, emb)
+			docs := &memDocuments{}
+
+			// The first version, as an ingest without -reindex stores it.
+			if _, err := (fileIngester{kb: kb, timeout: time.Minute}).ingest(context.Background(), oldText, "rome.md"); err != nil {
+				t.Fatal(err)
+			}
+			if tt.failAfter >= 0 {
+				emb.ok = emb.calls.Load() + tt.failAfter
+			}
+
+			fi := fileIngester{kb: kb, docs: docs, timeout: time.Minute}
+			chunks, err := fi.ingest(context.Background(), newText, "rome.md")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("reindex err = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			points := srv.Points(agent.CollectionName())
+			if tt.wantErr {
+				if chunks != 0 || len(docs.rows) != 0 {
+					t.Errorf("failed reindex reported %d chunk(s) and left %d row(s)", chunks, len(docs.rows))
+				}
+				if len(points) != 1 || points[0].Payload["text"] != oldText {
+					t.Errorf("stored %d point(s), want only the old chunk", len(points))
+				}
+				return
+			}
+
+			if chunks < 2 || len(points) != chunks {
+				t.Errorf("reindex stored %d chunk(s), %d point(s) remain", chunks, len(points))
+			}
+			if len(docs.rows) != 1 {
+				t.Errorf("documents rows = %d, want 1", len(docs.rows))
+			}
+			for _, p := range points {
+				if id, _ := p.Payload["document_id"].(float64); db.DocumentID(id) != docs.next {
+					t.Errorf("point %s has document_id %v, want %d", p.ID, p.Payload["document_id"], docs.next)
+				}
+			}
+		})
+	}
+}
+
+func TestFileIngesterDryRun(t *testing.T) {
+	files := map[string]string{
+		"rome.md":    strings.Repeat("The Roman Republic was governed by elected magistrates and a senate. ", 8),
+		"athens.txt": "Athens was the birthplace of democracy in the fifth century BC.",
+	}
+	dir := t.TempDir()
+	var want int
+	for name, text := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(text), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		want += len(agent.ChunkText(text))
+	}
+
+	tests := []struct {
+		name   string
+		dryRun bool
+	}{
+		{"dry run only chunks", true},
+		{"real run embeds and upserts", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			emb := &failingEmbedder{Embedder: llm.NewFakeEmbedder(), ok: 1 << 20}
+			kb, srv := newTestKB(t, emb)
+			fi := fileIngester{kb: kb, timeout: time.Minute, dryRun: tt.dryRun}
+
+			names, err := findTopicFiles(dir, false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			totals := ingestFiles(dir, names, 2, func(content, name string) (int, error) {
+				return fi.ingest(context.Background(), content, name)
+			})
+			if totals.files != len(files) || totals.chunks != want {
+				t.Errorf("totals = %+v, want %d files and %d chunks", totals, len(files), want)
+			}
+			embeds, upserts := int(emb.calls.Load()), srv.Upserts()
+			if tt.dryRun && (embeds != 0 || upserts != 0) {
+				t.Errorf("dry run made %d embed and %d upsert call(s)", embeds, upserts)
+			}
+			if !tt.dryRun && (embeds != want || upserts == 0) {
+				t.Errorf("real run made %d embed and %d upsert call(s), want %d embeds", embeds, upserts, want)
+			}
+		})
+	}
+}
+
+func TestFindTopicFiles(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{
+		"intro.md",
+		"notes.txt",
+		"image.png",
+		"history/rome.md",
+		"history/greece/athens.txt",
+		"history/greece/sparta.MD",
+		"science/readme.json",
+	} {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte("text"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name      string
+		recursive bool
+		want      []string
+	}{
+		{"top level only", false, []string{"intro.md", "notes.txt"}},
+		{"recursive uses paths relative to dir", true, []string{
+			"history/greece/athens.txt",
+			"history/greece/sparta.MD",
+			"history/rome.md",
+			"intro.md",
+			"notes.txt",
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := findTopicFiles(dir, tt.recursive)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("findTopicFiles() = %q, want %q", got, tt.want)
+			}
+			// Each entry must be readable through ingestFile's own join.
+			for _, name := range got {
+				if _, err := ingestFile(dir, name, func(string, string) (int, error) { return 0, nil }); err != nil {
+					t.Errorf("ingestFile(%q) err = %v", name, err)
+				}
+			}
+		})
+	}
+}
+
+func TestIngestFilesTotals(t *testing.T) {
+	dir := t.TempDir()
+	var names []string
+	for i := 0; i < 9; i++ {
+		name := fmt.Sprintf("topic%d.md", i)
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(strings.Repeat("x", i)), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, name)
+	}
+	names = append(names, "missing.md")
+
+	// A file's chunk count is its length; lengths 3 and 6 fail, 6 after
+	// storing its chunks.
+	ingest := func(content, _ string) (int, error) {
+		switch len(content) {
+		case 3:
+			return 0, errors.New("embed failed")
+		case 6:
+			return 6, errors.New("upsert failed")
+		}
+		return len(content), nil
+	}
+	want := ingestTotals{files: 7, chunks: 0 + 1 + 2 + 4 + 5 + 6 + 7 + 8, skipped: 3, partial: 1, partialChunks: 6}
+
+	tests := []struct {
+		name    string
+		workers int
+	}{
+		{"sequential", 1},
+		{"two workers", 2},
+		{"more workers than files", 16},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var calls atomic.Int32
+			got := ingestFiles(dir, names, tt.workers, func(content, name string) (int, error) {
+				calls.Add(1)
+				return ingest(content, name)
+			})
+			if got != want {
+				t.Errorf("ingestFiles() = %+v, want %+v", got, want)
+			}
+			// The unreadable file is skipped before ingest is called.
+			if int(calls.Load()) != len(names)-1 {
+				t.Errorf("ingest called %d times, want %d", calls.Load(), len(names)-1)
+			}
+		})
+	}
+}
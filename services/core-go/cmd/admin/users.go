@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"core-go/internal/agent"
+	"core-go/internal/vector"
+)
+
+// runUserCommand handles -list-users and -delete-user. Both work on the
+// Qdrant chunks only; Postgres rows (documents, tasks, conversations) are
+// left alone — DELETE /api/v1/users/{user_id}/data purges a user entirely.
+func runUserCommand(ctx context.Context, qdrantURL string, list bool, deleteUser string, force bool) error {
+	mode, err := agent.CollectionMode()
+	if err != nil {
+		return err
+	}
+	qdrantClient := vector.NewQdrantClient(qdrantURL)
+	// Only scroll and delete are used, so no embedder or chat provider.
+	kb := agent.NewKnowledgeBase(qdrantClient, nil, nil)
+	kb.SetCollectionMode(mode)
+
+	if list {
+		return listUsers(ctx, kb)
+	}
+	return deleteUserChunks(ctx, qdrantClient, kb, deleteUser, force)
+}
+
+// listUsers prints every user_id with its chunk and source counts.
+func listUsers(ctx context.Context, kb *agent.KnowledgeBase) error {
+	users, err := kb.ListUsers(ctx)
+	if err != nil {
+		return err
+	}
+	if len(users) == 0 {
+		fmt.Println("no chunks stored")
+		return nil
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "USER_ID\tCHUNKS\tSOURCES")
+	total := 0
+	for _, u := range users {
+		label := u.UserID
+		switch {
+		case label == "":
+			label = "(none)"
+		case label == vector.SharedUserID:
+			label += " (shared)"
+		}
+		fmt.Fprintf(tw, "%s\t%d\t%d\n", label, u.Chunks, u.Sources)
+		total += u.Chunks
+	}
+	tw.Flush()
+	fmt.Printf("\n%d user(s), %d chunk(s)\n", len(users), total)
+	return nil
+}
+
+// deleteUserChunks removes every chunk of userID. The shared namespace is
+// the common knowledge base, so deleting it needs force.
+func deleteUserChunks(ctx context.Context, qdrantClient *vector.QdrantClient, kb *agent.KnowledgeBase, userID string, force bool) error {
+	if userID != vector.SharedUserID {
+		if err := kb.DeleteAllForUser(ctx, userID); err != nil {
+			return err
+		}
+		fmt.Printf("Deleted all chunks with user_id = %q\n", userID)
+		return nil
+	}
+
+	if !force {
+		return fmt.Errorf("refusing to delete the shared namespace %q without -force", userID)
+	}
+	// kb.DeleteAllForUser always refuses the shared namespace.
+	if err := qdrantClient.DeleteByUser(ctx, agent.CollectionName(), userID); err != nil {
+		return err
+	}
+	fmt.Printf("Deleted all shared chunks (user_id = %q)\n", userID)
+	return nil
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+
+	"core-go/internal/agent"
+	"core-go/internal/llm"
+	"core-go/internal/vector"
+)
+
+// seedUsers ingests one chunk per (user, source) pair.
+func seedUsers(t *testing.T, kb *agent.KnowledgeBase, chunks map[string][]string) {
+	t.Helper()
+	for user, sources := range chunks {
+		for _, source := range sources {
+			text := "Notes about " + source + " kept for " + user + "."
+			if _, err := kb.IngestText(context.Background(), text, source, user, agent.IngestOptions{}); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+}
+
+func TestListUsers(t *testing.T) {
+	kb, _ := newTestKB(t, llm.NewFakeEmbedder())
+	seedUsers(t, kb, map[string][]string{
+		"u1":                {"a.md", "b.md", "b.md"},
+		"u2":                {"c.md"},
+		vector.SharedUserID: {"shared.md", "faq.md", "faq.md"},
+	})
+
+	users, err := kb.ListUsers(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, u := range users {
+		got = append(got, fmt.Sprintf("%s:%d:%d", u.UserID, u.Chunks, u.Sources))
+	}
+	// Largest first, ties by user_id; repeated sources count once.
+	want := []string{
+		fmt.Sprintf("%s:3:2", min(vector.SharedUserID, "u1")),
+		fmt.Sprintf("%s:3:2", max(vector.SharedUserID, "u1")),
+		"u2:1:1",
+	}
+	if strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Errorf("ListUsers() = %v, want %v", got, want)
+	}
+}
+
+func TestDeleteUserChunks(t *testing.T) {
+	tests := []struct {
+		name      string
+		userID    string
+		force     bool
+		wantErr   string
+		wantUsers []string // user_ids left, sorted
+	}{
+		{"regular user", "u1", false, "", sorted(vector.SharedUserID, "u2")},
+		{"regular user with force", "u2", true, "", sorted(vector.SharedUserID, "u1")},
+		{"shared namespace refused", vector.SharedUserID, false, "without -force", sorted(vector.SharedUserID, "u1", "u2")},
+		{"shared namespace with force", vector.SharedUserID, true, "", sorted("u1", "u2")},
+		{"unknown user is a no-op", "nobody", false, "", sorted(vector.SharedUserID, "u1", "u2")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			kb, srv := newTestKB(t, llm.NewFakeEmbedder())
+			seedUsers(t, kb, map[string][]string{"u1": {"a.md"}, "u2": {"b.md"}, vector.SharedUserID: {"faq.md"}})
+
+			err := deleteUserChunks(context.Background(), vector.NewQdrantClient(srv.URL), kb, tt.userID, tt.force)
+			if tt.wantErr == "" && err != nil {
+				t.Fatalf("deleteUserChunks() err = %v", err)
+			}
+			if tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Fatalf("deleteUserChunks() err = %v, want it to contain %q", err, tt.wantErr)
+			}
+
+			var left []string
+			for _, p := range srv.Points(agent.CollectionName()) {
+				left = append(left, p.Payload["user_id"].(string))
+			}
+			if fmt.Sprint(sorted(left...)) != fmt.Sprint(tt.wantUsers) {
+				t.Errorf("users left = %v, want %v", sorted(left...), tt.wantUsers)
+			}
+		})
+	}
+}
+
+func sorted(s ...string) []string {
+	out := append([]string(nil), s...)
+	slices.Sort(out)
+	return out
+}
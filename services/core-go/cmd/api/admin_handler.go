@@ -3,45 +3,60 @@
 //	GET    /api/v1/admin/documents           → list all admin docs (grouped by source)
 //	DELETE /api/v1/admin/documents?source=X  → delete all chunks for a source
 //	PUT    /api/v1/admin/documents?source=X  → replace a source (delete + re-ingest)
+//	GET    /api/v1/admin/documents/stale     → sources embedded with a different model
+//	GET    /api/v1/admin/search?q=X          → raw similarity search across any users
+//	POST   /api/v1/admin/similarity          → cosine similarity of two texts
+//	POST   /api/v1/admin/reembed             → re-embed every chunk (SSE progress)
 package main
 
 import (
 	"encoding/json"
 	"net/http"
 	"sort"
+	"strconv"
 	"strings"
 
 	"core-go/internal/agent"
+	"core-go/internal/db"
+	"core-go/internal/llm"
+	"core-go/internal/logging"
 	"core-go/internal/vector"
 )
 
 // adminDocResponse is the JSON shape returned by listAdminDocsHandler.
 type adminDocResponse struct {
-	Source     string `json:"source"`
-	ChunkCount int    `json:"chunk_count"`
-	Preview    string `json:"preview"`   // first ~120 chars of reconstructed text
-	FullText   string `json:"full_text"` // full reconstructed text for the edit UI
+	Source         string `json:"source"`
+	ChunkCount     int    `json:"chunk_count"`
+	Preview        string `json:"preview"`   // first ~120 chars of reconstructed text
+	FullText       string `json:"full_text"` // full reconstructed text for the edit UI
+	EmbeddingModel string `json:"embedding_model,omitempty"`
 }
 
 // listAdminDocsHandler handles GET /api/v1/admin/documents.
-// It scrolls all Qdrant points tagged user_id="admin", groups them by source,
-// reconstructs the original text from ordered chunks, and returns a sorted list.
-func listAdminDocsHandler(qdrant *vector.QdrantClient) http.HandlerFunc {
+// It scrolls all Qdrant points tagged with the shared user_id
+// (vector.SharedUserID), groups them by source, reconstructs the original
+// text from ordered chunks, and returns a sorted list. kb resolves which
+// collections hold them and loads chunk text kept in Postgres
+// (RAG_CHUNK_STORE=postgres).
+func listAdminDocsHandler(kb *agent.KnowledgeBase) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		points, err := qdrant.ScrollAdminPoints(r.Context(), agent.CollectionName())
+		points, err := kb.AdminPoints(r.Context())
 		if err != nil {
+			logging.FromContext(r.Context()).Error("admin: list documents", "err", err)
 			http.Error(w, `{"error":"failed to list documents"}`, http.StatusInternalServerError)
 			return
 		}
 
 		// Group chunks by source, preserving order.
 		type entry struct {
-			idx  int
-			text string
+			idx     int
+			text    string
+			model   string
+			overlap *int
 		}
 		grouped := map[string][]entry{}
 		for _, p := range points {
-			grouped[p.Source] = append(grouped[p.Source], entry{p.ChunkIndex, p.Text})
+			grouped[p.Source] = append(grouped[p.Source], entry{p.ChunkIndex, p.Text, p.EmbeddingModel, p.ChunkOverlap})
 		}
 
 		docs := make([]adminDocResponse, 0, len(grouped))
@@ -55,7 +70,12 @@ func listAdminDocsHandler(qdrant *vector.QdrantClient) http.HandlerFunc {
 				chunks[i] = e.text
 			}
 
+			// Documents ingested before chunk_overlap was recorded used
+			// the default window.
 			fullText := agent.ReconstructText(chunks)
+			if o := entries[0].overlap; o != nil {
+				fullText = agent.ReconstructTextOverlap(chunks, *o)
+			}
 
 			preview := fullText
 			runes := []rune(preview)
@@ -64,10 +84,11 @@ func listAdminDocsHandler(qdrant *vector.QdrantClient) http.HandlerFunc {
 			}
 
 			docs = append(docs, adminDocResponse{
-				Source:     source,
-				ChunkCount: len(chunks),
-				Preview:    preview,
-				FullText:   fullText,
+				Source:         source,
+				ChunkCount:     len(chunks),
+				Preview:        preview,
+				FullText:       fullText,
+				EmbeddingModel: entries[0].model,
 			})
 		}
 
@@ -81,8 +102,10 @@ func listAdminDocsHandler(qdrant *vector.QdrantClient) http.HandlerFunc {
 }
 
 // deleteAdminDocHandler handles DELETE /api/v1/admin/documents?source=<source>.
-// Removes every Qdrant chunk whose user_id="admin" AND source=<source>.
-func deleteAdminDocHandler(qdrant *vector.QdrantClient) http.HandlerFunc {
+// Removes every Qdrant chunk whose user_id is vector.SharedUserID AND
+// source=<source>, in whichever collections kb resolves for the shared
+// user, then the matching documents rows.
+func deleteAdminDocHandler(kb *agent.KnowledgeBase, docs db.DocumentRepository) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		source := r.URL.Query().Get("source")
 		if source == "" {
@@ -94,20 +117,28 @@ func deleteAdminDocHandler(qdrant *vector.QdrantClient) http.HandlerFunc {
 			http.Error(w, `{"error":"invalid source"}`, http.StatusBadRequest)
 			return
 		}
-		if err := qdrant.DeleteBySource(r.Context(), agent.CollectionName(), source); err != nil {
+		if err := kb.DeleteSharedSource(r.Context(), source); err != nil {
+			logging.FromContext(r.Context()).Error("admin: delete document", "source", source, "err", err)
 			http.Error(w, `{"error":"failed to delete document"}`, http.StatusInternalServerError)
 			return
 		}
+		if _, err := docs.DeleteBySource(r.Context(), vector.SharedUserID, source); err != nil {
+			logging.FromContext(r.Context()).Error("admin: delete document record", "source", source, "err", err)
+			http.Error(w, `{"error":"failed to delete document record"}`, http.StatusInternalServerError)
+			return
+		}
 		w.WriteHeader(http.StatusNoContent)
 	}
 }
 
 // updateAdminDocHandler handles PUT /api/v1/admin/documents?source=<old-source>.
-// Body: { "text": "...", "new_source": "..." }
+// Body: { "text": "...", "new_source": "...", "title": "...", "url": "..." }
 //
-// Deletes all chunks for the old source then re-ingests the new text.
-// new_source is optional; when omitted the source name is preserved.
-func updateAdminDocHandler(qdrant *vector.QdrantClient, kb *agent.KnowledgeBase) http.HandlerFunc {
+// Deletes all chunks for the old source then re-ingests the new text,
+// replacing the old source's documents rows with one for the new ingest.
+// new_source is optional; when omitted the source name is preserved. title
+// and url are optional citation fields, as on POST /api/v1/documents.
+func updateAdminDocHandler(kb *agent.KnowledgeBase, docs db.DocumentRepository) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		oldSource := r.URL.Query().Get("source")
 		if oldSource == "" {
@@ -123,6 +154,8 @@ func updateAdminDocHandler(qdrant *vector.QdrantClient, kb *agent.KnowledgeBase)
 		var body struct {
 			Text      string `json:"text"`
 			NewSource string `json:"new_source"`
+			Title     string `json:"title"`
+			URL       string `json:"url"`
 		}
 		if err := decodeJSONStrict(r, &body); err != nil || strings.TrimSpace(body.Text) == "" {
 			http.Error(w, `{"error":"text is required"}`, http.StatusBadRequest)
@@ -138,14 +171,49 @@ func updateAdminDocHandler(qdrant *vector.QdrantClient, kb *agent.KnowledgeBase)
 			return
 		}
 
-		// Delete existing chunks first.
-		if err := qdrant.DeleteBySource(r.Context(), agent.CollectionName(), oldSource); err != nil {
+		opts, msg := ingestOptions(body.Title, body.URL)
+		if msg != "" {
+			errJSON, _ := json.Marshal(map[string]string{"error": msg})
+			http.Error(w, string(errJSON), http.StatusBadRequest)
+			return
+		}
+
+		// Checked here because the old version is deleted before ingest.
+		if err := agent.CheckContentLength(body.Text); err != nil {
+			errJSON, _ := json.Marshal(map[string]string{"error": err.Error()})
+			http.Error(w, string(errJSON), http.StatusBadRequest)
+			return
+		}
+
+		// Delete existing chunks and their records first.
+		if err := kb.DeleteSharedSource(r.Context(), oldSource); err != nil {
+			logging.FromContext(r.Context()).Error("admin: remove old document", "source", oldSource, "err", err)
 			http.Error(w, `{"error":"failed to remove old document"}`, http.StatusInternalServerError)
 			return
 		}
+		if _, err := docs.DeleteBySource(r.Context(), vector.SharedUserID, oldSource); err != nil {
+			logging.FromContext(r.Context()).Error("admin: delete document record", "source", oldSource, "err", err)
+		}
 
-		// Re-ingest as admin with the (possibly renamed) source.
-		count, err := kb.IngestText(r.Context(), body.Text, newSource, "admin")
+		// Re-ingest as admin with the (possibly renamed) source, under a
+		// fresh documents row.
+		docID, err := docs.RecordDocument(r.Context(), db.NewDocument{
+			Source:   newSource,
+			UserID:   vector.SharedUserID,
+			ByteSize: len(body.Text),
+			Content:  body.Text,
+			Title:    opts.Title,
+			URL:      opts.URL,
+		})
+		if err != nil {
+			logging.FromContext(r.Context()).Error("admin: record document", "source", newSource, "err", err)
+			http.Error(w, `{"error":"failed to record document"}`, http.StatusInternalServerError)
+			return
+		}
+		opts.DocumentID = int64(docID)
+
+		count, err := kb.IngestText(r.Context(), body.Text, newSource, vector.SharedUserID, opts)
+		finishDocument(r, docs, docID, vector.SharedUserID, count)
 		if err != nil {
 			http.Error(w, `{"error":"failed to ingest updated document"}`, http.StatusInternalServerError)
 			return
@@ -155,6 +223,195 @@ func updateAdminDocHandler(qdrant *vector.QdrantClient, kb *agent.KnowledgeBase)
 		json.NewEncoder(w).Encode(map[string]any{
 			"source":          newSource,
 			"chunks_ingested": count,
+			"document_id":     int64(docID),
+		})
+	}
+}
+
+// listStaleDocsHandler handles GET /api/v1/admin/documents/stale.
+// Lists every source (across all users) whose chunks were embedded with a
+// model other than the currently configured one, so operators know what to
+// re-ingest after switching EMBEDDING_MODEL.
+func listStaleDocsHandler(kb *agent.KnowledgeBase) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		stale, err := kb.FindStaleSources(r.Context(), llm.EmbeddingModel())
+		if err != nil {
+			http.Error(w, `{"error":"failed to list stale documents"}`, http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]any{
+			"embedding_model": llm.EmbeddingModel(),
+			"stale":           stale,
+		})
+	}
+}
+
+// adminSearchResponse is the JSON shape returned by adminSearchHandler.
+type adminSearchResponse struct {
+	Query   string              `json:"query"`
+	Results []adminSearchResult `json:"results"`
+}
+
+// adminSearchResult is a raw Qdrant hit plus the rune ranges of its text
+// that lexically match the query.
+type adminSearchResult struct {
+	vector.ScoredPoint
+	Highlights []agent.Span `json:"highlights"`
+}
+
+// adminSearchHandler handles
+// GET /api/v1/admin/search?q=X[&user_id=A&user_id=B][&include_admin=true][&limit=N][&with_vectors=true].
+// With no user_id and include_admin unset it searches every document; with
+// user_id values it searches only those users (plus the shared namespace when
+// include_admin=true). Results are the raw Qdrant hits, unranked by the RAG
+// hybrid scoring, each with "highlights": the rune ranges of payload.text
+// containing a query term (see agent.Highlight), to show why it matched.
+// with_vectors=true adds each hit's stored "vector", e.g. to plot a
+// document map.
+func adminSearchHandler(kb *agent.KnowledgeBase) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		query := strings.TrimSpace(r.URL.Query().Get("q"))
+		if query == "" {
+			http.Error(w, `{"error":"q is required"}`, http.StatusBadRequest)
+			return
+		}
+
+		var opts vector.SearchOptions
+		for _, raw := range r.URL.Query()["user_id"] {
+			userID := strings.TrimSpace(raw)
+			if !isValidUserID(userID) {
+				http.Error(w, `{"error":"invalid user_id"}`, http.StatusBadRequest)
+				return
+			}
+			opts.UserIDs = append(opts.UserIDs, userID)
+		}
+		opts.IncludeAdmin = r.URL.Query().Get("include_admin") == "true"
+		opts.WithVectors = r.URL.Query().Get("with_vectors") == "true"
+
+		limit := 10
+		if raw := r.URL.Query().Get("limit"); raw != "" {
+			n, err := strconv.Atoi(raw)
+			if err != nil || n <= 0 || n > 100 {
+				http.Error(w, `{"error":"limit must be between 1 and 100"}`, http.StatusBadRequest)
+				return
+			}
+			limit = n
+		}
+
+		points, err := kb.SearchDocuments(r.Context(), query, limit, opts)
+		if err != nil {
+			http.Error(w, `{"error":"search failed"}`, http.StatusBadGateway)
+			return
+		}
+		results := make([]adminSearchResult, len(points))
+		for i, p := range points {
+			text, _ := p.Payload["text"].(string)
+			spans := agent.Highlight(text, query)
+			if spans == nil {
+				spans = []agent.Span{}
+			}
+			results[i] = adminSearchResult{ScoredPoint: p, Highlights: spans}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(adminSearchResponse{Query: query, Results: results})
+	}
+}
+
+// similarityRequest is the body for POST /api/v1/admin/similarity.
+type similarityRequest struct {
+	A string `json:"a"`
+	B string `json:"b"`
+}
+
+// similarityResponse is returned by similarityHandler.
+type similarityResponse struct {
+	Similarity     float64 `json:"similarity"`
+	EmbeddingModel string  `json:"embedding_model"`
+}
+
+// similarityHandler handles POST /api/v1/admin/similarity.
+// It embeds both texts with the knowledge base's embedder and returns their
+// cosine similarity, so retrieval thresholds can be tuned against real pairs.
+func similarityHandler(kb *agent.KnowledgeBase) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		r.Body = http.MaxBytesReader(w, r.Body, 1<<20)
+
+		var req similarityRequest
+		if err := decodeJSONStrict(r, &req); err != nil {
+			http.Error(w, `{"error":"invalid JSON body"}`, http.StatusBadRequest)
+			return
+		}
+		if strings.TrimSpace(req.A) == "" || strings.TrimSpace(req.B) == "" {
+			http.Error(w, `{"error":"a and b must be non-empty strings"}`, http.StatusBadRequest)
+			return
+		}
+
+		vecA, err := kb.Embed(r.Context(), req.A)
+		if err != nil {
+			http.Error(w, `{"error":"embedding failed"}`, http.StatusBadGateway)
+			return
+		}
+		vecB, err := kb.Embed(r.Context(), req.B)
+		if err != nil {
+			http.Error(w, `{"error":"embedding failed"}`, http.StatusBadGateway)
+			return
+		}
+
+		sim, err := llm.CosineSimilarity(vecA, vecB)
+		if err != nil {
+			http.Error(w, `{"error":"embedding length mismatch"}`, http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(similarityResponse{Similarity: sim, EmbeddingModel: llm.EmbeddingModel()})
+	}
+}
+
+// reembedHandler handles POST /api/v1/admin/reembed.
+// Re-embeds every stored chunk with the current EMBEDDING_MODEL, keeping
+// point IDs and payloads. The run can take minutes, so progress is streamed
+// as SSE "progress" events after each batch, followed by a final "done"
+// event, or an "error" event carrying the progress reached before the
+// failure.
+func reembedHandler(kb *agent.KnowledgeBase) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			http.Error(w, `{"error":"streaming not supported by this server"}`, http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("Connection", "keep-alive")
+		w.Header().Set("X-Accel-Buffering", "no")
+
+		// The server's WriteTimeout is sized for ordinary requests; lift it
+		// for this run, which ends on its own or when the client disconnects.
+		liftDeadlines(w, r, false)
+
+		progress, err := kb.ReembedAll(r.Context(), func(p agent.ReembedProgress) {
+			writeSSEEvent(w, flusher, eventProgress, p)
+		})
+		if err != nil {
+			logging.FromContext(r.Context()).Error("admin: reembed failed", "reembedded", progress.Reembedded, "err", err)
+			writeSSEEvent(w, flusher, eventError, map[string]any{
+				"error":    err.Error(),
+				"progress": progress,
+			})
+			return
+		}
+
+		logging.FromContext(r.Context()).Info("admin: reembed complete",
+			"total", progress.Total, "reembedded", progress.Reembedded, "skipped", progress.Skipped,
+			"embedding_model", llm.EmbeddingModel())
+		writeSSEEvent(w, flusher, eventDone, map[string]any{
+			"progress":        progress,
+			"embedding_model": llm.EmbeddingModel(),
 		})
 	}
 }
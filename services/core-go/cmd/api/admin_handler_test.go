@@ -0,0 +1,195 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"core-go/internal/agent"
+	"core-go/internal/db"
+	"core-go/internal/vector"
+)
+
+func TestAdminDocsUseResolvedCollections(t *testing.T) {
+	const user = "6f1c2a7e-3b4d-4e5f-8a9b-0c1d2e3f4a5b"
+	tests := []struct {
+		name string
+		mode string
+	}{
+		{"filter mode", agent.CollectionModeFilter},
+		{"per-user mode", agent.CollectionModePerUser},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			kb, srv := newTestKB(t)
+			kb.SetCollectionMode(tt.mode)
+			docs := &memDocuments{}
+			ctx := context.Background()
+
+			for _, d := range []struct{ user, source, text string }{
+				{vector.SharedUserID, "handbook.md", "The handbook covers leave, expenses and travel."},
+				{user, "diary.md", "Dear diary, today I learned about Roman aqueducts."},
+			} {
+				id, _ := docs.RecordDocument(ctx, db.NewDocument{Source: d.source, UserID: d.user})
+				if _, err := kb.IngestText(ctx, d.text, d.source, d.user, agent.IngestOptions{DocumentID: int64(id)}); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			rec := httptest.NewRecorder()
+			listAdminDocsHandler(kb)(rec, httptest.NewRequest(http.MethodGet, "/api/v1/admin/documents", nil))
+			var listed []adminDocResponse
+			if err := json.NewDecoder(rec.Body).Decode(&listed); err != nil {
+				t.Fatalf("list: %v (status %d)", err, rec.Code)
+			}
+			if len(listed) != 1 || listed[0].Source != "handbook.md" {
+				t.Fatalf("listed %+v, want only the shared handbook.md", listed)
+			}
+
+			rec = httptest.NewRecorder()
+			deleteAdminDocHandler(kb, docs)(rec, httptest.NewRequest(http.MethodDelete, "/api/v1/admin/documents?source=handbook.md", nil))
+			if rec.Code != http.StatusNoContent {
+				t.Fatalf("delete status = %d: %s", rec.Code, rec.Body)
+			}
+
+			var remaining []string
+			for _, c := range srv.Collections() {
+				for _, p := range srv.Points(c) {
+					remaining = append(remaining, p.Payload["source"].(string))
+				}
+			}
+			if len(remaining) != 1 || remaining[0] != "diary.md" {
+				t.Errorf("remaining chunks = %v, want only the user's diary.md", remaining)
+			}
+			if rows := docs.ids(); len(rows) != 1 {
+				t.Errorf("documents rows = %v, want the user's row only", rows)
+			}
+		})
+	}
+}
+
+func TestSimilarityHandler(t *testing.T) {
+	tests := []struct {
+		name       string
+		body       string
+		wantStatus int
+		wantOne    bool // identical texts score 1
+	}{
+		{"identical texts", `{"a":"Rome","b":"Rome"}`, http.StatusOK, true},
+		{"different texts", `{"a":"Rome","b":"a recipe for bread"}`, http.StatusOK, false},
+		{"missing b", `{"a":"Rome"}`, http.StatusBadRequest, false},
+		{"blank a", `{"a":"  ","b":"Rome"}`, http.StatusBadRequest, false},
+		{"unknown field", `{"a":"x","b":"y","c":"z"}`, http.StatusBadRequest, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			kb, _ := newTestKB(t)
+			rec := serve(similarityHandler(kb), http.MethodPost, "/api/v1/admin/similarity", "", tt.body)
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+			if rec.Code != http.StatusOK {
+				return
+			}
+			var resp similarityResponse
+			if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+				t.Fatal(err)
+			}
+			if one := math.Abs(resp.Similarity-1) < 1e-9; one != tt.wantOne || resp.Similarity < -1 || resp.Similarity > 1+1e-9 {
+				t.Errorf("similarity = %v, want identical = %v", resp.Similarity, tt.wantOne)
+			}
+			if resp.EmbeddingModel == "" {
+				t.Error("response has no embedding_model")
+			}
+		})
+	}
+}
+
+func TestReembedHandler(t *testing.T) {
+	tests := []struct {
+		name string
+		docs []string
+		want agent.ReembedProgress
+	}{
+		{"empty knowledge base", nil, agent.ReembedProgress{}},
+		{"every point re-embedded", []string{
+			"The Colosseum is an ancient amphitheatre in Rome.",
+			"The Parthenon is a former temple on the Athenian Acropolis.",
+		}, agent.ReembedProgress{Total: 2, Reembedded: 2}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			kb, _ := newTestKB(t)
+			for _, d := range tt.docs {
+				if _, err := kb.IngestText(context.Background(), d, "doc.md", testUser, agent.IngestOptions{}); err != nil {
+					t.Fatal(err)
+				}
+			}
+			rec := serve(reembedHandler(kb), http.MethodPost, "/api/v1/admin/reembed", "", "")
+			if ct := rec.Header().Get("Content-Type"); ct != "text/event-stream" {
+				t.Fatalf("Content-Type = %q: %s", ct, rec.Body)
+			}
+			events := parseSSE(rec.Body.String())
+			last := events[len(events)-1]
+			if last.name != eventDone.Name {
+				t.Fatalf("last event = %q %s, want %q", last.name, last.data, eventDone.Name)
+			}
+			var done struct {
+				Progress agent.ReembedProgress `json:"progress"`
+			}
+			if err := json.Unmarshal([]byte(last.data), &done); err != nil {
+				t.Fatal(err)
+			}
+			if done.Progress != tt.want {
+				t.Errorf("done progress = %+v, want %+v", done.Progress, tt.want)
+			}
+			// One progress event per written batch precedes done.
+			if len(tt.docs) > 0 && (len(events) < 2 || events[len(events)-2].name != eventProgress.Name) {
+				t.Errorf("events = %+v, want progress before done", events)
+			}
+		})
+	}
+}
+
+func TestAdminSearchHighlights(t *testing.T) {
+	tests := []struct {
+		name  string
+		query string
+		want  string // highlights of the single hit
+	}{
+		{"matched terms", "colosseum ROME", "[{4 13} {44 48}]"},
+		{"no lexical match", "gladiators", "[]"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			kb, _ := newTestKB(t)
+			if _, err := kb.IngestText(context.Background(), "The Colosseum is an ancient amphitheatre in Rome.", "rome.md", testUser, agent.IngestOptions{}); err != nil {
+				t.Fatal(err)
+			}
+			rec := serve(adminSearchHandler(kb), http.MethodGet, "/api/v1/admin/search?q="+url.QueryEscape(tt.query), "", "")
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+			}
+			var resp struct {
+				Results []struct {
+					Highlights []agent.Span `json:"highlights"`
+				} `json:"results"`
+			}
+			if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+				t.Fatal(err)
+			}
+			if len(resp.Results) != 1 {
+				t.Fatalf("got %d results, want 1", len(resp.Results))
+			}
+			// An empty list, not null, when nothing matches lexically.
+			if got := resp.Results[0].Highlights; got == nil || fmt.Sprint(got) != tt.want {
+				t.Errorf("highlights = %v, want %s", got, tt.want)
+			}
+		})
+	}
+}
@@ -1,15 +1,20 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"log"
 	"net/http"
 	"strconv"
 	"strings"
+	"time"
+	"unicode/utf8"
 
 	"core-go/internal/agent"
+	"core-go/internal/db"
 	"core-go/internal/llm"
+	"core-go/internal/logging"
 )
 
 // ── Request types (shared/api/chat_request.json) ──────────────────────────────
@@ -25,19 +30,126 @@ type apiMessage struct {
 // Matches shared/api/chat_request.json exactly — no flat "query" field.
 // UserID is the device-generated UUID of the requesting user; it scopes
 // RAG retrieval and task creation. Defaults to "default" when omitted.
+// Stream is a pointer so an omitted field keeps the schema default (true).
+// Mode ("rag" | "agent") pins the pipeline explicitly; when empty the route
+// is inferred (see routeChat).
+// ConversationID continues a stored conversation; 0 starts a new one.
+// Sources restricts RAG retrieval to chunks with one of these source labels.
 type chatRequest struct {
-	Messages  []apiMessage `json:"messages"`
-	Stream    bool         `json:"stream"`
-	UserID    string       `json:"user_id"`
-	ForceTask bool         `json:"force_task"`
+	Messages       []apiMessage `json:"messages"`
+	Stream         *bool        `json:"stream"`
+	UserID         string       `json:"user_id"`
+	ForceTask      bool         `json:"force_task"`
+	Mode           string       `json:"mode"`
+	ConversationID int64        `json:"conversation_id"`
+	Sources        []string     `json:"sources"`
+
+	// Optional generation parameters, e.g. a higher temperature to
+	// regenerate the last answer. Omitted fields keep the model defaults.
+	Temperature *float64 `json:"temperature"`
+	TopP        *float64 `json:"top_p"`
+	Seed        *int     `json:"seed"`
 }
 
+// streaming reports whether the client wants SSE (the default) rather than
+// a single JSON response.
+func (req chatRequest) streaming() bool {
+	return req.Stream == nil || *req.Stream
+}
+
+// chatResponse is the JSON body returned by POST /api/v1/chat when the
+// request sets "stream": false. TaskID is a string to match the SSE
+// tool_result payload. Sources keeps its original wire form, bare source
+// labels; SourceDetails carries the same sources with title and url.
+type chatResponse struct {
+	Content        string         `json:"content"`
+	Sources        []string       `json:"sources,omitempty"`
+	SourceDetails  []agent.Source `json:"source_details,omitempty"`
+	LowConfidence  bool           `json:"low_confidence,omitempty"`
+	TaskID         string         `json:"task_id,omitempty"`
+	Task           *db.Task       `json:"task,omitempty"`
+	Error          string         `json:"error,omitempty"`
+	Stats          *statsPayload  `json:"stats,omitempty"`
+	ConversationID int64          `json:"conversation_id"`
+}
+
+// statsPayload is the wire form of llm.Stats, shared by the SSE "stats"
+// event and the non-streaming chatResponse. Durations are milliseconds.
+type statsPayload struct {
+	PromptTokens     int   `json:"prompt_tokens"`
+	CompletionTokens int   `json:"completion_tokens"`
+	TotalMs          int64 `json:"total_ms"`
+	LoadMs           int64 `json:"load_ms"`
+	PromptEvalMs     int64 `json:"prompt_eval_ms"`
+	EvalMs           int64 `json:"eval_ms"`
+}
+
+func newStatsPayload(s *llm.Stats) *statsPayload {
+	if s == nil {
+		return nil
+	}
+	return &statsPayload{
+		PromptTokens:     s.PromptTokens,
+		CompletionTokens: s.CompletionTokens,
+		TotalMs:          s.TotalDuration.Milliseconds(),
+		LoadMs:           s.LoadDuration.Milliseconds(),
+		PromptEvalMs:     s.PromptEvalDuration.Milliseconds(),
+		EvalMs:           s.EvalDuration.Milliseconds(),
+	}
+}
+
+// idempotencyKeyHeader lets a client retry a chat request without the agent
+// creating the same task twice.
+const idempotencyKeyHeader = "Idempotency-Key"
+
+// conversationIDHeader carries the stored conversation ID on every chat
+// response so SSE clients can continue the conversation on the next turn.
+const conversationIDHeader = "X-Conversation-ID"
+
+const (
+	routeRAG   = "rag"
+	routeAgent = "agent"
+)
+
+// maxChatSources caps chatRequest.Sources; each becomes a clause of the
+// Qdrant filter.
+const maxChatSources = 20
+
+// validModes is the allowed set for chatRequest.Mode. Empty means "infer".
+var validModes = map[string]bool{"": true, routeRAG: true, routeAgent: true}
+
+// historyLimit bounds how many messages a chat request may carry. With
+// Truncate set, longer histories are cut to the most recent MaxTurns
+// messages instead of being rejected.
+type historyLimit struct {
+	MaxTurns int
+	Truncate bool
+}
+
+// apply enforces the limit on msgs. ok is false when msgs is too long and
+// truncation is off.
+func (l historyLimit) apply(msgs []apiMessage) (kept []apiMessage, ok bool) {
+	if l.MaxTurns <= 0 || len(msgs) <= l.MaxTurns {
+		return msgs, true
+	}
+	if !l.Truncate {
+		return msgs, false
+	}
+	return msgs[len(msgs)-l.MaxTurns:], true
+}
+
+// previewRunes is how many characters of a prompt previewPrompt keeps.
+const previewRunes = 120
+
+// previewPrompt shortens text to previewRunes characters for logs and new
+// conversation titles. It cuts on a rune boundary so the result is always
+// valid UTF-8, which Postgres requires of the title.
 func previewPrompt(text string) string {
 	trimmed := strings.TrimSpace(text)
-	if len(trimmed) <= 120 {
+	if utf8.RuneCountInString(trimmed) <= previewRunes {
 		return trimmed
 	}
-	return trimmed[:120] + "..."
+	return string([]rune(trimmed)[:previewRunes]) + "..."
 }
 
 // ── Handler ───────────────────────────────────────────────────────────────────
@@ -45,12 +157,19 @@ func previewPrompt(text string) string {
 // chatHandler returns an http.HandlerFunc that:
 //  1. Parses the ChatRequest body (messages array + stream flag).
 //  2. Extracts the user prompt from the last message in the array.
-//  3. Upgrades the response to a Server-Sent Events stream.
-//  4. Routes to either the RAG or Agent pipeline.
+//  3. Routes to either the RAG or Agent pipeline.
+//  4. Records the user turn in the conversation store.
+//  5. Streams the result as Server-Sent Events, or — when "stream": false —
+//     collects it into a single JSON chatResponse.
+//  6. Records the assistant reply once the pipeline completes.
 //
 // Dependencies are closed over so the handler is a plain http.HandlerFunc
-// with no global state.
-func chatHandler(kb *agent.KnowledgeBase, ta *agent.TaskAgent) http.HandlerFunc {
+// with no global state. askOpts carries the deployment's RAG options,
+// history bounds the length of the messages array, intents (nil when
+// disabled) routes requests that do not set a mode, streams caps how
+// many streaming replies run at once (503 with Retry-After when full), and
+// registry records each stream so POST /api/v1/chat/abort can stop it.
+func chatHandler(kb *agent.KnowledgeBase, ta *agent.TaskAgent, convos db.ConversationRepository, askOpts agent.AskOptions, history historyLimit, intents *agent.IntentClassifier, streams *streamLimiter, registry *streamRegistry, statusEvents bool) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 
 		// ── 1. Parse and validate request ─────────────────────────────────
@@ -65,6 +184,29 @@ func chatHandler(kb *agent.KnowledgeBase, ta *agent.TaskAgent) http.HandlerFunc
 			http.Error(w, `"messages" must be a non-empty array`, http.StatusBadRequest)
 			return
 		}
+		var ok bool
+		received := len(req.Messages)
+		if req.Messages, ok = history.apply(req.Messages); !ok {
+			http.Error(w, fmt.Sprintf(`"messages" must contain at most %d entries`, history.MaxTurns), http.StatusBadRequest)
+			return
+		}
+		if len(req.Messages) < received {
+			logging.FromContext(r.Context()).Info("chat: history truncated", "received", received, "kept", len(req.Messages))
+		}
+		req.Mode = strings.ToLower(strings.TrimSpace(req.Mode))
+		if !validModes[req.Mode] {
+			http.Error(w, `"mode" must be one of: rag, agent`, http.StatusBadRequest)
+			return
+		}
+		if msg := cleanSources(req.Sources); msg != "" {
+			http.Error(w, msg, http.StatusBadRequest)
+			return
+		}
+		gen := llm.Options{Temperature: req.Temperature, TopP: req.TopP, Seed: req.Seed}
+		if err := gen.Validate(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
 
 		// Extract the user prompt from the last message in the conversation.
 		// Multi-turn history is carried by the client; the backend treats the
@@ -76,21 +218,91 @@ func chatHandler(kb *agent.KnowledgeBase, ta *agent.TaskAgent) http.HandlerFunc
 			return
 		}
 
+		idempotencyKey := strings.TrimSpace(r.Header.Get(idempotencyKeyHeader))
+		if len(idempotencyKey) > agent.MaxIdempotencyKeyLen {
+			http.Error(w, fmt.Sprintf("Idempotency-Key must be at most %d characters", agent.MaxIdempotencyKeyLen), http.StatusBadRequest)
+			return
+		}
+		agentOpts := agent.AgentOptions{ForceTask: req.ForceTask, IdempotencyKey: idempotencyKey, Generation: gen}
+		ragOpts := askOpts // copy: askOpts is shared by every request
+		ragOpts.Sources = req.Sources
+		ragOpts.Generation = askOpts.Generation.Merge(gen)
+
 		// Default userID so clients that haven't updated still work.
-		userID := normalizeUserID(req.UserID, "default")
-		if !isValidUserID(userID) {
-			http.Error(w, "invalid user_id", http.StatusBadRequest)
+		userID, status, msg := requestUserID(r, req.UserID, "default")
+		if status != 0 {
+			http.Error(w, msg, status)
 			return
 		}
 
-		log.Printf("chat: user_id=%s force_task=%t stream=%t prompt_len=%d prompt_preview=%q",
-			userID,
-			req.ForceTask,
-			req.Stream,
-			len(userPrompt),
-			previewPrompt(userPrompt),
+		logger := logging.FromContext(r.Context()).With("user_id", userID)
+		logger.Info("chat: request",
+			"force_task", req.ForceTask,
+			"stream", req.streaming(),
+			"prompt_len", len(userPrompt),
+			"prompt_preview", previewPrompt(userPrompt),
 		)
 
+		route, reason := routeChat(r.Context(), req, userPrompt, intents)
+		logger.Info("chat: route", "route", route, "reason", reason)
+
+		// The agent appends the client's system messages to its own prompt;
+		// the RAG pipeline builds its prompt from retrieved context only.
+		if route == routeAgent {
+			agentOpts.SystemInstructions = systemInstructions(req.Messages)
+			if n := utf8.RuneCountInString(strings.Join(agentOpts.SystemInstructions, "\n")); n > agent.MaxSystemInstructionRunes {
+				http.Error(w, fmt.Sprintf("system messages must total at most %d characters", agent.MaxSystemInstructionRunes), http.StatusBadRequest)
+				return
+			}
+		}
+
+		// Take a stream slot before the user turn is recorded, so a rejected
+		// request leaves no trace and can simply be retried. The slot is held
+		// until the handler returns: stream finished, failed or cancelled.
+		if req.streaming() {
+			if !streams.TryAcquire() {
+				logger.Warn("chat: too many concurrent streams")
+				w.Header().Set("Retry-After", strconv.Itoa(int(streamRetryAfter.Seconds())))
+				http.Error(w, "too many concurrent chat streams; retry shortly", http.StatusServiceUnavailable)
+				return
+			}
+			defer streams.Release()
+		}
+
+		convID, status, err := startConversationTurn(r.Context(), convos, db.ConversationID(req.ConversationID), userID, userPrompt)
+		if err != nil {
+			logger.Error("chat: record user turn", "conversation_id", req.ConversationID, "err", err)
+			if status == http.StatusNotFound {
+				http.Error(w, "conversation not found", status)
+			} else {
+				http.Error(w, "failed to record conversation", status)
+			}
+			return
+		}
+		w.Header().Set(conversationIDHeader, strconv.FormatInt(int64(convID), 10))
+
+		if !req.streaming() {
+			var resp chatResponse
+			if route == routeAgent {
+				resp, err = collectAgent(r, ta, userPrompt, userID, agentOpts)
+			} else {
+				resp, err = collectRAG(r, kb, userPrompt, userID, ragOpts)
+			}
+			if err != nil {
+				logger.Error("chat: "+route+" pipeline", "err", err)
+				status := http.StatusBadGateway
+				if pipelineUnavailable(err) {
+					status = http.StatusServiceUnavailable
+				}
+				http.Error(w, ragErrorMessage(err), status)
+				return
+			}
+			finishConversationTurn(r.Context(), convos, convID, userID, resp.Content)
+			resp.ConversationID = int64(convID)
+			writeChatJSON(w, resp)
+			return
+		}
+
 		// ── 2. Assert http.Flusher before committing SSE headers ──────────
 		flusher, ok := w.(http.Flusher)
 		if !ok {
@@ -105,38 +317,146 @@ func chatHandler(kb *agent.KnowledgeBase, ta *agent.TaskAgent) http.HandlerFunc
 		w.Header().Set("Cache-Control", "no-cache")
 		w.Header().Set("Connection", "keep-alive")
 		w.Header().Set("X-Accel-Buffering", "no") // prevents nginx from buffering
+		// A reply streams for as long as the model generates, well past the
+		// server's WriteTimeout.
+		liftDeadlines(w, r, false)
+		// Send the headers now rather than with the first event: retrieval
+		// can take a while, and the client should see the stream open (and
+		// any failure as an "error" event) instead of a hung request.
+		w.WriteHeader(http.StatusOK)
+		flusher.Flush()
+
+		// ── 4. Stream ──────────────────────────────────────────────────────
+		// The pipeline runs under its own cancellable context so an abort
+		// request can stop it; the stream_id is the first event.
+		streamCtx, cancel := context.WithCancelCause(r.Context())
+		defer cancel(nil)
+		streamID, unregister := registry.Register(userID, cancel)
+		defer unregister()
+		writeSSEEvent(w, flusher, eventStream, map[string]string{"stream_id": streamID})
+
+		sr := r.WithContext(streamCtx)
+		var reply string
+		if route == routeAgent {
+			reply = streamAgent(w, flusher, sr, ta, userPrompt, userID, agentOpts, statusEvents)
+		} else {
+			reply = streamRAG(w, flusher, sr, kb, userPrompt, userID, ragOpts, statusEvents)
+		}
+		// Recorded under the request's context: an aborted reply is kept.
+		finishConversationTurn(r.Context(), convos, convID, userID, reply)
+	}
+}
 
-		// ── 4. Route ───────────────────────────────────────────────────────
-		// Knowledge-bound default policy:
-		//   - explicit task mode (`force_task: true`)             → Agent pipeline
-		//   - explicit RAG context system prompt (legacy support) → RAG pipeline
-		//   - otherwise                                            → RAG first,
-		//     which internally emits an out-of-scope response when
-		//     query topic is not covered by indexed knowledge.
-		if hasRAGContext(req.Messages) {
-			log.Printf("chat: route=rag user_id=%s reason=system_context", userID)
-			streamRAG(w, flusher, r, kb, userPrompt, userID)
-			return
+// startConversationTurn records the user's prompt, creating a new
+// conversation (titled after the prompt) when id is 0. It returns the
+// conversation ID to use and, on failure, the HTTP status to report:
+// 404 when id does not belong to userID, 500 otherwise.
+func startConversationTurn(ctx context.Context, convos db.ConversationRepository, id db.ConversationID, userID, prompt string) (db.ConversationID, int, error) {
+	if id == 0 {
+		newID, err := convos.CreateConversation(ctx, userID, previewPrompt(prompt))
+		if err != nil {
+			return 0, http.StatusInternalServerError, err
+		}
+		id = newID
+	}
+	if err := convos.AppendMessage(ctx, id, userID, "user", prompt); err != nil {
+		if errors.Is(err, db.ErrConversationNotFound) {
+			return 0, http.StatusNotFound, err
 		}
+		return 0, http.StatusInternalServerError, err
+	}
+	return id, 0, nil
+}
 
-		if agent.ShouldUseTaskAgent(userPrompt, req.ForceTask) {
-			reason := "task_intent"
-			if req.ForceTask {
-				reason = "force_task"
-			}
-			log.Printf("chat: route=agent user_id=%s reason=%s", userID, reason)
-			streamAgent(w, flusher, r, ta, userPrompt, userID, req.ForceTask)
-			return
+// finishConversationTurn stores the assistant reply. It runs detached from
+// request cancellation so a reply that was fully generated is still saved
+// when the client disconnects right at the end; failures are only logged.
+func finishConversationTurn(ctx context.Context, convos db.ConversationRepository, id db.ConversationID, userID, reply string) {
+	if strings.TrimSpace(reply) == "" {
+		return
+	}
+	saveCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 5*time.Second)
+	defer cancel()
+	if err := convos.AppendMessage(saveCtx, id, userID, "assistant", reply); err != nil {
+		logging.FromContext(ctx).Warn("chat: record assistant turn", "conversation_id", int64(id), "err", err)
+	}
+}
+
+// cleanSources trims each entry of sources in place and returns a non-empty
+// message if the list is too long or has an empty or over-long label.
+func cleanSources(sources []string) string {
+	if len(sources) > maxChatSources {
+		return fmt.Sprintf(`"sources" must contain at most %d entries`, maxChatSources)
+	}
+	for i, src := range sources {
+		src = strings.TrimSpace(src)
+		if src == "" || len(src) > 180 {
+			return `"sources" entries must be non-empty labels of at most 180 bytes`
+		}
+		sources[i] = src
+	}
+	return ""
+}
+
+// routeChat picks the pipeline for req and a short reason for the logs.
+// Knowledge-bound default policy:
+//   - explicit "mode" field                               → that pipeline
+//   - RAG context system prompt (fallback for old clients) → RAG pipeline
+//   - intent classifier, if enabled and force_task unset   → its pipeline
+//   - explicit task mode (`force_task: true`)             → Agent pipeline
+//   - task-like intent detected in the prompt              → Agent pipeline
+//   - otherwise                                            → RAG first,
+//     which internally emits an out-of-scope response when
+//     query topic is not covered by indexed knowledge.
+//
+// intents may be nil. If it fails, routing falls back to the heuristics.
+func routeChat(ctx context.Context, req chatRequest, userPrompt string, intents *agent.IntentClassifier) (route, reason string) {
+	if req.Mode != "" {
+		return req.Mode, "explicit_mode"
+	}
+	// A client that says it wants the knowledge base is taken at its word
+	// before the classifier gets a say.
+	if hasRAGContext(req.Messages) {
+		return routeRAG, "system_context"
+	}
+	if intents != nil && !req.ForceTask {
+		intent, err := intents.ClassifyIntent(ctx, userPrompt)
+		switch {
+		case err != nil:
+			logging.FromContext(ctx).Warn("chat: intent classifier failed, using heuristics", "err", err)
+		case intent == agent.IntentTask:
+			return routeAgent, "classified_task"
+		default:
+			return routeRAG, "classified_knowledge"
+		}
+	}
+	if agent.ShouldUseTaskAgent(userPrompt, req.ForceTask) {
+		if req.ForceTask {
+			return routeAgent, "force_task"
 		}
+		return routeAgent, "task_intent"
+	}
+	return routeRAG, "default"
+}
 
-		log.Printf("chat: route=rag user_id=%s reason=default", userID)
-		streamRAG(w, flusher, r, kb, userPrompt, userID)
+// systemInstructions returns the non-blank contents of the client's system
+// messages, in order. The agent appends them to its own system prompt.
+func systemInstructions(messages []apiMessage) []string {
+	var out []string
+	for _, m := range messages {
+		if m.Role != "system" {
+			continue
+		}
+		if text := strings.TrimSpace(m.Content); text != "" {
+			out = append(out, text)
+		}
 	}
+	return out
 }
 
 // hasRAGContext returns true when the message history contains a system
 // message whose content signals knowledge-base retrieval mode.
-// This keeps routing implicit in the conversation rather than a separate field.
+// Kept only as a fallback for clients that predate the "mode" field.
 func hasRAGContext(messages []apiMessage) bool {
 	for _, m := range messages {
 		if m.Role == "system" {
@@ -153,20 +473,46 @@ func hasRAGContext(messages []apiMessage) bool {
 
 // streamRAG runs AskKnowledgeBase and writes each text chunk as an SSE
 // "message" event. userID scopes retrieval to admin + user documents.
-func streamRAG(w http.ResponseWriter, f http.Flusher, r *http.Request, kb *agent.KnowledgeBase, query, userID string) {
-	ch, err := kb.AskKnowledgeBase(r.Context(), query, userID)
+// When the answer is grounded in documents, a "sources" event listing them
+// and a "meta" event carrying low_confidence are sent before the first
+// message so clients can render citations and warnings early. With
+// statusEvents a "status" event follows them, before the first message.
+// It returns the full text streamed to the client.
+func streamRAG(w http.ResponseWriter, f http.Flusher, r *http.Request, kb *agent.KnowledgeBase, query, userID string, opts agent.AskOptions, statusEvents bool) string {
+	answer, err := kb.AskKnowledgeBase(r.Context(), query, userID, opts)
 	if err != nil {
-		writeSSEError(w, f, err.Error())
-		return
+		if writeSSECancelled(w, f, r.Context()) {
+			return ""
+		}
+		logging.FromContext(r.Context()).Error("chat: rag pipeline", "err", err)
+		writeSSEError(w, f, err)
+		return ""
 	}
 
-	for chunk := range ch {
-		if chunk.Kind == llm.KindText && chunk.Text != "" {
-			writeSSEEvent(w, f, "message", map[string]any{
+	if len(answer.Sources) > 0 {
+		writeSSEEvent(w, f, eventSources, map[string]any{
+			"sources": answer.Sources,
+		})
+		writeSSEEvent(w, f, eventMeta, map[string]any{
+			"low_confidence": answer.LowConfidence,
+		})
+	}
+	writeSSEStatus(w, f, statusEvents)
+
+	var reply strings.Builder
+	for chunk := range answer.Stream {
+		switch {
+		case chunk.Kind == llm.KindText && chunk.Text != "":
+			reply.WriteString(chunk.Text)
+			writeSSEEvent(w, f, eventMessage, map[string]any{
 				"content": chunk.Text,
 			})
+		case chunk.Kind == llm.KindStats:
+			writeSSEEvent(w, f, eventStats, newStatsPayload(chunk.Stats))
 		}
 	}
+	writeSSECancelled(w, f, r.Context())
+	return reply.String()
 }
 
 // ── Agent pipeline ────────────────────────────────────────────────────────────
@@ -174,47 +520,161 @@ func streamRAG(w http.ResponseWriter, f http.Flusher, r *http.Request, kb *agent
 // streamAgent runs HandleAgentTask and maps each AgentEvent to its
 // corresponding SSE event type as defined in shared/api/sse_payloads.json.
 // userID is forwarded so created tasks are scoped to the requesting user.
-func streamAgent(w http.ResponseWriter, f http.Flusher, r *http.Request, ta *agent.TaskAgent, query, userID string, forceTask bool) {
-	ch, err := ta.HandleAgentTask(r.Context(), query, userID, forceTask)
+// With statusEvents a "status" event is sent once the model call is under
+// way and again after each tool result, while the follow-up is generated.
+// It returns the full prose text streamed to the client.
+func streamAgent(w http.ResponseWriter, f http.Flusher, r *http.Request, ta *agent.TaskAgent, query, userID string, opts agent.AgentOptions, statusEvents bool) string {
+	ch, err := ta.HandleAgentTask(r.Context(), query, userID, opts)
 	if err != nil {
-		writeSSEError(w, f, err.Error())
-		return
+		if writeSSECancelled(w, f, r.Context()) {
+			return ""
+		}
+		logging.FromContext(r.Context()).Error("chat: agent pipeline", "err", err)
+		writeSSEError(w, f, err)
+		return ""
 	}
+	writeSSEStatus(w, f, statusEvents)
 
+	var reply strings.Builder
 	for event := range ch {
 		switch event.Kind {
 
 		case agent.EventText:
 			if event.Text != "" {
-				writeSSEEvent(w, f, "message", map[string]any{
+				reply.WriteString(event.Text)
+				writeSSEEvent(w, f, eventMessage, map[string]any{
 					"content": event.Text,
 				})
 			}
 
 		case agent.EventToolCall:
 			// UI uses this to show a loading / executing state.
-			writeSSEEvent(w, f, "tool_call", map[string]any{
+			writeSSEEvent(w, f, eventToolCall, map[string]any{
 				"tool":   event.Tool,
 				"status": "executing",
 				"args":   event.Args,
 			})
 
 		case agent.EventToolDone:
-			// task_id serialised as a string per shared/api/sse_payloads.json.
-			writeSSEEvent(w, f, "tool_result", map[string]any{
+			// task_id serialised as a string per shared/api/sse_payloads.json;
+			// task is the stored row for the confirmation card.
+			writeSSEEvent(w, f, eventToolResult, map[string]any{
 				"tool":    event.Tool,
 				"status":  "success",
 				"task_id": strconv.FormatInt(event.TaskID, 10),
+				"args":    event.Args,
+				"task":    event.Task,
 			})
+			writeSSEStatus(w, f, statusEvents)
 
 		case agent.EventError:
-			writeSSEEvent(w, f, "tool_result", map[string]any{
+			writeSSEEvent(w, f, eventToolResult, map[string]any{
 				"tool":      event.Tool,
 				"status":    "error",
 				"error_msg": event.ErrMsg,
 			})
+			writeSSEStatus(w, f, statusEvents)
+
+		case agent.EventStats:
+			writeSSEEvent(w, f, eventStats, newStatsPayload(event.Stats))
+		}
+	}
+	writeSSECancelled(w, f, r.Context())
+	return reply.String()
+}
+
+// ragErrorMessage is the client-facing text for a failed pipeline. A
+// search failure gets a fixed message, since the wrapped Qdrant error
+// (URLs, dial errors) is for the logs; a missing chat model names the model
+// so an operator knows what to pull; anything else is reported as-is.
+func ragErrorMessage(err error) string {
+	if errors.Is(err, agent.ErrRetrieval) {
+		return "knowledge base search failed; please try again shortly"
+	}
+	var missing *llm.ModelNotFoundError
+	if errors.As(err, &missing) {
+		return fmt.Sprintf("the chat model %q is not available on the server; an operator needs to install it (e.g. ollama pull %s)", missing.Model, missing.Model)
+	}
+	return err.Error()
+}
+
+// ── Non-streaming pipelines ───────────────────────────────────────────────────
+
+// collectRAG runs AskKnowledgeBase to completion and returns the
+// concatenated answer plus its sources as a chatResponse.
+func collectRAG(r *http.Request, kb *agent.KnowledgeBase, query, userID string, opts agent.AskOptions) (chatResponse, error) {
+	answer, err := kb.AskKnowledgeBase(r.Context(), query, userID, opts)
+	if err != nil {
+		return chatResponse{}, err
+	}
+
+	var (
+		sb    strings.Builder
+		stats *llm.Stats
+	)
+	for chunk := range answer.Stream {
+		switch chunk.Kind {
+		case llm.KindText:
+			sb.WriteString(chunk.Text)
+		case llm.KindStats:
+			stats = chunk.Stats
+		}
+	}
+
+	return chatResponse{
+		Content:       sb.String(),
+		Sources:       sourceLabels(answer.Sources),
+		SourceDetails: answer.Sources,
+		LowConfidence: answer.LowConfidence,
+		Stats:         newStatsPayload(stats),
+	}, nil
+}
+
+// collectAgent runs HandleAgentTask to completion and folds its events into
+// a single chatResponse: text is concatenated, a created task's ID is
+// reported in task_id, and a tool failure is reported in error.
+func collectAgent(r *http.Request, ta *agent.TaskAgent, query, userID string, opts agent.AgentOptions) (chatResponse, error) {
+	ch, err := ta.HandleAgentTask(r.Context(), query, userID, opts)
+	if err != nil {
+		return chatResponse{}, err
+	}
+
+	var (
+		sb   strings.Builder
+		resp chatResponse
+	)
+	for event := range ch {
+		switch event.Kind {
+		case agent.EventText:
+			sb.WriteString(event.Text)
+		case agent.EventToolDone:
+			resp.TaskID = strconv.FormatInt(event.TaskID, 10)
+			resp.Task = event.Task
+		case agent.EventError:
+			resp.Error = event.ErrMsg
+		case agent.EventStats:
+			resp.Stats = newStatsPayload(event.Stats)
 		}
 	}
+	resp.Content = sb.String()
+	return resp, nil
+}
+
+// sourceLabels returns the source label of each entry in sources.
+func sourceLabels(sources []agent.Source) []string {
+	if len(sources) == 0 {
+		return nil
+	}
+	labels := make([]string, len(sources))
+	for i, src := range sources {
+		labels[i] = src.Source
+	}
+	return labels
+}
+
+func writeChatJSON(w http.ResponseWriter, resp chatResponse) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
 }
 
 // ── SSE helpers ───────────────────────────────────────────────────────────────
@@ -227,20 +687,65 @@ func streamAgent(w http.ResponseWriter, f http.Flusher, r *http.Request, ta *age
 //
 // It flushes immediately so the client receives the frame without waiting for
 // the connection to close.
-func writeSSEEvent(w http.ResponseWriter, f http.Flusher, event string, data any) {
+func writeSSEEvent(w http.ResponseWriter, f http.Flusher, event sseEvent, data any) {
 	payload, err := json.Marshal(data)
 	if err != nil {
 		// JSON marshalling of our own structs should never fail; log and skip.
-		fmt.Fprintf(w, "event: error\ndata: {\"error\":\"marshal failure\"}\n\n")
+		fmt.Fprintf(w, "event: %s\ndata: {\"error\":\"marshal failure\"}\n\n", eventError.Name)
 		f.Flush()
 		return
 	}
-	fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, payload)
+	fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event.Name, payload)
 	f.Flush()
 }
 
-// writeSSEError writes a single SSE "error" event and flushes.
-// Used only for pipeline startup failures before any other events are written.
-func writeSSEError(w http.ResponseWriter, f http.Flusher, msg string) {
-	writeSSEEvent(w, f, "error", map[string]string{"error": msg})
+// writeSSEStatus sends the "generating" status event when enabled.
+func writeSSEStatus(w http.ResponseWriter, f http.Flusher, enabled bool) {
+	if enabled {
+		writeSSEEvent(w, f, eventStatus, map[string]string{"status": "generating"})
+	}
+}
+
+// pipelineUnavailable reports whether err is a dependency being unavailable
+// (search backend down, chat model not installed) rather than a failure of
+// the request itself.
+func pipelineUnavailable(err error) bool {
+	return errors.Is(err, agent.ErrRetrieval) || errors.Is(err, llm.ErrModelNotFound)
+}
+
+// writeSSEError reports a pipeline startup failure as a single SSE "error"
+// event. The stream's HTTP status is already 200, so failures a
+// non-streaming request would answer with 503 carry "status": 503 instead,
+// telling the client the service is unavailable rather than broken.
+func writeSSEError(w http.ResponseWriter, f http.Flusher, err error) {
+	payload := map[string]any{"error": ragErrorMessage(err)}
+	if pipelineUnavailable(err) {
+		payload["status"] = http.StatusServiceUnavailable
+	}
+	writeSSEEvent(w, f, eventError, payload)
+}
+
+// writeSSECancelled writes a final cancelled event if ctx has ended, and
+// reports whether it did. When the client disconnected the write simply
+// fails, which writeSSEEvent ignores; when only a deadline fired, a client
+// still reading learns the stream was cut short on purpose. A stream stopped
+// through POST /api/v1/chat/abort reports reason "aborted".
+func writeSSECancelled(w http.ResponseWriter, f http.Flusher, ctx context.Context) bool {
+	err := ctx.Err()
+	if err == nil {
+		return false
+	}
+	if errors.Is(context.Cause(ctx), errServerShuttingDown) {
+		writeSSEEvent(w, f, eventShuttingDown, map[string]string{"message": "server is shutting down; retry the request"})
+		return true
+	}
+	reason := "cancelled"
+	switch {
+	case errors.Is(context.Cause(ctx), errStreamAborted):
+		reason = "aborted"
+	case errors.Is(err, context.DeadlineExceeded):
+		reason = "deadline_exceeded"
+	}
+	writeSSEEvent(w, f, eventCancelled, map[string]string{"reason": reason})
+	return true
 }
@@ -0,0 +1,851 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"core-go/internal/agent"
+	"core-go/internal/db"
+	"core-go/internal/llm"
+	"core-go/internal/vector"
+	"core-go/internal/vector/qdranttest"
+)
+
+func TestPreviewPrompt(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{"short", "  hello  ", "hello"},
+		{"exact limit", strings.Repeat("a", previewRunes), strings.Repeat("a", previewRunes)},
+		{"ascii over limit", strings.Repeat("a", previewRunes+1), strings.Repeat("a", previewRunes) + "..."},
+		{"multi-byte over limit", strings.Repeat("é", previewRunes+5), strings.Repeat("é", previewRunes) + "..."},
+		{"multi-byte under limit", strings.Repeat("日本", 50), strings.Repeat("日本", 50)},
+		// 119 ASCII bytes then a 3-byte rune straddling byte 120.
+		{"rune straddles byte limit", strings.Repeat("a", 119) + "日本語", strings.Repeat("a", 119) + "日..."},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := previewPrompt(tt.in)
+			if got != tt.want {
+				t.Errorf("previewPrompt() = %q, want %q", got, tt.want)
+			}
+			if !utf8.ValidString(got) {
+				t.Errorf("previewPrompt() returned invalid UTF-8 %q", got)
+			}
+		})
+	}
+}
+
+// fakeConversations is an in-memory db.ConversationRepository whose
+// failures can be injected per method.
+type fakeConversations struct {
+	createErr error
+	appendErr error
+	titles    []string
+	messages  []string
+}
+
+func (f *fakeConversations) CreateConversation(_ context.Context, _, title string) (db.ConversationID, error) {
+	if f.createErr != nil {
+		return 0, f.createErr
+	}
+	f.titles = append(f.titles, title)
+	return db.ConversationID(len(f.titles)), nil
+}
+
+func (f *fakeConversations) ListConversations(context.Context, string) ([]db.Conversation, error) {
+	return nil, nil
+}
+
+func (f *fakeConversations) AppendMessage(_ context.Context, _ db.ConversationID, _, role, content string) error {
+	if f.appendErr != nil {
+		return f.appendErr
+	}
+	f.messages = append(f.messages, role+": "+content)
+	return nil
+}
+
+func (f *fakeConversations) ListMessages(context.Context, db.ConversationID, string) ([]db.ChatMessage, error) {
+	return nil, nil
+}
+
+func (f *fakeConversations) DeleteAllForUser(context.Context, string) (int64, error) {
+	return 0, nil
+}
+
+func TestStartConversationTurn(t *testing.T) {
+	notFound := fmt.Errorf("conversation_repository: append: conversation 7: %w", db.ErrConversationNotFound)
+	tests := []struct {
+		name       string
+		id         db.ConversationID
+		repo       *fakeConversations
+		wantID     db.ConversationID
+		wantStatus int
+	}{
+		{"new conversation", 0, &fakeConversations{}, 1, 0},
+		{"existing conversation", 7, &fakeConversations{}, 7, 0},
+		{"create fails", 0, &fakeConversations{createErr: errors.New("db down")}, 0, http.StatusInternalServerError},
+		{"not owned", 7, &fakeConversations{appendErr: notFound}, 0, http.StatusNotFound},
+		{"append db error", 7, &fakeConversations{appendErr: errors.New("connection reset")}, 0, http.StatusInternalServerError},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			id, status, err := startConversationTurn(context.Background(), tt.repo, tt.id, "u1", "hello")
+			if id != tt.wantID || status != tt.wantStatus {
+				t.Fatalf("startConversationTurn() = (%d, %d, %v), want (%d, %d)", id, status, err, tt.wantID, tt.wantStatus)
+			}
+			if (err != nil) != (tt.wantStatus != 0) {
+				t.Fatalf("startConversationTurn() err = %v with status %d", err, status)
+			}
+		})
+	}
+}
+
+func TestStartConversationTurnTitleIsValidUTF8(t *testing.T) {
+	repo := &fakeConversations{}
+	prompt := strings.Repeat("a", 119) + "日本語の質問"
+	if _, _, err := startConversationTurn(context.Background(), repo, 0, "u1", prompt); err != nil {
+		t.Fatal(err)
+	}
+	if len(repo.titles) != 1 || !utf8.ValidString(repo.titles[0]) {
+		t.Fatalf("title = %q, want valid UTF-8", repo.titles)
+	}
+}
+
+func TestChatResponseSourcesWireFormat(t *testing.T) {
+	tests := []struct {
+		name    string
+		sources []agent.Source
+		want    string
+	}{
+		{"no sources", nil, `{"content":"hi","conversation_id":0}`},
+		{
+			"labels and details",
+			[]agent.Source{{Source: "a.md"}, {Source: "b.md", Title: "B", URL: "https://example.com/b"}},
+			`{"content":"hi","sources":["a.md","b.md"],"source_details":[{"source":"a.md"},{"source":"b.md","title":"B","url":"https://example.com/b"}],"conversation_id":0}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := chatResponse{Content: "hi", Sources: sourceLabels(tt.sources), SourceDetails: tt.sources}
+			got, err := json.Marshal(resp)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("json = %s\nwant   %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRouteChat(t *testing.T) {
+	ragSystem := []apiMessage{{Role: "system", Content: "Answer from the knowledge base."}}
+	classifier := agent.NewIntentClassifier(llm.NewFakeEmbedder())
+	tests := []struct {
+		name       string
+		req        chatRequest
+		prompt     string
+		intents    *agent.IntentClassifier
+		wantRoute  string
+		wantReason string
+	}{
+		{"explicit mode", chatRequest{Mode: routeAgent, Messages: ragSystem}, "what is rome", classifier, routeAgent, "explicit_mode"},
+		{"rag context before classifier", chatRequest{Messages: ragSystem}, "list my todos", classifier, routeRAG, "system_context"},
+		{"classifier task", chatRequest{}, "list my todos", classifier, routeAgent, "classified_task"},
+		{"classifier knowledge", chatRequest{}, "why is the sky blue", classifier, routeRAG, "classified_knowledge"},
+		{"rag context without classifier", chatRequest{Messages: ragSystem}, "remind me to call mom", nil, routeRAG, "system_context"},
+		{"heuristic task", chatRequest{}, "remind me to call mom", nil, routeAgent, "task_intent"},
+		{"force task skips classifier", chatRequest{ForceTask: true}, "why is the sky blue", classifier, routeAgent, "force_task"},
+		{"explicit rag beats task intent", chatRequest{Mode: routeRAG}, "remind me to call mom", nil, routeRAG, "explicit_mode"},
+		{"unrelated system message ignored", chatRequest{Messages: []apiMessage{{Role: "system", Content: "Be brief."}}}, "remind me to call mom", nil, routeAgent, "task_intent"},
+		{"default", chatRequest{}, "who was Rama", nil, routeRAG, "default"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			route, reason := routeChat(context.Background(), tt.req, tt.prompt, tt.intents)
+			if route != tt.wantRoute || reason != tt.wantReason {
+				t.Errorf("routeChat() = (%s, %s), want (%s, %s)", route, reason, tt.wantRoute, tt.wantReason)
+			}
+		})
+	}
+}
+
+// newTestChatHandler returns a chatHandler over the fake LLM providers, kb
+// and tasks, with no intent classifier or stream limit.
+func newTestChatHandler(kb *agent.KnowledgeBase, tasks db.TaskRepository) http.HandlerFunc {
+	return chatHandler(kb, agent.NewTaskAgent(tasks, llm.FakeChatProvider{}), &fakeConversations{}, agent.AskOptions{},
+		historyLimit{MaxTurns: 50}, nil, nil, newStreamRegistry(), false)
+}
+
+// chatBody returns a chat request body with one user message.
+func chatBody(prompt string, fields map[string]any) string {
+	req := map[string]any{"messages": []apiMessage{{Role: "user", Content: prompt}}, "user_id": testUser}
+	for k, v := range fields {
+		req[k] = v
+	}
+	b, _ := json.Marshal(req)
+	return string(b)
+}
+
+func TestChatHandlerNonStreaming(t *testing.T) {
+	const question = "Where is the Colosseum amphitheatre?"
+	tests := []struct {
+		name        string
+		ingest      bool
+		fields      map[string]any
+		wantContent string
+		wantSources []string
+		wantTaskID  string
+	}{
+		{"rag answer with sources", true, map[string]any{"mode": routeRAG, "stream": false},
+			"Fake answer: " + question, []string{"rome.md"}, ""},
+		{"agent creates a task", false, map[string]any{"mode": routeAgent, "force_task": true, "stream": false},
+			"Fake answer: " + question, nil, "1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			kb, _ := newTestKB(t)
+			if tt.ingest {
+				if _, err := kb.IngestText(context.Background(), "The Colosseum is an ancient amphitheatre in Rome.", "rome.md", testUser, agent.IngestOptions{}); err != nil {
+					t.Fatal(err)
+				}
+			}
+			tasks := &memTaskRepo{}
+			rec := serve(newTestChatHandler(kb, tasks), http.MethodPost, "/api/v1/chat", "", chatBody(question, tt.fields))
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+			}
+			if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+				t.Errorf("Content-Type = %q, want application/json", ct)
+			}
+			var resp chatResponse
+			if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+				t.Fatal(err)
+			}
+			if resp.Content != tt.wantContent {
+				t.Errorf("content = %q, want %q", resp.Content, tt.wantContent)
+			}
+			if fmt.Sprint(resp.Sources) != fmt.Sprint(tt.wantSources) {
+				t.Errorf("sources = %v, want %v", resp.Sources, tt.wantSources)
+			}
+			if resp.TaskID != tt.wantTaskID {
+				t.Errorf("task_id = %q, want %q", resp.TaskID, tt.wantTaskID)
+			}
+			if tt.wantTaskID != "" && (resp.Task == nil || resp.Task.Title != question) {
+				t.Errorf("task = %+v, want the created task titled %q", resp.Task, question)
+			}
+			if resp.ConversationID != 1 || rec.Header().Get(conversationIDHeader) != "1" {
+				t.Errorf("conversation_id = %d (header %q), want 1", resp.ConversationID, rec.Header().Get(conversationIDHeader))
+			}
+		})
+	}
+}
+
+func TestChatHandlerMode(t *testing.T) {
+	tests := []struct {
+		name       string
+		mode       string
+		wantStatus int
+		wantTask   bool
+	}{
+		{"explicit agent", "agent", http.StatusOK, true},
+		{"case and space normalised", " RAG ", http.StatusOK, false},
+		{"omitted infers the route", "", http.StatusOK, true},
+		{"unknown mode rejected", "search", http.StatusBadRequest, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			kb, _ := newTestKB(t)
+			tasks := &memTaskRepo{}
+			body := chatBody("remind me to call mom", map[string]any{"mode": tt.mode, "stream": false})
+			rec := serve(newTestChatHandler(kb, tasks), http.MethodPost, "/api/v1/chat", "", body)
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+			if created := len(tasks.tasks) > 0; created != tt.wantTask {
+				t.Errorf("task created = %v, want %v (agent route)", created, tt.wantTask)
+			}
+		})
+	}
+}
+
+// parsedEvent is one server-sent event read back from a response.
+type parsedEvent struct {
+	name string
+	data string
+}
+
+// parseSSE splits a recorded SSE body into its events.
+func parseSSE(body string) []parsedEvent {
+	var events []parsedEvent
+	for _, block := range strings.Split(strings.TrimSpace(body), "\n\n") {
+		var ev parsedEvent
+		for _, line := range strings.Split(block, "\n") {
+			if name, ok := strings.CutPrefix(line, "event: "); ok {
+				ev.name = name
+			} else if data, ok := strings.CutPrefix(line, "data: "); ok {
+				ev.data = data
+			}
+		}
+		events = append(events, ev)
+	}
+	return events
+}
+
+func TestChatHandlerReportsStats(t *testing.T) {
+	tests := []struct {
+		name   string
+		fields map[string]any
+	}{
+		{"rag stream", map[string]any{"mode": routeRAG}},
+		{"agent stream", map[string]any{"mode": routeAgent, "force_task": true}},
+		{"rag json", map[string]any{"mode": routeRAG, "stream": false}},
+		{"agent json", map[string]any{"mode": routeAgent, "force_task": true, "stream": false}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			kb, _ := newTestKB(t)
+			if _, err := kb.IngestText(context.Background(), "The Colosseum is an ancient amphitheatre in Rome.", "rome.md", testUser, agent.IngestOptions{}); err != nil {
+				t.Fatal(err)
+			}
+			rec := serve(newTestChatHandler(kb, &memTaskRepo{}), http.MethodPost, "/api/v1/chat", "", chatBody("Where is the Colosseum?", tt.fields))
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+			}
+
+			var stats *statsPayload
+			if tt.fields["stream"] == false {
+				var resp chatResponse
+				if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+					t.Fatal(err)
+				}
+				stats = resp.Stats
+			} else {
+				events := parseSSE(rec.Body.String())
+				last := events[len(events)-1]
+				if last.name != eventStats.Name {
+					t.Fatalf("last event = %q, want %q", last.name, eventStats.Name)
+				}
+				if err := json.Unmarshal([]byte(last.data), &stats); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if stats == nil || stats.PromptTokens == 0 || stats.CompletionTokens == 0 {
+				t.Errorf("stats = %+v, want prompt and completion token counts", stats)
+			}
+		})
+	}
+}
+
+func TestChatHandlerToolResultEvent(t *testing.T) {
+	const prompt = "remind me to call mom"
+	tests := []struct {
+		name       string
+		tasksErr   error
+		wantStatus string
+	}{
+		{"task created", nil, "success"},
+		{"create failed carries no task", errors.New("db down"), "error"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			kb, _ := newTestKB(t)
+			tasks := &memTaskRepo{err: tt.tasksErr}
+			rec := serve(newTestChatHandler(kb, tasks), http.MethodPost, "/api/v1/chat", "", chatBody(prompt, map[string]any{"mode": routeAgent, "force_task": true}))
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+			}
+
+			var results []parsedEvent
+			for _, ev := range parseSSE(rec.Body.String()) {
+				if ev.name == eventToolResult.Name {
+					results = append(results, ev)
+				}
+			}
+			if len(results) != 1 {
+				t.Fatalf("tool_result events = %v, want one", results)
+			}
+			var got struct {
+				Tool   string         `json:"tool"`
+				Status string         `json:"status"`
+				TaskID string         `json:"task_id"`
+				Args   map[string]any `json:"args"`
+				Task   *db.Task       `json:"task"`
+			}
+			if err := json.Unmarshal([]byte(results[0].data), &got); err != nil {
+				t.Fatal(err)
+			}
+			if got.Status != tt.wantStatus {
+				t.Fatalf("tool_result status = %q, want %q: %s", got.Status, tt.wantStatus, results[0].data)
+			}
+			if tt.wantStatus != "success" {
+				if got.Task != nil || got.TaskID != "" {
+					t.Errorf("tool_result = %s, want no task after a failed create", results[0].data)
+				}
+				return
+			}
+			if got.Tool != "create_task" || got.TaskID != "1" {
+				t.Errorf("tool_result = %s, want create_task with task_id \"1\"", results[0].data)
+			}
+			if got.Args["title"] != prompt {
+				t.Errorf("args = %v, want title %q", got.Args, prompt)
+			}
+			want := tasks.tasks[0]
+			if got.Task == nil || got.Task.ID != want.ID || got.Task.Title != prompt ||
+				got.Task.Priority != want.Priority || got.Task.Status != want.Status || got.Task.UserID != testUser {
+				t.Errorf("task = %+v, want the stored row %+v", got.Task, want)
+			}
+		})
+	}
+}
+
+func TestChatHandlerGenerationOptions(t *testing.T) {
+	tests := []struct {
+		name       string
+		fields     map[string]any
+		wantStatus int
+		wantErr    string
+	}{
+		{"in range", map[string]any{"temperature": 1.1, "top_p": 0.9, "seed": 3}, http.StatusOK, ""},
+		{"omitted", nil, http.StatusOK, ""},
+		{"temperature too high", map[string]any{"temperature": 2.5}, http.StatusBadRequest, "temperature"},
+		{"top_p zero", map[string]any{"top_p": 0}, http.StatusBadRequest, "top_p"},
+		{"negative seed", map[string]any{"seed": -1}, http.StatusBadRequest, "seed"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			kb, _ := newTestKB(t)
+			fields := map[string]any{"mode": routeRAG, "stream": false}
+			for k, v := range tt.fields {
+				fields[k] = v
+			}
+			rec := serve(newTestChatHandler(kb, &memTaskRepo{}), http.MethodPost, "/api/v1/chat", "", chatBody("Where is the Colosseum?", fields))
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+			if tt.wantErr != "" && !strings.Contains(rec.Body.String(), tt.wantErr) {
+				t.Errorf("body = %q, want it to name %s", rec.Body, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestChatHandlerStatusEvent(t *testing.T) {
+	tests := []struct {
+		name    string
+		fields  map[string]any
+		enabled bool
+		want    []string // event names, stream/stats aside
+	}{
+		{"rag", map[string]any{"mode": routeRAG}, true,
+			[]string{"sources", "meta", "status", "message", "message"}},
+		{"agent", map[string]any{"mode": routeAgent, "force_task": true}, true,
+			[]string{"status", "tool_call", "tool_result", "status", "message", "message"}},
+		{"rag disabled", map[string]any{"mode": routeRAG}, false,
+			[]string{"sources", "meta", "message", "message"}},
+		{"agent disabled", map[string]any{"mode": routeAgent, "force_task": true}, false,
+			[]string{"tool_call", "tool_result", "message", "message"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			kb, _ := newTestKB(t)
+			if _, err := kb.IngestText(context.Background(), "The Colosseum is an ancient amphitheatre in Rome.", "rome.md", testUser, agent.IngestOptions{}); err != nil {
+				t.Fatal(err)
+			}
+			h := chatHandler(kb, agent.NewTaskAgent(&memTaskRepo{}, llm.FakeChatProvider{}), &fakeConversations{}, agent.AskOptions{},
+				historyLimit{MaxTurns: 50}, nil, nil, newStreamRegistry(), tt.enabled)
+			rec := serve(h, http.MethodPost, "/api/v1/chat", "", chatBody("Where is the Colosseum?", tt.fields))
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+			}
+
+			var got []string
+			for _, ev := range parseSSE(rec.Body.String()) {
+				switch ev.name {
+				case eventStream.Name, eventStats.Name:
+				case eventStatus.Name:
+					if ev.data != `{"status":"generating"}` {
+						t.Errorf("status data = %s", ev.data)
+					}
+					fallthrough
+				default:
+					got = append(got, ev.name)
+				}
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("events = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestChatHandlerIdempotencyKey(t *testing.T) {
+	tests := []struct {
+		name       string
+		keys       []string // Idempotency-Key per request
+		wantStatus int
+		wantTasks  int
+	}{
+		{"retry with the same key", []string{"retry-1", "retry-1"}, http.StatusOK, 1},
+		{"different keys", []string{"a", "b"}, http.StatusOK, 2},
+		{"no key", []string{"", ""}, http.StatusOK, 2},
+		{"key too long", []string{strings.Repeat("k", agent.MaxIdempotencyKeyLen+1)}, http.StatusBadRequest, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			kb, _ := newTestKB(t)
+			tasks := &memTaskRepo{}
+			h := newTestChatHandler(kb, tasks)
+			var ids []string
+			for _, key := range tt.keys {
+				req := httptest.NewRequest(http.MethodPost, "/api/v1/chat", strings.NewReader(chatBody("Buy milk", map[string]any{"mode": routeAgent, "force_task": true, "stream": false})))
+				if key != "" {
+					req.Header.Set(idempotencyKeyHeader, key)
+				}
+				rec := httptest.NewRecorder()
+				h(rec, req)
+				if rec.Code != tt.wantStatus {
+					t.Fatalf("status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body)
+				}
+				if rec.Code != http.StatusOK {
+					continue
+				}
+				var resp chatResponse
+				if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+					t.Fatal(err)
+				}
+				ids = append(ids, resp.TaskID)
+			}
+			if len(tasks.tasks) != tt.wantTasks {
+				t.Errorf("created %d tasks (task_ids %v), want %d", len(tasks.tasks), ids, tt.wantTasks)
+			}
+			if tt.wantTasks == 1 && len(ids) == 2 && ids[0] != ids[1] {
+				t.Errorf("retry returned task_id %s, want the original %s", ids[1], ids[0])
+			}
+		})
+	}
+}
+
+func TestHistoryLimit(t *testing.T) {
+	history := func(n int) []apiMessage {
+		msgs := make([]apiMessage, n)
+		for i := range msgs {
+			msgs[i] = apiMessage{Role: "user", Content: fmt.Sprintf("message %d", i)}
+		}
+		return msgs
+	}
+	tests := []struct {
+		name       string
+		limit      historyLimit
+		messages   int
+		wantKept   []string // first and last kept contents
+		wantStatus int
+	}{
+		{"within the limit", historyLimit{MaxTurns: 3}, 3, []string{"message 0", "message 2"}, http.StatusOK},
+		{"over the limit rejected", historyLimit{MaxTurns: 3}, 4, nil, http.StatusBadRequest},
+		{"over the limit truncated", historyLimit{MaxTurns: 3, Truncate: true}, 5, []string{"message 2", "message 4"}, http.StatusOK},
+		{"zero disables the limit", historyLimit{}, 60, []string{"message 0", "message 59"}, http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			kept, ok := tt.limit.apply(history(tt.messages))
+			if ok != (tt.wantKept != nil) {
+				t.Fatalf("apply() ok = %v, want %v", ok, tt.wantKept != nil)
+			}
+			if ok {
+				if got := []string{kept[0].Content, kept[len(kept)-1].Content}; fmt.Sprint(got) != fmt.Sprint(tt.wantKept) {
+					t.Errorf("apply() kept %v, want %v", got, tt.wantKept)
+				}
+			}
+
+			kb, _ := newTestKB(t)
+			h := chatHandler(kb, agent.NewTaskAgent(&memTaskRepo{}, llm.FakeChatProvider{}), &fakeConversations{}, agent.AskOptions{},
+				tt.limit, nil, nil, newStreamRegistry(), false)
+			body, _ := json.Marshal(map[string]any{"messages": history(tt.messages), "user_id": testUser, "mode": routeRAG, "stream": false})
+			rec := serve(h, http.MethodPost, "/api/v1/chat", "", string(body))
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+		})
+	}
+}
+
+func TestChatHandlerMetaEvent(t *testing.T) {
+	tests := []struct {
+		name     string
+		ingest   bool
+		wantMeta bool
+	}{
+		{"grounded answer carries meta after sources", true, true},
+		{"boundary reply has no meta", false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			kb, _ := newTestKB(t)
+			if tt.ingest {
+				if _, err := kb.IngestText(context.Background(), "The Colosseum is an ancient amphitheatre in Rome.", "rome.md", testUser, agent.IngestOptions{}); err != nil {
+					t.Fatal(err)
+				}
+			}
+			rec := serve(newTestChatHandler(kb, &memTaskRepo{}), http.MethodPost, "/api/v1/chat", "", chatBody("Where is the Colosseum amphitheatre?", map[string]any{"mode": routeRAG}))
+			var names []string
+			var meta map[string]any
+			for _, ev := range parseSSE(rec.Body.String()) {
+				names = append(names, ev.name)
+				if ev.name == eventMeta.Name {
+					if err := json.Unmarshal([]byte(ev.data), &meta); err != nil {
+						t.Fatal(err)
+					}
+				}
+			}
+			if (meta != nil) != tt.wantMeta {
+				t.Fatalf("events %v: meta present = %v, want %v", names, meta != nil, tt.wantMeta)
+			}
+			if !tt.wantMeta {
+				return
+			}
+			if _, ok := meta["low_confidence"].(bool); !ok {
+				t.Errorf("meta = %v, want a boolean low_confidence", meta)
+			}
+			if i := slices.Index(names, eventMeta.Name); i == 0 || names[i-1] != eventSources.Name {
+				t.Errorf("events %v: want meta directly after sources", names)
+			}
+		})
+	}
+}
+
+func TestChatHandlerRetrievalError(t *testing.T) {
+	tests := []struct {
+		name        string
+		collection  bool // without it every Qdrant search fails
+		stream      bool
+		wantStatus  int
+		wantEvent   string // last SSE event when streaming
+		wantContent string // text of that event, or of the JSON response
+	}{
+		{"stream: empty result", true, true, http.StatusOK, eventMessage.Name, "I don't have information on that topic."},
+		{"stream: search failure", false, true, http.StatusOK, eventError.Name, "knowledge base search failed; please try again shortly"},
+		{"json: empty result", true, false, http.StatusOK, "", "I don't have information on that topic."},
+		{"json: search failure", false, false, http.StatusServiceUnavailable, "", "knowledge base search failed; please try again shortly"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var kb *agent.KnowledgeBase
+			if tt.collection {
+				kb, _ = newTestKB(t)
+			} else {
+				srv := qdranttest.NewServer()
+				t.Cleanup(srv.Close)
+				kb = agent.NewKnowledgeBase(vector.NewQdrantClient(srv.URL), llm.NewFakeEmbedder(), llm.FakeChatProvider{})
+			}
+			body := chatBody("Where is the Colosseum?", map[string]any{"mode": routeRAG, "stream": tt.stream})
+			rec := serve(newTestChatHandler(kb, &memTaskRepo{}), http.MethodPost, "/api/v1/chat", "", body)
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+
+			var content string
+			switch {
+			case tt.stream:
+				events := parseSSE(rec.Body.String())
+				last := events[len(events)-1]
+				if last.name != tt.wantEvent {
+					t.Fatalf("last event = %q, want %q (events: %v)", last.name, tt.wantEvent, events)
+				}
+				var data struct{ Content, Error string }
+				if err := json.Unmarshal([]byte(last.data), &data); err != nil {
+					t.Fatal(err)
+				}
+				content = data.Content + data.Error
+			case rec.Code == http.StatusOK:
+				var resp chatResponse
+				if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+					t.Fatal(err)
+				}
+				content = resp.Content
+			default:
+				content = strings.TrimSpace(rec.Body.String())
+			}
+			if content != tt.wantContent {
+				t.Errorf("content = %q, want %q", content, tt.wantContent)
+			}
+		})
+	}
+}
+
+func TestCleanSources(t *testing.T) {
+	many := make([]string, maxChatSources+1)
+	for i := range many {
+		many[i] = fmt.Sprintf("doc-%d.md", i)
+	}
+	tests := []struct {
+		name    string
+		sources []string
+		want    []string
+		wantErr bool
+	}{
+		{"none", nil, nil, false},
+		{"trimmed", []string{" handbook.pdf ", "notes.md"}, []string{"handbook.pdf", "notes.md"}, false},
+		{"at the limit", many[:maxChatSources], many[:maxChatSources], false},
+		{"too many", many, nil, true},
+		{"blank entry", []string{"notes.md", "  "}, nil, true},
+		{"over-long entry", []string{strings.Repeat("s", 181)}, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sources := append([]string(nil), tt.sources...)
+			msg := cleanSources(sources)
+			if (msg != "") != tt.wantErr {
+				t.Fatalf("cleanSources() = %q, wantErr %v", msg, tt.wantErr)
+			}
+			if !tt.wantErr && fmt.Sprint(sources) != fmt.Sprint(tt.want) {
+				t.Errorf("sources = %q, want %q", sources, tt.want)
+			}
+		})
+	}
+}
+
+func TestWriteSSECancelled(t *testing.T) {
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+	expired, cancelExpired := context.WithDeadline(context.Background(), time.Now().Add(-time.Second))
+	defer cancelExpired()
+	tests := []struct {
+		name       string
+		ctx        context.Context
+		want       bool
+		wantReason string
+	}{
+		{"live context writes nothing", context.Background(), false, ""},
+		{"client cancelled", cancelled, true, "cancelled"},
+		{"deadline exceeded", expired, true, "deadline_exceeded"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			if got := writeSSECancelled(rec, rec, tt.ctx); got != tt.want {
+				t.Errorf("writeSSECancelled() = %v, want %v", got, tt.want)
+			}
+			events := parseSSE(rec.Body.String())
+			if !tt.want {
+				if rec.Body.Len() != 0 {
+					t.Errorf("wrote %q, want nothing", rec.Body)
+				}
+				return
+			}
+			var payload struct{ Reason string }
+			if len(events) != 1 || events[0].name != eventCancelled.Name || json.Unmarshal([]byte(events[0].data), &payload) != nil {
+				t.Fatalf("events = %v, want one %s event", events, eventCancelled.Name)
+			}
+			if payload.Reason != tt.wantReason {
+				t.Errorf("reason = %q, want %q", payload.Reason, tt.wantReason)
+			}
+		})
+	}
+}
+
+func TestChatHandlerStreamCancelled(t *testing.T) {
+	tests := []struct {
+		name   string
+		fields map[string]any
+	}{
+		{"rag stream", map[string]any{"mode": routeRAG}},
+		{"agent stream", map[string]any{"mode": routeAgent, "force_task": true}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			kb, _ := newTestKB(t)
+			if _, err := kb.IngestText(context.Background(), "The Colosseum is an ancient amphitheatre in Rome.", "rome.md", testUser, agent.IngestOptions{}); err != nil {
+				t.Fatal(err)
+			}
+			tasks := &memTaskRepo{}
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel() // the client is gone before the pipeline starts
+			req := httptest.NewRequestWithContext(ctx, http.MethodPost, "/api/v1/chat", strings.NewReader(chatBody("Where is the Colosseum?", tt.fields)))
+			rec := httptest.NewRecorder()
+			newTestChatHandler(kb, tasks)(rec, req)
+
+			events := parseSSE(rec.Body.String())
+			last := events[len(events)-1]
+			if last.name != eventCancelled.Name {
+				t.Fatalf("last event = %q (%s), want %q", last.name, rec.Body, eventCancelled.Name)
+			}
+			for _, ev := range events {
+				if ev.name == eventError.Name {
+					t.Errorf("stream reported an error for a cancellation: %s", ev.data)
+				}
+			}
+			if len(tasks.tasks) != 0 {
+				t.Errorf("created %d tasks after cancellation, want 0", len(tasks.tasks))
+			}
+		})
+	}
+}
+
+func TestSystemInstructions(t *testing.T) {
+	tests := []struct {
+		name     string
+		messages []apiMessage
+		want     []string
+	}{
+		{"no system messages", []apiMessage{{Role: "user", Content: "hi"}}, nil},
+		{"system messages in order", []apiMessage{
+			{Role: "system", Content: " Reply in French. "},
+			{Role: "user", Content: "hi"},
+			{Role: "system", Content: "Use metric units."},
+		}, []string{"Reply in French.", "Use metric units."}},
+		{"blank system message skipped", []apiMessage{{Role: "system", Content: "  "}}, nil},
+		{"assistant turns ignored", []apiMessage{{Role: "assistant", Content: "Done."}}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := systemInstructions(tt.messages); !slices.Equal(got, tt.want) {
+				t.Errorf("systemInstructions() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestChatHandlerSystemInstructionLimit(t *testing.T) {
+	long := strings.Repeat("x", agent.MaxSystemInstructionRunes+1)
+	tests := []struct {
+		name       string
+		mode       string
+		system     []string
+		wantStatus int
+	}{
+		{"agent within the limit", routeAgent, []string{"Reply in French."}, http.StatusOK},
+		{"agent at the limit", routeAgent, []string{strings.Repeat("é", agent.MaxSystemInstructionRunes)}, http.StatusOK},
+		{"agent over the limit", routeAgent, []string{long}, http.StatusBadRequest},
+		{"agent over the limit combined", routeAgent, []string{long[:3000], long[:3000]}, http.StatusBadRequest},
+		{"rag ignores system messages", routeRAG, []string{long}, http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			kb, _ := newTestKB(t)
+			var messages []apiMessage
+			for _, s := range tt.system {
+				messages = append(messages, apiMessage{Role: "system", Content: s})
+			}
+			messages = append(messages, apiMessage{Role: "user", Content: "Where is the Colosseum?"})
+			body := chatBody("", map[string]any{"mode": tt.mode, "stream": false, "messages": messages})
+
+			rec := serve(newTestChatHandler(kb, &memTaskRepo{}), http.MethodPost, "/api/v1/chat", "", body)
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+		})
+	}
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"core-go/internal/db"
+	"core-go/internal/logging"
+)
+
+// ── List conversations ────────────────────────────────────────────────────────
+
+// listConversationsHandler handles GET /api/v1/conversations?user_id=<uuid>
+// Returns the user's conversations, most recently active first.
+func listConversationsHandler(repo db.ConversationRepository) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, status, msg := requestUserID(r, r.URL.Query().Get("user_id"), "")
+		if status != 0 {
+			http.Error(w, msg, status)
+			return
+		}
+
+		conversations, err := repo.ListConversations(r.Context(), userID)
+		if err != nil {
+			http.Error(w, "failed to list conversations", http.StatusInternalServerError)
+			return
+		}
+		if conversations == nil {
+			conversations = []db.Conversation{}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(conversations)
+	}
+}
+
+// ── List messages ─────────────────────────────────────────────────────────────
+
+// listConversationMessagesHandler handles
+// GET /api/v1/conversations/{id}/messages?user_id=<uuid>
+// Returns the conversation's messages oldest-first.
+func listConversationMessagesHandler(repo db.ConversationRepository) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := parseConversationID(r.PathValue("id"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		userID, status, msg := requestUserID(r, r.URL.Query().Get("user_id"), "")
+		if status != 0 {
+			http.Error(w, msg, status)
+			return
+		}
+
+		messages, err := repo.ListMessages(r.Context(), id, userID)
+		if errors.Is(err, db.ErrConversationNotFound) {
+			http.Error(w, "conversation not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			logging.FromContext(r.Context()).Error("conversations: list messages", "conversation_id", int64(id), "err", err)
+			http.Error(w, "failed to load conversation", http.StatusInternalServerError)
+			return
+		}
+		if messages == nil {
+			messages = []db.ChatMessage{}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(messages)
+	}
+}
+
+// ── Helpers ───────────────────────────────────────────────────────────────────
+
+func parseConversationID(raw string) (db.ConversationID, error) {
+	n, err := strconv.ParseInt(raw, 10, 64)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("invalid conversation id %q", raw)
+	}
+	return db.ConversationID(n), nil
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"core-go/internal/agent"
+	"core-go/internal/db"
+	"core-go/internal/logging"
+)
+
+// ── List documents ────────────────────────────────────────────────────────────
+
+// listDocumentsHandler handles GET /api/v1/documents?user_id=<uuid>
+// Returns the user's ingested documents from the documents table, newest
+// first. Pass the shared user ID (SHARED_USER_ID, default "admin") for the
+// shared knowledge base.
+func listDocumentsHandler(repo db.DocumentRepository) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, status, msg := requestUserID(r, r.URL.Query().Get("user_id"), "")
+		if status != 0 {
+			http.Error(w, msg, status)
+			return
+		}
+
+		docs, err := repo.ListDocuments(r.Context(), userID)
+		if err != nil {
+			http.Error(w, "failed to list documents", http.StatusInternalServerError)
+			return
+		}
+		if docs == nil {
+			docs = []db.Document{}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(docs)
+	}
+}
+
+// ── Delete document ───────────────────────────────────────────────────────────
+
+// deleteDocumentHandler handles DELETE /api/v1/documents/{id}?user_id=<uuid>
+// Removes the documents row and then every Qdrant chunk tagged with its
+// document_id. The row goes first because it is what scopes the request to
+// the caller's own documents. If the chunk delete then fails, the orphaned
+// chunks are logged with the document ID and the request answers 502; they
+// still carry source and user_id, so an admin delete by source or a user
+// purge removes them. Returns 204 on success and 404 when the document does
+// not exist or belongs to another user.
+func deleteDocumentHandler(docs db.DocumentRepository, kb *agent.KnowledgeBase) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+		if err != nil || id <= 0 {
+			http.Error(w, "invalid document id", http.StatusBadRequest)
+			return
+		}
+
+		userID, status, msg := requestUserID(r, r.URL.Query().Get("user_id"), "")
+		if status != 0 {
+			http.Error(w, msg, status)
+			return
+		}
+
+		logger := logging.FromContext(r.Context()).With("document_id", id, "user_id", userID)
+
+		err = docs.DeleteDocument(r.Context(), db.DocumentID(id), userID)
+		if errors.Is(err, db.ErrDocumentNotFound) {
+			http.Error(w, "document not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			logger.Error("documents: delete record", "err", err)
+			http.Error(w, "failed to delete document", http.StatusInternalServerError)
+			return
+		}
+
+		if err := kb.DeleteDocument(r.Context(), userID, id); err != nil {
+			logger.Error("documents: record deleted but chunks remain", "err", err)
+			http.Error(w, "document record deleted, but its chunks could not be removed from the vector store", http.StatusBadGateway)
+			return
+		}
+
+		logger.Info("documents: deleted")
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// ── Rechunk document ──────────────────────────────────────────────────────────
+
+// rechunkDocumentHandler handles
+// POST /api/v1/documents/{source}/rechunk?user_id=<uuid>
+// Body: { "chunk_size": N, "chunk_overlap": M } (both optional, as on
+// POST /api/v1/documents).
+//
+// Re-ingests the stored text of the user's newest document labelled source
+// with the new chunking window, keeping its title and url. The new chunks
+// are written under a fresh documents row first; only once they are all
+// stored are the source's older chunks and rows removed, so a failed
+// rechunk leaves the previous chunks searchable. Returns 404 when the user
+// has no such document and 409 when it was ingested before document text
+// was stored, in which case it must be uploaded again.
+func rechunkDocumentHandler(docs db.DocumentRepository, kb *agent.KnowledgeBase) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		source := strings.TrimSpace(r.PathValue("source"))
+		if source == "" || len(source) > 180 {
+			http.Error(w, "invalid source", http.StatusBadRequest)
+			return
+		}
+
+		userID, status, msg := requestUserID(r, r.URL.Query().Get("user_id"), "")
+		if status != 0 {
+			http.Error(w, msg, status)
+			return
+		}
+
+		var body struct {
+			ChunkSize    *int `json:"chunk_size"`
+			ChunkOverlap *int `json:"chunk_overlap"`
+		}
+		if err := decodeJSONStrict(r, &body); err != nil {
+			http.Error(w, "invalid JSON body", http.StatusBadRequest)
+			return
+		}
+
+		logger := logging.FromContext(r.Context()).With("source", source, "user_id", userID)
+
+		stored, err := docs.GetSourceText(r.Context(), userID, source)
+		if errors.Is(err, db.ErrDocumentNotFound) {
+			http.Error(w, "document not found", http.StatusNotFound)
+			return
+		}
+		if errors.Is(err, db.ErrNoStoredText) {
+			http.Error(w, "document text was not stored; ingest it again instead", http.StatusConflict)
+			return
+		}
+		if err != nil {
+			logger.Error("documents: read stored text", "err", err)
+			http.Error(w, "failed to read document", http.StatusInternalServerError)
+			return
+		}
+
+		// The old chunks are removed below once the new ones are stored, so
+		// they do not count against the user's quota.
+		opts := agent.IngestOptions{Title: stored.Title, URL: stored.URL, ReplacesSource: true}
+		if msg := chunkingOptions(&opts, body.ChunkSize, body.ChunkOverlap); msg != "" {
+			http.Error(w, msg, http.StatusBadRequest)
+			return
+		}
+
+		docID, err := docs.RecordDocument(r.Context(), db.NewDocument{
+			Source:   source,
+			UserID:   userID,
+			ByteSize: len(stored.Content),
+			Content:  stored.Content,
+			Title:    stored.Title,
+			URL:      stored.URL,
+		})
+		if err != nil {
+			logger.Error("documents: record rechunked document", "err", err)
+			http.Error(w, "failed to record document", http.StatusInternalServerError)
+			return
+		}
+		opts.DocumentID = int64(docID)
+
+		n, err := kb.IngestText(r.Context(), stored.Content, source, userID, opts)
+		if err != nil {
+			// Drop whatever the new document stored; the old chunks are
+			// untouched and still answer queries.
+			if n > 0 {
+				if delErr := kb.DeleteDocument(context.WithoutCancel(r.Context()), userID, int64(docID)); delErr != nil {
+					logger.Error("documents: remove partial rechunk", "document_id", int64(docID), "err", delErr)
+				}
+			}
+			finishDocument(r, docs, docID, userID, 0)
+			if errors.Is(err, agent.ErrQuotaExceeded) {
+				http.Error(w, err.Error(), http.StatusForbidden)
+				return
+			}
+			logger.Error("documents: rechunk failed", "err", err)
+			http.Error(w, "rechunk failed", http.StatusInternalServerError)
+			return
+		}
+		finishDocument(r, docs, docID, userID, n)
+
+		if !supersedeSource(w, r, kb, docs, userID, source, docID) {
+			return
+		}
+
+		logger.Info("documents: rechunked", "document_id", int64(docID), "chunks", n)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(ingestResponse{
+			ChunksIngested: n,
+			Source:         source,
+			DocumentID:     int64(docID),
+		})
+	}
+}
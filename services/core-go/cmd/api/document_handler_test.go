@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httputil"
+	"net/url"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"core-go/internal/agent"
+	"core-go/internal/db"
+	"core-go/internal/llm"
+	"core-go/internal/vector"
+	"core-go/internal/vector/qdranttest"
+)
+
+// ListDocuments returns the user's rows newest (highest ID) first, with err
+// standing in for a database failure.
+func (m *memDocuments) ListDocuments(_ context.Context, userID string) ([]db.Document, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.err != nil {
+		return nil, m.err
+	}
+	var docs []db.Document
+	for id, d := range m.rows {
+		if d.UserID == userID {
+			docs = append(docs, db.Document{ID: id, Source: d.Source, UserID: d.UserID, ByteSize: d.ByteSize})
+		}
+	}
+	sort.Slice(docs, func(i, j int) bool { return docs[i].ID > docs[j].ID })
+	return docs, nil
+}
+
+func TestListDocumentsHandler(t *testing.T) {
+	tests := []struct {
+		name        string
+		target      string
+		repoErr     error
+		wantStatus  int
+		wantSources []string
+	}{
+		{"newest first", "/api/v1/documents?user_id=" + testUser, nil, http.StatusOK, []string{"rome.md", "notes.md"}},
+		{"no documents is an empty array", "/api/v1/documents?user_id=" + otherTestUser, nil, http.StatusOK, []string{}},
+		{"missing user", "/api/v1/documents", nil, http.StatusBadRequest, nil},
+		{"database failure", "/api/v1/documents?user_id=" + testUser, errors.New("down"), http.StatusInternalServerError, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			docs := &memDocuments{}
+			for _, source := range []string{"notes.md", "rome.md"} {
+				docs.RecordDocument(context.Background(), db.NewDocument{Source: source, UserID: testUser})
+			}
+			docs.err = tt.repoErr
+
+			rec := serve(listDocumentsHandler(docs), http.MethodGet, tt.target, "", "")
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+			if rec.Code != http.StatusOK {
+				return
+			}
+			var got []db.Document
+			if err := json.NewDecoder(rec.Body).Decode(&got); err != nil {
+				t.Fatal(err)
+			}
+			sources := []string{}
+			for _, d := range got {
+				sources = append(sources, d.Source)
+			}
+			if fmt.Sprint(sources) != fmt.Sprint(tt.wantSources) || got == nil {
+				t.Errorf("sources = %v, want %v", sources, tt.wantSources)
+			}
+		})
+	}
+}
+
+func TestDeleteDocumentHandler(t *testing.T) {
+	tests := []struct {
+		name         string
+		id           string
+		user         string
+		failQdrant   bool // chunk deletes answer 400
+		wantStatus   int
+		wantRows     int
+		wantDocument []float64 // document_ids of the chunks left
+	}{
+		{"deletes the row and its chunks", "1", testUser, false, http.StatusNoContent, 1, []float64{2}},
+		{"chunk delete fails after the row is gone", "1", testUser, true, http.StatusBadGateway, 1, []float64{1, 2}},
+		{"other user's document", "1", otherTestUser, false, http.StatusNotFound, 2, []float64{1, 2}},
+		{"unknown document", "9", testUser, false, http.StatusNotFound, 2, []float64{1, 2}},
+		{"invalid id", "abc", testUser, false, http.StatusBadRequest, 2, []float64{1, 2}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			backend := qdranttest.NewServer()
+			t.Cleanup(backend.Close)
+			target, _ := url.Parse(backend.URL)
+			proxy := httputil.NewSingleHostReverseProxy(target)
+			var failDeletes bool
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if failDeletes && strings.HasSuffix(r.URL.Path, "/points/delete") {
+					http.Error(w, `{"status":{"error":"bad request"}}`, http.StatusBadRequest)
+					return
+				}
+				proxy.ServeHTTP(w, r)
+			}))
+			t.Cleanup(srv.Close)
+
+			q := vector.NewQdrantClient(srv.URL)
+			dim, err := agent.CollectionDim()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := q.EnsureCollection(context.Background(), agent.CollectionName(), dim, vector.DistanceCosine); err != nil {
+				t.Fatal(err)
+			}
+			kb := agent.NewKnowledgeBase(q, llm.NewFakeEmbedder(), llm.FakeChatProvider{})
+			docs := &memDocuments{}
+			ingest := ingestHandler(kb, docs, newRateLimiter(100, 100, time.Minute))
+			for _, source := range []string{"notes.md", "rome.md"} {
+				body, _ := json.Marshal(map[string]any{"text": "Text of " + source, "source": source, "user_id": testUser})
+				if rec := serve(ingest, http.MethodPost, "/api/v1/documents", "", string(body)); rec.Code != http.StatusOK {
+					t.Fatalf("ingest status = %d: %s", rec.Code, rec.Body)
+				}
+			}
+			failDeletes = tt.failQdrant
+
+			rec := serve(deleteDocumentHandler(docs, kb), http.MethodDelete, "/api/v1/documents/"+tt.id+"?user_id="+tt.user, tt.id, "")
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+			if rows := docs.ids(); len(rows) != tt.wantRows {
+				t.Errorf("documents rows = %v, want %d", rows, tt.wantRows)
+			}
+			var left []float64
+			for _, p := range backend.Points(agent.CollectionName()) {
+				id, _ := p.Payload["document_id"].(float64)
+				left = append(left, id)
+			}
+			sort.Float64s(left)
+			if fmt.Sprint(left) != fmt.Sprint(tt.wantDocument) {
+				t.Errorf("chunks left for documents %v, want %v", left, tt.wantDocument)
+			}
+		})
+	}
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// getEnvInt reads a positive integer from key, returning defaultValue when the
+// variable is unset or unparsable.
+func getEnvInt(key string, defaultValue int) int {
+	raw := strings.TrimSpace(os.Getenv(key))
+	if raw == "" {
+		return defaultValue
+	}
+	v, err := strconv.Atoi(raw)
+	if err != nil || v <= 0 {
+		return defaultValue
+	}
+	return v
+}
+
+// getEnvNonNegativeInt reads an integer that may be 0 from key, returning
+// defaultValue when the variable is unset. Unlike getEnvInt it reports an
+// unparsable or negative value instead of falling back, for settings where
+// a silent fallback would hide a typo.
+func getEnvNonNegativeInt(key string, defaultValue int) (int, error) {
+	raw := strings.TrimSpace(os.Getenv(key))
+	if raw == "" {
+		return defaultValue, nil
+	}
+	v, err := strconv.Atoi(raw)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %q is not an integer", key, raw)
+	}
+	if v < 0 {
+		return 0, fmt.Errorf("%s: must be non-negative, got %d", key, v)
+	}
+	return v, nil
+}
+
+// getEnvFloat reads a positive float from key, returning defaultValue when the
+// variable is unset or unparsable.
+func getEnvFloat(key string, defaultValue float64) float64 {
+	raw := strings.TrimSpace(os.Getenv(key))
+	if raw == "" {
+		return defaultValue
+	}
+	v, err := strconv.ParseFloat(raw, 64)
+	if err != nil || v <= 0 {
+		return defaultValue
+	}
+	return v
+}
+
+// getEnvOptionalFloat reads a float (zero and negatives included) from key,
+// returning nil when the variable is unset or unparsable so the caller can
+// tell "not configured" from an explicit 0.
+func getEnvOptionalFloat(key string) *float64 {
+	raw := strings.TrimSpace(os.Getenv(key))
+	if raw == "" {
+		return nil
+	}
+	v, err := strconv.ParseFloat(raw, 64)
+	if err != nil {
+		return nil
+	}
+	return &v
+}
+
+// getEnvBool reads a boolean ("true", "1", "false", "0", ...) from key,
+// returning defaultValue when the variable is unset or unparsable.
+func getEnvBool(key string, defaultValue bool) bool {
+	raw := strings.TrimSpace(os.Getenv(key))
+	if raw == "" {
+		return defaultValue
+	}
+	v, err := strconv.ParseBool(raw)
+	if err != nil {
+		return defaultValue
+	}
+	return v
+}
+
+// getEnvDuration reads a Go duration string (e.g. "30s") from key, returning
+// defaultValue when the variable is unset or unparsable.
+func getEnvDuration(key string, defaultValue time.Duration) time.Duration {
+	raw := strings.TrimSpace(os.Getenv(key))
+	if raw == "" {
+		return defaultValue
+	}
+	v, err := time.ParseDuration(raw)
+	if err != nil || v <= 0 {
+		return defaultValue
+	}
+	return v
+}
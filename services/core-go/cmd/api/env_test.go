@@ -0,0 +1,60 @@
+package main
+
+import (
+	"strconv"
+	"testing"
+)
+
+func TestGetEnvNonNegativeInt(t *testing.T) {
+	tests := []struct {
+		name    string
+		raw     string
+		want    int
+		wantErr bool
+	}{
+		{"unset", "", 7, false},
+		{"zero", "0", 0, false},
+		{"positive", "128", 128, false},
+		{"padded", " 64 ", 64, false},
+		{"negative", "-1", 0, true},
+		{"not a number", "lots", 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("TEST_NON_NEGATIVE_INT", tt.raw)
+			got, err := getEnvNonNegativeInt("TEST_NON_NEGATIVE_INT", 7)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("getEnvNonNegativeInt() err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("getEnvNonNegativeInt() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGetEnvOptionalFloat(t *testing.T) {
+	tests := []struct {
+		name string
+		raw  string
+		want string // fmt of the result; "<nil>" when unset
+	}{
+		{"unset", "", "<nil>"},
+		{"explicit zero", "0", "0"},
+		{"negative", "-0.5", "-0.5"},
+		{"padded", " 0.7 ", "0.7"},
+		{"not a number", "warm", "<nil>"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("TEST_OPTIONAL_FLOAT", tt.raw)
+			got := "<nil>"
+			if v := getEnvOptionalFloat("TEST_OPTIONAL_FLOAT"); v != nil {
+				got = strconv.FormatFloat(*v, 'g', -1, 64)
+			}
+			if got != tt.want {
+				t.Errorf("getEnvOptionalFloat() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
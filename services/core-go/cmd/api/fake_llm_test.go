@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"core-go/internal/agent"
+	"core-go/internal/llm"
+	"core-go/internal/vector"
+	"core-go/internal/vector/qdranttest"
+)
+
+// TestFakeLLMEndToEnd drives ingest, retrieval and chat through the
+// providers LLM_FAKE selects, with no model server: every vector and reply
+// is deterministic, so they can be asserted exactly.
+func TestFakeLLMEndToEnd(t *testing.T) {
+	t.Setenv("LLM_FAKE", "true")
+	t.Setenv("APP_ENV", "test")
+	const user = "6f1c2a7e-3b4d-4e5f-8a9b-0c1d2e3f4a5b"
+	docs := map[string]string{
+		"rome.md":  "The Colosseum is an ancient amphitheatre in Rome.",
+		"tokyo.md": "Shibuya crossing is the busiest intersection in Tokyo.",
+	}
+
+	embedder, err := llm.NewEmbedderFromEnv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	chat, err := llm.NewChatProviderFromEnv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := qdranttest.NewServer()
+	t.Cleanup(srv.Close)
+	q := vector.NewQdrantClient(srv.URL)
+	dim, err := agent.CollectionDim()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := q.EnsureCollection(context.Background(), agent.CollectionName(), dim, vector.DistanceCosine); err != nil {
+		t.Fatal(err)
+	}
+	kb := agent.NewKnowledgeBase(q, embedder, chat)
+
+	// Ingest: each document is one chunk whose vector is the fake
+	// embedding of its text.
+	ingest := ingestHandler(kb, &memDocuments{}, newRateLimiter(100, 100, time.Minute))
+	for source, text := range docs {
+		body, _ := json.Marshal(map[string]any{"text": text, "source": source, "user_id": user})
+		rec := httptest.NewRecorder()
+		ingest(rec, httptest.NewRequest(http.MethodPost, "/api/v1/documents", strings.NewReader(string(body))))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("ingest %s: status %d: %s", source, rec.Code, rec.Body)
+		}
+	}
+	var stored int
+	for _, c := range srv.Collections() {
+		for _, p := range srv.Points(c) {
+			stored++
+			text, _ := p.Payload["text"].(string)
+			want, _ := embedder.Embed(context.Background(), text)
+			if !sameVector(p.Vector, want) {
+				t.Errorf("point for %q does not hold the fake embedding of its text", text)
+			}
+		}
+	}
+	if stored != len(docs) {
+		t.Fatalf("stored %d points, want %d", stored, len(docs))
+	}
+
+	chatH := chatHandler(kb, agent.NewTaskAgent(nil, chat), &fakeConversations{}, agent.AskOptions{},
+		historyLimit{MaxTurns: 50}, nil, nil, newStreamRegistry(), false)
+
+	tests := []struct {
+		name       string
+		question   string
+		wantSource string
+	}{
+		{"rome", "Where is the Colosseum amphitheatre?", "rome.md"},
+		{"tokyo", "Which intersection in Tokyo is the busiest?", "tokyo.md"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Retrieval: the document sharing the question's words ranks first.
+			hits, err := kb.SearchDocuments(context.Background(), tt.question, 2, vector.SearchOptions{UserIDs: []string{user}})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(hits) == 0 || hits[0].Payload["source"] != tt.wantSource {
+				t.Fatalf("top hit = %v, want %s", hits, tt.wantSource)
+			}
+
+			// Chat: the fake provider echoes the question and the answer
+			// cites the retrieved document.
+			body, _ := json.Marshal(map[string]any{
+				"messages": []apiMessage{{Role: "user", Content: tt.question}},
+				"user_id":  user, "mode": routeRAG, "stream": false,
+			})
+			rec := httptest.NewRecorder()
+			chatH(rec, httptest.NewRequest(http.MethodPost, "/api/v1/chat", strings.NewReader(string(body))))
+			if rec.Code != http.StatusOK {
+				t.Fatalf("chat status = %d: %s", rec.Code, rec.Body)
+			}
+			var resp chatResponse
+			if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+				t.Fatal(err)
+			}
+			if want := "Fake answer: " + tt.question; resp.Content != want {
+				t.Errorf("content = %q, want %q", resp.Content, want)
+			}
+			if len(resp.Sources) == 0 || resp.Sources[0] != tt.wantSource {
+				t.Errorf("sources = %v, want %s first", resp.Sources, tt.wantSource)
+			}
+		})
+	}
+}
+
+func sameVector(a, b []float64) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if diff := a[i] - b[i]; diff > 1e-9 || diff < -1e-9 {
+			return false
+		}
+	}
+	return true
+}
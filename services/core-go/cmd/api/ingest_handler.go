@@ -1,11 +1,23 @@
 package main
 
 import (
+	"context"
+	"encoding/base64"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
 	"net/http"
+	"net/url"
+	"strconv"
 	"strings"
+	"unicode/utf8"
 
 	"core-go/internal/agent"
+	"core-go/internal/db"
+	"core-go/internal/document"
+	"core-go/internal/logging"
+	"core-go/internal/vector"
 )
 
 // ── Request / Response types ───────────────────────────────────────────────────
@@ -14,19 +26,38 @@ import (
 // text is the raw content to chunk, embed, and store in Qdrant.
 // source is an optional human-readable label (filename, URL, title) stored in
 // each chunk's payload for provenance tracking.
-// user_id tags chunks so retrieval is scoped per-user; use "admin" for shared
-// knowledge accessible by all users. Defaults to "admin" when omitted so that
+// user_id tags chunks so retrieval is scoped per-user; use the shared user ID
+// (SHARED_USER_ID, default "admin") for knowledge accessible by all users.
+// Defaults to the shared user ID when omitted so that
 // documents ingested without a user_id are treated as shared knowledge.
+// format is "text" (default) or "pdf"; with "pdf", text carries the
+// base64-encoded PDF file and its extracted text is what gets chunked.
+// title and url are optional citation fields stored alongside source so
+// clients can link back to web-ingested content.
 type ingestRequest struct {
 	Text   string `json:"text"`
 	Source string `json:"source"`
 	UserID string `json:"user_id"`
+	Format string `json:"format"`
+	Title  string `json:"title"`
+	URL    string `json:"url"`
+
+	ChunkSize    *int `json:"chunk_size"`
+	ChunkOverlap *int `json:"chunk_overlap"`
+
+	DeterministicIDs bool `json:"deterministic_ids"`
 }
 
+const (
+	formatText = "text"
+	formatPDF  = "pdf"
+)
+
 // ingestResponse is returned on success.
 type ingestResponse struct {
 	ChunksIngested int    `json:"chunks_ingested"`
 	Source         string `json:"source"`
+	DocumentID     int64  `json:"document_id,omitempty"`
 }
 
 // ── Handler ───────────────────────────────────────────────────────────────────
@@ -36,15 +67,28 @@ type ingestResponse struct {
 // It accepts a JSON body with "text" (required) and "source" (optional),
 // chunks the text into overlapping windows, embeds each chunk via Ollama
 // nomic-embed-text, and upserts all resulting vectors into the Qdrant
-// "Personal Context" collection.
+// "Personal Context" collection. With "format": "pdf" the "text" field holds
+// a base64-encoded PDF whose extracted text is ingested instead; encrypted or
+// text-less PDFs are rejected with 400. Optional "chunk_size" and
+// "chunk_overlap" (runes) replace the default 400/50 window; they must
+// satisfy 0 <= overlap < size <= agent.MaxChunkSize. With
+// "deterministic_ids": true each chunk's point ID is derived from its
+// source, index and user_id, so re-ingesting the same source overwrites
+// its chunks instead of duplicating them; the new document then replaces
+// the source's older rows and any chunks past its own last index.
 //
-// On success it returns JSON: {"chunks_ingested": N, "source": "..."}
+// Each ingest is recorded as a row in docs, whose ID is stored on every
+// chunk. On success it returns JSON:
+// {"chunks_ingested": N, "source": "...", "document_id": ID}
 // On error it returns an HTTP error status with a plain-text message.
 //
 // Embedding N chunks makes N sequential calls to Ollama. For very large
 // documents this can take several seconds; callers should set an appropriate
 // client-side timeout (30 s is usually sufficient for up to ~50 chunks).
-func ingestHandler(kb *agent.KnowledgeBase) http.HandlerFunc {
+//
+// limiter throttles ingests per user_id; when a user's bucket is empty the
+// handler returns 429 with a Retry-After header before touching Ollama.
+func ingestHandler(kb *agent.KnowledgeBase, docs db.DocumentRepository, limiter *rateLimiter) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 
 		// ── 1. Parse body ──────────────────────────────────────────────────
@@ -71,26 +115,200 @@ func ingestHandler(kb *agent.KnowledgeBase) http.HandlerFunc {
 			return
 		}
 
-		// Default user_id to "admin" so documents without an explicit owner
+		// Default user_id to the shared namespace so documents without an explicit owner
 		// are treated as shared knowledge, retrievable by all users.
-		req.UserID = normalizeUserID(req.UserID, "admin")
+		req.UserID = normalizeUserID(req.UserID, vector.SharedUserID)
 		if !isValidUserID(req.UserID) {
 			http.Error(w, "invalid user_id", http.StatusBadRequest)
 			return
 		}
 
-		// ── 2. Chunk → embed → upsert ──────────────────────────────────────
-		n, err := kb.IngestText(r.Context(), req.Text, req.Source, req.UserID)
+		opts, msg := ingestOptions(req.Title, req.URL)
+		if msg != "" {
+			http.Error(w, msg, http.StatusBadRequest)
+			return
+		}
+		if msg := chunkingOptions(&opts, req.ChunkSize, req.ChunkOverlap); msg != "" {
+			http.Error(w, msg, http.StatusBadRequest)
+			return
+		}
+		opts.DeterministicIDs = req.DeterministicIDs
+		opts.ReplacesSource = req.DeterministicIDs
+
+		if ok, wait := limiter.Allow(req.UserID); !ok {
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			http.Error(w, "rate limit exceeded", http.StatusTooManyRequests)
+			return
+		}
+
+		// ── 2. Decode non-text formats ─────────────────────────────────────
+		text, status, msg := ingestText(req)
+		if status != 0 {
+			http.Error(w, msg, status)
+			return
+		}
+
+		// ── 3. Record → chunk → embed → upsert ─────────────────────────────
+		// The row is written first so its ID can be stored on every chunk,
+		// which is what DELETE /api/v1/documents/{id} matches on.
+		docID, err := docs.RecordDocument(r.Context(), db.NewDocument{
+			Source:   req.Source,
+			UserID:   req.UserID,
+			ByteSize: len(text),
+			Content:  text,
+			Title:    opts.Title,
+			URL:      opts.URL,
+		})
+		if err != nil {
+			logging.FromContext(r.Context()).Error("ingest: record document", "source", req.Source, "user_id", req.UserID, "err", err)
+			http.Error(w, "failed to record document", http.StatusInternalServerError)
+			return
+		}
+		opts.DocumentID = int64(docID)
+
+		n, err := kb.IngestText(r.Context(), text, req.Source, req.UserID, opts)
+		finishDocument(r, docs, docID, req.UserID, n)
+		if errors.Is(err, agent.ErrContentTooShort) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if errors.Is(err, agent.ErrDocumentTooLarge) {
+			http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+			return
+		}
+		if errors.Is(err, agent.ErrQuotaExceeded) {
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		}
 		if err != nil {
+			logging.FromContext(r.Context()).Error("ingest: failed", "source", req.Source, "user_id", req.UserID, "chunks_ingested", n, "err", err)
+			if n > 0 {
+				// The stored chunks are searchable; tell the caller so a retry
+				// can delete the document first instead of duplicating them.
+				http.Error(w, fmt.Sprintf("ingest failed after %d chunk(s) were stored as document %d", n, docID), http.StatusInternalServerError)
+				return
+			}
 			http.Error(w, "ingest failed", http.StatusInternalServerError)
 			return
 		}
 
-		// ── 3. Respond ────────────────────────────────────────────────────
+		if opts.DeterministicIDs && !supersedeSource(w, r, kb, docs, req.UserID, req.Source, docID) {
+			return
+		}
+
+		// ── 4. Respond ────────────────────────────────────────────────────
 		w.Header().Set("Content-Type", "application/json")
 		json.NewEncoder(w).Encode(ingestResponse{
 			ChunksIngested: n,
 			Source:         req.Source,
+			DocumentID:     int64(docID),
 		})
 	}
 }
+
+// finishDocument settles the documents row written before an ingest that
+// stored chunks chunks: it records the count, or removes the row when
+// nothing was stored so a failed ingest leaves no trace. It runs detached
+// from request cancellation, and failures are only logged — the ingest
+// outcome is what the caller needs to hear about.
+func finishDocument(r *http.Request, docs db.DocumentRepository, id db.DocumentID, userID string, chunks int) {
+	ctx := context.WithoutCancel(r.Context())
+	var err error
+	if chunks == 0 {
+		err = docs.DeleteDocument(ctx, id, userID)
+	} else {
+		err = docs.SetChunkCount(ctx, id, chunks)
+	}
+	if err != nil {
+		logging.FromContext(ctx).Error("ingest: finish document record", "document_id", int64(id), "chunks", chunks, "err", err)
+	}
+}
+
+// supersedeSource removes userID's chunks and documents rows for source
+// other than document id's, once id has replaced them; deleting a row also
+// drops its stored chunk text. It answers 502 and reports false when the
+// old chunks cannot be removed from Qdrant. A leftover row is only logged.
+func supersedeSource(w http.ResponseWriter, r *http.Request, kb *agent.KnowledgeBase, docs db.DocumentRepository, userID, source string, id db.DocumentID) bool {
+	logger := logging.FromContext(r.Context())
+	if err := kb.ReplaceSource(r.Context(), userID, source, int64(id)); err != nil {
+		logger.Error("documents: old chunks remain", "document_id", int64(id), "err", err)
+		http.Error(w, "document stored, but its old chunks could not be removed from the vector store", http.StatusBadGateway)
+		return false
+	}
+	if _, err := docs.DeleteSourceExcept(r.Context(), userID, source, id); err != nil {
+		logger.Error("documents: delete superseded records", "document_id", int64(id), "err", err)
+	}
+	return true
+}
+
+// ingestText returns the plain text to ingest for req. A non-zero status
+// means the body is unusable and msg explains why.
+func ingestText(req ingestRequest) (text string, status int, msg string) {
+	switch strings.ToLower(strings.TrimSpace(req.Format)) {
+	case "", formatText:
+		return req.Text, 0, ""
+	case formatPDF:
+		data, err := base64.StdEncoding.DecodeString(strings.TrimSpace(req.Text))
+		if err != nil {
+			return "", http.StatusBadRequest, `"text" must be base64-encoded when "format" is "pdf"`
+		}
+		text, err := document.ExtractPDFText(data)
+		switch {
+		case errors.Is(err, document.ErrEncryptedPDF):
+			return "", http.StatusBadRequest, "pdf is encrypted; remove the password and retry"
+		case errors.Is(err, document.ErrEmptyPDF):
+			return "", http.StatusBadRequest, "pdf contains no extractable text"
+		case err != nil:
+			return "", http.StatusBadRequest, "could not read pdf"
+		}
+		return text, 0, ""
+	default:
+		return "", http.StatusBadRequest, `"format" must be one of: text, pdf`
+	}
+}
+
+// chunkingOptions copies the optional chunk_size/chunk_overlap fields into
+// opts and validates the resulting window. On failure it returns a non-empty
+// message suitable for a 400 response.
+func chunkingOptions(opts *agent.IngestOptions, size, overlap *int) string {
+	if size != nil {
+		if *size <= 0 {
+			return `"chunk_size" must be positive`
+		}
+		opts.ChunkSize = *size
+	}
+	opts.ChunkOverlap = overlap
+	if _, _, err := opts.Chunking(); err != nil {
+		return err.Error()
+	}
+	return ""
+}
+
+const (
+	maxTitleLen = 255 // runes; documents.title is VARCHAR(255)
+	maxURLLen   = 2048
+)
+
+// ingestOptions validates the optional citation fields shared by the ingest
+// and admin update endpoints. On failure it returns a non-empty message
+// suitable for a 400 response.
+func ingestOptions(title, rawURL string) (agent.IngestOptions, string) {
+	opts := agent.IngestOptions{
+		Title: strings.TrimSpace(title),
+		URL:   strings.TrimSpace(rawURL),
+	}
+	if utf8.RuneCountInString(opts.Title) > maxTitleLen {
+		return opts, `"title" is too long`
+	}
+	if opts.URL == "" {
+		return opts, ""
+	}
+	if len(opts.URL) > maxURLLen {
+		return opts, `"url" is too long`
+	}
+	u, err := url.Parse(opts.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return opts, `"url" must be an absolute http or https URL`
+	}
+	return opts, ""
+}
@@ -0,0 +1,294 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"core-go/internal/agent"
+	"core-go/internal/db"
+	"core-go/internal/llm"
+	"core-go/internal/vector"
+	"core-go/internal/vector/qdranttest"
+)
+
+// newTestKB returns a KnowledgeBase on an in-memory Qdrant with the base
+// collection created, using the deterministic fake embedder and chat.
+func newTestKB(t *testing.T) (*agent.KnowledgeBase, *qdranttest.Server) {
+	t.Helper()
+	srv := qdranttest.NewServer()
+	t.Cleanup(srv.Close)
+	q := vector.NewQdrantClient(srv.URL)
+	dim, err := agent.CollectionDim()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := q.EnsureCollection(context.Background(), agent.CollectionName(), dim, vector.DistanceCosine); err != nil {
+		t.Fatal(err)
+	}
+	return agent.NewKnowledgeBase(q, llm.NewFakeEmbedder(), llm.FakeChatProvider{}), srv
+}
+
+// memDocuments is an in-memory db.DocumentRepository covering what the
+// ingest, rechunk and document handlers call.
+type memDocuments struct {
+	db.DocumentRepository // unimplemented methods panic
+
+	mu   sync.Mutex
+	next db.DocumentID
+	rows map[db.DocumentID]db.NewDocument
+	err  error // returned by ListDocuments when set
+}
+
+func (m *memDocuments) RecordDocument(_ context.Context, d db.NewDocument) (db.DocumentID, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.rows == nil {
+		m.rows = map[db.DocumentID]db.NewDocument{}
+	}
+	m.next++
+	m.rows[m.next] = d
+	return m.next, nil
+}
+
+func (m *memDocuments) SetChunkCount(context.Context, db.DocumentID, int) error { return nil }
+
+func (m *memDocuments) DeleteDocument(_ context.Context, id db.DocumentID, userID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if d, ok := m.rows[id]; !ok || d.UserID != userID {
+		return db.ErrDocumentNotFound
+	}
+	delete(m.rows, id)
+	return nil
+}
+
+func (m *memDocuments) DeleteSourceExcept(_ context.Context, userID, source string, keep db.DocumentID) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var n int64
+	for id, d := range m.rows {
+		if id != keep && d.UserID == userID && d.Source == source {
+			delete(m.rows, id)
+			n++
+		}
+	}
+	return n, nil
+}
+
+func (m *memDocuments) DeleteBySource(_ context.Context, userID, source string) (int64, error) {
+	return m.DeleteSourceExcept(context.Background(), userID, source, 0)
+}
+
+func (m *memDocuments) ids() []db.DocumentID {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var ids []db.DocumentID
+	for id := range m.rows {
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+func TestIngestHandlerReingest(t *testing.T) {
+	const (
+		user  = "6f1c2a7e-3b4d-4e5f-8a9b-0c1d2e3f4a5b"
+		long  = "alpha beta gamma delzeta theta iota kappomega sigma tau phi."
+		short = "alpha beta gamma delzeta theta iota kapp"
+	)
+	tests := []struct {
+		name          string
+		deterministic bool
+		wantPoints    int
+		wantRows      int
+	}{
+		// The second ingest keeps its own row and two chunks; the first
+		// document's row and third chunk go.
+		{"deterministic ids replace the source", true, 2, 1},
+		{"random ids keep both documents", false, 5, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			kb, srv := newTestKB(t)
+			docs := &memDocuments{}
+			h := ingestHandler(kb, docs, newRateLimiter(100, 100, time.Minute))
+
+			var last ingestResponse
+			for _, text := range []string{long, short} {
+				body, _ := json.Marshal(map[string]any{
+					"text": text, "source": "notes.md", "user_id": user,
+					"chunk_size": 20, "chunk_overlap": 0, "deterministic_ids": tt.deterministic,
+				})
+				rec := httptest.NewRecorder()
+				h(rec, httptest.NewRequest(http.MethodPost, "/api/v1/documents", strings.NewReader(string(body))))
+				if rec.Code != http.StatusOK {
+					t.Fatalf("ingest status = %d: %s", rec.Code, rec.Body)
+				}
+				if err := json.NewDecoder(rec.Body).Decode(&last); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			var points int
+			for _, c := range srv.Collections() {
+				for _, p := range srv.Points(c) {
+					points++
+					if tt.deterministic {
+						if id, _ := p.Payload["document_id"].(float64); int64(id) != last.DocumentID {
+							t.Errorf("point %s has document_id %v, want %d", p.ID, p.Payload["document_id"], last.DocumentID)
+						}
+					}
+				}
+			}
+			if points != tt.wantPoints {
+				t.Errorf("stored %d points, want %d", points, tt.wantPoints)
+			}
+			if rows := docs.ids(); len(rows) != tt.wantRows {
+				t.Errorf("documents rows = %v, want %d", rows, tt.wantRows)
+			}
+		})
+	}
+}
+
+func (m *memDocuments) DeleteAllForUser(_ context.Context, userID string) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var n int64
+	for id, d := range m.rows {
+		if d.UserID == userID {
+			delete(m.rows, id)
+			n++
+		}
+	}
+	return n, nil
+}
+
+func TestIngestText(t *testing.T) {
+	sample, err := os.ReadFile("../../internal/document/testdata/sample.pdf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	encrypted, err := os.ReadFile("../../internal/document/testdata/encrypted.pdf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name       string
+		req        ingestRequest
+		want       string
+		wantStatus int
+	}{
+		{"plain text", ingestRequest{Text: "hello"}, "hello", 0},
+		{"pdf", ingestRequest{Format: "PDF", Text: base64.StdEncoding.EncodeToString(sample)}, "The Colosseum is in Rome.", 0},
+		{"pdf not base64", ingestRequest{Format: "pdf", Text: "%PDF-1.4"}, "", http.StatusBadRequest},
+		{"encrypted pdf", ingestRequest{Format: "pdf", Text: base64.StdEncoding.EncodeToString(encrypted)}, "", http.StatusBadRequest},
+		{"unknown format", ingestRequest{Format: "docx", Text: "x"}, "", http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, status, msg := ingestText(tt.req)
+			if got != tt.want || status != tt.wantStatus {
+				t.Errorf("ingestText() = (%q, %d, %q), want (%q, %d)", got, status, msg, tt.want, tt.wantStatus)
+			}
+		})
+	}
+}
+
+func TestIngestHandlerChunking(t *testing.T) {
+	tests := []struct {
+		name       string
+		fields     map[string]any
+		wantStatus int
+		wantChunks int
+	}{
+		{"custom window", map[string]any{"chunk_size": 25, "chunk_overlap": 0}, http.StatusOK, 4},
+		{"defaults", nil, http.StatusOK, 1},
+		{"zero size", map[string]any{"chunk_size": 0}, http.StatusBadRequest, 0},
+		{"overlap not below size", map[string]any{"chunk_size": 20, "chunk_overlap": 20}, http.StatusBadRequest, 0},
+		{"negative overlap", map[string]any{"chunk_overlap": -5}, http.StatusBadRequest, 0},
+		{"size above maximum", map[string]any{"chunk_size": agent.MaxChunkSize + 1}, http.StatusBadRequest, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			kb, srv := newTestKB(t)
+			req := map[string]any{"text": strings.Repeat("abcdefghij", 10), "source": "letters.txt", "user_id": testUser}
+			for k, v := range tt.fields {
+				req[k] = v
+			}
+			body, _ := json.Marshal(req)
+			rec := serve(ingestHandler(kb, &memDocuments{}, newRateLimiter(100, 100, time.Minute)), http.MethodPost, "/api/v1/documents", "", string(body))
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+			var points int
+			for _, c := range srv.Collections() {
+				points += len(srv.Points(c))
+			}
+			if points != tt.wantChunks {
+				t.Errorf("stored %d chunks, want %d", points, tt.wantChunks)
+			}
+		})
+	}
+}
+
+func TestIngestHandlerTitleLength(t *testing.T) {
+	tests := []struct {
+		name       string
+		title      string
+		wantStatus int
+	}{
+		{"at the column width", strings.Repeat("a", 255), http.StatusOK},
+		{"multi-byte at the column width", strings.Repeat("é", 255), http.StatusOK},
+		{"one past the column width", strings.Repeat("a", 256), http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			kb, srv := newTestKB(t)
+			body, _ := json.Marshal(map[string]any{"text": "The Colosseum is in Rome.", "source": "rome.md", "user_id": testUser, "title": tt.title})
+			rec := serve(ingestHandler(kb, &memDocuments{}, newRateLimiter(100, 100, time.Minute)), http.MethodPost, "/api/v1/documents", "", string(body))
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+			if tt.wantStatus != http.StatusOK && len(srv.Points(agent.CollectionName())) != 0 {
+				t.Error("stored chunks for a rejected title")
+			}
+		})
+	}
+}
+
+// The handler tests run with the default RAG_MIN_CONTENT_RUNES of 10.
+func TestIngestHandlerRejectsShortContent(t *testing.T) {
+	tests := []struct {
+		name       string
+		text       string
+		wantStatus int
+	}{
+		{"below the minimum", "hi there", http.StatusBadRequest},
+		{"at the minimum", "hi there!!", http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			kb, srv := newTestKB(t)
+			body, _ := json.Marshal(map[string]any{"text": tt.text, "source": "short.txt", "user_id": testUser})
+			rec := serve(ingestHandler(kb, &memDocuments{}, newRateLimiter(100, 100, time.Minute)), http.MethodPost, "/api/v1/documents", "", string(body))
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+			if tt.wantStatus != http.StatusOK {
+				if !strings.Contains(rec.Body.String(), "too short") {
+					t.Errorf("body = %q, want it to say the content is too short", rec.Body)
+				}
+				if n := len(srv.Points(agent.CollectionName())); n != 0 {
+					t.Errorf("stored %d points for rejected content", n)
+				}
+			}
+		})
+	}
+}
@@ -3,30 +3,56 @@ package main
 import (
 	"context"
 	"encoding/json"
-	"log"
+	"errors"
+	"log/slog"
 	"net/http"
 	"os"
 	"os/signal"
+	"regexp"
+	"sort"
 	"strings"
 	"syscall"
 	"time"
 
 	"core-go/internal/agent"
 	"core-go/internal/db"
+	"core-go/internal/llm"
+	"core-go/internal/logging"
 	"core-go/internal/vector"
 )
 
-var allowedOrigins = func() map[string]bool {
-	raw := strings.TrimSpace(os.Getenv("ALLOWED_ORIGINS"))
+// devOrigins is the CORS allowlist used outside production when none is
+// configured: the local admin panel and Expo web dev servers.
+var devOrigins = []string{
+	"http://localhost:3000",
+	"http://localhost:5173",
+	"http://127.0.0.1:3000",
+	"http://127.0.0.1:5173",
+}
+
+// allowedOrigins is the CORS allowlist; see loadAllowedOrigins.
+var allowedOrigins = loadAllowedOrigins()
+
+// loadAllowedOrigins reads the CORS allowlist from CORS_ALLOWED_ORIGINS
+// (comma-separated; ALLOWED_ORIGINS is accepted as the older name). "*"
+// allows any origin and must be listed explicitly. When unset, production
+// (APP_ENV=production) allows no cross-origin callers and every other
+// environment allows devOrigins.
+func loadAllowedOrigins() map[string]bool {
+	raw := strings.TrimSpace(os.Getenv("CORS_ALLOWED_ORIGINS"))
 	if raw == "" {
-		return map[string]bool{
-			"http://localhost:3000": true,
-			"http://localhost:5173": true,
-			"http://127.0.0.1:3000": true,
-			"http://127.0.0.1:5173": true,
-		}
+		raw = strings.TrimSpace(os.Getenv("ALLOWED_ORIGINS"))
 	}
 	set := map[string]bool{}
+	if raw == "" {
+		if strings.EqualFold(strings.TrimSpace(os.Getenv("APP_ENV")), "production") {
+			return set
+		}
+		for _, origin := range devOrigins {
+			set[origin] = true
+		}
+		return set
+	}
 	for _, part := range strings.Split(raw, ",") {
 		origin := strings.TrimSpace(part)
 		if origin != "" {
@@ -34,7 +60,7 @@ var allowedOrigins = func() map[string]bool {
 		}
 	}
 	return set
-}()
+}
 
 type loggingResponseWriter struct {
 	http.ResponseWriter
@@ -62,6 +88,30 @@ func (lrw *loggingResponseWriter) Flush() {
 	}
 }
 
+// Unwrap exposes the underlying writer to http.ResponseController, so
+// handlers can still adjust deadlines or enable full duplex through it.
+func (lrw *loggingResponseWriter) Unwrap() http.ResponseWriter {
+	return lrw.ResponseWriter
+}
+
+// requestIDPattern bounds what an inbound X-Request-ID may contain so a
+// client cannot inject arbitrary bytes into log lines.
+var requestIDPattern = regexp.MustCompile(`^[A-Za-z0-9._-]{1,128}$`)
+
+// requestIDMiddleware honours an inbound X-Request-ID when it is well-formed,
+// otherwise generates a fresh one. The ID is stored in the request context
+// (see logging.FromContext) and echoed in the response header.
+func requestIDMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := strings.TrimSpace(r.Header.Get(logging.RequestIDHeader))
+		if !requestIDPattern.MatchString(id) {
+			id = logging.NewRequestID()
+		}
+		w.Header().Set(logging.RequestIDHeader, id)
+		next.ServeHTTP(w, r.WithContext(logging.WithRequestID(r.Context(), id)))
+	})
+}
+
 // requestLoggerMiddleware logs one line per request with method, path,
 // response status, response bytes, caller address, and latency.
 func requestLoggerMiddleware(next http.Handler) http.Handler {
@@ -76,39 +126,49 @@ func requestLoggerMiddleware(next http.Handler) http.Handler {
 			status = http.StatusOK
 		}
 
-		log.Printf("http: method=%s path=%s status=%d bytes=%d remote=%s duration=%s",
-			r.Method,
-			r.URL.Path,
-			status,
-			lrw.bytesWritten,
-			r.RemoteAddr,
-			time.Since(start).Round(time.Millisecond),
+		logging.FromContext(r.Context()).Info("http: request",
+			"method", r.Method,
+			"path", r.URL.Path,
+			"status", status,
+			"bytes", lrw.bytesWritten,
+			"remote", r.RemoteAddr,
+			"duration", time.Since(start).Round(time.Millisecond),
 		)
 	})
 }
 
-// corsMiddleware adds permissive CORS headers so the admin React panel
-// (running on a different port, e.g. localhost:5173) can reach this API.
-// For production, restrict Access-Control-Allow-Origin to the panel's origin.
+// corsMaxAge is how long browsers may cache a preflight response.
+const corsMaxAge = "600"
+
+// corsMiddleware applies the allowedOrigins CORS policy. Requests without
+// an Origin header (curl, the mobile app) pass through untouched. A
+// disallowed origin gets 403, including on preflight. Preflight OPTIONS
+// requests are answered here for every route. SSE responses need no extra
+// headers: a streaming fetch is an ordinary CORS request.
 func corsMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		origin := strings.TrimSpace(r.Header.Get("Origin"))
-		if origin != "" {
-			if !allowedOrigins[origin] {
-				if r.Method == http.MethodOptions {
-					http.Error(w, "origin not allowed", http.StatusForbidden)
-					return
-				}
-				http.Error(w, "origin not allowed", http.StatusForbidden)
-				return
-			}
+		if origin == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		w.Header().Add("Vary", "Origin")
+		switch {
+		case allowedOrigins[origin]:
 			w.Header().Set("Access-Control-Allow-Origin", origin)
-			w.Header().Set("Vary", "Origin")
+		case allowedOrigins["*"]:
+			w.Header().Set("Access-Control-Allow-Origin", "*")
+		default:
+			http.Error(w, "origin not allowed", http.StatusForbidden)
+			return
 		}
+		w.Header().Set("Access-Control-Expose-Headers", "X-Request-ID, X-Conversation-ID")
 
-		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, PATCH, OPTIONS")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Accept, X-Admin-Token")
-		if r.Method == http.MethodOptions {
+		if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+			w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, PATCH, OPTIONS")
+			w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Accept, Authorization, X-Admin-Token, X-Request-ID, Idempotency-Key")
+			w.Header().Set("Access-Control-Max-Age", corsMaxAge)
 			w.WriteHeader(http.StatusNoContent)
 			return
 		}
@@ -142,8 +202,51 @@ func healthHandler(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
+type dbHealthResponse struct {
+	OK        bool  `json:"ok"`
+	LatencyMS int64 `json:"latency_ms"`
+	OpenConns int32 `json:"open_conns"`
+	IdleConns int32 `json:"idle_conns"`
+}
+
+// dbHealthTimeout bounds the health query so a wedged database answers 503
+// promptly instead of hanging the probe.
+const dbHealthTimeout = 2 * time.Second
+
+// dbHealthHandler reports Postgres round-trip latency and pool usage.
+// Responds 503 when the query fails.
+func dbHealthHandler(pool db.HealthSource) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), dbHealthTimeout)
+		defer cancel()
+
+		h, err := db.CheckHealth(ctx, pool)
+		status := http.StatusOK
+		if err != nil {
+			logging.FromContext(r.Context()).Warn("health: db check failed", "err", err)
+			status = http.StatusServiceUnavailable
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(dbHealthResponse{
+			OK:        err == nil,
+			LatencyMS: h.Latency.Milliseconds(),
+			OpenConns: h.OpenConns,
+			IdleConns: h.IdleConns,
+		})
+	}
+}
+
+// fatal logs msg at error level and exits the process.
+func fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	os.Exit(1)
+}
+
 func main() {
 	ctx := context.Background()
+	slog.SetDefault(slog.New(slog.NewTextHandler(os.Stdout, nil)))
 
 	// ── PostgreSQL ────────────────────────────────────────────────────────────
 	dsn := os.Getenv("DATABASE_URL")
@@ -152,11 +255,13 @@ func main() {
 	}
 	pool, err := db.NewPool(ctx, dsn)
 	if err != nil {
-		log.Fatalf("db pool: %v", err)
+		fatal("db pool", "err", err)
 	}
 	defer pool.Close()
 
 	taskRepo := db.NewTaskRepository(pool)
+	convoRepo := db.NewConversationRepository(pool)
+	docRepo := db.NewDocumentRepository(pool)
 
 	// ── Qdrant ────────────────────────────────────────────────────────────────
 	qdrantURL := os.Getenv("QDRANT_URL")
@@ -164,54 +269,213 @@ func main() {
 		qdrantURL = "http://localhost:6333"
 	}
 	qdrantClient := vector.NewQdrantClient(qdrantURL)
+	qdrantClient.SetUpsertBatchSize(getEnvInt("QDRANT_UPSERT_BATCH_SIZE", 64))
+	qdrantClient.SetRetryPolicy(
+		getEnvInt("QDRANT_RETRY_ATTEMPTS", 4),
+		getEnvDuration("QDRANT_RETRY_BASE_DELAY", 250*time.Millisecond),
+	)
+
+	embedder, err := llm.NewEmbedderFromEnv()
+	if err != nil {
+		fatal("embedder", "err", err)
+	}
+	chat, err := llm.NewChatProviderFromEnv()
+	if err != nil {
+		fatal("chat provider", "err", err)
+	}
+	if llm.FakeEnabled() {
+		slog.Warn("llm: LLM_FAKE is set; using deterministic fake embedding and chat providers")
+	}
+
+	// Ask the embedder for its vector size rather than trusting the model
+	// table. An unreachable embedder only costs the probe: the table (or
+	// EMBEDDING_DIM) is used instead. A probe that contradicts EMBEDDING_DIM
+	// is fatal, since every upsert would fail.
+	if getEnvBool("EMBEDDING_PROBE_DIM", true) {
+		probeCtx, cancel := context.WithTimeout(ctx, getEnvDuration("EMBEDDING_PROBE_TIMEOUT", 30*time.Second))
+		probedDim, err := llm.ProbeEmbeddingDim(probeCtx, embedder)
+		cancel()
+		switch {
+		case errors.Is(err, llm.ErrEmbeddingDimMismatch):
+			fatal("embedding dimension", "err", err)
+		case err != nil:
+			slog.Warn("embed: dimension probe failed, using configured dimension", "err", err)
+		default:
+			slog.Info("embed: probed dimension", "dims", probedDim, "embedding_model", llm.EmbeddingModel())
+		}
+	}
 
 	// Ensure the "Personal Context" collection exists before serving requests.
 	// This is idempotent: if the collection already exists Qdrant returns 200.
 	// Doing it at startup avoids a race where the first RAG query arrives
 	// before any documents have been ingested.
-	if err := qdrantClient.EnsureCollection(ctx, agent.CollectionName(), agent.CollectionDim()); err != nil {
-		log.Fatalf("qdrant: ensure collection: %v", err)
+	dim, err := agent.CollectionDim()
+	if err != nil {
+		fatal("embedding dimension", "err", err)
+	}
+	distance, err := agent.CollectionDistance()
+	if err != nil {
+		fatal("qdrant distance", "err", err)
 	}
-	log.Printf("qdrant: collection %q ready (%d dims)", agent.CollectionName(), agent.CollectionDim())
+	if err := qdrantClient.EnsureCollection(ctx, agent.CollectionName(), dim, distance); err != nil {
+		fatal("qdrant: ensure collection", "err", err)
+	}
+	slog.Info("qdrant: collection ready", "collection", agent.CollectionName(), "dims", dim, "distance", distance, "embedding_model", llm.EmbeddingModel())
+
+	// ── Model warm-up ─────────────────────────────────────────────────────────
+	// Ollama loads models lazily, so the first embed/chat after boot can take
+	// far longer than a client is willing to wait. Load them in the background
+	// so startup is not blocked; failure only means the first request is slow.
+	go func() {
+		warmCtx, cancel := context.WithTimeout(ctx, getEnvDuration("LLM_WARMUP_TIMEOUT", 2*time.Minute))
+		defer cancel()
+		start := time.Now()
+		if err := llm.WarmUp(warmCtx, embedder, chat); err != nil {
+			slog.Warn("llm: warm-up failed", "err", err, "elapsed", time.Since(start))
+			return
+		}
+		slog.Info("llm: warm-up complete", "elapsed", time.Since(start))
+	}()
 
 	// ── Agent services ────────────────────────────────────────────────────────
-	kb := agent.NewKnowledgeBase(qdrantClient)
-	ta := agent.NewTaskAgent(taskRepo)
+	kb := agent.NewKnowledgeBase(qdrantClient, embedder, chat)
+	collectionMode, err := agent.CollectionMode()
+	if err != nil {
+		fatal("rag collection mode", "err", err)
+	}
+	kb.SetCollectionMode(collectionMode)
+	slog.Info("rag: collection mode", "mode", collectionMode)
+	switch store := strings.ToLower(strings.TrimSpace(os.Getenv("RAG_CHUNK_STORE"))); store {
+	case "", "qdrant":
+	case "postgres":
+		kb.SetChunkStore(agent.NewPostgresChunkStore(db.NewChunkRepository(pool)))
+		slog.Info("rag: chunk text stored in postgres")
+	default:
+		fatal("rag: unknown RAG_CHUNK_STORE (want qdrant or postgres)", "value", store)
+	}
+	ta := agent.NewTaskAgent(taskRepo, chat)
+	prompts, err := agent.LoadPrompts()
+	if err != nil {
+		fatal("prompts", "err", err)
+	}
+	if err := kb.SetSystemPrompt(prompts.RAG); err != nil {
+		fatal("prompts", "err", err)
+	}
+	ta.SetSystemPrompt(prompts.Agent)
+	hnswEf, err := getEnvNonNegativeInt("QDRANT_SEARCH_HNSW_EF", 0)
+	if err != nil {
+		fatal("qdrant search params", "err", err)
+	}
+	askOpts := agent.AskOptions{
+		AllowFallback: getEnvBool("RAG_ALLOW_FALLBACK", false),
+		Search: &vector.SearchParams{
+			HnswEf: hnswEf,
+			Exact:  getEnvBool("QDRANT_SEARCH_EXACT", false),
+		},
+		Generation: llm.Options{
+			Temperature: getEnvOptionalFloat("RAG_TEMPERATURE"),
+			TopP:        getEnvOptionalFloat("RAG_TOP_P"),
+			NumCtx:      getEnvInt("RAG_NUM_CTX", 0),
+			NumPredict:  getEnvInt("RAG_NUM_PREDICT", 0),
+		},
+	}
+	if err := askOpts.Generation.Validate(); err != nil {
+		fatal("rag generation options", "err", err)
+	}
+	var intents *agent.IntentClassifier
+	if getEnvBool("CHAT_INTENT_CLASSIFIER", false) {
+		intents = agent.NewIntentClassifier(embedder)
+	}
+	history := historyLimit{
+		MaxTurns: getEnvInt("CHAT_MAX_TURNS", 50),
+		Truncate: getEnvBool("CHAT_TRUNCATE_HISTORY", false),
+	}
+
+	// ── Rate limiting ─────────────────────────────────────────────────────────
+	// Ingest embeds every chunk through Ollama, so one client posting large
+	// documents in a loop can starve chat. Throttle per user_id.
+	ingestLimiter := newRateLimiter(
+		getEnvFloat("INGEST_RATE_PER_SEC", 0.2),
+		getEnvInt("INGEST_RATE_BURST", 5),
+		10*time.Minute,
+	)
+	go ingestLimiter.runCleanup(ctx, time.Minute)
+
+	// Each streaming chat holds an LLM stream for its whole reply; cap how
+	// many overlap so a single-GPU Ollama is not swamped.
+	streams := newStreamLimiter(getEnvInt("CHAT_MAX_STREAMS", 0))
+	streamIDs := newStreamRegistry()
+	statusEvents := getEnvBool("CHAT_STATUS_EVENTS", true)
+
+	// ── User auth ─────────────────────────────────────────────────────────────
+	// AUTH_TOKENS maps bearer tokens to user_ids; when set, every route
+	// that reads or changes a user's data requires a valid token, and the
+	// token's user_id replaces the one the client sends.
+	bearerTokens, err := parseBearerTokens(os.Getenv("AUTH_TOKENS"))
+	if err != nil {
+		fatal("auth", "err", err)
+	}
+	userAuth := bearerAuthMiddleware(bearerTokens)
 
 	// ── Routes ───────────────────────────────────────────────────────────────
 	mux := http.NewServeMux()
 	mux.HandleFunc("GET /health", healthHandler)
-	mux.HandleFunc("POST /api/v1/chat", chatHandler(kb, ta))
-	mux.Handle("POST /api/v1/documents", adminAuthMiddleware(http.HandlerFunc(ingestHandler(kb))))
-	mux.HandleFunc("GET /api/v1/tasks", listTasksHandler(taskRepo))
-	mux.HandleFunc("PATCH /api/v1/tasks/{id}", updateTaskHandler(taskRepo))
-	mux.HandleFunc("DELETE /api/v1/tasks/{id}", deleteTaskHandler(taskRepo))
+	mux.HandleFunc("GET /health/db", dbHealthHandler(db.PoolHealth(pool)))
+	mux.HandleFunc("GET /api/v1/chat/events", chatEventsHandler)
+	mux.Handle("POST /api/v1/chat", userAuth(chatHandler(kb, ta, convoRepo, askOpts, history, intents, streams, streamIDs, statusEvents)))
+	mux.Handle("POST /api/v1/chat/abort", userAuth(abortChatHandler(streamIDs)))
+	mux.Handle("GET /api/v1/conversations", userAuth(listConversationsHandler(convoRepo)))
+	mux.Handle("GET /api/v1/conversations/{id}/messages", userAuth(listConversationMessagesHandler(convoRepo)))
+	mux.Handle("GET /api/v1/documents", userAuth(listDocumentsHandler(docRepo)))
+	mux.Handle("POST /api/v1/documents", adminAuthMiddleware(http.HandlerFunc(ingestHandler(kb, docRepo, ingestLimiter))))
+	mux.Handle("DELETE /api/v1/documents/{id}", adminAuthMiddleware(userAuth(deleteDocumentHandler(docRepo, kb))))
+	mux.Handle("POST /api/v1/documents/{source}/rechunk", adminAuthMiddleware(userAuth(rechunkDocumentHandler(docRepo, kb))))
+	mux.Handle("GET /api/v1/tasks", gzipMiddleware(userAuth(listTasksHandler(taskRepo))))
+	mux.Handle("POST /api/v1/tasks/batch", userAuth(batchCreateTasksHandler(taskRepo)))
+	mux.Handle("GET /api/v1/tasks/export", gzipMiddleware(userAuth(exportTasksHandler(taskRepo))))
+	mux.Handle("POST /api/v1/tasks/import", userAuth(importTasksHandler(taskRepo)))
+	mux.Handle("GET /api/v1/tasks/stats", userAuth(taskStatsHandler(taskRepo)))
+	mux.Handle("PATCH /api/v1/tasks/bulk", userAuth(bulkStatusHandler(taskRepo)))
+	mux.Handle("GET /api/v1/tasks/{id}", userAuth(getTaskHandler(taskRepo)))
+	mux.Handle("PATCH /api/v1/tasks/{id}", userAuth(updateTaskHandler(taskRepo)))
+	mux.Handle("DELETE /api/v1/tasks/{id}", userAuth(deleteTaskHandler(taskRepo)))
+	mux.Handle("POST /api/v1/tasks/{id}/complete", userAuth(completeTaskHandler(taskRepo)))
+	mux.Handle("POST /api/v1/tasks/{id}/next", userAuth(nextOccurrenceHandler(taskRepo)))
+	mux.Handle("DELETE /api/v1/users/{user_id}/data", adminAuthMiddleware(http.HandlerFunc(purgeUserDataHandler(taskRepo, convoRepo, docRepo, kb))))
 
 	// ── Admin panel routes ────────────────────────────────────────────────────
-	mux.Handle("GET /api/v1/admin/documents", adminAuthMiddleware(http.HandlerFunc(listAdminDocsHandler(qdrantClient))))
-	mux.Handle("DELETE /api/v1/admin/documents", adminAuthMiddleware(http.HandlerFunc(deleteAdminDocHandler(qdrantClient))))
-	mux.Handle("PUT /api/v1/admin/documents", adminAuthMiddleware(http.HandlerFunc(updateAdminDocHandler(qdrantClient, kb))))
+	mux.Handle("GET /api/v1/admin/documents", adminAuthMiddleware(http.HandlerFunc(listAdminDocsHandler(kb))))
+	mux.Handle("DELETE /api/v1/admin/documents", adminAuthMiddleware(http.HandlerFunc(deleteAdminDocHandler(kb, docRepo))))
+	mux.Handle("PUT /api/v1/admin/documents", adminAuthMiddleware(http.HandlerFunc(updateAdminDocHandler(kb, docRepo))))
+	mux.Handle("GET /api/v1/admin/documents/stale", adminAuthMiddleware(http.HandlerFunc(listStaleDocsHandler(kb))))
+	mux.Handle("GET /api/v1/admin/search", adminAuthMiddleware(http.HandlerFunc(adminSearchHandler(kb))))
+	mux.Handle("POST /api/v1/admin/similarity", adminAuthMiddleware(http.HandlerFunc(similarityHandler(kb))))
+	mux.Handle("POST /api/v1/admin/reembed", adminAuthMiddleware(http.HandlerFunc(reembedHandler(kb))))
 
 	// ── Server ────────────────────────────────────────────────────────────────
-	server := &http.Server{
-		Addr:              ":8080",
-		Handler:           requestLoggerMiddleware(securityHeadersMiddleware(corsMiddleware(mux))),
-		ReadHeaderTimeout: 5 * time.Second,
-		ReadTimeout:       15 * time.Second,
-		WriteTimeout:      30 * time.Second,
-		IdleTimeout:       60 * time.Second,
-	}
+	server := newServer(":8080", requestIDMiddleware(requestLoggerMiddleware(securityHeadersMiddleware(corsMiddleware(mux)))))
 
 	if adminAuthEnabled() {
-		log.Printf("security: admin token auth enabled for /api/v1/admin/* and /api/v1/documents")
+		slog.Info("security: admin token auth enabled for /api/v1/admin/* and /api/v1/documents")
+	} else {
+		slog.Info("security: admin token auth disabled (set ADMIN_API_KEY to enable)")
+	}
+	origins := make([]string, 0, len(allowedOrigins))
+	for origin := range allowedOrigins {
+		origins = append(origins, origin)
+	}
+	sort.Strings(origins)
+	if len(bearerTokens) > 0 {
+		slog.Info("security: bearer token auth enabled for user routes", "tokens", len(bearerTokens))
 	} else {
-		log.Printf("security: admin token auth disabled (set ADMIN_API_KEY to enable)")
+		slog.Info("security: bearer token auth disabled (set AUTH_TOKENS to enable)")
 	}
+	slog.Info("security: cors allowlist", "origins", origins)
 
 	go func() {
-		log.Println("core-go listening on :8080")
+		slog.Info("core-go listening", "addr", server.Addr)
 		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-			log.Fatalf("server error: %v", err)
+			fatal("server error", "err", err)
 		}
 	}()
 
@@ -220,14 +484,59 @@ func main() {
 	signal.Notify(quit, os.Interrupt, syscall.SIGTERM)
 	<-quit
 
-	log.Println("shutdown signal received, draining connections...")
+	slog.Info("shutdown signal received, draining connections...")
 
-	shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	// Chat streams get CHAT_SHUTDOWN_GRACE to finish before they are cut
+	// with a shutting_down event; the rest of the shutdown budget covers
+	// flushing that event and ordinary requests.
+	grace := getEnvDuration("CHAT_SHUTDOWN_GRACE", 5*time.Second)
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), grace+10*time.Second)
 	defer cancel()
 
-	if err := server.Shutdown(shutdownCtx); err != nil {
-		log.Fatalf("graceful shutdown failed: %v", err)
+	shutdownErr := make(chan error, 1)
+	go func() { shutdownErr <- server.Shutdown(shutdownCtx) }()
+	if n := streamIDs.Drain(grace); n > 0 {
+		slog.Info("shutdown: stopped in-flight chat streams", "streams", n, "grace", grace)
+	}
+	if err := <-shutdownErr; err != nil {
+		fatal("graceful shutdown failed", "err", err)
+	}
+
+	slog.Info("shutdown complete")
+}
+
+// newServer returns the API server with its connection timeouts:
+// HTTP_READ_HEADER_TIMEOUT (default 5s) bounds slow-loris style header
+// trickling, HTTP_READ_TIMEOUT (default 15s) the whole request including
+// the body, HTTP_WRITE_TIMEOUT (default 30s) the response, and
+// HTTP_IDLE_TIMEOUT (default 60s) how long a keep-alive connection may sit
+// unused. Streaming routes lift the read and write deadlines per request
+// with liftDeadlines.
+func newServer(addr string, handler http.Handler) *http.Server {
+	return &http.Server{
+		Addr:              addr,
+		Handler:           handler,
+		ReadHeaderTimeout: getEnvDuration("HTTP_READ_HEADER_TIMEOUT", 5*time.Second),
+		ReadTimeout:       getEnvDuration("HTTP_READ_TIMEOUT", 15*time.Second),
+		WriteTimeout:      getEnvDuration("HTTP_WRITE_TIMEOUT", 30*time.Second),
+		IdleTimeout:       getEnvDuration("HTTP_IDLE_TIMEOUT", 60*time.Second),
 	}
+}
 
-	log.Println("shutdown complete")
+// liftDeadlines clears the server's write deadline for a streamed response
+// that runs until it ends on its own or the client disconnects, and with
+// read also its read deadline, for a request body consumed as it arrives.
+// Failures are only logged: the route still works within the normal limits.
+func liftDeadlines(w http.ResponseWriter, r *http.Request, read bool) {
+	rc := http.NewResponseController(w)
+	logger := logging.FromContext(r.Context())
+	if err := rc.SetWriteDeadline(time.Time{}); err != nil {
+		logger.Warn("http: cannot clear write deadline", "path", r.URL.Path, "err", err)
+	}
+	if !read {
+		return
+	}
+	if err := rc.SetReadDeadline(time.Time{}); err != nil {
+		logger.Warn("http: cannot clear read deadline", "path", r.URL.Path, "err", err)
+	}
 }
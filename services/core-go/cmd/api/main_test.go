@@ -0,0 +1,244 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"core-go/internal/logging"
+)
+
+// stubHealth is a db.HealthSource with a fixed ping result.
+type stubHealth struct{ err error }
+
+func (s stubHealth) Ping(context.Context) error     { return s.err }
+func (s stubHealth) ConnCounts() (open, idle int32) { return 5, 2 }
+
+func TestDBHealthHandler(t *testing.T) {
+	tests := []struct {
+		name       string
+		pingErr    error
+		wantStatus int
+		wantOK     bool
+	}{
+		{"reachable", nil, http.StatusOK, true},
+		{"unreachable", errors.New("connection refused"), http.StatusServiceUnavailable, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			dbHealthHandler(stubHealth{tt.pingErr})(rec, httptest.NewRequest(http.MethodGet, "/health/db", nil))
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			var body dbHealthResponse
+			if err := json.NewDecoder(rec.Body).Decode(&body); err != nil {
+				t.Fatal(err)
+			}
+			if body.OK != tt.wantOK || body.OpenConns != 5 || body.IdleConns != 2 {
+				t.Errorf("body = %+v, want ok=%v with 5 open/2 idle", body, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestLiftDeadlines(t *testing.T) {
+	const timeout = 100 * time.Millisecond
+	tests := []struct {
+		name     string
+		lift     bool
+		read     bool
+		slowBody bool // the client trickles the body past ReadTimeout
+		wantBody bool
+	}{
+		{"slow reply cut off by write timeout", false, false, false, false},
+		{"slow reply with write deadline lifted", true, false, false, true},
+		{"slow body cut off by read timeout", false, false, true, false},
+		{"slow body with write deadline only", true, false, true, false},
+		{"slow body with read deadline lifted", true, true, true, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("HTTP_READ_TIMEOUT", timeout.String())
+			t.Setenv("HTTP_WRITE_TIMEOUT", timeout.String())
+
+			srv := httptest.NewUnstartedServer(nil)
+			srv.Config = newServer("", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if tt.lift {
+					liftDeadlines(w, r, tt.read)
+				}
+				if _, err := io.ReadAll(r.Body); err != nil {
+					return
+				}
+				if !tt.slowBody {
+					time.Sleep(2 * timeout)
+				}
+				io.WriteString(w, "done")
+			}))
+			srv.Start()
+			defer srv.Close()
+
+			var body io.Reader = strings.NewReader("{}")
+			if tt.slowBody {
+				pr, pw := io.Pipe()
+				go func() {
+					pw.Write([]byte("{"))
+					time.Sleep(2 * timeout)
+					pw.Write([]byte("}"))
+					pw.Close()
+				}()
+				body = pr
+			}
+			resp, err := http.Post(srv.URL, "application/json", body)
+			got := ""
+			if err == nil {
+				b, _ := io.ReadAll(resp.Body)
+				resp.Body.Close()
+				got = string(b)
+			}
+			if (got == "done") != tt.wantBody {
+				t.Errorf("response body = %q (err %v), want complete = %v", got, err, tt.wantBody)
+			}
+		})
+	}
+}
+
+func TestRequestIDMiddleware(t *testing.T) {
+	generated := regexp.MustCompile(`^[0-9a-f]{32}$`)
+	tests := []struct {
+		name    string
+		inbound string
+		want    string // "" expects a freshly generated ID
+	}{
+		{"inbound id echoed", "req-42.abc_DEF", "req-42.abc_DEF"},
+		{"inbound id trimmed", "  req-42  ", "req-42"},
+		{"missing id generated", "", ""},
+		{"unsafe characters replaced", "bad id\nforged=1", ""},
+		{"overlong id replaced", strings.Repeat("a", 129), ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var inContext string
+			h := requestIDMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				inContext = logging.RequestID(r.Context())
+			}))
+			req := httptest.NewRequest(http.MethodGet, "/health", nil)
+			if tt.inbound != "" {
+				req.Header.Set(logging.RequestIDHeader, tt.inbound)
+			}
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+
+			got := rec.Header().Get(logging.RequestIDHeader)
+			if tt.want != "" && got != tt.want {
+				t.Errorf("%s = %q, want %q", logging.RequestIDHeader, got, tt.want)
+			}
+			if tt.want == "" && !generated.MatchString(got) {
+				t.Errorf("%s = %q, want a generated 32-hex-digit ID", logging.RequestIDHeader, got)
+			}
+			if inContext != got {
+				t.Errorf("context request ID = %q, response header = %q", inContext, got)
+			}
+		})
+	}
+}
+
+func TestLoadAllowedOrigins(t *testing.T) {
+	tests := []struct {
+		name    string
+		origins string
+		legacy  string
+		appEnv  string
+		want    []string
+	}{
+		{"unset in development allows dev servers", "", "", "", devOrigins},
+		{"unset in production denies all", "", "", "production", nil},
+		{"configured list", " https://a.example , https://b.example,", "", "production", []string{"https://a.example", "https://b.example"}},
+		{"explicit wildcard", "*", "", "production", []string{"*"}},
+		{"older variable name", "", "https://old.example", "", []string{"https://old.example"}},
+		{"new name wins", "https://new.example", "https://old.example", "", []string{"https://new.example"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("CORS_ALLOWED_ORIGINS", tt.origins)
+			t.Setenv("ALLOWED_ORIGINS", tt.legacy)
+			t.Setenv("APP_ENV", tt.appEnv)
+			got := loadAllowedOrigins()
+			if len(got) != len(tt.want) {
+				t.Fatalf("loadAllowedOrigins() = %v, want %v", got, tt.want)
+			}
+			for _, origin := range tt.want {
+				if !got[origin] {
+					t.Errorf("loadAllowedOrigins() = %v, missing %q", got, origin)
+				}
+			}
+		})
+	}
+}
+
+func TestCORSMiddleware(t *testing.T) {
+	tests := []struct {
+		name        string
+		allowed     []string
+		method      string
+		origin      string
+		preflight   bool
+		wantStatus  int
+		wantOrigin  string
+		wantMethods bool // Access-Control-Allow-Methods set
+		wantNext    bool
+	}{
+		{"allowed origin", []string{"https://app.example"}, http.MethodPost, "https://app.example", false, http.StatusOK, "https://app.example", false, true},
+		{"disallowed origin", []string{"https://app.example"}, http.MethodPost, "https://evil.example", false, http.StatusForbidden, "", false, false},
+		{"no origin passes through", nil, http.MethodPost, "", false, http.StatusOK, "", false, true},
+		{"empty allowlist denies", nil, http.MethodGet, "https://app.example", false, http.StatusForbidden, "", false, false},
+		{"wildcard", []string{"*"}, http.MethodGet, "https://any.example", false, http.StatusOK, "*", false, true},
+		{"preflight allowed", []string{"https://app.example"}, http.MethodOptions, "https://app.example", true, http.StatusNoContent, "https://app.example", true, false},
+		{"preflight disallowed", []string{"https://app.example"}, http.MethodOptions, "https://evil.example", true, http.StatusForbidden, "", false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			saved := allowedOrigins
+			t.Cleanup(func() { allowedOrigins = saved })
+			allowedOrigins = map[string]bool{}
+			for _, o := range tt.allowed {
+				allowedOrigins[o] = true
+			}
+
+			var called bool
+			h := corsMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				called = true
+				w.Header().Set("Content-Type", "text/event-stream")
+			}))
+			req := httptest.NewRequest(tt.method, "/api/v1/chat", nil)
+			if tt.origin != "" {
+				req.Header.Set("Origin", tt.origin)
+			}
+			if tt.preflight {
+				req.Header.Set("Access-Control-Request-Method", http.MethodPost)
+			}
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if got := rec.Header().Get("Access-Control-Allow-Origin"); got != tt.wantOrigin {
+				t.Errorf("Access-Control-Allow-Origin = %q, want %q", got, tt.wantOrigin)
+			}
+			if got := rec.Header().Get("Access-Control-Allow-Methods") != ""; got != tt.wantMethods {
+				t.Errorf("Access-Control-Allow-Methods set = %v, want %v", got, tt.wantMethods)
+			}
+			if called != tt.wantNext {
+				t.Errorf("next handler called = %v, want %v", called, tt.wantNext)
+			}
+		})
+	}
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+)
+
+// tokenBucket is the per-key limiter state. tokens refills continuously at
+// the limiter's rate up to burst.
+type tokenBucket struct {
+	tokens   float64
+	lastSeen time.Time
+}
+
+// rateLimiter is an in-memory, per-key token-bucket limiter. It is used to
+// stop a single user from saturating Ollama with back-to-back ingests.
+// It is safe for concurrent use.
+type rateLimiter struct {
+	mu      sync.Mutex
+	rate    float64 // tokens added per second
+	burst   float64 // bucket capacity
+	idleTTL time.Duration
+	buckets map[string]*tokenBucket
+	now     func() time.Time
+}
+
+// newRateLimiter returns a limiter that allows burst requests at once and
+// refills at rate requests per second. Buckets untouched for idleTTL are
+// dropped by cleanup.
+func newRateLimiter(rate float64, burst int, idleTTL time.Duration) *rateLimiter {
+	return &rateLimiter{
+		rate:    rate,
+		burst:   float64(burst),
+		idleTTL: idleTTL,
+		buckets: map[string]*tokenBucket{},
+		now:     time.Now,
+	}
+}
+
+// Allow consumes one token for key. When the bucket is empty it returns false
+// and how long the caller must wait before a token becomes available.
+func (l *rateLimiter) Allow(key string) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &tokenBucket{tokens: l.burst, lastSeen: now}
+		l.buckets[key] = b
+	}
+
+	elapsed := now.Sub(b.lastSeen).Seconds()
+	b.tokens = math.Min(l.burst, b.tokens+elapsed*l.rate)
+	b.lastSeen = now
+
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+
+	wait := (1 - b.tokens) / l.rate
+	return false, time.Duration(wait * float64(time.Second))
+}
+
+// cleanup removes buckets that have been idle longer than idleTTL.
+// An idle bucket is full by then anyway, so dropping it loses no state.
+func (l *rateLimiter) cleanup() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	cutoff := l.now().Add(-l.idleTTL)
+	for key, b := range l.buckets {
+		if b.lastSeen.Before(cutoff) {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+// runCleanup calls cleanup every interval until ctx is cancelled.
+func (l *rateLimiter) runCleanup(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			l.cleanup()
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// streamLimiter caps how many chat streams run at once server-wide. Each SSE
+// chat holds an LLM stream open for its whole reply, and a single-GPU
+// Ollama slows to a crawl for everyone when too many overlap. A nil
+// *streamLimiter is unlimited. It is safe for concurrent use.
+type streamLimiter struct {
+	slots chan struct{}
+}
+
+// streamRetryAfter is the Retry-After hint sent when every stream slot is
+// taken. Replies usually finish within seconds, so clients retry soon.
+const streamRetryAfter = 5 * time.Second
+
+// newStreamLimiter returns a limiter allowing max concurrent streams, or
+// nil (unlimited) when max <= 0.
+func newStreamLimiter(max int) *streamLimiter {
+	if max <= 0 {
+		return nil
+	}
+	return &streamLimiter{slots: make(chan struct{}, max)}
+}
+
+// TryAcquire takes a slot without waiting and reports whether one was free.
+// Every successful call must be paired with Release.
+func (l *streamLimiter) TryAcquire() bool {
+	if l == nil {
+		return true
+	}
+	select {
+	case l.slots <- struct{}{}:
+		return true
+	default:
+		return false
+	}
+}
+
+// Release returns a slot taken by TryAcquire.
+func (l *streamLimiter) Release() {
+	if l == nil {
+		return
+	}
+	<-l.slots
+}
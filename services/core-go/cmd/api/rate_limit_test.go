@@ -0,0 +1,207 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"core-go/internal/agent"
+	"core-go/internal/llm"
+)
+
+// fakeClock is a settable time source for rateLimiter.now.
+type fakeClock struct{ t time.Time }
+
+func (c *fakeClock) now() time.Time { return c.t }
+
+func TestRateLimiterAllow(t *testing.T) {
+	type step struct {
+		after    time.Duration // clock advance before the request
+		key      string
+		want     bool
+		wantWait time.Duration
+	}
+	tests := []struct {
+		name  string
+		steps []step
+	}{
+		{"burst then rejected", []step{
+			{0, "u1", true, 0},
+			{0, "u1", true, 0},
+			{0, "u1", true, 0},
+			{0, "u1", false, time.Second},
+		}},
+		{"recovers after the window", []step{
+			{0, "u1", true, 0},
+			{0, "u1", true, 0},
+			{0, "u1", true, 0},
+			{0, "u1", false, time.Second},
+			{time.Second, "u1", true, 0},
+			{0, "u1", false, time.Second},
+		}},
+		{"partial refill shortens the wait", []step{
+			{0, "u1", true, 0},
+			{0, "u1", true, 0},
+			{0, "u1", true, 0},
+			{250 * time.Millisecond, "u1", false, 750 * time.Millisecond},
+		}},
+		{"users are independent", []step{
+			{0, "u1", true, 0},
+			{0, "u1", true, 0},
+			{0, "u1", true, 0},
+			{0, "u1", false, time.Second},
+			{0, "u2", true, 0},
+		}},
+		{"refill caps at burst", []step{
+			{0, "u1", true, 0},
+			{time.Hour, "u1", true, 0},
+			{0, "u1", true, 0},
+			{0, "u1", true, 0},
+			{0, "u1", false, time.Second},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clock := &fakeClock{t: time.Unix(1_700_000_000, 0)}
+			l := newRateLimiter(1, 3, time.Minute)
+			l.now = clock.now
+			for i, s := range tt.steps {
+				clock.t = clock.t.Add(s.after)
+				ok, wait := l.Allow(s.key)
+				if ok != s.want || wait != s.wantWait {
+					t.Fatalf("request %d: Allow(%q) = (%v, %v), want (%v, %v)", i, s.key, ok, wait, s.want, s.wantWait)
+				}
+			}
+		})
+	}
+}
+
+func TestRateLimiterCleanup(t *testing.T) {
+	tests := []struct {
+		name     string
+		idle     time.Duration
+		wantKept bool
+	}{
+		{"recent bucket kept", 30 * time.Second, true},
+		{"idle bucket dropped", 2 * time.Minute, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clock := &fakeClock{t: time.Unix(1_700_000_000, 0)}
+			l := newRateLimiter(1, 3, time.Minute)
+			l.now = clock.now
+			l.Allow("u1")
+			clock.t = clock.t.Add(tt.idle)
+			l.cleanup()
+			if _, kept := l.buckets["u1"]; kept != tt.wantKept {
+				t.Errorf("bucket kept = %v, want %v", kept, tt.wantKept)
+			}
+		})
+	}
+}
+
+func TestIngestHandlerRateLimited(t *testing.T) {
+	const user = "6f1c2a7e-3b4d-4e5f-8a9b-0c1d2e3f4a5b"
+	kb, _ := newTestKB(t)
+	clock := &fakeClock{t: time.Unix(1_700_000_000, 0)}
+	limiter := newRateLimiter(0.5, 2, time.Minute)
+	limiter.now = clock.now
+	h := ingestHandler(kb, &memDocuments{}, limiter)
+
+	tests := []struct {
+		name           string
+		after          time.Duration
+		wantStatus     int
+		wantRetryAfter string
+	}{
+		{"first", 0, http.StatusOK, ""},
+		{"second", 0, http.StatusOK, ""},
+		{"third rejected", 0, http.StatusTooManyRequests, "2"},
+		{"allowed after the window", 2 * time.Second, http.StatusOK, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clock.t = clock.t.Add(tt.after)
+			body := `{"text":"The Colosseum is an ancient amphitheatre in Rome.","source":"rome.md","user_id":"` + user + `"}`
+			rec := httptest.NewRecorder()
+			h(rec, httptest.NewRequest(http.MethodPost, "/api/v1/documents", strings.NewReader(body)))
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+			if got := rec.Header().Get("Retry-After"); got != tt.wantRetryAfter {
+				t.Errorf("Retry-After = %q, want %q", got, tt.wantRetryAfter)
+			}
+		})
+	}
+}
+
+func TestStreamLimiter(t *testing.T) {
+	tests := []struct {
+		name     string
+		max      int
+		acquires int
+		want     []bool
+	}{
+		{"unlimited when zero", 0, 3, []bool{true, true, true}},
+		{"unlimited when negative", -1, 2, []bool{true, true}},
+		{"overflow rejected", 2, 3, []bool{true, true, false}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := newStreamLimiter(tt.max)
+			for i := 0; i < tt.acquires; i++ {
+				if got := l.TryAcquire(); got != tt.want[i] {
+					t.Fatalf("TryAcquire() #%d = %v, want %v", i+1, got, tt.want[i])
+				}
+			}
+			// Releasing one held slot frees room for exactly one more.
+			l.Release()
+			if !l.TryAcquire() {
+				t.Error("TryAcquire() after Release = false, want true")
+			}
+		})
+	}
+}
+
+func TestChatHandlerStreamLimit(t *testing.T) {
+	kb, _ := newTestKB(t)
+	streams := newStreamLimiter(1)
+	h := chatHandler(kb, agent.NewTaskAgent(&memTaskRepo{}, llm.FakeChatProvider{}), &fakeConversations{}, agent.AskOptions{},
+		historyLimit{MaxTurns: 50}, nil, streams, newStreamRegistry(), false)
+
+	tests := []struct {
+		name           string
+		hold           bool // another stream holds the only slot
+		stream         bool
+		wantStatus     int
+		wantRetryAfter string
+	}{
+		{"stream with a free slot", false, true, http.StatusOK, ""},
+		{"overflow stream rejected", true, true, http.StatusServiceUnavailable, "5"},
+		{"non-streaming reply not limited", true, false, http.StatusOK, ""},
+		{"slot freed after the other stream ends", false, true, http.StatusOK, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.hold {
+				if !streams.TryAcquire() {
+					t.Fatal("slot still held by an earlier request")
+				}
+				defer streams.Release()
+			}
+			rec := serve(h, http.MethodPost, "/api/v1/chat", "", chatBody("Where is the Colosseum?", map[string]any{"mode": routeRAG, "stream": tt.stream}))
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+			if got := rec.Header().Get("Retry-After"); got != tt.wantRetryAfter {
+				t.Errorf("Retry-After = %q, want %q", got, tt.wantRetryAfter)
+			}
+		})
+	}
+	// Every request has returned, so its slot must be free again.
+	if !streams.TryAcquire() {
+		t.Error("a finished stream did not release its slot")
+	}
+}
@@ -1,13 +1,17 @@
 package main
 
 import (
+	"context"
 	"crypto/subtle"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"net/http"
 	"os"
 	"regexp"
 	"strings"
+
+	"core-go/internal/vector"
 )
 
 var userIDRegex = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[1-5][0-9a-fA-F]{3}-[89abAB][0-9a-fA-F]{3}-[0-9a-fA-F]{12}$`)
@@ -21,7 +25,7 @@ func normalizeUserID(raw string, fallback string) string {
 }
 
 func isValidUserID(userID string) bool {
-	if userID == "admin" || userID == "default" {
+	if userID == vector.SharedUserID || userID == "default" {
 		return true
 	}
 	return userIDRegex.MatchString(userID)
@@ -61,3 +65,107 @@ func adminAuthMiddleware(next http.Handler) http.Handler {
 		next.ServeHTTP(w, r)
 	})
 }
+
+// ── Bearer-token user auth ────────────────────────────────────────────────────
+
+// authUserKey is the context key under which bearerAuthMiddleware stores
+// the authenticated user_id.
+type authUserKey struct{}
+
+// authenticatedUserID returns the user_id established by
+// bearerAuthMiddleware, if the request was authenticated.
+func authenticatedUserID(r *http.Request) (string, bool) {
+	userID, ok := r.Context().Value(authUserKey{}).(string)
+	return userID, ok
+}
+
+// parseBearerTokens parses AUTH_TOKENS, a comma-separated list of
+// "token:user_id" pairs. An empty value yields an empty map, which disables
+// bearer auth. Tokens shorter than 16 characters or invalid user_ids are
+// rejected so a typo cannot silently open or break access.
+func parseBearerTokens(raw string) (map[string]string, error) {
+	tokens := map[string]string{}
+	for _, entry := range strings.Split(raw, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		token, userID, ok := strings.Cut(entry, ":")
+		token, userID = strings.TrimSpace(token), strings.TrimSpace(userID)
+		if !ok || len(token) < 16 {
+			return nil, errors.New("AUTH_TOKENS: each entry must be token:user_id with a token of at least 16 characters")
+		}
+		if !isValidUserID(userID) {
+			return nil, fmt.Errorf("AUTH_TOKENS: invalid user_id %q", userID)
+		}
+		if _, dup := tokens[token]; dup {
+			return nil, errors.New("AUTH_TOKENS: duplicate token")
+		}
+		tokens[token] = userID
+	}
+	return tokens, nil
+}
+
+// bearerAuthMiddleware returns middleware that requires an
+// "Authorization: Bearer <token>" header naming one of tokens and stores
+// the token's user_id in the request context. With no tokens configured it
+// is a no-op, leaving handlers to trust the client-supplied user_id as
+// before.
+func bearerAuthMiddleware(tokens map[string]string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if len(tokens) == 0 {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			scheme, provided, _ := strings.Cut(strings.TrimSpace(r.Header.Get("Authorization")), " ")
+			provided = strings.TrimSpace(provided)
+			if !strings.EqualFold(scheme, "Bearer") || provided == "" {
+				w.Header().Set("WWW-Authenticate", `Bearer`)
+				http.Error(w, "unauthorized", http.StatusUnauthorized)
+				return
+			}
+
+			// Compare against every token so the response time does not
+			// depend on which (if any) token matched.
+			var userID string
+			for token, uid := range tokens {
+				if subtle.ConstantTimeCompare([]byte(provided), []byte(token)) == 1 {
+					userID = uid
+				}
+			}
+			if userID == "" {
+				w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+				http.Error(w, "unauthorized", http.StatusUnauthorized)
+				return
+			}
+
+			ctx := context.WithValue(r.Context(), authUserKey{}, userID)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// requestUserID resolves the user_id a request acts as from the value the
+// client claimed (body or query). When bearerAuthMiddleware authenticated
+// the request, the token's user_id is authoritative: claimed may be omitted,
+// and a different claimed value is refused with 403 so one user cannot act
+// on another's data. Without auth, claimed (or fallback when it is empty) is
+// trusted as before. On failure it returns the status and message to send.
+func requestUserID(r *http.Request, claimed, fallback string) (userID string, status int, msg string) {
+	claimed = strings.TrimSpace(claimed)
+	if authed, ok := authenticatedUserID(r); ok {
+		if claimed != "" && claimed != authed {
+			return "", http.StatusForbidden, "user_id does not match the authenticated user"
+		}
+		return authed, 0, ""
+	}
+
+	userID = normalizeUserID(claimed, fallback)
+	if userID == "" {
+		return "", http.StatusBadRequest, `"user_id" is required`
+	}
+	if !isValidUserID(userID) {
+		return "", http.StatusBadRequest, "invalid user_id"
+	}
+	return userID, 0, ""
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+const (
+	testToken      = "s3cret-token-0123456789"
+	otherTestUser  = "0a1b2c3d-4e5f-4a6b-8c7d-9e0f1a2b3c4d"
+	otherTestToken = "other-token-0123456789"
+)
+
+func TestParseBearerTokens(t *testing.T) {
+	tests := []struct {
+		name    string
+		raw     string
+		want    map[string]string
+		wantErr bool
+	}{
+		{"empty disables auth", "", map[string]string{}, false},
+		{"pairs with spacing", " " + testToken + " : " + testUser + " ,", map[string]string{testToken: testUser}, false},
+		{"two users", testToken + ":" + testUser + "," + otherTestToken + ":" + otherTestUser,
+			map[string]string{testToken: testUser, otherTestToken: otherTestUser}, false},
+		{"missing user", testToken, nil, true},
+		{"short token", "short:" + testUser, nil, true},
+		{"invalid user", testToken + ":not-a-uuid", nil, true},
+		{"duplicate token", testToken + ":" + testUser + "," + testToken + ":" + otherTestUser, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseBearerTokens(tt.raw)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseBearerTokens() err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("parseBearerTokens() = %v, want %v", got, tt.want)
+			}
+			for token, user := range tt.want {
+				if got[token] != user {
+					t.Errorf("token %q maps to %q, want %q", token, got[token], user)
+				}
+			}
+		})
+	}
+}
+
+func TestBearerAuthMiddleware(t *testing.T) {
+	tokens := map[string]string{testToken: testUser}
+	tests := []struct {
+		name       string
+		tokens     map[string]string
+		header     string
+		wantStatus int
+		wantUser   string // "" expects no authenticated user
+	}{
+		{"valid token", tokens, "Bearer " + testToken, http.StatusOK, testUser},
+		{"scheme is case-insensitive", tokens, "bearer " + testToken, http.StatusOK, testUser},
+		{"invalid token", tokens, "Bearer wrong-token-0123456789", http.StatusUnauthorized, ""},
+		{"missing header", tokens, "", http.StatusUnauthorized, ""},
+		{"wrong scheme", tokens, "Basic " + testToken, http.StatusUnauthorized, ""},
+		{"auth disabled", nil, "", http.StatusOK, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var gotUser string
+			h := bearerAuthMiddleware(tt.tokens)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				gotUser, _ = authenticatedUserID(r)
+			}))
+			req := httptest.NewRequest(http.MethodPost, "/api/v1/tasks", nil)
+			if tt.header != "" {
+				req.Header.Set("Authorization", tt.header)
+			}
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if rec.Code == http.StatusUnauthorized && rec.Header().Get("WWW-Authenticate") == "" {
+				t.Error("401 without a WWW-Authenticate header")
+			}
+			if gotUser != tt.wantUser {
+				t.Errorf("authenticated user = %q, want %q", gotUser, tt.wantUser)
+			}
+		})
+	}
+}
+
+func TestRequestUserID(t *testing.T) {
+	tests := []struct {
+		name       string
+		authed     string // "" leaves the request unauthenticated
+		claimed    string
+		fallback   string
+		want       string
+		wantStatus int
+	}{
+		{"auth user when nothing claimed", testUser, "", "", testUser, 0},
+		{"matching claim accepted", testUser, testUser, "", testUser, 0},
+		{"conflicting claim forbidden", testUser, otherTestUser, "", "", http.StatusForbidden},
+		{"auth ignores the fallback", testUser, "", "default", testUser, 0},
+		{"no auth trusts the claim", "", otherTestUser, "", otherTestUser, 0},
+		{"no auth uses the fallback", "", "", "default", "default", 0},
+		{"no auth requires a user", "", "", "", "", http.StatusBadRequest},
+		{"no auth rejects an invalid user", "", "nobody", "", "", http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/api/v1/tasks", nil)
+			if tt.authed != "" {
+				req = req.WithContext(context.WithValue(req.Context(), authUserKey{}, tt.authed))
+			}
+			got, status, _ := requestUserID(req, tt.claimed, tt.fallback)
+			if got != tt.want || status != tt.wantStatus {
+				t.Errorf("requestUserID() = (%q, %d), want (%q, %d)", got, status, tt.want, tt.wantStatus)
+			}
+		})
+	}
+}
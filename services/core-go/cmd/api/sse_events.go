@@ -0,0 +1,62 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// sseEvent is an SSE event name plus its documentation. writeSSEEvent only
+// accepts this type, so every event the server can emit must be declared
+// here, and the chat ones listed in chatEvents, which is what
+// GET /api/v1/chat/events serves.
+type sseEvent struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+}
+
+// ── Chat stream events (POST /api/v1/chat) ────────────────────────────────────
+
+var (
+	eventStream       = sseEvent{"stream", "First event of every chat stream: {stream_id}. POST it to /api/v1/chat/abort to stop the reply."}
+	eventSources      = sseEvent{"sources", "Documents the RAG answer is grounded in: {sources: [{source, title?, url?}]}. Sent once, before the first message."}
+	eventMeta         = sseEvent{"meta", "Answer metadata for a document-grounded RAG answer: {low_confidence}. low_confidence is true when even the best retrieved chunk scored below RAG_LOW_CONFIDENCE_SCORE. Sent once, after sources."}
+	eventStatus       = sseEvent{"status", "The model is generating the reply: {status: \"generating\"}. Sent once retrieval (RAG) or a tool call (agent) is done and before the text that follows, so clients can show a typing indicator. Omitted when CHAT_STATUS_EVENTS=false."}
+	eventMessage      = sseEvent{"message", "A chunk of assistant text: {content}."}
+	eventToolCall     = sseEvent{"tool_call", "The agent is executing a tool: {tool, status: \"executing\", args}."}
+	eventToolResult   = sseEvent{"tool_result", "Outcome of a tool call: {tool, status: \"success\"|\"error\", task_id?, args?, task?, error_msg?}. On success task is the created task as stored."}
+	eventStats        = sseEvent{"stats", "Token usage and timing summed over the reply's model calls: {prompt_tokens, completion_tokens, total_ms, ...}. Last event of a model-backed reply."}
+	eventError        = sseEvent{"error", "The pipeline failed: {error}. Ends the stream."}
+	eventShuttingDown = sseEvent{"shutting_down", "The server is shutting down and stopped the reply after CHAT_SHUTDOWN_GRACE: {message}. Sent in place of cancelled; ends the stream. Retry the request."}
+	eventCancelled    = sseEvent{"cancelled", "The request was cancelled, timed out or was stopped via POST /api/v1/chat/abort before the reply finished: {reason: \"cancelled\"|\"deadline_exceeded\"|\"aborted\"}. Best-effort, sent in place of the remaining events; ends the stream."}
+)
+
+// chatEvents is the catalog of events a chat stream may contain, in the
+// order they can appear.
+var chatEvents = []sseEvent{
+	eventStream,
+	eventSources,
+	eventMeta,
+	eventStatus,
+	eventMessage,
+	eventToolCall,
+	eventToolResult,
+	eventStats,
+	eventError,
+	eventCancelled,
+	eventShuttingDown,
+}
+
+// ── Admin reembed events (POST /api/v1/admin/reembed) ─────────────────────────
+
+var (
+	eventProgress = sseEvent{"progress", "Re-embedding progress after each batch: {total, reembedded, skipped}."}
+	eventDone     = sseEvent{"done", "Re-embedding finished: {progress, embedding_model}."}
+)
+
+// chatEventsHandler handles GET /api/v1/chat/events and returns the chat
+// stream's event catalog so clients can see every event name they must
+// handle.
+func chatEventsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{"events": chatEvents})
+}
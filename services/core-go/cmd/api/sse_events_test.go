@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"core-go/internal/agent"
+)
+
+// writtenEvents returns the names of the sseEvent variables passed to
+// writeSSEEvent in file.
+func writtenEvents(t *testing.T, file string) map[string]bool {
+	t.Helper()
+	f, err := parser.ParseFile(token.NewFileSet(), file, nil, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	vars := map[string]bool{}
+	ast.Inspect(f, func(n ast.Node) bool {
+		call, ok := n.(*ast.CallExpr)
+		if !ok || len(call.Args) < 3 {
+			return true
+		}
+		if fn, ok := call.Fun.(*ast.Ident); ok && fn.Name == "writeSSEEvent" {
+			if ev, ok := call.Args[2].(*ast.Ident); ok && ev.Name != "event" {
+				vars[ev.Name] = true
+			}
+		}
+		return true
+	})
+	return vars
+}
+
+func TestChatEventsCatalog(t *testing.T) {
+	// Every event the chat handler can write, by variable name.
+	eventVars := map[string]sseEvent{
+		"eventStream": eventStream, "eventSources": eventSources, "eventMeta": eventMeta,
+		"eventStatus": eventStatus, "eventMessage": eventMessage, "eventToolCall": eventToolCall,
+		"eventToolResult": eventToolResult, "eventStats": eventStats, "eventError": eventError,
+		"eventShuttingDown": eventShuttingDown, "eventCancelled": eventCancelled,
+	}
+
+	rec := httptest.NewRecorder()
+	chatEventsHandler(rec, httptest.NewRequest(http.MethodGet, "/api/v1/chat/events", nil))
+	var resp struct {
+		Events []sseEvent `json:"events"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	served := map[string]bool{}
+	for _, ev := range resp.Events {
+		if ev.Description == "" {
+			t.Errorf("event %q has no description", ev.Name)
+		}
+		served[ev.Name] = true
+	}
+
+	written := writtenEvents(t, "chat_handler.go")
+	if len(written) == 0 {
+		t.Fatal("found no writeSSEEvent calls in chat_handler.go")
+	}
+	for name := range written {
+		ev, ok := eventVars[name]
+		if !ok {
+			t.Errorf("chat_handler.go writes %s, which this test does not know; add it to chatEvents and here", name)
+			continue
+		}
+		if !served[ev.Name] {
+			t.Errorf("chat_handler.go writes %q, missing from GET /api/v1/chat/events", ev.Name)
+		}
+	}
+
+	tests := []struct {
+		name   string
+		fields map[string]any
+	}{
+		{"rag stream", map[string]any{"mode": routeRAG}},
+		{"agent stream", map[string]any{"mode": routeAgent, "force_task": true}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			kb, _ := newTestKB(t)
+			if _, err := kb.IngestText(context.Background(), "The Colosseum is an ancient amphitheatre in Rome.", "rome.md", testUser, agent.IngestOptions{}); err != nil {
+				t.Fatal(err)
+			}
+			rec := serve(newTestChatHandler(kb, &memTaskRepo{}), http.MethodPost, "/api/v1/chat", "", chatBody("Add a task to visit the Colosseum", tt.fields))
+			for _, ev := range parseSSE(rec.Body.String()) {
+				if ev.name != "" && !served[ev.name] {
+					t.Errorf("stream wrote event %q, missing from GET /api/v1/chat/events", ev.name)
+				}
+			}
+		})
+	}
+}
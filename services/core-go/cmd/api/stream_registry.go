@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"core-go/internal/logging"
+)
+
+// errStreamAborted is the cancel cause of a chat stream stopped through
+// POST /api/v1/chat/abort, which lets the stream report "aborted" rather
+// than a plain cancellation.
+var errStreamAborted = errors.New("chat: stream aborted by client")
+
+// errServerShuttingDown is the cancel cause of chat streams still running
+// when the shutdown grace period ends; they close with a "shutting_down"
+// event so the client knows to retry rather than treat it as a failure.
+var errServerShuttingDown = errors.New("chat: server shutting down")
+
+// streamRegistry maps the stream_id of every in-flight chat stream to the
+// function that cancels it, so a client that cannot drop the SSE
+// connection cleanly can still stop generation. It is safe for concurrent
+// use.
+type streamRegistry struct {
+	mu      sync.Mutex
+	streams map[string]registeredStream
+}
+
+// registeredStream is one in-flight stream and the user it belongs to; only
+// that user may abort it.
+type registeredStream struct {
+	userID string
+	cancel context.CancelCauseFunc
+}
+
+func newStreamRegistry() *streamRegistry {
+	return &streamRegistry{streams: map[string]registeredStream{}}
+}
+
+// Register records a stream owned by userID and returns its new stream_id
+// and the function that removes it again. Callers defer the removal so
+// finished streams do not accumulate.
+func (s *streamRegistry) Register(userID string, cancel context.CancelCauseFunc) (id string, unregister func()) {
+	id = logging.NewRequestID()
+	s.mu.Lock()
+	s.streams[id] = registeredStream{userID: userID, cancel: cancel}
+	s.mu.Unlock()
+	return id, func() {
+		s.mu.Lock()
+		delete(s.streams, id)
+		s.mu.Unlock()
+	}
+}
+
+// Abort cancels the stream id if it is still running and belongs to
+// userID, and reports whether it did.
+func (s *streamRegistry) Abort(id, userID string) bool {
+	s.mu.Lock()
+	stream, ok := s.streams[id]
+	if ok && stream.userID == userID {
+		delete(s.streams, id)
+	}
+	s.mu.Unlock()
+	if !ok || stream.userID != userID {
+		return false
+	}
+	stream.cancel(errStreamAborted)
+	return true
+}
+
+// Drain waits up to grace for the in-flight streams to finish on their own,
+// then cancels the rest with errServerShuttingDown and returns how many it
+// cut short. Run it alongside http.Server.Shutdown: Shutdown stops new
+// requests but would otherwise wait on each stream until the model is done.
+func (s *streamRegistry) Drain(grace time.Duration) int {
+	deadline := time.Now().Add(grace)
+	for s.active() > 0 && time.Now().Before(deadline) {
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	s.mu.Lock()
+	remaining := s.streams
+	s.streams = map[string]registeredStream{}
+	s.mu.Unlock()
+	for _, stream := range remaining {
+		stream.cancel(errServerShuttingDown)
+	}
+	return len(remaining)
+}
+
+// active returns the number of registered streams.
+func (s *streamRegistry) active() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.streams)
+}
+
+// ── Abort chat stream ─────────────────────────────────────────────────────────
+
+// abortChatHandler handles POST /api/v1/chat/abort.
+// Body: { "stream_id": "...", "user_id": "..." }
+//
+// Cancels the chat stream whose "stream" event carried stream_id. The
+// stream ends with a "cancelled" event (reason "aborted") and the reply so
+// far is kept in the conversation. Returns 204 on success and 404 when the
+// stream has already finished, never existed or belongs to another user.
+func abortChatHandler(registry *streamRegistry) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		r.Body = http.MaxBytesReader(w, r.Body, 4<<10)
+
+		var req struct {
+			StreamID string `json:"stream_id"`
+			UserID   string `json:"user_id"`
+		}
+		if err := decodeJSONStrict(r, &req); err != nil {
+			http.Error(w, "invalid JSON body", http.StatusBadRequest)
+			return
+		}
+		streamID := strings.TrimSpace(req.StreamID)
+		if streamID == "" {
+			http.Error(w, `"stream_id" is required`, http.StatusBadRequest)
+			return
+		}
+
+		userID, status, msg := requestUserID(r, req.UserID, "default")
+		if status != 0 {
+			http.Error(w, msg, status)
+			return
+		}
+
+		if !registry.Abort(streamID, userID) {
+			http.Error(w, "stream not found", http.StatusNotFound)
+			return
+		}
+		logging.FromContext(r.Context()).Info("chat: stream aborted", "stream_id", streamID, "user_id", userID)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
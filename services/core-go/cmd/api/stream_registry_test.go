@@ -0,0 +1,237 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"core-go/internal/agent"
+	"core-go/internal/llm"
+	"core-go/internal/vector"
+)
+
+// blockingChat streams one text chunk and then holds the stream open until
+// its context ends, like a model still generating.
+type blockingChat struct{}
+
+func (blockingChat) StreamChat(ctx context.Context, _ []llm.Message, _ []llm.Tool, _ llm.Options) (<-chan llm.Chunk, error) {
+	ch := make(chan llm.Chunk, 1)
+	ch <- llm.Chunk{Kind: llm.KindText, Text: "The Colosseum"}
+	go func() {
+		<-ctx.Done()
+		close(ch)
+	}()
+	return ch, nil
+}
+
+func TestStreamRegistryAbort(t *testing.T) {
+	tests := []struct {
+		name       string
+		id         func(registered string) string
+		userID     string
+		unregister bool // the stream finished before the abort
+		want       bool
+	}{
+		{"own stream", func(id string) string { return id }, testUser, false, true},
+		{"unknown id", func(string) string { return "no-such-stream" }, testUser, false, false},
+		{"another user's stream", func(id string) string { return id }, otherTestUser, false, false},
+		{"finished stream", func(id string) string { return id }, testUser, true, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			registry := newStreamRegistry()
+			ctx, cancel := context.WithCancelCause(context.Background())
+			defer cancel(nil)
+			id, unregister := registry.Register(testUser, cancel)
+			if tt.unregister {
+				unregister()
+			}
+
+			if got := registry.Abort(tt.id(id), tt.userID); got != tt.want {
+				t.Fatalf("Abort() = %v, want %v", got, tt.want)
+			}
+			if cancelled := ctx.Err() != nil; cancelled != tt.want {
+				t.Errorf("stream cancelled = %v, want %v", cancelled, tt.want)
+			}
+			if tt.want && !errors.Is(context.Cause(ctx), errStreamAborted) {
+				t.Errorf("cancel cause = %v, want errStreamAborted", context.Cause(ctx))
+			}
+			wantActive := 1
+			if tt.want || tt.unregister {
+				wantActive = 0
+			}
+			if registry.active() != wantActive {
+				t.Errorf("%d streams registered, want %d", registry.active(), wantActive)
+			}
+		})
+	}
+}
+
+func TestAbortChatHandler(t *testing.T) {
+	tests := []struct {
+		name       string
+		body       string
+		wantStatus int
+	}{
+		{"unknown id", `{"stream_id":"no-such-stream","user_id":"` + testUser + `"}`, http.StatusNotFound},
+		{"missing id", `{"user_id":"` + testUser + `"}`, http.StatusBadRequest},
+		{"invalid JSON", `{"stream_id":`, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := serve(abortChatHandler(newStreamRegistry()), http.MethodPost, "/api/v1/chat/abort", "", tt.body)
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+		})
+	}
+}
+
+// startBlockingStream serves the chat and abort routes over registry with a
+// model that never finishes, opens a RAG chat stream and returns the
+// server and a function that reads the stream's next event.
+func startBlockingStream(t *testing.T, registry *streamRegistry) (srv *httptest.Server, next func() (name, data string)) {
+	t.Helper()
+	_, qsrv := newTestKB(t)
+	kb := agent.NewKnowledgeBase(vector.NewQdrantClient(qsrv.URL), llm.NewFakeEmbedder(), blockingChat{})
+	if _, err := kb.IngestText(context.Background(), "The Colosseum is an ancient amphitheatre in Rome.", "rome.md", testUser, agent.IngestOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	mux := http.NewServeMux()
+	mux.Handle("POST /api/v1/chat", chatHandler(kb, agent.NewTaskAgent(&memTaskRepo{}, blockingChat{}), &fakeConversations{},
+		agent.AskOptions{}, historyLimit{MaxTurns: 50}, nil, nil, registry, false))
+	mux.Handle("POST /api/v1/chat/abort", abortChatHandler(registry))
+	srv = httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	t.Cleanup(cancel)
+	req, _ := http.NewRequestWithContext(ctx, http.MethodPost, srv.URL+"/api/v1/chat", strings.NewReader(chatBody("Where is the Colosseum?", map[string]any{"mode": routeRAG})))
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+	events := bufio.NewScanner(resp.Body)
+
+	return srv, func() (string, string) {
+		var name, data string
+		for events.Scan() {
+			line := events.Text()
+			if line == "" && name != "" {
+				return name, data
+			}
+			if v, ok := strings.CutPrefix(line, "event: "); ok {
+				name = v
+			} else if v, ok := strings.CutPrefix(line, "data: "); ok {
+				data = v
+			}
+		}
+		t.Fatalf("stream ended early: %v", events.Err())
+		return "", ""
+	}
+}
+
+func TestChatStreamAbortBeforeCompletion(t *testing.T) {
+	srv, next := startBlockingStream(t, newStreamRegistry())
+
+	name, data := next()
+	var stream struct {
+		StreamID string `json:"stream_id"`
+	}
+	if name != eventStream.Name || json.Unmarshal([]byte(data), &stream) != nil || stream.StreamID == "" {
+		t.Fatalf("first event = %s %s, want %s with a stream_id", name, data, eventStream.Name)
+	}
+	// Wait until the model is generating, then abort.
+	for name != eventMessage.Name {
+		name, _ = next()
+	}
+
+	abort := func() int {
+		body := `{"stream_id":"` + stream.StreamID + `","user_id":"` + testUser + `"}`
+		resp, err := http.Post(srv.URL+"/api/v1/chat/abort", "application/json", strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	if status := abort(); status != http.StatusNoContent {
+		t.Fatalf("abort status = %d, want %d", status, http.StatusNoContent)
+	}
+
+	for name != eventCancelled.Name {
+		name, data = next()
+	}
+	var payload struct{ Reason string }
+	if err := json.Unmarshal([]byte(data), &payload); err != nil || payload.Reason != "aborted" {
+		t.Errorf("cancelled reason = %q, want aborted", payload.Reason)
+	}
+	if status := abort(); status != http.StatusNotFound {
+		t.Errorf("second abort status = %d, want %d", status, http.StatusNotFound)
+	}
+}
+
+func TestStreamRegistryDrain(t *testing.T) {
+	tests := []struct {
+		name     string
+		running  bool          // a stream is registered
+		finishIn time.Duration // when it ends on its own; 0 never
+		grace    time.Duration
+		want     int
+	}{
+		{"no streams", false, 0, 0, 0},
+		{"stream finishes within grace", true, 10 * time.Millisecond, time.Second, 0},
+		{"stream still running", true, 0, 0, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			registry := newStreamRegistry()
+			ctx, cancel := context.WithCancelCause(context.Background())
+			defer cancel(nil)
+			if tt.running {
+				_, unregister := registry.Register(testUser, cancel)
+				if tt.finishIn > 0 {
+					time.AfterFunc(tt.finishIn, unregister)
+				}
+			}
+
+			if got := registry.Drain(tt.grace); got != tt.want {
+				t.Errorf("Drain() = %d, want %d", got, tt.want)
+			}
+			if cut := errors.Is(context.Cause(ctx), errServerShuttingDown); cut != (tt.want > 0) {
+				t.Errorf("cancel cause = %v, want errServerShuttingDown %v", context.Cause(ctx), tt.want > 0)
+			}
+			if registry.active() != 0 {
+				t.Errorf("%d streams registered after Drain, want 0", registry.active())
+			}
+		})
+	}
+}
+
+func TestChatStreamShutdownDuringStream(t *testing.T) {
+	registry := newStreamRegistry()
+	_, next := startBlockingStream(t, registry)
+
+	// Wait until the model is generating, then shut down with no grace.
+	for name, _ := next(); name != eventMessage.Name; name, _ = next() {
+	}
+	if n := registry.Drain(0); n != 1 {
+		t.Fatalf("Drain() = %d, want 1 stream cut short", n)
+	}
+
+	name, data := next()
+	if name != eventShuttingDown.Name {
+		t.Fatalf("event after shutdown = %s %s, want %s", name, data, eventShuttingDown.Name)
+	}
+	var payload struct{ Message string }
+	if err := json.Unmarshal([]byte(data), &payload); err != nil || payload.Message == "" {
+		t.Errorf("shutting_down payload = %s, want a message", data)
+	}
+}
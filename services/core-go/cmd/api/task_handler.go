@@ -2,12 +2,15 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"strconv"
 	"strings"
+	"time"
 
 	"core-go/internal/db"
+	"core-go/internal/logging"
 )
 
 // validStatuses is the allowed set for PATCH /api/v1/tasks/{id}.
@@ -23,13 +26,9 @@ var validStatuses = map[string]bool{
 // Returns all tasks for the given user ordered newest-first.
 func listTasksHandler(repo db.TaskRepository) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		userID := strings.TrimSpace(r.URL.Query().Get("user_id"))
-		if userID == "" {
-			http.Error(w, `"user_id" query parameter is required`, http.StatusBadRequest)
-			return
-		}
-		if !isValidUserID(userID) {
-			http.Error(w, "invalid user_id", http.StatusBadRequest)
+		userID, status, msg := requestUserID(r, r.URL.Query().Get("user_id"), "")
+		if status != 0 {
+			http.Error(w, msg, status)
 			return
 		}
 
@@ -49,16 +48,79 @@ func listTasksHandler(repo db.TaskRepository) http.HandlerFunc {
 	}
 }
 
-// ── Update task status ────────────────────────────────────────────────────────
+// ── Get task ──────────────────────────────────────────────────────────────────
 
-// updateTaskStatusRequest is the body for PATCH /api/v1/tasks/{id}.
-type updateTaskStatusRequest struct {
-	Status string `json:"status"`
-	UserID string `json:"user_id"`
+// getTaskHandler handles GET /api/v1/tasks/{id}?user_id=<uuid>
+// Returns 404 when the task does not exist or belongs to another user.
+func getTaskHandler(repo db.TaskRepository) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := parseTaskID(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		userID, status, msg := requestUserID(r, r.URL.Query().Get("user_id"), "")
+		if status != 0 {
+			http.Error(w, msg, status)
+			return
+		}
+
+		task, err := repo.GetTask(r.Context(), id, userID)
+		if errors.Is(err, db.ErrTaskNotFound) {
+			http.Error(w, "task not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, "failed to get task", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(task)
+	}
+}
+
+// ── Task stats ────────────────────────────────────────────────────────────────
+
+// taskStatsHandler handles GET /api/v1/tasks/stats?user_id=<uuid>
+// Returns {"pending": N, "in_progress": N, "done": N} so the UI can render
+// badge counts without fetching every task.
+func taskStatsHandler(repo db.TaskRepository) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, status, msg := requestUserID(r, r.URL.Query().Get("user_id"), "")
+		if status != 0 {
+			http.Error(w, msg, status)
+			return
+		}
+
+		counts, err := repo.CountByStatus(r.Context(), userID)
+		if err != nil {
+			http.Error(w, "failed to count tasks", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(counts)
+	}
+}
+
+// ── Update task ───────────────────────────────────────────────────────────────
+
+// updateTaskRequest is the body for PATCH /api/v1/tasks/{id}.
+// Every task field is optional; omitted fields are left unchanged, but at
+// least one must be present.
+type updateTaskRequest struct {
+	Title       *string `json:"title"`
+	Description *string `json:"description"`
+	Priority    *int    `json:"priority"`
+	Status      *string `json:"status"`
+	UserID      string  `json:"user_id"`
 }
 
 // updateTaskHandler handles PATCH /api/v1/tasks/{id}
-// Updates the status of a task owned by the requesting user.
+// Partially updates title, description, priority, and/or status of a task
+// owned by the requesting user and returns the updated task.
 func updateTaskHandler(repo db.TaskRepository) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		id, err := parseTaskID(r)
@@ -67,35 +129,324 @@ func updateTaskHandler(repo db.TaskRepository) http.HandlerFunc {
 			return
 		}
 
-		var req updateTaskStatusRequest
+		var req updateTaskRequest
+		if err := decodeJSONStrict(r, &req); err != nil {
+			http.Error(w, "invalid JSON body", http.StatusBadRequest)
+			return
+		}
+
+		fields, msg := req.taskUpdate()
+		if msg != "" {
+			http.Error(w, msg, http.StatusBadRequest)
+			return
+		}
+
+		userID, status, msg := requestUserID(r, req.UserID, "")
+		if status != 0 {
+			http.Error(w, msg, status)
+			return
+		}
+
+		task, err := repo.UpdateTask(r.Context(), id, userID, fields)
+		switch {
+		case errors.Is(err, db.ErrEmptyTaskUpdate):
+			http.Error(w, "at least one of title, description, priority, status is required", http.StatusBadRequest)
+			return
+		case errors.Is(err, db.ErrTaskNotFound):
+			http.Error(w, "task not found", http.StatusNotFound)
+			return
+		case err != nil:
+			http.Error(w, "failed to update task", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(task)
+	}
+}
+
+// taskUpdate validates the provided fields and converts them to a
+// db.TaskUpdate. A non-empty msg describes the first invalid field.
+func (req updateTaskRequest) taskUpdate() (fields db.TaskUpdate, msg string) {
+	if req.Title != nil {
+		title := strings.TrimSpace(*req.Title)
+		if title == "" {
+			return fields, `"title" must be a non-empty string`
+		}
+		fields.Title = &title
+	}
+	if req.Description != nil {
+		description := strings.TrimSpace(*req.Description)
+		fields.Description = &description
+	}
+	if req.Priority != nil {
+		if !db.ValidPriority(*req.Priority) {
+			return fields, `"priority" must be an integer 0-3 (0 = low, 1 = medium, 2 = high, 3 = urgent)`
+		}
+		fields.Priority = req.Priority
+	}
+	if req.Status != nil {
+		status := strings.TrimSpace(*req.Status)
+		if !validStatuses[status] {
+			return fields, `"status" must be one of: pending, in_progress, done`
+		}
+		fields.Status = &status
+	}
+	return fields, ""
+}
+
+// ── Bulk status ───────────────────────────────────────────────────────────────
+
+// maxBulkTaskIDs caps PATCH /api/v1/tasks/bulk, like maxBatchTasks for
+// creates.
+const maxBulkTaskIDs = 500
+
+// bulkStatusRequest is the body for PATCH /api/v1/tasks/bulk.
+type bulkStatusRequest struct {
+	IDs    []db.TaskID `json:"ids"`
+	Status string      `json:"status"`
+	UserID string      `json:"user_id"`
+}
+
+// bulkStatusHandler handles PATCH /api/v1/tasks/bulk
+// Sets status on every listed task the user owns in one query and responds
+// {"updated": N}. IDs that are unknown or belong to someone else are
+// silently skipped, so N can be smaller than len(ids).
+func bulkStatusHandler(repo db.TaskRepository) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		r.Body = http.MaxBytesReader(w, r.Body, 64<<10)
+
+		var req bulkStatusRequest
 		if err := decodeJSONStrict(r, &req); err != nil {
 			http.Error(w, "invalid JSON body", http.StatusBadRequest)
 			return
 		}
 
-		req.Status = strings.TrimSpace(req.Status)
-		if !validStatuses[req.Status] {
+		userID, status, msg := requestUserID(r, req.UserID, "")
+		if status != 0 {
+			http.Error(w, msg, status)
+			return
+		}
+
+		if len(req.IDs) == 0 {
+			http.Error(w, `"ids" must be a non-empty array`, http.StatusBadRequest)
+			return
+		}
+		if len(req.IDs) > maxBulkTaskIDs {
+			http.Error(w, fmt.Sprintf(`"ids" must contain at most %d entries`, maxBulkTaskIDs), http.StatusBadRequest)
+			return
+		}
+		newStatus := strings.TrimSpace(req.Status)
+		if !validStatuses[newStatus] {
 			http.Error(w, `"status" must be one of: pending, in_progress, done`, http.StatusBadRequest)
 			return
 		}
 
-		userID := strings.TrimSpace(req.UserID)
-		if userID == "" {
-			http.Error(w, `"user_id" is required`, http.StatusBadRequest)
+		n, err := repo.UpdateManyStatus(r.Context(), req.IDs, userID, newStatus)
+		if err != nil {
+			logging.FromContext(r.Context()).Error("tasks: bulk status", "user_id", userID, "count", len(req.IDs), "err", err)
+			http.Error(w, "failed to update tasks", http.StatusInternalServerError)
 			return
 		}
-		if !isValidUserID(userID) {
-			http.Error(w, "invalid user_id", http.StatusBadRequest)
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]any{"updated": n})
+	}
+}
+
+// ── Complete ──────────────────────────────────────────────────────────────────
+
+// completeTaskHandler handles POST /api/v1/tasks/{id}/complete?user_id=X
+// Sets the task's status to "done" without a request body and returns the
+// updated task. Any status may be completed, so completing an already-done
+// task is a no-op that still returns 200 with the task.
+func completeTaskHandler(repo db.TaskRepository) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := parseTaskID(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		userID, status, msg := requestUserID(r, r.URL.Query().Get("user_id"), "")
+		if status != 0 {
+			http.Error(w, msg, status)
+			return
+		}
+
+		done := "done"
+		task, err := repo.UpdateTask(r.Context(), id, userID, db.TaskUpdate{Status: &done})
+		switch {
+		case errors.Is(err, db.ErrTaskNotFound):
+			http.Error(w, "task not found", http.StatusNotFound)
+			return
+		case err != nil:
+			http.Error(w, "failed to complete task", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(task)
+	}
+}
+
+// ── Next occurrence ───────────────────────────────────────────────────────────
+
+// nextOccurrenceRequest is the body for POST /api/v1/tasks/{id}/next.
+type nextOccurrenceRequest struct {
+	UserID string `json:"user_id"`
+}
+
+// nextOccurrenceHandler handles POST /api/v1/tasks/{id}/next
+// Clones a completed recurring task into a new pending task due at its next
+// occurrence. Intended to be called by the client or an external scheduler
+// once a recurring task is marked done; returns 409 if the task is not a
+// completed recurring task.
+func nextOccurrenceHandler(repo db.TaskRepository) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := parseTaskID(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
 
-		if err := repo.UpdateTaskStatus(r.Context(), id, userID, req.Status); err != nil {
-			http.Error(w, "failed to update task", http.StatusNotFound)
+		var req nextOccurrenceRequest
+		if err := decodeJSONStrict(r, &req); err != nil {
+			http.Error(w, "invalid JSON body", http.StatusBadRequest)
+			return
+		}
+
+		userID, status, msg := requestUserID(r, req.UserID, "")
+		if status != 0 {
+			http.Error(w, msg, status)
+			return
+		}
+
+		task, err := repo.NextOccurrence(r.Context(), id, userID)
+		switch {
+		case errors.Is(err, db.ErrTaskNotFound):
+			http.Error(w, "task not found", http.StatusNotFound)
+			return
+		case errors.Is(err, db.ErrNotRecurring):
+			http.Error(w, "task is not a completed recurring task", http.StatusConflict)
+			return
+		case err != nil:
+			http.Error(w, "failed to create next occurrence", http.StatusInternalServerError)
 			return
 		}
 
 		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(map[string]any{"id": id, "status": req.Status})
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(task)
+	}
+}
+
+// ── Batch create ──────────────────────────────────────────────────────────────
+
+// maxBatchTasks caps POST /api/v1/tasks/batch so one request cannot hold a
+// transaction open over an unbounded number of inserts.
+const maxBatchTasks = 100
+
+// batchTaskItem is one task in a batch create request. Priority defaults to
+// medium when omitted. DueDate is RFC 3339 or a plain YYYY-MM-DD date (taken
+// as midnight UTC).
+type batchTaskItem struct {
+	Title       string `json:"title"`
+	Description string `json:"description"`
+	Priority    *int   `json:"priority"`
+	DueDate     string `json:"due_date"`
+}
+
+// batchCreateRequest is the body for POST /api/v1/tasks/batch.
+type batchCreateRequest struct {
+	UserID string          `json:"user_id"`
+	Tasks  []batchTaskItem `json:"tasks"`
+}
+
+// newTask validates item and converts it to a db.NewTask for userID. On
+// failure it returns a non-empty message suitable for a 400 response.
+func (item batchTaskItem) newTask(userID string) (db.NewTask, string) {
+	t := db.NewTask{
+		Title:       strings.TrimSpace(item.Title),
+		Description: strings.TrimSpace(item.Description),
+		Priority:    db.DefaultPriority,
+		UserID:      userID,
+	}
+	if t.Title == "" {
+		return t, `"title" must be a non-empty string`
+	}
+	if len(t.Title) > 255 {
+		return t, `"title" is too long`
+	}
+	if item.Priority != nil {
+		if !db.ValidPriority(*item.Priority) {
+			return t, `"priority" must be an integer 0-3 (0 = low, 1 = medium, 2 = high, 3 = urgent)`
+		}
+		t.Priority = *item.Priority
+	}
+	if raw := strings.TrimSpace(item.DueDate); raw != "" {
+		due, err := time.Parse(time.RFC3339, raw)
+		if err != nil {
+			due, err = time.Parse(time.DateOnly, raw)
+		}
+		if err != nil {
+			return t, `"due_date" must be RFC 3339 or YYYY-MM-DD`
+		}
+		t.DueAt = &due
+	}
+	return t, ""
+}
+
+// batchCreateTasksHandler handles POST /api/v1/tasks/batch
+// Creates up to maxBatchTasks tasks in one transaction and responds 201 with
+// {"ids": [...]} in request order. Any invalid item fails the whole request
+// with 400 before anything is written; a database failure rolls back every
+// insert.
+func batchCreateTasksHandler(repo db.TaskRepository) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		r.Body = http.MaxBytesReader(w, r.Body, 1<<20) // 1 MB cap
+
+		var req batchCreateRequest
+		if err := decodeJSONStrict(r, &req); err != nil {
+			http.Error(w, "invalid JSON body", http.StatusBadRequest)
+			return
+		}
+
+		userID, status, msg := requestUserID(r, req.UserID, "")
+		if status != 0 {
+			http.Error(w, msg, status)
+			return
+		}
+
+		if len(req.Tasks) == 0 {
+			http.Error(w, `"tasks" must be a non-empty array`, http.StatusBadRequest)
+			return
+		}
+		if len(req.Tasks) > maxBatchTasks {
+			http.Error(w, fmt.Sprintf(`"tasks" must contain at most %d entries`, maxBatchTasks), http.StatusBadRequest)
+			return
+		}
+
+		tasks := make([]db.NewTask, 0, len(req.Tasks))
+		for i, item := range req.Tasks {
+			t, msg := item.newTask(userID)
+			if msg != "" {
+				http.Error(w, fmt.Sprintf("tasks[%d]: %s", i, msg), http.StatusBadRequest)
+				return
+			}
+			tasks = append(tasks, t)
+		}
+
+		ids, err := repo.CreateTasks(r.Context(), tasks)
+		if err != nil {
+			logging.FromContext(r.Context()).Error("tasks: batch create", "user_id", userID, "count", len(tasks), "err", err)
+			http.Error(w, "failed to create tasks", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(map[string]any{"ids": ids})
 	}
 }
 
@@ -110,13 +461,9 @@ func deleteTaskHandler(repo db.TaskRepository) http.HandlerFunc {
 			return
 		}
 
-		userID := strings.TrimSpace(r.URL.Query().Get("user_id"))
-		if userID == "" {
-			http.Error(w, `"user_id" query parameter is required`, http.StatusBadRequest)
-			return
-		}
-		if !isValidUserID(userID) {
-			http.Error(w, "invalid user_id", http.StatusBadRequest)
+		userID, status, msg := requestUserID(r, r.URL.Query().Get("user_id"), "")
+		if status != 0 {
+			http.Error(w, msg, status)
 			return
 		}
 
@@ -0,0 +1,518 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"core-go/internal/db"
+)
+
+const testUser = "6f1c2a7e-3b4d-4e5f-8a9b-0c1d2e3f4a5b"
+
+// memTaskRepo is an in-memory db.TaskRepository covering what the task
+// handlers call. err, when set, is returned by every method. Like the tasks
+// table, a repeated idempotency key for the same user returns the first ID.
+type memTaskRepo struct {
+	db.TaskRepository // unimplemented methods panic
+
+	mu    sync.Mutex
+	tasks []db.Task
+	keys  map[string]db.TaskID // user_id + "\x00" + key
+	err   error
+	// failAfter is how many rows EachTask streams before returning err.
+	failAfter int
+}
+
+// add stores a task with the next ID and returns it.
+func (m *memTaskRepo) add(t db.Task) db.Task {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	t.ID = db.TaskID(len(m.tasks) + 1)
+	if t.Status == "" {
+		t.Status = "pending"
+	}
+	m.tasks = append(m.tasks, t)
+	return t
+}
+
+func (m *memTaskRepo) CreateTask(_ context.Context, t db.NewTask) (db.TaskID, error) {
+	if m.err != nil {
+		return 0, m.err
+	}
+	key := t.UserID + "\x00" + t.IdempotencyKey
+	m.mu.Lock()
+	id, seen := m.keys[key]
+	m.mu.Unlock()
+	if seen && t.IdempotencyKey != "" {
+		return id, nil
+	}
+	task := m.add(db.Task{Title: t.Title, Description: t.Description, Priority: t.Priority, Status: t.Status, Recurrence: t.Recurrence, DueAt: t.DueAt, UserID: t.UserID})
+	if t.IdempotencyKey != "" {
+		m.mu.Lock()
+		if m.keys == nil {
+			m.keys = map[string]db.TaskID{}
+		}
+		m.keys[key] = task.ID
+		m.mu.Unlock()
+	}
+	return task.ID, nil
+}
+
+// CreateTasks is all-or-nothing like the transactional repository: with err
+// set nothing is stored.
+func (m *memTaskRepo) CreateTasks(ctx context.Context, tasks []db.NewTask) ([]db.TaskID, error) {
+	if m.err != nil {
+		return nil, m.err
+	}
+	ids := make([]db.TaskID, 0, len(tasks))
+	for _, t := range tasks {
+		id, _ := m.CreateTask(ctx, t)
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+func (m *memTaskRepo) GetTask(_ context.Context, id db.TaskID, userID string) (db.Task, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.err != nil {
+		return db.Task{}, m.err
+	}
+	if id < 1 || int(id) > len(m.tasks) || m.tasks[id-1].UserID != userID {
+		return db.Task{}, db.ErrTaskNotFound
+	}
+	return m.tasks[id-1], nil
+}
+
+func (m *memTaskRepo) ListTasks(_ context.Context, userID string) ([]db.Task, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.err != nil {
+		return nil, m.err
+	}
+	var tasks []db.Task
+	for i := len(m.tasks) - 1; i >= 0; i-- {
+		if m.tasks[i].UserID == userID {
+			tasks = append(tasks, m.tasks[i])
+		}
+	}
+	return tasks, nil
+}
+
+func (m *memTaskRepo) WithTx(_ context.Context, fn func(db.TaskRepository) error) error {
+	return fn(m)
+}
+
+func (m *memTaskRepo) UpdateTask(_ context.Context, id db.TaskID, userID string, f db.TaskUpdate) (db.Task, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.err != nil {
+		return db.Task{}, m.err
+	}
+	if f.Title == nil && f.Description == nil && f.Priority == nil && f.Status == nil {
+		return db.Task{}, db.ErrEmptyTaskUpdate
+	}
+	if id < 1 || int(id) > len(m.tasks) || m.tasks[id-1].UserID != userID {
+		return db.Task{}, db.ErrTaskNotFound
+	}
+	t := &m.tasks[id-1]
+	if f.Title != nil {
+		t.Title = *f.Title
+	}
+	if f.Description != nil {
+		t.Description = *f.Description
+	}
+	if f.Priority != nil {
+		t.Priority = *f.Priority
+	}
+	if f.Status != nil {
+		t.Status = *f.Status
+	}
+	return *t, nil
+}
+
+// DeleteAllForUser blanks the owner of the user's tasks rather than removing
+// them, so IDs stay positional.
+func (m *memTaskRepo) DeleteAllForUser(_ context.Context, userID string) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.err != nil {
+		return 0, m.err
+	}
+	var n int64
+	for i := range m.tasks {
+		if m.tasks[i].UserID == userID {
+			m.tasks[i].UserID = ""
+			n++
+		}
+	}
+	return n, nil
+}
+
+func (m *memTaskRepo) CountByStatus(_ context.Context, userID string) (map[string]int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.err != nil {
+		return nil, m.err
+	}
+	counts := map[string]int{"pending": 0, "in_progress": 0, "done": 0}
+	for _, t := range m.tasks {
+		if t.UserID == userID {
+			counts[t.Status]++
+		}
+	}
+	return counts, nil
+}
+
+func (m *memTaskRepo) UpdateManyStatus(_ context.Context, ids []db.TaskID, userID, status string) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.err != nil {
+		return 0, m.err
+	}
+	var n int64
+	for _, id := range ids {
+		if id >= 1 && int(id) <= len(m.tasks) && m.tasks[id-1].UserID == userID {
+			m.tasks[id-1].Status = status
+			n++
+		}
+	}
+	return n, nil
+}
+
+// serve runs h on a request for target with the {id} path value set when
+// id is not empty, and returns the recorder.
+func serve(h http.HandlerFunc, method, target, id, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	if id != "" {
+		req.SetPathValue("id", id)
+	}
+	rec := httptest.NewRecorder()
+	h(rec, req)
+	return rec
+}
+
+func TestTaskStatsHandler(t *testing.T) {
+	mixed := func() *memTaskRepo {
+		m := &memTaskRepo{}
+		for _, s := range []string{"pending", "done", "pending", "in_progress", "done", "done"} {
+			m.add(db.Task{UserID: testUser, Status: s})
+		}
+		m.add(db.Task{UserID: "11111111-2222-4333-8444-555555555555", Status: "pending"})
+		return m
+	}
+	tests := []struct {
+		name       string
+		repo       *memTaskRepo
+		query      string
+		wantStatus int
+		wantBody   string
+	}{
+		{"mixed statuses", mixed(), "?user_id=" + testUser, http.StatusOK, `{"done":3,"in_progress":1,"pending":2}`},
+		{"no tasks reports zeros", &memTaskRepo{}, "?user_id=" + testUser, http.StatusOK, `{"done":0,"in_progress":0,"pending":0}`},
+		{"missing user", mixed(), "", http.StatusBadRequest, ""},
+		{"repository failure", &memTaskRepo{err: errors.New("db down")}, "?user_id=" + testUser, http.StatusInternalServerError, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := serve(taskStatsHandler(tt.repo), http.MethodGet, "/api/v1/tasks/stats"+tt.query, "", "")
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+			if tt.wantBody != "" && strings.TrimSpace(rec.Body.String()) != tt.wantBody {
+				t.Errorf("body = %s, want %s", rec.Body, tt.wantBody)
+			}
+		})
+	}
+}
+
+func TestGetTaskHandler(t *testing.T) {
+	const other = "11111111-2222-4333-8444-555555555555"
+	repo := &memTaskRepo{}
+	repo.add(db.Task{Title: "mine", UserID: testUser})
+	repo.add(db.Task{Title: "theirs", UserID: other})
+
+	tests := []struct {
+		name       string
+		id         string
+		user       string
+		wantStatus int
+		wantTitle  string
+	}{
+		{"found", "1", testUser, http.StatusOK, "mine"},
+		{"not found", "99", testUser, http.StatusNotFound, ""},
+		{"wrong owner", "2", testUser, http.StatusNotFound, ""},
+		{"invalid id", "abc", testUser, http.StatusBadRequest, ""},
+		{"missing user", "1", "", http.StatusBadRequest, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := serve(getTaskHandler(repo), http.MethodGet, "/api/v1/tasks/"+tt.id+"?user_id="+tt.user, tt.id, "")
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+			if tt.wantTitle == "" {
+				return
+			}
+			var task db.Task
+			if err := json.NewDecoder(rec.Body).Decode(&task); err != nil {
+				t.Fatal(err)
+			}
+			if task.Title != tt.wantTitle || task.UserID != tt.user {
+				t.Errorf("task = %+v, want %q owned by %s", task, tt.wantTitle, tt.user)
+			}
+		})
+	}
+}
+
+func TestUpdateTaskHandler(t *testing.T) {
+	const other = "11111111-2222-4333-8444-555555555555"
+	tests := []struct {
+		name       string
+		id         string
+		body       string
+		wantStatus int
+		want       db.Task // compared when the update succeeds
+	}{
+		{"title only", "1", `{"title":" Buy oat milk ","user_id":"` + testUser + `"}`, http.StatusOK,
+			db.Task{Title: "Buy oat milk", Description: "2 litres", Priority: 1, Status: "pending"}},
+		{"description and priority", "1", `{"description":"3 litres","priority":3,"user_id":"` + testUser + `"}`, http.StatusOK,
+			db.Task{Title: "Buy milk", Description: "3 litres", Priority: 3, Status: "pending"}},
+		{"status only", "1", `{"status":"done","user_id":"` + testUser + `"}`, http.StatusOK,
+			db.Task{Title: "Buy milk", Description: "2 litres", Priority: 1, Status: "done"}},
+		{"empty update", "1", `{"user_id":"` + testUser + `"}`, http.StatusBadRequest, db.Task{}},
+		{"blank title", "1", `{"title":"  ","user_id":"` + testUser + `"}`, http.StatusBadRequest, db.Task{}},
+		{"invalid priority", "1", `{"priority":7,"user_id":"` + testUser + `"}`, http.StatusBadRequest, db.Task{}},
+		{"invalid status", "1", `{"status":"later","user_id":"` + testUser + `"}`, http.StatusBadRequest, db.Task{}},
+		{"unknown field", "1", `{"titel":"x","user_id":"` + testUser + `"}`, http.StatusBadRequest, db.Task{}},
+		{"wrong owner", "1", `{"title":"x","user_id":"` + other + `"}`, http.StatusNotFound, db.Task{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := &memTaskRepo{}
+			orig := repo.add(db.Task{Title: "Buy milk", Description: "2 litres", Priority: 1, UserID: testUser})
+
+			rec := serve(updateTaskHandler(repo), http.MethodPatch, "/api/v1/tasks/"+tt.id, tt.id, tt.body)
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+			stored, _ := repo.GetTask(context.Background(), orig.ID, testUser)
+			if rec.Code != http.StatusOK {
+				if stored != orig {
+					t.Errorf("rejected update changed the task to %+v", stored)
+				}
+				return
+			}
+			var got db.Task
+			if err := json.NewDecoder(rec.Body).Decode(&got); err != nil {
+				t.Fatal(err)
+			}
+			want := tt.want
+			want.ID, want.UserID = orig.ID, testUser
+			if got != want || stored != want {
+				t.Errorf("updated task = %+v (stored %+v), want %+v", got, stored, want)
+			}
+		})
+	}
+}
+
+func TestBulkStatusHandler(t *testing.T) {
+	const other = "11111111-2222-4333-8444-555555555555"
+	tests := []struct {
+		name       string
+		body       string
+		repoErr    error
+		wantStatus int
+		wantBody   string
+		wantDone   []db.TaskID // tasks done afterwards; 1 and 2 are the user's, 3 is other's
+	}{
+		{"owned tasks", `{"ids":[1,2],"status":"done","user_id":"` + testUser + `"}`, nil,
+			http.StatusOK, `{"updated":2}`, []db.TaskID{1, 2}},
+		{"owned and unowned ids", `{"ids":[1,3,99],"status":"done","user_id":"` + testUser + `"}`, nil,
+			http.StatusOK, `{"updated":1}`, []db.TaskID{1}},
+		{"only unowned ids", `{"ids":[3],"status":"done","user_id":"` + testUser + `"}`, nil,
+			http.StatusOK, `{"updated":0}`, nil},
+		{"empty ids", `{"ids":[],"status":"done","user_id":"` + testUser + `"}`, nil, http.StatusBadRequest, "", nil},
+		{"invalid status", `{"ids":[1],"status":"later","user_id":"` + testUser + `"}`, nil, http.StatusBadRequest, "", nil},
+		{"missing user", `{"ids":[1],"status":"done"}`, nil, http.StatusBadRequest, "", nil},
+		{"unknown field", `{"ids":[1],"status":"done","user_id":"` + testUser + `","x":1}`, nil, http.StatusBadRequest, "", nil},
+		{"too many ids", `{"ids":[` + strings.Repeat("1,", maxBulkTaskIDs) + `1],"status":"done","user_id":"` + testUser + `"}`, nil,
+			http.StatusBadRequest, "", nil},
+		{"repository failure", `{"ids":[1],"status":"done","user_id":"` + testUser + `"}`, errors.New("db down"),
+			http.StatusInternalServerError, "", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := &memTaskRepo{}
+			repo.add(db.Task{Title: "a", UserID: testUser})
+			repo.add(db.Task{Title: "b", UserID: testUser})
+			repo.add(db.Task{Title: "c", UserID: other})
+			repo.err = tt.repoErr
+
+			rec := serve(bulkStatusHandler(repo), http.MethodPatch, "/api/v1/tasks/bulk", "", tt.body)
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+			if tt.wantBody != "" && strings.TrimSpace(rec.Body.String()) != tt.wantBody {
+				t.Errorf("body = %s, want %s", rec.Body, tt.wantBody)
+			}
+			var done []db.TaskID
+			for _, task := range repo.tasks {
+				if task.Status == "done" {
+					done = append(done, task.ID)
+				}
+			}
+			if fmt.Sprint(done) != fmt.Sprint(tt.wantDone) {
+				t.Errorf("done tasks = %v, want %v", done, tt.wantDone)
+			}
+		})
+	}
+}
+
+func TestCompleteTaskHandler(t *testing.T) {
+	const other = "11111111-2222-4333-8444-555555555555"
+	tests := []struct {
+		name       string
+		status     string // the task's status before the call
+		id         string
+		user       string
+		repoErr    error
+		wantStatus int
+	}{
+		{"pending task", "pending", "1", testUser, nil, http.StatusOK},
+		{"in progress task", "in_progress", "1", testUser, nil, http.StatusOK},
+		{"already done is a no-op", "done", "1", testUser, nil, http.StatusOK},
+		{"wrong owner", "pending", "1", other, nil, http.StatusNotFound},
+		{"unknown id", "pending", "99", testUser, nil, http.StatusNotFound},
+		{"invalid id", "pending", "abc", testUser, nil, http.StatusBadRequest},
+		{"missing user", "pending", "1", "", nil, http.StatusBadRequest},
+		{"repository failure", "pending", "1", testUser, errors.New("db down"), http.StatusInternalServerError},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := &memTaskRepo{}
+			orig := repo.add(db.Task{Title: "Buy milk", Status: tt.status, UserID: testUser})
+			repo.err = tt.repoErr
+
+			rec := serve(completeTaskHandler(repo), http.MethodPost, "/api/v1/tasks/"+tt.id+"/complete?user_id="+tt.user, tt.id, "")
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+			repo.err = nil
+			stored, _ := repo.GetTask(context.Background(), orig.ID, testUser)
+			if rec.Code != http.StatusOK {
+				if stored != orig {
+					t.Errorf("failed complete changed the task to %+v", stored)
+				}
+				return
+			}
+			var got db.Task
+			if err := json.NewDecoder(rec.Body).Decode(&got); err != nil {
+				t.Fatal(err)
+			}
+			want := orig
+			want.Status = "done"
+			if got != want || stored != want {
+				t.Errorf("completed task = %+v (stored %+v), want %+v", got, stored, want)
+			}
+		})
+	}
+}
+
+func TestTaskHandlersBlockCrossUserAccess(t *testing.T) {
+	auth := bearerAuthMiddleware(map[string]string{testToken: testUser, otherTestToken: otherTestUser})
+	tests := []struct {
+		name       string
+		handler    func(db.TaskRepository) http.HandlerFunc
+		method     string
+		target     string
+		body       string
+		token      string
+		wantStatus int
+	}{
+		{"owner reads own task", getTaskHandler, http.MethodGet, "/api/v1/tasks/1", "", testToken, http.StatusOK},
+		{"other user cannot read it", getTaskHandler, http.MethodGet, "/api/v1/tasks/1", "", otherTestToken, http.StatusNotFound},
+		{"other user cannot claim the owner", getTaskHandler, http.MethodGet, "/api/v1/tasks/1?user_id=" + testUser, "", otherTestToken, http.StatusForbidden},
+		{"other user cannot list the owner's tasks", listTasksHandler, http.MethodGet, "/api/v1/tasks?user_id=" + testUser, "", otherTestToken, http.StatusForbidden},
+		{"other user cannot update it", updateTaskHandler, http.MethodPatch, "/api/v1/tasks/1", `{"title":"hijacked"}`, otherTestToken, http.StatusNotFound},
+		{"other user cannot update via a claimed user_id", updateTaskHandler, http.MethodPatch, "/api/v1/tasks/1", `{"title":"hijacked","user_id":"` + testUser + `"}`, otherTestToken, http.StatusForbidden},
+		{"owner updates own task", updateTaskHandler, http.MethodPatch, "/api/v1/tasks/1", `{"title":"renamed"}`, testToken, http.StatusOK},
+		{"missing token rejected", getTaskHandler, http.MethodGet, "/api/v1/tasks/1?user_id=" + testUser, "", "", http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := &memTaskRepo{}
+			repo.add(db.Task{Title: "buy milk", UserID: testUser})
+
+			req := httptest.NewRequest(tt.method, tt.target, strings.NewReader(tt.body))
+			req.SetPathValue("id", "1")
+			if tt.token != "" {
+				req.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+			rec := httptest.NewRecorder()
+			auth(tt.handler(repo)).ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+			if title := repo.tasks[0].Title; tt.wantStatus != http.StatusOK && title != "buy milk" {
+				t.Errorf("task title = %q after a refused request", title)
+			}
+		})
+	}
+}
+
+func TestBatchCreateTasksHandler(t *testing.T) {
+	tests := []struct {
+		name       string
+		body       string
+		repoErr    error
+		wantStatus int
+		wantIDs    []db.TaskID
+		wantTitles []string // stored afterwards, in order
+	}{
+		{"created in order", `{"user_id":"` + testUser + `","tasks":[{"title":"a"},{"title":"b","priority":3,"due_date":"2026-11-01"}]}`,
+			nil, http.StatusCreated, []db.TaskID{1, 2}, []string{"a", "b"}},
+		{"invalid item writes nothing", `{"user_id":"` + testUser + `","tasks":[{"title":"a"},{"title":" "}]}`,
+			nil, http.StatusBadRequest, nil, nil},
+		{"bad due date writes nothing", `{"user_id":"` + testUser + `","tasks":[{"title":"a","due_date":"soon"}]}`,
+			nil, http.StatusBadRequest, nil, nil},
+		{"empty batch", `{"user_id":"` + testUser + `","tasks":[]}`, nil, http.StatusBadRequest, nil, nil},
+		{"repository failure rolls back", `{"user_id":"` + testUser + `","tasks":[{"title":"a"},{"title":"b"}]}`,
+			errors.New("insert failed"), http.StatusInternalServerError, nil, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := &memTaskRepo{err: tt.repoErr}
+			rec := serve(batchCreateTasksHandler(repo), http.MethodPost, "/api/v1/tasks/batch", "", tt.body)
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+			if rec.Code == http.StatusCreated {
+				var resp struct {
+					IDs []db.TaskID `json:"ids"`
+				}
+				if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+					t.Fatal(err)
+				}
+				if fmt.Sprint(resp.IDs) != fmt.Sprint(tt.wantIDs) {
+					t.Errorf("ids = %v, want %v", resp.IDs, tt.wantIDs)
+				}
+			}
+			var titles []string
+			for _, task := range repo.tasks {
+				titles = append(titles, task.Title)
+			}
+			if fmt.Sprint(titles) != fmt.Sprint(tt.wantTitles) {
+				t.Errorf("stored %v, want %v", titles, tt.wantTitles)
+			}
+		})
+	}
+}
@@ -0,0 +1,246 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"core-go/internal/db"
+	"core-go/internal/logging"
+)
+
+// exportFlushEvery is how many JSONL rows the export writes between
+// flushes: often enough that a large account streams steadily, rarely
+// enough that each row is not its own TCP write.
+const exportFlushEvery = 100
+
+// ── Export tasks ──────────────────────────────────────────────────────────────
+
+// exportTasksHandler handles GET /api/v1/tasks/export?user_id=<uuid>
+// Streams every task the user owns as newline-delimited JSON (one db.Task
+// object per line, oldest first) straight from the database cursor, so
+// large accounts are never buffered in memory.
+//
+// Once the first row is written the status is committed; a database error
+// after that aborts the connection so the client sees a truncated transfer
+// instead of a clean end of a partial file.
+func exportTasksHandler(repo db.TaskRepository) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, status, msg := requestUserID(r, r.URL.Query().Get("user_id"), "")
+		if status != 0 {
+			http.Error(w, msg, status)
+			return
+		}
+
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			http.Error(w, "streaming not supported by this server", http.StatusInternalServerError)
+			return
+		}
+
+		// A large account takes longer to stream than WriteTimeout allows.
+		liftDeadlines(w, r, false)
+
+		var (
+			enc     = json.NewEncoder(w)
+			written int
+		)
+		err := repo.EachTask(r.Context(), userID, func(t db.Task) error {
+			if written == 0 {
+				w.Header().Set("Content-Type", "application/x-ndjson")
+				w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="tasks-%s.jsonl"`, userID))
+			}
+			// Encode terminates every value with '\n', which is exactly the
+			// JSONL line separator.
+			if err := enc.Encode(t); err != nil {
+				return err
+			}
+			written++
+			if written%exportFlushEvery == 0 {
+				flusher.Flush()
+			}
+			return nil
+		})
+
+		logger := logging.FromContext(r.Context()).With("user_id", userID, "tasks", written)
+		if err != nil {
+			logger.Error("tasks: export", "err", err)
+			if written == 0 {
+				http.Error(w, "failed to export tasks", http.StatusInternalServerError)
+				return
+			}
+			panic(http.ErrAbortHandler)
+		}
+
+		if written == 0 {
+			// No rows: still answer with an empty JSONL body of the right type.
+			w.Header().Set("Content-Type", "application/x-ndjson")
+		}
+		flusher.Flush()
+		logger.Info("tasks: exported")
+	}
+}
+
+// ── Import tasks ──────────────────────────────────────────────────────────────
+
+const (
+	// maxImportBytes caps an import body; at a few hundred bytes per task
+	// that is well over 100k tasks.
+	maxImportBytes = 32 << 20
+
+	// maxImportLineBytes caps a single JSONL line. A longer line cannot be
+	// skipped safely, so it ends the import.
+	maxImportLineBytes = 64 << 10
+)
+
+// importTaskLine is one line of POST /api/v1/tasks/import. It accepts the
+// batch create fields plus everything an export line carries, so an export
+// can be imported as-is. id, user_id and created_at are accepted but
+// ignored: the task gets a fresh ID, the caller's user_id, and the current
+// time. due_at (RFC 3339) is the export's spelling of due_date.
+type importTaskLine struct {
+	batchTaskItem
+	Status     string     `json:"status"`
+	Recurrence string     `json:"recurrence"`
+	DueAt      *time.Time `json:"due_at"`
+
+	ID        json.RawMessage `json:"id"`
+	UserID    json.RawMessage `json:"user_id"`
+	CreatedAt json.RawMessage `json:"created_at"`
+}
+
+// newTask validates the line with the batch create rules plus status and
+// recurrence, returning a non-empty message on failure.
+func (l importTaskLine) newTask(userID string) (db.NewTask, string) {
+	t, msg := l.batchTaskItem.newTask(userID)
+	if msg != "" {
+		return t, msg
+	}
+	if l.Status != "" && !validStatuses[l.Status] {
+		return t, `"status" must be one of: pending, in_progress, done`
+	}
+	if !db.ValidRecurrence(l.Recurrence) {
+		return t, `"recurrence" must be one of: daily, weekly, monthly`
+	}
+	if l.DueAt != nil && t.DueAt == nil {
+		t.DueAt = l.DueAt
+	}
+	t.Status = l.Status
+	t.Recurrence = l.Recurrence
+	return t, ""
+}
+
+// importLineResult is one line of the import response.
+type importLineResult struct {
+	Line   int       `json:"line"`
+	Status string    `json:"status"` // "created" or "error"
+	ID     db.TaskID `json:"id,omitempty"`
+	Error  string    `json:"error,omitempty"`
+}
+
+// importTasksHandler handles POST /api/v1/tasks/import?user_id=<uuid>
+// The body is newline-delimited JSON, one task per line (an export file
+// works). Each line is validated and inserted on its own, and the response
+// streams one JSON line per input line as {line, status, id|error}; an
+// invalid or failed line is reported and the import moves on. Blank lines
+// are skipped. Line numbers are 1-based.
+func importTasksHandler(repo db.TaskRepository) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, status, msg := requestUserID(r, r.URL.Query().Get("user_id"), "")
+		if status != 0 {
+			http.Error(w, msg, status)
+			return
+		}
+
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			http.Error(w, "streaming not supported by this server", http.StatusInternalServerError)
+			return
+		}
+
+		// Results are streamed while the body is still being read, which
+		// HTTP/1.x only allows once full duplex is enabled.
+		if err := http.NewResponseController(w).EnableFullDuplex(); err != nil {
+			logging.FromContext(r.Context()).Warn("tasks: import: full duplex unavailable", "err", err)
+		}
+		// A body of up to maxImportBytes, inserted line by line, outlasts
+		// both ReadTimeout and WriteTimeout.
+		liftDeadlines(w, r, true)
+
+		r.Body = http.MaxBytesReader(w, r.Body, maxImportBytes)
+		scanner := bufio.NewScanner(r.Body)
+		scanner.Buffer(make([]byte, 0, 4096), maxImportLineBytes)
+
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		enc := json.NewEncoder(w)
+		report := func(res importLineResult) {
+			enc.Encode(res)
+			flusher.Flush()
+		}
+
+		var line, created, failed int
+		for scanner.Scan() {
+			line++
+			raw := bytes.TrimSpace(scanner.Bytes())
+			if len(raw) == 0 {
+				continue
+			}
+
+			var item importTaskLine
+			dec := json.NewDecoder(bytes.NewReader(raw))
+			dec.DisallowUnknownFields()
+			if err := dec.Decode(&item); err != nil || dec.More() {
+				failed++
+				report(importLineResult{Line: line, Status: "error", Error: "invalid JSON object"})
+				continue
+			}
+			t, msg := item.newTask(userID)
+			if msg != "" {
+				failed++
+				report(importLineResult{Line: line, Status: "error", Error: msg})
+				continue
+			}
+
+			id, err := repo.CreateTask(r.Context(), t)
+			if err != nil {
+				if r.Context().Err() != nil {
+					return // client gone; nobody to report to
+				}
+				logging.FromContext(r.Context()).Error("tasks: import line", "user_id", userID, "line", line, "err", err)
+				failed++
+				report(importLineResult{Line: line, Status: "error", Error: "failed to create task"})
+				continue
+			}
+			created++
+			report(importLineResult{Line: line, Status: "created", ID: id})
+		}
+
+		logger := logging.FromContext(r.Context()).With("user_id", userID, "created", created, "failed", failed)
+		if err := scanner.Err(); err != nil {
+			// An over-long line or an over-size body cannot be resumed
+			// past; report it against the line that was being read.
+			logger.Warn("tasks: import stopped", "line", line+1, "err", err)
+			report(importLineResult{Line: line + 1, Status: "error", Error: "import stopped: " + importScanError(err)})
+			return
+		}
+		logger.Info("tasks: imported")
+	}
+}
+
+// importScanError is the client-facing reason a scan of the import body
+// ended early.
+func importScanError(err error) string {
+	var maxBytes *http.MaxBytesError
+	switch {
+	case errors.Is(err, bufio.ErrTooLong):
+		return fmt.Sprintf("line longer than %d bytes", maxImportLineBytes)
+	case errors.As(err, &maxBytes):
+		return fmt.Sprintf("body larger than %d bytes", maxImportBytes)
+	default:
+		return "could not read request body"
+	}
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"core-go/internal/db"
+)
+
+// EachTask calls fn for the user's tasks oldest first. With err set it fails
+// after failAfter rows, as a dropped database connection would.
+func (m *memTaskRepo) EachTask(_ context.Context, userID string, fn func(db.Task) error) error {
+	m.mu.Lock()
+	tasks := append([]db.Task(nil), m.tasks...)
+	m.mu.Unlock()
+	var sent int
+	for _, t := range tasks {
+		if t.UserID != userID {
+			continue
+		}
+		if m.err != nil && sent == m.failAfter {
+			return m.err
+		}
+		if err := fn(t); err != nil {
+			return err
+		}
+		sent++
+	}
+	if m.err != nil {
+		return m.err
+	}
+	return nil
+}
+
+// flushCounter is a ResponseRecorder that counts Flush calls.
+type flushCounter struct {
+	*httptest.ResponseRecorder
+	flushes int
+}
+
+func (f *flushCounter) Flush() {
+	f.flushes++
+	f.ResponseRecorder.Flush()
+}
+
+func TestExportTasksHandler(t *testing.T) {
+	tests := []struct {
+		name        string
+		tasks       int
+		err         error
+		failAfter   int
+		wantStatus  int
+		wantLines   int
+		wantFlushes int
+		wantAbort   bool
+	}{
+		{"no tasks", 0, nil, 0, http.StatusOK, 0, 1, false},
+		{"a few tasks", 3, nil, 0, http.StatusOK, 3, 1, false},
+		{"flushed while streaming", 2*exportFlushEvery + 5, nil, 0, http.StatusOK, 2*exportFlushEvery + 5, 3, false},
+		{"failure before the first row", 3, errors.New("down"), 0, http.StatusInternalServerError, 0, 0, false},
+		{"failure mid-stream aborts", 3, errors.New("down"), 2, http.StatusOK, 2, 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := &memTaskRepo{}
+			for i := 0; i < tt.tasks; i++ {
+				repo.add(db.Task{Title: fmt.Sprintf("task %d", i), UserID: testUser, Priority: db.PriorityMedium})
+			}
+			repo.add(db.Task{Title: "someone else's", UserID: otherTestUser})
+			repo.err, repo.failAfter = tt.err, tt.failAfter
+
+			rec := &flushCounter{ResponseRecorder: httptest.NewRecorder()}
+			aborted := func() (aborted bool) {
+				defer func() {
+					if p := recover(); p != nil {
+						if p != http.ErrAbortHandler {
+							panic(p)
+						}
+						aborted = true
+					}
+				}()
+				exportTasksHandler(repo)(rec, httptest.NewRequest(http.MethodGet, "/api/v1/tasks/export?user_id="+testUser, nil))
+				return false
+			}()
+
+			if aborted != tt.wantAbort {
+				t.Fatalf("aborted = %v, want %v", aborted, tt.wantAbort)
+			}
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+			if ct := rec.Header().Get("Content-Type"); ct != "application/x-ndjson" {
+				t.Errorf("Content-Type = %q, want application/x-ndjson", ct)
+			}
+			var lines int
+			scanner := bufio.NewScanner(rec.Body)
+			for scanner.Scan() {
+				var task db.Task
+				if err := json.Unmarshal(scanner.Bytes(), &task); err != nil {
+					t.Fatalf("line %d is not a JSON task: %v", lines+1, err)
+				}
+				if want := fmt.Sprintf("task %d", lines); task.Title != want || task.UserID != testUser {
+					t.Errorf("line %d = %q for %s, want %q for %s", lines+1, task.Title, task.UserID, want, testUser)
+				}
+				lines++
+			}
+			if lines != tt.wantLines {
+				t.Errorf("exported %d lines, want %d", lines, tt.wantLines)
+			}
+			if !tt.wantAbort && rec.flushes != tt.wantFlushes {
+				t.Errorf("flushed %d times, want %d", rec.flushes, tt.wantFlushes)
+			}
+		})
+	}
+}
+
+func TestImportTasksHandler(t *testing.T) {
+	tests := []struct {
+		name       string
+		body       string
+		wantResult []string // "line:status" per reported line
+		wantTitles []string // created, in order
+	}{
+		{
+			"mixed valid and invalid lines",
+			strings.Join([]string{
+				`{"title":"buy milk","priority":2}`,
+				`not json`,
+				``,
+				`{"title":"  "}`,
+				`{"title":"file taxes","status":"someday"}`,
+				`{"title":"walk","recurrence":"daily","status":"in_progress"}`,
+				`{"title":"x","colour":"red"}`,
+			}, "\n"),
+			[]string{"1:created", "2:error", "4:error", "5:error", "6:created", "7:error"},
+			[]string{"buy milk", "walk"},
+		},
+		{
+			"export line imported as-is",
+			`{"id":7,"title":"from export","priority":1,"status":"done","user_id":"` + otherTestUser + `","created_at":"2026-01-02T03:04:05Z","due_at":"2026-02-01T00:00:00Z"}`,
+			[]string{"1:created"},
+			[]string{"from export"},
+		},
+		{
+			"over-long line stops the import",
+			`{"title":"kept"}` + "\n" + `{"title":"` + strings.Repeat("x", maxImportLineBytes) + `"}` + "\n" + `{"title":"never read"}`,
+			[]string{"1:created", "2:error"},
+			[]string{"kept"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := &memTaskRepo{}
+			rec := serve(importTasksHandler(repo), http.MethodPost, "/api/v1/tasks/import?user_id="+testUser, "", tt.body)
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+			}
+
+			var results []string
+			scanner := bufio.NewScanner(rec.Body)
+			for scanner.Scan() {
+				var res importLineResult
+				if err := json.Unmarshal(scanner.Bytes(), &res); err != nil {
+					t.Fatalf("response line %q: %v", scanner.Text(), err)
+				}
+				if (res.Status == "created") != (res.ID != 0) || (res.Status == "error") != (res.Error != "") {
+					t.Errorf("result %+v: want an id when created and an error otherwise", res)
+				}
+				results = append(results, fmt.Sprintf("%d:%s", res.Line, res.Status))
+			}
+			if fmt.Sprint(results) != fmt.Sprint(tt.wantResult) {
+				t.Errorf("results = %v, want %v", results, tt.wantResult)
+			}
+
+			var titles []string
+			for _, task := range repo.tasks {
+				titles = append(titles, task.Title)
+				if task.UserID != testUser {
+					t.Errorf("task %q imported for %q, want %q", task.Title, task.UserID, testUser)
+				}
+			}
+			if fmt.Sprint(titles) != fmt.Sprint(tt.wantTitles) {
+				t.Errorf("created %v, want %v", titles, tt.wantTitles)
+			}
+		})
+	}
+}
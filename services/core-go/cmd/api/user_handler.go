@@ -0,0 +1,77 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"core-go/internal/agent"
+	"core-go/internal/db"
+	"core-go/internal/logging"
+	"core-go/internal/vector"
+)
+
+// purgeUserResponse is returned by DELETE /api/v1/users/{user_id}/data.
+type purgeUserResponse struct {
+	UserID               string `json:"user_id"`
+	TasksDeleted         int64  `json:"tasks_deleted"`
+	ConversationsDeleted int64  `json:"conversations_deleted"`
+	DocumentsDeleted     int64  `json:"documents_deleted"`
+}
+
+// purgeUserDataHandler handles DELETE /api/v1/users/{user_id}/data.
+// Wipes everything the user owns: tasks, stored conversations, document
+// records, and their Qdrant chunks. The shared namespace (vector.SharedUserID)
+// can never be purged here.
+func purgeUserDataHandler(tasks db.TaskRepository, convos db.ConversationRepository, docs db.DocumentRepository, kb *agent.KnowledgeBase) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID := strings.TrimSpace(r.PathValue("user_id"))
+		if !isValidUserID(userID) {
+			http.Error(w, "invalid user_id", http.StatusBadRequest)
+			return
+		}
+		if userID == vector.SharedUserID {
+			http.Error(w, "the shared namespace cannot be purged", http.StatusForbidden)
+			return
+		}
+
+		logger := logging.FromContext(r.Context()).With("user_id", userID)
+
+		tasksDeleted, err := tasks.DeleteAllForUser(r.Context(), userID)
+		if err != nil {
+			logger.Error("purge: tasks", "err", err)
+			http.Error(w, "failed to delete tasks", http.StatusInternalServerError)
+			return
+		}
+
+		convosDeleted, err := convos.DeleteAllForUser(r.Context(), userID)
+		if err != nil {
+			logger.Error("purge: conversations", "err", err)
+			http.Error(w, "failed to delete conversations", http.StatusInternalServerError)
+			return
+		}
+
+		if err := kb.DeleteAllForUser(r.Context(), userID); err != nil {
+			logger.Error("purge: documents", "err", err)
+			http.Error(w, "failed to delete documents", http.StatusInternalServerError)
+			return
+		}
+
+		docsDeleted, err := docs.DeleteAllForUser(r.Context(), userID)
+		if err != nil {
+			logger.Error("purge: document records", "err", err)
+			http.Error(w, "failed to delete document records", http.StatusInternalServerError)
+			return
+		}
+
+		logger.Info("purge: user data deleted", "tasks", tasksDeleted, "conversations", convosDeleted, "documents", docsDeleted)
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(purgeUserResponse{
+			UserID:               userID,
+			TasksDeleted:         tasksDeleted,
+			ConversationsDeleted: convosDeleted,
+			DocumentsDeleted:     docsDeleted,
+		})
+	}
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"core-go/internal/agent"
+	"core-go/internal/db"
+	"core-go/internal/vector"
+)
+
+func TestPurgeUserDataHandler(t *testing.T) {
+	tests := []struct {
+		name       string
+		userID     string
+		wantStatus int
+		wantTasks  int64
+		wantDocs   int64
+	}{
+		{"user purged", testUser, http.StatusOK, 2, 1},
+		{"shared namespace refused", vector.SharedUserID, http.StatusForbidden, 0, 0},
+		{"invalid user_id", "not a uuid", http.StatusBadRequest, 0, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			kb, srv := newTestKB(t)
+			tasks := &memTaskRepo{}
+			docs := &memDocuments{}
+			for _, owner := range []string{testUser, vector.SharedUserID} {
+				tasks.add(db.Task{Title: "a", UserID: owner})
+				tasks.add(db.Task{Title: "b", UserID: owner})
+				docs.RecordDocument(ctx, db.NewDocument{UserID: owner, Source: "notes.md"})
+				if _, err := kb.IngestText(ctx, "alpha beta gamma", "notes.md", owner, agent.IngestOptions{}); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			req := httptest.NewRequest(http.MethodDelete, "/api/v1/users/x/data", nil)
+			req.SetPathValue("user_id", tt.userID)
+			rec := httptest.NewRecorder()
+			purgeUserDataHandler(tasks, &fakeConversations{}, docs, kb)(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+			if tt.wantStatus == http.StatusOK {
+				var resp purgeUserResponse
+				if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+					t.Fatal(err)
+				}
+				if resp.TasksDeleted != tt.wantTasks || resp.DocumentsDeleted != tt.wantDocs {
+					t.Errorf("response = %+v, want %d tasks and %d documents", resp, tt.wantTasks, tt.wantDocs)
+				}
+			}
+
+			// Whatever happened to the target, the shared namespace is intact.
+			purged := tt.wantStatus == http.StatusOK
+			for _, owner := range []string{testUser, vector.SharedUserID} {
+				left, _ := tasks.ListTasks(ctx, owner)
+				var points int
+				for _, c := range srv.Collections() {
+					for _, p := range srv.Points(c) {
+						if p.Payload["user_id"] == owner {
+							points++
+						}
+					}
+				}
+				if gone := owner == tt.userID && purged; gone != (len(left) == 0) || gone != (points == 0) {
+					t.Errorf("user %q after purge: %d tasks, %d chunks; want them gone = %v", owner, len(left), points, gone)
+				}
+			}
+		})
+	}
+}
+
+func TestPurgeProtectsConfiguredSharedUser(t *testing.T) {
+	saved := vector.SharedUserID
+	vector.SharedUserID = "team-kb"
+	t.Cleanup(func() { vector.SharedUserID = saved })
+
+	tests := []struct {
+		name       string
+		userID     string
+		wantStatus int
+	}{
+		{"configured shared id refused", "team-kb", http.StatusForbidden},
+		{"old default is an ordinary invalid id", "admin", http.StatusBadRequest},
+		{"user purged", testUser, http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			kb, _ := newTestKB(t)
+			req := httptest.NewRequest(http.MethodDelete, "/api/v1/users/x/data", nil)
+			req.SetPathValue("user_id", tt.userID)
+			rec := httptest.NewRecorder()
+			purgeUserDataHandler(&memTaskRepo{}, &fakeConversations{}, &memDocuments{}, kb)(rec, req)
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+		})
+	}
+}
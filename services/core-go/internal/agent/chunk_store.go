@@ -0,0 +1,180 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+
+	"core-go/internal/db"
+	"core-go/internal/vector"
+)
+
+// ChunkStore decides where the text of ingested chunks lives. The default
+// keeps it inline in each Qdrant payload under "text"; PostgresChunkStore
+// moves it to Postgres so the vector store holds only vectors and small
+// metadata. Either way the rest of the pipeline reads payload["text"]:
+// StoreText runs on every batch before it is upserted and LoadText on
+// every retrieval result before it is ranked.
+type ChunkStore interface {
+	// StoreText persists the text of each payload about to be upserted and
+	// may remove "text" from the payload once it is stored elsewhere.
+	StoreText(ctx context.Context, payloads []map[string]any) error
+
+	// LoadText restores "text" on payloads StoreText moved it out of.
+	// Payloads that still carry their text are left alone.
+	LoadText(ctx context.Context, payloads []map[string]any) error
+}
+
+// InlineChunkStore keeps chunk text in the Qdrant payload. It is the
+// default, and its methods do nothing.
+type InlineChunkStore struct{}
+
+func (InlineChunkStore) StoreText(context.Context, []map[string]any) error { return nil }
+func (InlineChunkStore) LoadText(context.Context, []map[string]any) error  { return nil }
+
+// PostgresChunkStore keeps chunk text in the document_chunks table, keyed
+// by the payload's document_id and chunk_index. Chunks ingested without a
+// document_id (e.g. by the admin CLI) have no row to hang off and keep
+// their text inline.
+type PostgresChunkStore struct {
+	repo db.ChunkRepository
+}
+
+// NewPostgresChunkStore returns a PostgresChunkStore backed by repo.
+func NewPostgresChunkStore(repo db.ChunkRepository) *PostgresChunkStore {
+	return &PostgresChunkStore{repo: repo}
+}
+
+// StoreText saves the text of every payload with a chunk key and strips it
+// from those payloads.
+func (s *PostgresChunkStore) StoreText(ctx context.Context, payloads []map[string]any) error {
+	var (
+		chunks   []db.ChunkText
+		stripped []map[string]any
+	)
+	for _, p := range payloads {
+		key, ok := chunkKey(p)
+		text, hasText := p["text"].(string)
+		if !ok || !hasText {
+			continue
+		}
+		chunks = append(chunks, db.ChunkText{ChunkKey: key, Text: text})
+		stripped = append(stripped, p)
+	}
+	if err := s.repo.SaveChunks(ctx, chunks); err != nil {
+		return fmt.Errorf("chunk store: %w", err)
+	}
+	// Only once the text is safely stored.
+	for _, p := range stripped {
+		delete(p, "text")
+	}
+	return nil
+}
+
+// LoadText fetches the text of every payload that has a chunk key but no
+// text, in one query. A chunk whose row is missing is left without text and
+// so ranks as empty rather than failing the whole retrieval.
+func (s *PostgresChunkStore) LoadText(ctx context.Context, payloads []map[string]any) error {
+	var keys []db.ChunkKey
+	for _, p := range payloads {
+		if _, hasText := p["text"]; hasText {
+			continue
+		}
+		if key, ok := chunkKey(p); ok {
+			keys = append(keys, key)
+		}
+	}
+	if len(keys) == 0 {
+		return nil
+	}
+	texts, err := s.repo.GetChunks(ctx, keys)
+	if err != nil {
+		return fmt.Errorf("chunk store: %w", err)
+	}
+	for _, p := range payloads {
+		if _, hasText := p["text"]; hasText {
+			continue
+		}
+		if key, ok := chunkKey(p); ok {
+			if text, found := texts[key]; found {
+				p["text"] = text
+			}
+		}
+	}
+	return nil
+}
+
+// chunkKey reads document_id and chunk_index from a payload, as written by
+// IngestText (ints) or decoded from Qdrant JSON (float64s).
+func chunkKey(payload map[string]any) (db.ChunkKey, bool) {
+	docID, ok := payloadInt(payload["document_id"])
+	if !ok || docID == 0 {
+		return db.ChunkKey{}, false
+	}
+	index, ok := payloadInt(payload["chunk_index"])
+	if !ok {
+		return db.ChunkKey{}, false
+	}
+	return db.ChunkKey{DocumentID: db.DocumentID(docID), ChunkIndex: int(index)}, true
+}
+
+// payloadInt converts a numeric payload value to int64.
+func payloadInt(v any) (int64, bool) {
+	switch n := v.(type) {
+	case int:
+		return int64(n), true
+	case int64:
+		return n, true
+	case int32:
+		return int64(n), true
+	case float64:
+		return int64(n), true
+	}
+	return 0, false
+}
+
+// SetChunkStore replaces where chunk text is kept; nil restores the inline
+// default. Switching stores does not move existing chunks: those ingested
+// before keep their text wherever it was written.
+func (kb *KnowledgeBase) SetChunkStore(store ChunkStore) {
+	if store == nil {
+		store = InlineChunkStore{}
+	}
+	kb.chunks = store
+}
+
+// storeChunkText runs the chunk store over points about to be upserted.
+func (kb *KnowledgeBase) storeChunkText(ctx context.Context, points []vector.PointInput) error {
+	payloads := make([]map[string]any, len(points))
+	for i, p := range points {
+		payloads[i] = p.Payload
+	}
+	return kb.chunks.StoreText(ctx, payloads)
+}
+
+// LoadAdminText fills in the Text of admin listing points whose chunk text
+// is kept outside Qdrant.
+func (kb *KnowledgeBase) LoadAdminText(ctx context.Context, points []vector.AdminPoint) error {
+	payloads := make([]map[string]any, len(points))
+	for i, p := range points {
+		payloads[i] = map[string]any{"document_id": p.DocumentID, "chunk_index": p.ChunkIndex}
+		if p.Text != "" {
+			payloads[i]["text"] = p.Text
+		}
+	}
+	if err := kb.chunks.LoadText(ctx, payloads); err != nil {
+		return fmt.Errorf("rag: load admin text: %w", err)
+	}
+	for i := range points {
+		points[i].Text, _ = payloads[i]["text"].(string)
+	}
+	return nil
+}
+
+// loadChunkText restores the text of retrieved points kept outside Qdrant.
+func (kb *KnowledgeBase) loadChunkText(ctx context.Context, points []vector.ScoredPoint) error {
+	payloads := make([]map[string]any, len(points))
+	for i, p := range points {
+		payloads[i] = p.Payload
+	}
+	return kb.chunks.LoadText(ctx, payloads)
+}
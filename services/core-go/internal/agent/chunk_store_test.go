@@ -0,0 +1,120 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+
+	"core-go/internal/db"
+	"core-go/internal/llm"
+)
+
+// memChunks is an in-memory db.ChunkRepository.
+type memChunks struct {
+	mu   sync.Mutex
+	rows map[db.ChunkKey]string
+}
+
+func (m *memChunks) SaveChunks(_ context.Context, chunks []db.ChunkText) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.rows == nil {
+		m.rows = map[db.ChunkKey]string{}
+	}
+	for _, c := range chunks {
+		m.rows[c.ChunkKey] = c.Text
+	}
+	return nil
+}
+
+func (m *memChunks) GetChunks(_ context.Context, keys []db.ChunkKey) (map[db.ChunkKey]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	out := map[db.ChunkKey]string{}
+	for _, k := range keys {
+		if text, ok := m.rows[k]; ok {
+			out[k] = text
+		}
+	}
+	return out, nil
+}
+
+func TestChunkStoreRetrieval(t *testing.T) {
+	const text = "The Colosseum is an ancient amphitheatre in Rome."
+	tests := []struct {
+		name         string
+		postgres     bool
+		documentID   int64
+		wantInline   bool // Qdrant payload still carries the text
+		wantPostgres int  // rows in the chunk table
+	}{
+		{"inline store", false, 7, true, 0},
+		{"postgres store", true, 7, false, 1},
+		{"postgres store without a document row keeps text inline", true, 0, true, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			kb, srv := newTestKB(t)
+			chat := &recordingChat{ChatProvider: llm.FakeChatProvider{}}
+			kb.chat = chat
+			chunks := &memChunks{}
+			if tt.postgres {
+				kb.SetChunkStore(NewPostgresChunkStore(chunks))
+			}
+			ctx := context.Background()
+			if _, err := kb.IngestText(ctx, text, "rome.md", "u1", IngestOptions{DocumentID: tt.documentID}); err != nil {
+				t.Fatal(err)
+			}
+
+			stored := storedChunks(srv, CollectionName())
+			if len(stored) != 1 {
+				t.Fatalf("stored %d points, want 1", len(stored))
+			}
+			if _, inline := stored[0].Payload["text"]; inline != tt.wantInline {
+				t.Errorf("payload has text = %v, want %v", inline, tt.wantInline)
+			}
+			if len(chunks.rows) != tt.wantPostgres {
+				t.Errorf("chunk table holds %d rows, want %d", len(chunks.rows), tt.wantPostgres)
+			}
+
+			// Retrieval restores the text before ranking, so the chunk is
+			// found and reaches the prompt either way.
+			answer, err := kb.AskKnowledgeBase(ctx, "Where is the Colosseum amphitheatre?", "u1", AskOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			for range answer.Stream {
+			}
+			if len(chat.systems) != 1 || !strings.Contains(chat.systems[0], text) {
+				t.Errorf("system prompts = %q, want one containing the chunk text", chat.systems)
+			}
+		})
+	}
+}
+
+func TestPostgresChunkStoreLoadText(t *testing.T) {
+	chunks := &memChunks{rows: map[db.ChunkKey]string{{DocumentID: 7, ChunkIndex: 0}: "stored"}}
+	store := NewPostgresChunkStore(chunks)
+	tests := []struct {
+		name    string
+		payload map[string]any
+		want    any // payload["text"] afterwards; nil when absent
+	}{
+		{"loaded by key", map[string]any{"document_id": 7.0, "chunk_index": 0.0}, "stored"},
+		{"inline text left alone", map[string]any{"document_id": 7.0, "chunk_index": 0.0, "text": "inline"}, "inline"},
+		{"missing row stays empty", map[string]any{"document_id": 7.0, "chunk_index": 3.0}, nil},
+		{"no document id", map[string]any{"chunk_index": 0.0}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := store.LoadText(context.Background(), []map[string]any{tt.payload}); err != nil {
+				t.Fatal(err)
+			}
+			if got := tt.payload["text"]; fmt.Sprint(got) != fmt.Sprint(tt.want) {
+				t.Errorf("text = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
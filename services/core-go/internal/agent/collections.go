@@ -0,0 +1,199 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"core-go/internal/vector"
+)
+
+// Collection modes, selected by RAG_COLLECTION_MODE.
+const (
+	// CollectionModeFilter keeps every user's chunks in the one shared
+	// collection and scopes retrieval with a user_id payload filter. It is
+	// the default.
+	CollectionModeFilter = "filter"
+	// CollectionModePerUser gives each user a collection of their own,
+	// "Personal Context - <userID>", created on their first ingest, for
+	// deployments that want physical isolation between tenants. Shared
+	// documents stay in the base collection.
+	CollectionModePerUser = "collection"
+)
+
+// userCollectionPrefix starts the name of every per-user collection.
+const userCollectionPrefix = ragCollection + " - "
+
+// CollectionMode returns the collection mode from RAG_COLLECTION_MODE
+// ("filter" or "collection"; default "filter").
+func CollectionMode() (string, error) {
+	switch m := strings.ToLower(strings.TrimSpace(os.Getenv("RAG_COLLECTION_MODE"))); m {
+	case "", CollectionModeFilter:
+		return CollectionModeFilter, nil
+	case CollectionModePerUser:
+		return CollectionModePerUser, nil
+	default:
+		return "", fmt.Errorf("rag: invalid RAG_COLLECTION_MODE %q (want filter or collection)", m)
+	}
+}
+
+// UserCollectionName returns the collection that holds userID's documents
+// in CollectionModePerUser. The shared user's collection is the base one.
+func UserCollectionName(userID string) string {
+	if userID == "" || userID == vector.SharedUserID {
+		return ragCollection
+	}
+	return userCollectionPrefix + userID
+}
+
+// SetCollectionMode switches between CollectionModeFilter and
+// CollectionModePerUser. It does not move existing chunks; both modes still
+// search and delete in the base collection, so chunks ingested before a
+// switch to per-user collections stay reachable.
+func (kb *KnowledgeBase) SetCollectionMode(mode string) {
+	kb.perUser = mode == CollectionModePerUser
+}
+
+// writeCollection is the collection userID's new chunks are stored in.
+func (kb *KnowledgeBase) writeCollection(userID string) string {
+	if !kb.perUser {
+		return ragCollection
+	}
+	return UserCollectionName(userID)
+}
+
+// userCollections lists every collection that may hold userID's chunks or
+// the shared ones visible to them: the base collection and, in per-user
+// mode, userID's own.
+func (kb *KnowledgeBase) userCollections(userID string) []string {
+	if own := kb.writeCollection(userID); own != ragCollection {
+		return []string{ragCollection, own}
+	}
+	return []string{ragCollection}
+}
+
+// allCollections lists every collection the knowledge base uses: the base
+// collection and, in per-user mode, every existing per-user collection.
+func (kb *KnowledgeBase) allCollections(ctx context.Context) ([]string, error) {
+	if !kb.perUser {
+		return []string{ragCollection}, nil
+	}
+	names, err := kb.qdrant.ListCollections(ctx)
+	if err != nil {
+		return nil, err
+	}
+	out := []string{ragCollection}
+	for _, name := range names {
+		if strings.HasPrefix(name, userCollectionPrefix) {
+			out = append(out, name)
+		}
+	}
+	return out, nil
+}
+
+// ensureCollection creates collection on first use, with the base
+// collection's dimension and distance. Known collections are cached so the
+// check costs one Qdrant call per collection per process.
+func (kb *KnowledgeBase) ensureCollection(ctx context.Context, collection string) error {
+	if kb.knownCollection(collection) {
+		return nil
+	}
+	dim, err := CollectionDim()
+	if err != nil {
+		return err
+	}
+	distance, err := CollectionDistance()
+	if err != nil {
+		return err
+	}
+	if err := kb.qdrant.EnsureCollection(ctx, collection, dim, distance); err != nil {
+		return err
+	}
+	kb.rememberCollection(collection, true)
+	return nil
+}
+
+// collectionExists reports whether collection exists, so per-user
+// collections not created yet read as empty rather than as errors.
+func (kb *KnowledgeBase) collectionExists(ctx context.Context, collection string) (bool, error) {
+	if kb.knownCollection(collection) {
+		return true, nil
+	}
+	_, err := kb.qdrant.CollectionInfo(ctx, collection)
+	if errors.Is(err, vector.ErrCollectionNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	kb.rememberCollection(collection, true)
+	return true, nil
+}
+
+// existingCollections filters userCollections(userID) down to those that
+// exist.
+func (kb *KnowledgeBase) existingCollections(ctx context.Context, userID string) ([]string, error) {
+	var out []string
+	for _, c := range kb.userCollections(userID) {
+		ok, err := kb.collectionExists(ctx, c)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			out = append(out, c)
+		}
+	}
+	return out, nil
+}
+
+func (kb *KnowledgeBase) knownCollection(collection string) bool {
+	kb.collectionsMu.Lock()
+	defer kb.collectionsMu.Unlock()
+	return kb.collections[collection]
+}
+
+func (kb *KnowledgeBase) rememberCollection(collection string, exists bool) {
+	kb.collectionsMu.Lock()
+	defer kb.collectionsMu.Unlock()
+	if exists {
+		kb.collections[collection] = true
+	} else {
+		delete(kb.collections, collection)
+	}
+}
+
+// search runs a vector search over every collection visible to userID and
+// merges the hits by score. In filter mode it is a single search; opts
+// still carries the user_id filter either way.
+func (kb *KnowledgeBase) search(ctx context.Context, userID string, vec []float64, limit int, opts vector.SearchOptions) ([]vector.ScoredPoint, error) {
+	collections, err := kb.existingCollections(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	return kb.searchCollections(ctx, collections, vec, limit, opts)
+}
+
+// searchCollections searches each of collections and returns the best
+// limit hits across them.
+func (kb *KnowledgeBase) searchCollections(ctx context.Context, collections []string, vec []float64, limit int, opts vector.SearchOptions) ([]vector.ScoredPoint, error) {
+	if len(collections) == 1 {
+		return kb.qdrant.SearchWithOptions(ctx, collections[0], vec, limit, opts)
+	}
+
+	var merged []vector.ScoredPoint
+	for _, c := range collections {
+		points, err := kb.qdrant.SearchWithOptions(ctx, c, vec, limit, opts)
+		if err != nil {
+			return nil, fmt.Errorf("collection %q: %w", c, err)
+		}
+		merged = append(merged, points...)
+	}
+	sort.SliceStable(merged, func(i, j int) bool { return merged[i].Score > merged[j].Score })
+	if len(merged) > limit {
+		merged = merged[:limit]
+	}
+	return merged, nil
+}
@@ -0,0 +1,78 @@
+package agent
+
+import (
+	"sort"
+	"unicode"
+)
+
+// Span is a highlighted range of a text in rune offsets, End exclusive, so
+// []rune(text)[Start:End] is the matched text — the same convention as
+// Chunk offsets.
+type Span struct {
+	Start int `json:"start"`
+	End   int `json:"end"`
+}
+
+// Highlight returns the ranges of text that match a meaningful query term
+// (the tokens the lexical score counts: lower-cased, stop words and words
+// under three letters dropped), compared case-insensitively. Like the
+// lexical score it matches substrings, so "index" also marks part of
+// "reindexing". Overlapping or touching matches are merged, and the spans
+// come back sorted by Start. It says nothing about the vector match.
+func Highlight(text, query string) []Span {
+	terms := tokenizeMeaningful(query)
+	if len(terms) == 0 {
+		return nil
+	}
+	runes := []rune(text)
+
+	var spans []Span
+	seen := make(map[string]bool, len(terms))
+	for _, term := range terms {
+		if seen[term] {
+			continue
+		}
+		seen[term] = true
+		t := []rune(term)
+		for i := 0; i+len(t) <= len(runes); i++ {
+			if foldEqual(runes[i:i+len(t)], t) {
+				spans = append(spans, Span{Start: i, End: i + len(t)})
+			}
+		}
+	}
+	return mergeSpans(spans)
+}
+
+// foldEqual reports whether a and b are equal under simple Unicode case
+// folding, rune for rune.
+func foldEqual(a, b []rune) bool {
+	for i := range a {
+		if a[i] == b[i] {
+			continue
+		}
+		if unicode.ToLower(a[i]) != unicode.ToLower(b[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// mergeSpans sorts spans and joins any that overlap or touch.
+func mergeSpans(spans []Span) []Span {
+	if len(spans) == 0 {
+		return nil
+	}
+	sort.Slice(spans, func(i, j int) bool { return spans[i].Start < spans[j].Start })
+	merged := spans[:1]
+	for _, s := range spans[1:] {
+		last := &merged[len(merged)-1]
+		if s.Start <= last.End {
+			if s.End > last.End {
+				last.End = s.End
+			}
+			continue
+		}
+		merged = append(merged, s)
+	}
+	return merged
+}
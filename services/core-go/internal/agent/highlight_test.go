@@ -0,0 +1,35 @@
+package agent
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestHighlight(t *testing.T) {
+	tests := []struct {
+		name  string
+		text  string
+		query string
+		want  []Span
+	}{
+		{"case-insensitive", "The COLOSSEUM is in Rome", "colosseum", []Span{{4, 13}}},
+		{"every occurrence", "Rome, rome and ROME", "Rome", []Span{{0, 4}, {6, 10}, {15, 19}}},
+		{"overlapping terms merge", "reindexing", "index indexing", []Span{{2, 10}}},
+		{"touching terms merge", "abcdef", "abc def", []Span{{0, 6}}},
+		{"overlapping occurrences of one term", "aaaa", "aaa", []Span{{0, 4}}},
+		{"repeated query term", "Rome", "rome rome", []Span{{0, 4}}},
+		{"stop words ignored", "An amphitheatre in the city", "where is the amphitheatre", []Span{{3, 15}}},
+		{"short words ignored", "go to Rome", "go", nil},
+		{"rune offsets", "Café Roma CAFÉ", "café", []Span{{0, 4}, {10, 14}}},
+		{"no match", "The Colosseum", "Parthenon", nil},
+		{"blank query", "The Colosseum", "  ", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Highlight(tt.text, tt.query)
+			if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+				t.Errorf("Highlight(%q, %q) = %v, want %v", tt.text, tt.query, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,168 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"golang.org/x/sync/singleflight"
+
+	"core-go/internal/llm"
+)
+
+// Intent is what a chat query asks for, as decided by IntentClassifier.
+type Intent string
+
+const (
+	// IntentKnowledge is a question to answer from the knowledge base.
+	IntentKnowledge Intent = "knowledge"
+	// IntentTask is a command to create, list or update tasks.
+	IntentTask Intent = "task"
+)
+
+// intentExamples are the reference queries whose embeddings are averaged
+// into each intent's centroid. They cover the phrasings the substring
+// heuristics handle poorly: task commands without "task" in them, and
+// questions that mention reminders or schedules.
+var intentExamples = map[Intent][]string{
+	IntentTask: {
+		"remind me to call mom tomorrow",
+		"add a task to buy groceries",
+		"create a todo to renew my passport",
+		"schedule a dentist appointment for next friday",
+		"I need to pay the electricity bill by monday",
+		"set a reminder to water the plants every week",
+		"show my pending tasks",
+		"what do I have to do today",
+		"list my todos",
+		"mark the report as done",
+	},
+	IntentKnowledge: {
+		"what is the capital of france",
+		"explain how photosynthesis works",
+		"who wrote the employee handbook",
+		"summarize the onboarding document",
+		"what does the policy say about remote work",
+		"how do I configure the vpn",
+		"tell me about the history of rome",
+		"why is the sky blue",
+		"what are the benefits of regular exercise",
+		"according to my notes, when did the project start",
+	},
+}
+
+// centroidBuildTimeout bounds embedding the intent examples. The build is
+// detached from the request that triggered it, so this is what stops a
+// wedged embedder from holding it open.
+const centroidBuildTimeout = time.Minute
+
+// IntentClassifier routes chat queries by embedding them and picking the
+// intent whose example centroid is most cosine-similar. It costs one
+// embedding call per query; the centroids are embedded on first use and
+// cached.
+type IntentClassifier struct {
+	embedder llm.Embedder
+
+	builds    singleflight.Group
+	mu        sync.Mutex
+	centroids map[Intent][]float64 // nil until first successful build
+}
+
+// NewIntentClassifier returns an IntentClassifier that embeds with embedder.
+// It must be the embedder the examples are meaningful for — normally the
+// same one the knowledge base uses.
+func NewIntentClassifier(embedder llm.Embedder) *IntentClassifier {
+	return &IntentClassifier{embedder: embedder}
+}
+
+// ClassifyIntent returns whether query is a knowledge question or a task
+// command. An error means the embedder failed; callers should fall back to
+// their heuristics rather than fail the request.
+func (c *IntentClassifier) ClassifyIntent(ctx context.Context, query string) (Intent, error) {
+	centroids, err := c.loadCentroids(ctx)
+	if err != nil {
+		return "", err
+	}
+	vec, err := c.embedder.Embed(ctx, query)
+	if err != nil {
+		return "", fmt.Errorf("intent: embed query: %w", err)
+	}
+
+	// Ties go to knowledge, the pipeline that is harmless when wrong.
+	best, bestScore := IntentKnowledge, -2.0
+	for _, intent := range []Intent{IntentKnowledge, IntentTask} {
+		score, err := llm.CosineSimilarity(vec, centroids[intent])
+		if err != nil {
+			return "", fmt.Errorf("intent: %w", err)
+		}
+		if score > bestScore {
+			best, bestScore = intent, score
+		}
+	}
+	return best, nil
+}
+
+// loadCentroids returns the cached centroids, embedding the examples on the
+// first call. Concurrent callers share one build, which runs detached from
+// ctx under centroidBuildTimeout: a caller that gives up stops waiting
+// without failing the build for the others. A failed build is not cached,
+// so the next call retries.
+func (c *IntentClassifier) loadCentroids(ctx context.Context) (map[Intent][]float64, error) {
+	c.mu.Lock()
+	centroids := c.centroids
+	c.mu.Unlock()
+	if centroids != nil {
+		return centroids, nil
+	}
+
+	ch := c.builds.DoChan("centroids", func() (any, error) {
+		buildCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), centroidBuildTimeout)
+		defer cancel()
+		centroids, err := buildCentroids(buildCtx, c.embedder)
+		if err != nil {
+			return nil, err
+		}
+		c.mu.Lock()
+		c.centroids = centroids
+		c.mu.Unlock()
+		return centroids, nil
+	})
+	select {
+	case res := <-ch:
+		if res.Err != nil {
+			return nil, res.Err
+		}
+		return res.Val.(map[Intent][]float64), nil
+	case <-ctx.Done():
+		return nil, fmt.Errorf("intent: embed examples: %w", ctx.Err())
+	}
+}
+
+// buildCentroids embeds every intent's examples and sums them into its
+// centroid.
+func buildCentroids(ctx context.Context, embedder llm.Embedder) (map[Intent][]float64, error) {
+	centroids := make(map[Intent][]float64, len(intentExamples))
+	for intent, examples := range intentExamples {
+		var sum []float64
+		for _, example := range examples {
+			vec, err := embedder.Embed(ctx, example)
+			if err != nil {
+				return nil, fmt.Errorf("intent: embed examples: %w", err)
+			}
+			// Normalise first so every example weighs the same in the mean.
+			vec = llm.Normalize(vec)
+			if sum == nil {
+				sum = make([]float64, len(vec))
+			}
+			if len(vec) != len(sum) {
+				return nil, fmt.Errorf("intent: embed examples: inconsistent dimensions %d and %d", len(sum), len(vec))
+			}
+			for i, v := range vec {
+				sum[i] += v
+			}
+		}
+		centroids[intent] = sum // cosine similarity ignores the 1/n scale
+	}
+	return centroids, nil
+}
@@ -0,0 +1,117 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"core-go/internal/llm"
+)
+
+// gatedEmbedder counts Embed calls and blocks each one until release is
+// closed, failing with err instead when set.
+type gatedEmbedder struct {
+	llm.Embedder
+	calls   atomic.Int32
+	release chan struct{}
+	err     error
+}
+
+func (e *gatedEmbedder) Embed(ctx context.Context, text string) ([]float64, error) {
+	e.calls.Add(1)
+	<-e.release
+	if e.err != nil {
+		return nil, e.err
+	}
+	return e.Embedder.Embed(ctx, text)
+}
+
+func exampleCount() int32 {
+	n := 0
+	for _, examples := range intentExamples {
+		n += len(examples)
+	}
+	return int32(n)
+}
+
+func TestIntentClassifierSharesOneCentroidBuild(t *testing.T) {
+	tests := []struct {
+		name    string
+		callers int
+	}{
+		{"single caller", 1},
+		{"concurrent callers", 8},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			emb := &gatedEmbedder{Embedder: llm.NewFakeEmbedder(), release: make(chan struct{})}
+			c := NewIntentClassifier(emb)
+
+			var wg sync.WaitGroup
+			for i := 0; i < tt.callers; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					if _, err := c.loadCentroids(context.Background()); err != nil {
+						t.Error(err)
+					}
+				}()
+			}
+			time.Sleep(20 * time.Millisecond) // let every caller join the build
+			close(emb.release)
+			wg.Wait()
+
+			if got, want := emb.calls.Load(), exampleCount(); got != want {
+				t.Errorf("embedded %d examples, want %d (one build)", got, want)
+			}
+		})
+	}
+}
+
+func TestIntentClassifierBuildOutlivesCancelledCaller(t *testing.T) {
+	emb := &gatedEmbedder{Embedder: llm.NewFakeEmbedder(), release: make(chan struct{})}
+	c := NewIntentClassifier(emb)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	first := make(chan error, 1)
+	go func() {
+		_, err := c.loadCentroids(ctx)
+		first <- err
+	}()
+	time.Sleep(10 * time.Millisecond)
+	cancel()
+	if err := <-first; !errors.Is(err, context.Canceled) {
+		t.Fatalf("cancelled caller err = %v, want context.Canceled", err)
+	}
+
+	// The build carries on for the next caller instead of failing.
+	close(emb.release)
+	if _, err := c.loadCentroids(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := emb.calls.Load(), exampleCount(); got != want {
+		t.Errorf("embedded %d examples, want %d (one build)", got, want)
+	}
+}
+
+func TestIntentClassifierRetriesFailedBuild(t *testing.T) {
+	release := make(chan struct{})
+	close(release)
+	emb := &gatedEmbedder{Embedder: llm.NewFakeEmbedder(), release: release, err: errors.New("ollama down")}
+	c := NewIntentClassifier(emb)
+
+	if _, err := c.ClassifyIntent(context.Background(), "list my todos"); err == nil {
+		t.Fatal("ClassifyIntent() with a failing embedder succeeded")
+	}
+	emb.err = nil
+	intent, err := c.ClassifyIntent(context.Background(), "list my todos")
+	if err != nil {
+		t.Fatalf("ClassifyIntent() after recovery err = %v", err)
+	}
+	if intent != IntentTask {
+		t.Errorf("ClassifyIntent() = %s, want %s", intent, IntentTask)
+	}
+}
@@ -0,0 +1,87 @@
+package agent
+
+import (
+	"math"
+	"unicode/utf8"
+
+	"core-go/internal/vector"
+)
+
+// ScoreNormalizer adjusts a retrieved chunk's similarity score given the
+// chunk's length in runes and refLen, the length a full chunk of its
+// document has. AskKnowledgeBase applies it to every search hit before
+// ranking and thresholding, so it can correct for short chunks (a heading,
+// a one-line fragment) that embed close to many queries and score higher
+// than their content deserves.
+type ScoreNormalizer func(score float64, chunkLen, refLen int) float64
+
+// NoNormalization is the default ScoreNormalizer: scores are used as
+// Qdrant returns them.
+func NoNormalization(score float64, _, _ int) float64 { return score }
+
+// LengthPenalty returns a ScoreNormalizer that scales down the scores of
+// chunks shorter than refLen runes by (chunkLen/refLen)^alpha. Chunks of
+// refLen or more are unchanged, so only the short-chunk bias is corrected.
+// alpha sets the strength: at 0.5 a quarter-length chunk keeps half its
+// score, at 1 the penalty is proportional. alpha <= 0 disables it, as does
+// refLen <= 0 for a single chunk.
+func LengthPenalty(alpha float64) ScoreNormalizer {
+	if alpha <= 0 {
+		return NoNormalization
+	}
+	return func(score float64, chunkLen, refLen int) float64 {
+		if refLen <= 0 || chunkLen >= refLen {
+			return score
+		}
+		ratio := float64(max(chunkLen, 1)) / float64(refLen)
+		return score * math.Pow(ratio, alpha)
+	}
+}
+
+// SetScoreNormalizer replaces the function applied to search scores before
+// ranking. nil restores NoNormalization. Call before the KnowledgeBase is
+// shared.
+func (kb *KnowledgeBase) SetScoreNormalizer(fn ScoreNormalizer) {
+	if fn == nil {
+		fn = NoNormalization
+	}
+	kb.normalize = fn
+}
+
+// normalizeScores returns points with kb.normalize applied to each score.
+// The input slice is not modified.
+func (kb *KnowledgeBase) normalizeScores(points []vector.ScoredPoint) []vector.ScoredPoint {
+	out := make([]vector.ScoredPoint, len(points))
+	for i, p := range points {
+		n := chunkLength(p.Payload)
+		p.Score = kb.normalize(p.Score, n, referenceLength(p.Payload, n))
+		out[i] = p
+	}
+	return out
+}
+
+// chunkLength is a stored chunk's length in runes: end_offset - start_offset
+// when the payload records them, otherwise the length of its text.
+func chunkLength(payload map[string]any) int {
+	start, okStart := payload["start_offset"].(float64)
+	end, okEnd := payload["end_offset"].(float64)
+	if okStart && okEnd && end > start {
+		return int(end - start)
+	}
+	text, _ := payload["text"].(string)
+	return utf8.RuneCountInString(text)
+}
+
+// referenceLength is the length a full chunk of a stored chunk's document
+// has: the "chunk_size" it was ingested with, or the default chunk size for
+// chunks stored before the field existed. The last chunk of a document is
+// its own reference, since it is short only because the text ran out.
+func referenceLength(payload map[string]any, chunkLen int) int {
+	if last, _ := payload["last_chunk"].(bool); last {
+		return chunkLen
+	}
+	if size, ok := payload["chunk_size"].(float64); ok && size > 0 {
+		return int(size)
+	}
+	return chunkSize
+}
@@ -0,0 +1,65 @@
+package agent
+
+import (
+	"context"
+	"math"
+	"testing"
+
+	"core-go/internal/vector"
+)
+
+func TestNormalizeScoresLengthPenaltyReference(t *testing.T) {
+	tests := []struct {
+		name    string
+		payload map[string]any
+		want    float64
+	}{
+		{"full chunk of custom size", map[string]any{"start_offset": 0.0, "end_offset": 100.0, "chunk_size": 100.0}, 0.8},
+		{"short chunk of custom size", map[string]any{"start_offset": 0.0, "end_offset": 25.0, "chunk_size": 100.0}, 0.4},
+		{"legacy chunk uses default size", map[string]any{"start_offset": 0.0, "end_offset": 100.0}, 0.4},
+		{"short last chunk", map[string]any{"start_offset": 400.0, "end_offset": 410.0, "chunk_size": 400.0, "last_chunk": true}, 0.8},
+		{"text without offsets", map[string]any{"text": "abcd", "chunk_size": 16.0}, 0.4},
+	}
+	kb := &KnowledgeBase{}
+	kb.SetScoreNormalizer(LengthPenalty(0.5))
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := kb.normalizeScores([]vector.ScoredPoint{{Score: 0.8, Payload: tt.payload}})[0].Score
+			if math.Abs(got-tt.want) > 1e-9 {
+				t.Errorf("normalized score = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIngestTextRecordsChunkSize(t *testing.T) {
+	tests := []struct {
+		name     string
+		text     string
+		wantLast []bool
+	}{
+		{"single chunk is not a last chunk", "alpha beta gamma", []bool{false}},
+		{"final chunk marked", "alpha beta gamma delzeta theta iota kappomega", []bool{false, false, true}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			kb, srv := newTestKB(t)
+			opts := IngestOptions{ChunkSize: 20, ChunkOverlap: intPtr(0)}
+			if _, err := kb.IngestText(context.Background(), tt.text, "a.txt", "u1", opts); err != nil {
+				t.Fatal(err)
+			}
+			points := storedChunks(srv, ragCollection)
+			if len(points) != len(tt.wantLast) {
+				t.Fatalf("stored %d chunks, want %d", len(points), len(tt.wantLast))
+			}
+			for i, p := range points {
+				if size, _ := payloadInt(p.Payload["chunk_size"]); size != 20 {
+					t.Errorf("chunk %d chunk_size = %v, want 20", i, p.Payload["chunk_size"])
+				}
+				if last, _ := p.Payload["last_chunk"].(bool); last != tt.wantLast[i] {
+					t.Errorf("chunk %d last_chunk = %v, want %v", i, last, tt.wantLast[i])
+				}
+			}
+		})
+	}
+}
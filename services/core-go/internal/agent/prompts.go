@@ -0,0 +1,80 @@
+package agent
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// ErrMissingContextPlaceholder is returned for a RAG prompt template without
+// exactly one %s for the retrieved context.
+var ErrMissingContextPlaceholder = errors.New("rag prompt template must contain exactly one %s for the context")
+
+// Prompts are the system prompts used by the two pipelines. RAG is a
+// fmt template whose single %s receives the retrieved context.
+type Prompts struct {
+	Agent string
+	RAG   string
+}
+
+// DefaultPrompts returns the built-in prompts.
+func DefaultPrompts() Prompts {
+	return Prompts{Agent: agentSystemPrompt, RAG: systemPromptTmpl}
+}
+
+// LoadPrompts returns the built-in prompts with any overrides read from the
+// files named by AGENT_SYSTEM_PROMPT_PATH and RAG_SYSTEM_PROMPT_PATH. An
+// unset variable keeps the default; an unreadable or empty file, or a RAG
+// template without its context placeholder, is an error so a bad deploy
+// fails at startup rather than on the first question.
+func LoadPrompts() (Prompts, error) {
+	p := DefaultPrompts()
+
+	if path := strings.TrimSpace(os.Getenv("AGENT_SYSTEM_PROMPT_PATH")); path != "" {
+		text, err := readPromptFile(path)
+		if err != nil {
+			return p, fmt.Errorf("agent: AGENT_SYSTEM_PROMPT_PATH: %w", err)
+		}
+		p.Agent = text
+	}
+
+	if path := strings.TrimSpace(os.Getenv("RAG_SYSTEM_PROMPT_PATH")); path != "" {
+		text, err := readPromptFile(path)
+		if err != nil {
+			return p, fmt.Errorf("rag: RAG_SYSTEM_PROMPT_PATH: %w", err)
+		}
+		if err := ValidateRAGTemplate(text); err != nil {
+			return p, fmt.Errorf("rag: RAG_SYSTEM_PROMPT_PATH %s: %w", path, err)
+		}
+		p.RAG = text
+	}
+
+	return p, nil
+}
+
+// ValidateRAGTemplate checks that tmpl has exactly one %s and no other
+// formatting verbs, so fmt.Sprintf(tmpl, context) yields a clean prompt.
+// A literal percent sign must be written as %%.
+func ValidateRAGTemplate(tmpl string) error {
+	if strings.Count(strings.ReplaceAll(tmpl, "%%", ""), "%s") != 1 {
+		return ErrMissingContextPlaceholder
+	}
+	if out := fmt.Sprintf(tmpl, ""); strings.Contains(out, "%!") {
+		return fmt.Errorf("rag prompt template has a stray formatting verb (write a literal %% as %%%%)")
+	}
+	return nil
+}
+
+// readPromptFile returns the trimmed contents of path, rejecting empty files.
+func readPromptFile(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	text := strings.TrimSpace(string(data))
+	if text == "" {
+		return "", fmt.Errorf("%s is empty", path)
+	}
+	return text, nil
+}
@@ -0,0 +1,77 @@
+package agent
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadPrompts(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, text string) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(text), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	agentFile := write("agent.txt", "  Be terse.\n")
+	ragFile := write("rag.txt", "Use only this:\n%s\n")
+	noPlaceholder := write("rag-bad.txt", "Answer from memory.")
+	empty := write("empty.txt", "\n")
+
+	tests := []struct {
+		name      string
+		agentPath string
+		ragPath   string
+		want      Prompts
+		wantErr   bool
+		wantIs    error
+	}{
+		{"unset keeps defaults", "", "", DefaultPrompts(), false, nil},
+		{"both loaded", agentFile, ragFile, Prompts{Agent: "Be terse.", RAG: "Use only this:\n%s"}, false, nil},
+		{"agent only", agentFile, "", Prompts{Agent: "Be terse.", RAG: systemPromptTmpl}, false, nil},
+		{"missing placeholder", "", noPlaceholder, Prompts{}, true, ErrMissingContextPlaceholder},
+		{"missing file", filepath.Join(dir, "nope.txt"), "", Prompts{}, true, os.ErrNotExist},
+		{"empty file", "", empty, Prompts{}, true, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("AGENT_SYSTEM_PROMPT_PATH", tt.agentPath)
+			t.Setenv("RAG_SYSTEM_PROMPT_PATH", tt.ragPath)
+			got, err := LoadPrompts()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("LoadPrompts() err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantIs != nil && !errors.Is(err, tt.wantIs) {
+				t.Errorf("LoadPrompts() err = %v, want %v", err, tt.wantIs)
+			}
+			if err == nil && got != tt.want {
+				t.Errorf("LoadPrompts() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateRAGTemplate(t *testing.T) {
+	tests := []struct {
+		name    string
+		tmpl    string
+		wantErr bool
+	}{
+		{"default", systemPromptTmpl, false},
+		{"escaped percent", "Be 100%% sure.\n%s", false},
+		{"no placeholder", "No context here.", true},
+		{"two placeholders", "%s and %s", true},
+		{"escaped placeholder only", "%%s", true},
+		{"stray verb", "%d items\n%s", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := ValidateRAGTemplate(tt.tmpl); (err != nil) != tt.wantErr {
+				t.Errorf("ValidateRAGTemplate(%q) err = %v, wantErr %v", tt.tmpl, err, tt.wantErr)
+			}
+		})
+	}
+}
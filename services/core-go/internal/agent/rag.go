@@ -2,16 +2,23 @@ package agent
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"log"
+	"log/slog"
 	"math"
 	"os"
 	"path/filepath"
 	"sort"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
+	"unicode"
+	"unicode/utf8"
 
+	"core-go/internal/document"
 	"core-go/internal/llm"
+	"core-go/internal/logging"
 	"core-go/internal/vector"
 )
 
@@ -28,9 +35,18 @@ const (
 	// split across two chunks is still fully represented in one of them.
 	chunkOverlap = 50
 
-	// ragVectorDim must match the dimension that nomic-embed-text outputs.
-	// Changing this requires recreating the Qdrant collection.
-	ragVectorDim = 768
+	// MaxChunkSize caps a caller-chosen chunk size. Larger windows dilute the
+	// embedding until retrieval stops finding specific passages.
+	MaxChunkSize = 4000
+
+	// ingestBatchSize is how many embedded chunks IngestText accumulates
+	// before upserting, so a failure part-way through keeps earlier chunks.
+	ingestBatchSize = 16
+
+	// ingestFlushTimeout bounds the upsert IngestText makes after its
+	// context has ended, so chunks already embedded are stored rather than
+	// thrown away without letting a dead request hang on Qdrant.
+	ingestFlushTimeout = 10 * time.Second
 )
 
 type ragRuntimeConfig struct {
@@ -42,17 +58,43 @@ type ragRuntimeConfig struct {
 	MinLexicalScore     float64
 	LexicalWeight       float64
 	SourceHintWeight    float64
+	MaxContextChars     int     // rune budget for the CONTEXT block of the system prompt; 0 disables
+	DedupThreshold      float64 // ingest skips chunks at least this similar to a queued one; 0 disables
+	MaxChunksPerDoc     int     // ingest rejects documents that chunk into more than this
+	MaxChunksPerUser    int     // ingest rejects documents that would take a user past this many stored chunks; 0 is unlimited
+	MinContentRunes     int     // ingest rejects non-blank documents shorter than this many runes
+	DetectLanguage      bool    // ingest tags each chunk's payload with its detected language
+	FilterByLanguage    bool    // retrieval keeps only chunks in the query's detected language
+	LowConfidenceScore  float64 // answers whose best context chunk scores below this are flagged; 0 disables
+	LengthNormAlpha     float64 // strength of the short-chunk score penalty (see LengthPenalty); 0 disables
+	MaxNumCtx           int     // largest num_ctx RAG answers are auto-sized to; 0 disables auto-sizing
 }
 
-var ragCfg = ragRuntimeConfig{
-	TopK:                getEnvInt("RAG_TOP_K", 8),
-	FallbackTopK:        getEnvInt("RAG_FALLBACK_TOP_K", 80),
-	MaxContextChunks:    getEnvInt("RAG_MAX_CONTEXT_CHUNKS", 6),
-	MinTopSemanticScore: getEnvFloat("RAG_MIN_TOP_SEMANTIC_SCORE", 0.20),
-	MinSemanticFloor:    getEnvFloat("RAG_MIN_SEMANTIC_FLOOR", 0.08),
-	MinLexicalScore:     getEnvFloat("RAG_MIN_LEXICAL_SCORE", 0.20),
-	LexicalWeight:       getEnvFloat("RAG_LEXICAL_WEIGHT", 0.45),
-	SourceHintWeight:    getEnvFloat("RAG_SOURCE_HINT_WEIGHT", 0.20),
+var ragCfg = loadRAGConfig()
+
+// loadRAGConfig reads the RAG_* environment variables, falling back to the
+// defaults for unset or invalid values.
+func loadRAGConfig() ragRuntimeConfig {
+	return ragRuntimeConfig{
+		TopK:                getEnvInt("RAG_TOP_K", 8),
+		FallbackTopK:        getEnvInt("RAG_FALLBACK_TOP_K", 80),
+		MaxContextChunks:    getEnvInt("RAG_MAX_CONTEXT_CHUNKS", 6),
+		MinTopSemanticScore: getEnvFloat("RAG_MIN_TOP_SEMANTIC_SCORE", 0.20),
+		MinSemanticFloor:    getEnvFloat("RAG_MIN_SEMANTIC_FLOOR", 0.08),
+		MinLexicalScore:     getEnvFloat("RAG_MIN_LEXICAL_SCORE", 0.20),
+		LexicalWeight:       getEnvFloat("RAG_LEXICAL_WEIGHT", 0.45),
+		SourceHintWeight:    getEnvFloat("RAG_SOURCE_HINT_WEIGHT", 0.20),
+		MaxContextChars:     getEnvNonNegativeInt("RAG_MAX_CONTEXT_CHARS", 8000),
+		DedupThreshold:      getEnvFloat("RAG_INGEST_DEDUP_THRESHOLD", 0),
+		MaxChunksPerDoc:     getEnvInt("RAG_MAX_CHUNKS_PER_DOCUMENT", 500),
+		MaxChunksPerUser:    getEnvInt("RAG_MAX_CHUNKS_PER_USER", 0),
+		MinContentRunes:     getEnvInt("RAG_MIN_CONTENT_RUNES", 10),
+		DetectLanguage:      getEnvBool("RAG_DETECT_LANGUAGE", false),
+		FilterByLanguage:    getEnvBool("RAG_FILTER_BY_LANGUAGE", false),
+		LowConfidenceScore:  getEnvFloat("RAG_LOW_CONFIDENCE_SCORE", 0.45),
+		LengthNormAlpha:     getEnvFloat("RAG_LENGTH_NORM_ALPHA", 0),
+		MaxNumCtx:           getEnvNonNegativeInt("RAG_MAX_NUM_CTX", 8192),
+	}
 }
 
 type rankedPoint struct {
@@ -74,6 +116,45 @@ var stopWords = map[string]bool{
 
 const outOfScopeMsg = "I don't have information on that topic."
 
+// ErrDocumentTooLarge is returned by IngestText when a document would produce
+// more chunks than RAG_MAX_CHUNKS_PER_DOCUMENT allows.
+var ErrDocumentTooLarge = errors.New("rag: document too large")
+
+// ErrQuotaExceeded is returned by IngestText when storing a document would
+// take its owner past RAG_MAX_CHUNKS_PER_USER.
+var ErrQuotaExceeded = errors.New("rag: chunk quota exceeded")
+
+// ErrContentTooShort is returned by IngestText for a document shorter than
+// RAG_MIN_CONTENT_RUNES: a word or two embeds to a vector that matches
+// almost anything and crowds out real context.
+var ErrContentTooShort = errors.New("rag: content too short")
+
+// ErrRetrieval wraps a failed Qdrant search in AskKnowledgeBase, so callers
+// can tell "the knowledge base could not be searched" apart from a search
+// that simply found nothing (which yields the boundary message instead).
+var ErrRetrieval = errors.New("rag: retrieval failed")
+
+// ErrOutsideKnowledge is returned by AskKnowledgeBase with
+// AskOptions.ErrorOutsideKnowledge when no indexed chunk covers the query,
+// so programmatic callers can branch on it instead of matching the
+// out-of-scope message text.
+var ErrOutsideKnowledge = errors.New("rag: query is outside the knowledge base")
+
+// ErrInvalidChunking is returned by IngestText when IngestOptions asks for
+// a chunk size or overlap outside 0 <= overlap < size <= MaxChunkSize.
+var ErrInvalidChunking = errors.New("rag: invalid chunk size or overlap")
+
+// fallbackPrefix marks answers that were not grounded in the user's documents.
+const fallbackPrefix = "Not from your documents: "
+
+// fallbackSystemPrompt is used instead of systemPromptTmpl when AllowFallback
+// is set and retrieval found nothing relevant. The caller emits
+// fallbackPrefix itself, so the model is told not to add its own disclaimer.
+const fallbackSystemPrompt = `You are a helpful assistant. None of the user's documents cover this question, so answer from your general knowledge.
+Your answer will already be shown with a note that it does not come from the user's documents; do not repeat that note.
+
+Answer concisely and directly.`
+
 // systemPromptTmpl enforces a strictly closed domain: the model must respond
 // only from the provided CONTEXT and must not use any training knowledge.
 // When no relevant context was found the caller returns a static boundary
@@ -93,19 +174,87 @@ Answer concisely and directly.`
 // KnowledgeBase orchestrates the full RAG pipeline:
 // embed → vector search → prompt assembly → streaming LLM response.
 type KnowledgeBase struct {
-	qdrant *vector.QdrantClient
+	qdrant     *vector.QdrantClient
+	embedder   llm.Embedder
+	chat       llm.ChatProvider
+	promptTmpl string          // see SetSystemPrompt
+	normalize  ScoreNormalizer // see SetScoreNormalizer
+	chunks     ChunkStore      // see SetChunkStore
+	perUser    bool            // see SetCollectionMode
+
+	collectionsMu sync.Mutex
+	collections   map[string]bool // collections known to exist
+
+	quotaLocks userLocks // see reserveQuota
 }
 
-// NewKnowledgeBase returns a KnowledgeBase backed by the given Qdrant client.
-func NewKnowledgeBase(qdrant *vector.QdrantClient) *KnowledgeBase {
-	log.Printf("rag: config topK=%d fallbackTopK=%d maxContext=%d minTopSemantic=%.2f minLexical=%.2f",
-		ragCfg.TopK,
-		ragCfg.FallbackTopK,
-		ragCfg.MaxContextChunks,
-		ragCfg.MinTopSemanticScore,
-		ragCfg.MinLexicalScore,
+// NewKnowledgeBase returns a KnowledgeBase backed by the given Qdrant client
+// that vectorises queries and documents with embedder and generates answers
+// through chat.
+func NewKnowledgeBase(qdrant *vector.QdrantClient, embedder llm.Embedder, chat llm.ChatProvider) *KnowledgeBase {
+	slog.Info("rag: config",
+		"top_k", ragCfg.TopK,
+		"fallback_top_k", ragCfg.FallbackTopK,
+		"max_context", ragCfg.MaxContextChunks,
+		"min_top_semantic", ragCfg.MinTopSemanticScore,
+		"min_lexical", ragCfg.MinLexicalScore,
+		"max_context_chars", ragCfg.MaxContextChars,
+		"dedup_threshold", ragCfg.DedupThreshold,
+		"max_chunks_per_doc", ragCfg.MaxChunksPerDoc,
+		"max_chunks_per_user", ragCfg.MaxChunksPerUser,
+		"min_content_runes", ragCfg.MinContentRunes,
+		"detect_language", ragCfg.DetectLanguage,
+		"filter_by_language", ragCfg.FilterByLanguage,
+		"low_confidence_score", ragCfg.LowConfidenceScore,
+		"length_norm_alpha", ragCfg.LengthNormAlpha,
+		"max_num_ctx", ragCfg.MaxNumCtx,
 	)
-	return &KnowledgeBase{qdrant: qdrant}
+	return &KnowledgeBase{
+		qdrant:     qdrant,
+		embedder:   embedder,
+		chat:       chat,
+		promptTmpl: systemPromptTmpl,
+		normalize:  LengthPenalty(ragCfg.LengthNormAlpha),
+		chunks:     InlineChunkStore{},
+
+		// main ensures the base collection at startup.
+		collections: map[string]bool{ragCollection: true},
+	}
+}
+
+// SetSystemPrompt replaces the built-in RAG system prompt template. tmpl
+// must satisfy ValidateRAGTemplate; on error the current template is kept.
+func (kb *KnowledgeBase) SetSystemPrompt(tmpl string) error {
+	if err := ValidateRAGTemplate(tmpl); err != nil {
+		return fmt.Errorf("rag: set system prompt: %w", err)
+	}
+	kb.promptTmpl = tmpl
+	return nil
+}
+
+// Answer is the result of a RAG query: the streaming LLM response plus the
+// provenance of the context it was grounded on.
+type Answer struct {
+	Stream  <-chan llm.Chunk
+	Sources []Source // distinct sources used in the prompt; nil for boundary replies
+
+	// LowConfidence is set when the answer is grounded in documents but even
+	// the best of them only just cleared retrieval (semantic score below
+	// RAG_LOW_CONFIDENCE_SCORE), so the UI can suggest double-checking it.
+	LowConfidence bool
+}
+
+// Source is a citation for one document that contributed context. Title and
+// URL are set only when the document was ingested with them.
+type Source struct {
+	Source string `json:"source"`
+	Title  string `json:"title,omitempty"`
+	URL    string `json:"url,omitempty"`
+}
+
+// staticAnswer wraps a static boundary message in an Answer with no sources.
+func staticAnswer(text string) *Answer {
+	return &Answer{Stream: staticTextStream(text)}
 }
 
 // staticTextStream returns a closed channel pre-loaded with a single text
@@ -117,11 +266,40 @@ func staticTextStream(text string) <-chan llm.Chunk {
 	return ch
 }
 
-// AskKnowledgeBase runs the full RAG pipeline for query and returns a
-// read-only channel of streaming LLM chunks.
+// AskOptions tunes a single AskKnowledgeBase call. The zero value is the
+// strict closed-domain behaviour.
+type AskOptions struct {
+	// AllowFallback answers from the model's general knowledge, prefixed
+	// with fallbackPrefix, when no chunk is relevant enough — instead of
+	// returning the static out-of-scope message.
+	AllowFallback bool
+
+	// Sources, when non-empty, restricts retrieval to chunks ingested under
+	// one of these source labels, e.g. to answer "according to
+	// handbook.pdf, ...". The user scope still applies.
+	Sources []string
+
+	// Search tunes the Qdrant searches (HNSW ef, exact search); nil uses
+	// the collection defaults.
+	Search *vector.SearchParams
+
+	// Generation is passed to the chat model, e.g. a higher temperature
+	// when the client regenerates an answer.
+	Generation llm.Options
+
+	// ErrorOutsideKnowledge makes an out-of-scope query fail with
+	// ErrOutsideKnowledge instead of answering with the static boundary
+	// message. AllowFallback takes precedence: with it set there is always
+	// an answer.
+	ErrorOutsideKnowledge bool
+}
+
+// AskKnowledgeBase runs the full RAG pipeline for query and returns an
+// Answer holding a read-only channel of streaming LLM chunks and the sources
+// of the chunks placed in the prompt.
 //
 // userID scopes retrieval to admin documents (shared knowledge base) plus
-// documents ingested by this specific user. Pass "admin" to retrieve only
+// documents ingested by this specific user. Pass vector.SharedUserID to retrieve only
 // shared documents, or empty string for unfiltered access.
 //
 //  1. Vectorises query via Ollama nomic-embed-text.
@@ -130,62 +308,236 @@ func staticTextStream(text string) <-chan llm.Chunk {
 //  4. Compiles a strict system prompt from the filtered context.
 //  5. Streams the LLM response via llama3.1:8b (no tools — pure Q&A).
 //
+// When nothing relevant is found the Answer is the static out-of-scope
+// message, or — with opts.AllowFallback — a general-knowledge answer; with
+// opts.ErrorOutsideKnowledge it is ErrOutsideKnowledge instead.
 // The returned channel is closed when the stream ends or ctx is cancelled.
-func (kb *KnowledgeBase) AskKnowledgeBase(ctx context.Context, query, userID string) (<-chan llm.Chunk, error) {
+func (kb *KnowledgeBase) AskKnowledgeBase(ctx context.Context, query, userID string, opts AskOptions) (*Answer, error) {
 	// Step 1: embed the query.
-	vec, err := llm.Embed(ctx, query)
+	vec, err := kb.embedder.Embed(ctx, query)
 	if err != nil {
 		return nil, fmt.Errorf("rag: embed: %w", err)
 	}
 
-	// Step 2: retrieve primary semantic matches scoped to admin + userID.
-	points, err := kb.qdrant.Search(ctx, ragCollection, vec, ragCfg.TopK, userID)
+	// Step 2: retrieve primary semantic matches scoped to admin + userID,
+	// to opts.Sources when given, and to the query's language when
+	// RAG_FILTER_BY_LANGUAGE is on.
+	searchOpts := vector.SearchOptions{UserIDs: []string{userID}, IncludeAdmin: true, Sources: opts.Sources, Params: opts.Search}
+	if ragCfg.FilterByLanguage {
+		searchOpts.Language = document.DetectLanguage(query)
+		logging.FromContext(ctx).Info("rag: query language", "language", searchOpts.Language)
+	}
+	points, err := kb.search(ctx, userID, vec, ragCfg.TopK, searchOpts)
 	if err != nil {
-		return nil, fmt.Errorf("rag: search: %w", err)
+		return nil, fmt.Errorf("%w: search: %w", ErrRetrieval, err)
+	}
+	if err := kb.loadChunkText(ctx, points); err != nil {
+		return nil, fmt.Errorf("%w: load chunk text: %w", ErrRetrieval, err)
 	}
 	if len(points) == 0 {
-		return staticTextStream(kb.outOfScopeMessage(ctx, userID)), nil
+		return kb.outOfScopeAnswer(ctx, query, userID, opts)
 	}
 
-	// Step 3: rank primary candidates with hybrid semantic+lexical scoring.
-	ranked := rankPoints(query, points)
+	// Step 3: rank primary candidates with hybrid semantic+lexical scoring,
+	// on scores adjusted for chunk length by the configured normalizer.
+	ranked := rankPoints(query, kb.normalizeScores(points))
 	inScope := isInScope(ranked)
 
 	// Step 4: if low-confidence, expand retrieval and re-rank using deeper pool.
 	if !inScope && ragCfg.FallbackTopK > ragCfg.TopK {
-		fallbackPoints, searchErr := kb.qdrant.Search(ctx, ragCollection, vec, ragCfg.FallbackTopK, userID)
+		fallbackPoints, searchErr := kb.search(ctx, userID, vec, ragCfg.FallbackTopK, searchOpts)
 		if searchErr != nil {
-			return nil, fmt.Errorf("rag: fallback search: %w", searchErr)
+			return nil, fmt.Errorf("%w: fallback search: %w", ErrRetrieval, searchErr)
+		}
+		if err := kb.loadChunkText(ctx, fallbackPoints); err != nil {
+			return nil, fmt.Errorf("%w: load chunk text: %w", ErrRetrieval, err)
 		}
 		if len(fallbackPoints) > 0 {
-			ranked = rankPoints(query, fallbackPoints)
+			ranked = rankPoints(query, kb.normalizeScores(fallbackPoints))
 			inScope = isInScope(ranked)
 		}
 	}
 
 	if !inScope {
-		return staticTextStream(kb.outOfScopeMessage(ctx, userID)), nil
+		return kb.outOfScopeAnswer(ctx, query, userID, opts)
 	}
 
-	relevant := selectContextPoints(ranked)
+	relevant, above := selectContextPoints(ranked)
 	if len(relevant) == 0 {
-		return staticTextStream(kb.outOfScopeMessage(ctx, userID)), nil
+		return kb.outOfScopeAnswer(ctx, query, userID, opts)
+	}
+	relevant, dropped := fitContextBudget(relevant, ragCfg.MaxContextChars)
+	// Normally retrieved ≥ above_threshold ≥ in_prompt + dropped_for_budget,
+	// the last gap being what RAG_MAX_CONTEXT_CHUNKS cut. above_threshold
+	// is 0 with one chunk in the prompt when only the top point was kept.
+	logging.FromContext(ctx).Info("rag: context selected",
+		"retrieved", len(ranked),
+		"above_threshold", above,
+		"in_prompt", len(relevant),
+		"dropped_for_budget", dropped,
+		"top_hybrid", ranked[0].Hybrid,
+	)
+
+	// Retrieval may have taken a while; don't open an LLM stream for a
+	// client that has already disconnected.
+	if err := ctx.Err(); err != nil {
+		return nil, fmt.Errorf("rag: cancelled before generation: %w", err)
 	}
 
 	// Step 5: compile system prompt from selected context.
-	systemPrompt := buildSystemPrompt(relevant)
+	systemPrompt := buildSystemPrompt(kb.promptTmpl, relevant)
 
 	// Step 4: stream LLM response — no tools, this is pure retrieval Q&A.
 	messages := []llm.Message{
 		{Role: "system", Content: systemPrompt},
 		{Role: "user", Content: query},
 	}
-	ch, err := llm.StreamChat(ctx, messages, nil)
+	gen := fitNumCtx(ctx, opts.Generation, estimateTokens(messages))
+	ch, err := kb.chat.StreamChat(ctx, messages, nil, gen)
 	if err != nil {
 		return nil, fmt.Errorf("rag: stream: %w", err)
 	}
 
-	return ch, nil
+	return &Answer{
+		Stream:        ch,
+		Sources:       distinctSources(relevant),
+		LowConfidence: isLowConfidence(relevant, ragCfg.LowConfidenceScore),
+	}, nil
+}
+
+const (
+	// defaultNumCtx is Ollama's context window when num_ctx is not sent.
+	// Prompt tokens beyond it are silently dropped from the front — which
+	// is where the CONTEXT block sits.
+	defaultNumCtx = 2048
+
+	// answerTokenReserve is room left for the reply when NumPredict does
+	// not say how long it may be.
+	answerTokenReserve = 512
+
+	// numCtxStep is what auto-sized windows are rounded up to.
+	numCtxStep = 1024
+)
+
+// estimateTokens roughly counts the prompt tokens of messages: about four
+// runes per token for English text, plus a few per message for the chat
+// template. It only needs to be good enough to size num_ctx.
+func estimateTokens(messages []llm.Message) int {
+	tokens := 0
+	for _, m := range messages {
+		tokens += utf8.RuneCountInString(m.Content)/4 + 4
+	}
+	return tokens
+}
+
+// fitNumCtx returns gen with a context window large enough for a prompt of
+// promptTokens plus the reply. An explicit gen.NumCtx is kept as configured
+// and only warned about when the prompt likely exceeds it. Otherwise, when
+// Ollama's default window is too small, NumCtx is raised in numCtxStep
+// steps up to RAG_MAX_NUM_CTX, with a warning if even that falls short.
+// RAG_MAX_NUM_CTX=0 turns auto-sizing, and its warning, off.
+func fitNumCtx(ctx context.Context, gen llm.Options, promptTokens int) llm.Options {
+	reserve := answerTokenReserve
+	if gen.NumPredict > 0 {
+		reserve = gen.NumPredict
+	}
+	needed := promptTokens + reserve
+
+	if gen.NumCtx > 0 {
+		if needed > gen.NumCtx {
+			logging.FromContext(ctx).Warn("rag: prompt likely exceeds num_ctx; context may be truncated",
+				"estimated_tokens", needed, "num_ctx", gen.NumCtx)
+		}
+		return gen
+	}
+	if ragCfg.MaxNumCtx == 0 || needed <= defaultNumCtx {
+		return gen
+	}
+
+	numCtx := (needed + numCtxStep - 1) / numCtxStep * numCtxStep
+	if numCtx > ragCfg.MaxNumCtx {
+		numCtx = max(ragCfg.MaxNumCtx, defaultNumCtx)
+		logging.FromContext(ctx).Warn("rag: prompt likely exceeds the context window; context may be truncated",
+			"estimated_tokens", needed, "num_ctx", numCtx)
+	}
+	if numCtx > defaultNumCtx {
+		gen.NumCtx = numCtx
+	}
+	return gen
+}
+
+// isLowConfidence reports whether the best similarity score among points is
+// below threshold. A threshold <= 0 disables the check.
+func isLowConfidence(points []vector.ScoredPoint, threshold float64) bool {
+	if threshold <= 0 || len(points) == 0 {
+		return false
+	}
+	best := points[0].Score
+	for _, p := range points[1:] {
+		best = math.Max(best, p.Score)
+	}
+	return best < threshold
+}
+
+// outOfScopeAnswer is the Answer for a query no indexed chunk covers: the
+// static boundary message, or a prefixed general-knowledge answer when
+// opts.AllowFallback is set, or ErrOutsideKnowledge when the caller asked
+// for an error.
+func (kb *KnowledgeBase) outOfScopeAnswer(ctx context.Context, query, userID string, opts AskOptions) (*Answer, error) {
+	if !opts.AllowFallback && opts.ErrorOutsideKnowledge {
+		return nil, ErrOutsideKnowledge
+	}
+	if !opts.AllowFallback {
+		return staticAnswer(kb.outOfScopeMessage(ctx, userID)), nil
+	}
+
+	logging.FromContext(ctx).Info("rag: no relevant context, answering from general knowledge")
+	messages := []llm.Message{
+		{Role: "system", Content: fallbackSystemPrompt},
+		{Role: "user", Content: query},
+	}
+	ch, err := kb.chat.StreamChat(ctx, messages, nil, opts.Generation)
+	if err != nil {
+		return nil, fmt.Errorf("rag: fallback stream: %w", err)
+	}
+	return &Answer{Stream: prefixStream(ctx, fallbackPrefix, ch)}, nil
+}
+
+// prefixStream returns a channel that yields prefix as a text chunk and then
+// everything from ch. The prefix is added here rather than left to the
+// prompt so it is guaranteed to appear.
+func prefixStream(ctx context.Context, prefix string, ch <-chan llm.Chunk) <-chan llm.Chunk {
+	out := make(chan llm.Chunk, 16)
+	go func() {
+		defer close(out)
+		out <- llm.Chunk{Kind: llm.KindText, Text: prefix}
+		for chunk := range ch {
+			select {
+			case out <- chunk:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return out
+}
+
+// distinctSources returns the sources of points in first-seen order, one
+// per source label.
+func distinctSources(points []vector.ScoredPoint) []Source {
+	seen := map[string]bool{}
+	var sources []Source
+	for _, p := range points {
+		source, _ := p.Payload["source"].(string)
+		if source == "" || seen[source] {
+			continue
+		}
+		seen[source] = true
+		title, _ := p.Payload["title"].(string)
+		url, _ := p.Payload["url"].(string)
+		sources = append(sources, Source{Source: source, Title: title, URL: url})
+	}
+	return sources
 }
 
 func rankPoints(query string, points []vector.ScoredPoint) []rankedPoint {
@@ -259,9 +611,13 @@ func isInScope(ranked []rankedPoint) bool {
 	return false
 }
 
-func selectContextPoints(ranked []rankedPoint) []vector.ScoredPoint {
+// selectContextPoints picks up to RAG_MAX_CONTEXT_CHUNKS of ranked for the
+// prompt: those above the semantic floor or with any lexical or source-hint
+// match, falling back to the top point. above counts every point that
+// passed that threshold, including those cut by the chunk limit.
+func selectContextPoints(ranked []rankedPoint) (out []vector.ScoredPoint, above int) {
 	if len(ranked) == 0 {
-		return nil
+		return nil, 0
 	}
 
 	limit := ragCfg.MaxContextChunks
@@ -269,13 +625,13 @@ func selectContextPoints(ranked []rankedPoint) []vector.ScoredPoint {
 		limit = 4
 	}
 
-	out := make([]vector.ScoredPoint, 0, limit)
+	out = make([]vector.ScoredPoint, 0, limit)
 	for _, item := range ranked {
-		if len(out) >= limit {
-			break
-		}
 		if item.Semantic >= ragCfg.MinSemanticFloor || item.Lexical > 0 || item.SourceHint > 0 {
-			out = append(out, item.Point)
+			above++
+			if len(out) < limit {
+				out = append(out, item.Point)
+			}
 		}
 	}
 
@@ -283,7 +639,7 @@ func selectContextPoints(ranked []rankedPoint) []vector.ScoredPoint {
 		out = append(out, ranked[0].Point)
 	}
 
-	return out
+	return out, above
 }
 
 func hasTokenOrNearMatchInText(queryTokens []string, candidateTokens []string) bool {
@@ -370,6 +726,32 @@ func getEnvInt(key string, defaultValue int) int {
 	return v
 }
 
+// getEnvNonNegativeInt is getEnvInt for settings where 0 means "disabled":
+// only negative or unparsable values fall back to defaultValue.
+func getEnvNonNegativeInt(key string, defaultValue int) int {
+	raw := strings.TrimSpace(os.Getenv(key))
+	if raw == "" {
+		return defaultValue
+	}
+	v, err := strconv.Atoi(raw)
+	if err != nil || v < 0 {
+		return defaultValue
+	}
+	return v
+}
+
+func getEnvBool(key string, defaultValue bool) bool {
+	raw := strings.TrimSpace(os.Getenv(key))
+	if raw == "" {
+		return defaultValue
+	}
+	v, err := strconv.ParseBool(raw)
+	if err != nil {
+		return defaultValue
+	}
+	return v
+}
+
 func getEnvFloat(key string, defaultValue float64) float64 {
 	raw := strings.TrimSpace(os.Getenv(key))
 	if raw == "" {
@@ -496,49 +878,733 @@ func (kb *KnowledgeBase) outOfScopeMessage(ctx context.Context, userID string) s
 	return outOfScopeMsg
 }
 
-// CollectionDim returns the vector dimension this KnowledgeBase was configured
-// with. Called by main to pass the right value to EnsureCollection.
-func CollectionDim() int { return ragVectorDim }
+// CollectionDim returns the vector dimension of the configured embedding
+// model. Called by main to pass the right value to EnsureCollection.
+// Changing the embedding model requires recreating the Qdrant collection.
+func CollectionDim() (int, error) { return llm.EmbeddingDim() }
 
 // CollectionName returns the Qdrant collection name used by this KnowledgeBase.
 func CollectionName() string { return ragCollection }
 
+// CollectionDistance returns the distance metric for the collection, from
+// QDRANT_DISTANCE ("Cosine", "Dot", or "Euclid"; default "Cosine").
+// Changing it requires recreating the Qdrant collection. The retrieval score
+// thresholds assume a similarity where higher is better, so they need
+// retuning for anything but Cosine (and inverting for Euclid).
+func CollectionDistance() (string, error) {
+	d := strings.TrimSpace(os.Getenv("QDRANT_DISTANCE"))
+	if d == "" {
+		return vector.DistanceCosine, nil
+	}
+	if !vector.ValidDistance(d) {
+		return "", fmt.Errorf("rag: invalid QDRANT_DISTANCE %q (want Cosine, Dot, or Euclid)", d)
+	}
+	return d, nil
+}
+
+// IngestOptions carries optional citation metadata for IngestText. Title and
+// URL are stored on every chunk when set and returned with search results
+// and chat sources, so clients can render a link instead of the bare source
+// label.
+//
+// ChunkSize and ChunkOverlap override the 400/50 rune chunking window, e.g.
+// smaller windows for code. Zero ChunkSize and nil ChunkOverlap keep the
+// defaults; nil is needed because an overlap of 0 is a valid choice.
+//
+// DocumentID, when non-zero, is the documents-table row this ingest belongs
+// to. It is stored on every chunk as "document_id" so DeleteDocument can
+// remove exactly that document's chunks.
+//
+// DeterministicIDs derives each point ID from (source, chunk index, user_id)
+// instead of a random UUID, so re-ingesting a source overwrites its chunks in
+// place rather than duplicating them. Only positions the new ingest reaches
+// are overwritten: if the text now yields fewer chunks, the old tail stays
+// until ReplaceSource removes it.
+//
+// ReplacesSource tells the quota check that the caller removes the source's
+// existing chunks once this ingest succeeds, as a rechunk does, so those
+// chunks are not counted against RAG_MAX_CHUNKS_PER_USER.
+type IngestOptions struct {
+	Title            string
+	URL              string
+	ChunkSize        int
+	ChunkOverlap     *int
+	DocumentID       int64
+	DeterministicIDs bool
+	ReplacesSource   bool
+}
+
+// Chunking returns the window size and overlap o selects, or
+// ErrInvalidChunking when they do not satisfy
+// 0 <= overlap < size <= MaxChunkSize. Handlers call it to reject a bad
+// request before doing any work; IngestText calls it again.
+func (o IngestOptions) Chunking() (size, overlap int, err error) {
+	size, overlap = chunkSize, chunkOverlap
+	if o.ChunkSize != 0 {
+		size = o.ChunkSize
+	}
+	if o.ChunkOverlap != nil {
+		overlap = *o.ChunkOverlap
+	}
+	if err := validateChunking(size, overlap); err != nil {
+		return 0, 0, err
+	}
+	return size, overlap, nil
+}
+
+// validateChunking returns ErrInvalidChunking unless
+// 0 <= overlap < size <= MaxChunkSize.
+func validateChunking(size, overlap int) error {
+	switch {
+	case size <= 0 || size > MaxChunkSize:
+		return fmt.Errorf("%w: chunk size %d must be between 1 and %d", ErrInvalidChunking, size, MaxChunkSize)
+	case overlap < 0:
+		return fmt.Errorf("%w: chunk overlap %d must not be negative", ErrInvalidChunking, overlap)
+	case overlap >= size:
+		return fmt.Errorf("%w: chunk overlap %d must be smaller than chunk size %d", ErrInvalidChunking, overlap, size)
+	}
+	return nil
+}
+
+// addPayload copies the set fields of o into payload.
+func (o IngestOptions) addPayload(payload map[string]any) {
+	if o.Title != "" {
+		payload["title"] = o.Title
+	}
+	if o.URL != "" {
+		payload["url"] = o.URL
+	}
+	if o.DocumentID != 0 {
+		payload["document_id"] = o.DocumentID
+	}
+}
+
 // IngestText chunks text, embeds each chunk via nomic-embed-text, and upserts
 // the resulting vectors into the "Personal Context" Qdrant collection.
 //
-// userID tags every chunk so retrieval can be scoped per-user. Use "admin"
-// for shared knowledge documents accessible by all users.
+// userID tags every chunk so retrieval can be scoped per-user. Use
+// vector.SharedUserID for shared knowledge documents accessible by all users.
 // source is an arbitrary provenance label (e.g. "notes.txt").
+// Each chunk's payload records start_offset/end_offset, the rune range it
+// covers in text, so search hits can be traced back to the document.
+//
+// opts.ChunkSize/ChunkOverlap choose the chunking window; every chunk's
+// payload records the overlap used as "chunk_overlap" so the admin view can
+// reconstruct the document, and the window as "chunk_size" so LengthPenalty
+// measures each chunk against the size it was cut to. The final chunk of a
+// multi-chunk document is marked "last_chunk": it is only as long as the
+// text that was left.
+//
+// When RAG_INGEST_DEDUP_THRESHOLD is set, chunks whose embedding is at least
+// that cosine-similar to an earlier chunk of the same document are skipped;
+// chunk_index counts only the chunks kept, so it stays contiguous.
+// When RAG_DETECT_LANGUAGE is set, each chunk's payload also records its
+// detected "language" (ISO 639-1), for RAG_FILTER_BY_LANGUAGE retrieval.
 //
-// Returns the number of chunks successfully upserted.
-func (kb *KnowledgeBase) IngestText(ctx context.Context, text, source, userID string) (int, error) {
-	chunks := chunkText(text, chunkSize, chunkOverlap)
+// Before anything is embedded, a document shorter than RAG_MIN_CONTENT_RUNES
+// (ignoring surrounding whitespace) fails with ErrContentTooShort, one over
+// RAG_MAX_CHUNKS_PER_DOCUMENT with ErrDocumentTooLarge, and one that would
+// take userID past RAG_MAX_CHUNKS_PER_USER stored chunks with
+// ErrQuotaExceeded. Blank text stores nothing and is not an error.
+//
+// Chunks are upserted in batches of ingestBatchSize as they are embedded. On
+// failure the chunks embedded so far are still stored, and the returned
+// count is the number actually upserted alongside the error — callers should
+// treat a non-zero count with an error as partial success. That includes
+// cancellation: once ctx ends no more chunks are embedded, but the pending
+// batch is still upserted under a fresh ingestFlushTimeout deadline.
+func (kb *KnowledgeBase) IngestText(ctx context.Context, text, source, userID string, opts IngestOptions) (int, error) {
+	size, overlap, err := opts.Chunking()
+	if err != nil {
+		return 0, err
+	}
+	chunks, err := chunkTextChecked(text, size, overlap)
+	if err != nil {
+		return 0, err
+	}
 	if len(chunks) == 0 {
 		return 0, nil
 	}
+	if err := CheckContentLength(text); err != nil {
+		return 0, err
+	}
+	// Reject before the first embed call: a huge upload would otherwise
+	// grind through thousands of sequential embeddings.
+	if len(chunks) > ragCfg.MaxChunksPerDoc {
+		return 0, fmt.Errorf("%w: %d chunks exceeds the limit of %d", ErrDocumentTooLarge, len(chunks), ragCfg.MaxChunksPerDoc)
+	}
+	replaced := ""
+	if opts.ReplacesSource {
+		replaced = source
+	}
+	release, err := kb.reserveQuota(ctx, userID, replaced, len(chunks))
+	if err != nil {
+		return 0, err
+	}
+	defer release()
+
+	collection := kb.writeCollection(userID)
+	if err := kb.ensureCollection(ctx, collection); err != nil {
+		return 0, fmt.Errorf("rag: ingest: %w", err)
+	}
+
+	// Short chunks often carry too few words to call; they inherit the
+	// language of the document as a whole.
+	var docLang string
+	if ragCfg.DetectLanguage {
+		docLang = document.DetectLanguage(text)
+	}
+
+	var (
+		pending    []vector.PointInput // embedded, not yet upserted
+		kept       [][]float64         // every vector kept so far, for dedup
+		upserted   int
+		duplicates int
+	)
+	// flush upserts the pending points and counts them as stored. Once ctx
+	// has ended it runs detached from it, bounded by ingestFlushTimeout.
+	flush := func() error {
+		if len(pending) == 0 {
+			return nil
+		}
+		flushCtx := ctx
+		if ctx.Err() != nil {
+			var cancel context.CancelFunc
+			flushCtx, cancel = context.WithTimeout(context.WithoutCancel(ctx), ingestFlushTimeout)
+			defer cancel()
+		}
+		if err := kb.storeChunkText(flushCtx, pending); err != nil {
+			return fmt.Errorf("rag: ingest: store text after %d chunks: %w", upserted, err)
+		}
+		if err := kb.qdrant.UpsertPoints(flushCtx, collection, pending); err != nil {
+			return fmt.Errorf("rag: ingest: upsert after %d chunks: %w", upserted, err)
+		}
+		upserted += len(pending)
+		pending = pending[:0]
+		return nil
+	}
+	// fail stores whatever was embedded before err so the returned count
+	// reflects real progress.
+	fail := func(err error) (int, error) {
+		if flushErr := flush(); flushErr != nil {
+			return upserted, errors.Join(err, flushErr)
+		}
+		return upserted, err
+	}
 
-	points := make([]vector.PointInput, 0, len(chunks))
 	for i, chunk := range chunks {
-		vec, err := llm.Embed(ctx, chunk)
+		// Stop between embeds as soon as the caller goes away rather than
+		// grinding through the remaining chunks.
+		if err := ctx.Err(); err != nil {
+			return fail(fmt.Errorf("rag: ingest: cancelled before chunk %d: %w", i, err))
+		}
+		vec, err := kb.embedder.Embed(ctx, chunk.Text)
 		if err != nil {
-			return 0, fmt.Errorf("rag: ingest: embed chunk %d: %w", i, err)
+			return fail(fmt.Errorf("rag: ingest: embed chunk %d: %w", i, err))
+		}
+		if isNearDuplicate(vec, kept, ragCfg.DedupThreshold) {
+			duplicates++
+			continue
 		}
-		points = append(points, vector.PointInput{
-			ID:     vector.NewPointID(),
+		// Number kept chunks only, so a skipped duplicate leaves no gap in
+		// chunk_index for reconstruction or the chunk store's keys.
+		index := len(kept)
+		kept = append(kept, vec)
+		id := vector.NewPointID()
+		if opts.DeterministicIDs {
+			id = vector.DeterministicPointID(source, index, userID)
+		}
+		pending = append(pending, vector.PointInput{
+			ID:     id,
 			Vector: vec,
 			Payload: map[string]any{
-				"text":        chunk,
-				"source":      source,
-				"user_id":     userID,
-				"chunk_index": i,
+				"text":            chunk.Text,
+				"source":          source,
+				"user_id":         userID,
+				"chunk_index":     index,
+				"start_offset":    chunk.Start,
+				"end_offset":      chunk.End,
+				"chunk_size":      size,
+				"chunk_overlap":   overlap,
+				"embedding_model": llm.EmbeddingModel(),
 			},
 		})
+		if i > 0 && i == len(chunks)-1 {
+			pending[len(pending)-1].Payload["last_chunk"] = true
+		}
+		opts.addPayload(pending[len(pending)-1].Payload)
+		if ragCfg.DetectLanguage {
+			lang := document.DetectLanguage(chunk.Text)
+			if lang == "" {
+				lang = docLang
+			}
+			if lang != "" {
+				pending[len(pending)-1].Payload["language"] = lang
+			}
+		}
+		if len(pending) >= ingestBatchSize {
+			if err := flush(); err != nil {
+				return upserted, err
+			}
+		}
+	}
+
+	if duplicates > 0 {
+		logging.FromContext(ctx).Info("rag: ingest: skipped near-duplicate chunks",
+			"source", source, "skipped", duplicates, "threshold", ragCfg.DedupThreshold)
+	}
+
+	if err := flush(); err != nil {
+		return upserted, err
+	}
+	return upserted, nil
+}
+
+// CheckContentLength returns ErrContentTooShort if non-blank text is
+// shorter than RAG_MIN_CONTENT_RUNES. IngestText applies it; handlers that
+// remove an old version first call it up front.
+func CheckContentLength(text string) error {
+	n := utf8.RuneCountInString(strings.TrimSpace(text))
+	if n > 0 && n < ragCfg.MinContentRunes {
+		return fmt.Errorf("%w: %d characters, at least %d required", ErrContentTooShort, n, ragCfg.MinContentRunes)
+	}
+	return nil
+}
+
+// isNearDuplicate reports whether vec's cosine similarity to any already
+// kept vector is at least threshold. threshold <= 0 disables the check.
+// The comparison is O(n) per chunk, which is fine at per-document scale.
+func isNearDuplicate(vec []float64, kept [][]float64, threshold float64) bool {
+	if threshold <= 0 {
+		return false
+	}
+	for _, k := range kept {
+		if sim, err := llm.CosineSimilarity(vec, k); err == nil && sim >= threshold {
+			return true
+		}
+	}
+	return false
+}
+
+// Embed vectorises text with the KnowledgeBase's embedder, so callers get
+// vectors comparable with the stored chunks.
+func (kb *KnowledgeBase) Embed(ctx context.Context, text string) ([]float64, error) {
+	return kb.embedder.Embed(ctx, text)
+}
+
+// SearchDocuments embeds query and returns the raw top-limit chunks scoped
+// by opts, skipping the RAG ranking and scope checks. It is meant for
+// operators inspecting what retrieval would see across users.
+func (kb *KnowledgeBase) SearchDocuments(ctx context.Context, query string, limit int, opts vector.SearchOptions) ([]vector.ScoredPoint, error) {
+	vec, err := kb.embedder.Embed(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("rag: search documents: embed: %w", err)
+	}
+	collections, err := kb.allCollections(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("rag: search documents: %w", err)
+	}
+	points, err := kb.searchCollections(ctx, collections, vec, limit, opts)
+	if err != nil {
+		return nil, fmt.Errorf("rag: search documents: %w", err)
 	}
+	if err := kb.loadChunkText(ctx, points); err != nil {
+		return nil, fmt.Errorf("rag: search documents: %w", err)
+	}
+	return points, nil
+}
 
-	if err := kb.qdrant.UpsertPoints(ctx, ragCollection, points); err != nil {
-		return 0, fmt.Errorf("rag: ingest: upsert: %w", err)
+// reserveQuota checks userID's quota and, when one applies, holds the
+// user's ingest lock until the returned release is called. Without it two
+// concurrent uploads could each see room for their chunks and together
+// overshoot the limit, so IngestText keeps the lock until its chunks are
+// stored. The lock is per process: replicas behind a load balancer can
+// still overshoot by one document each.
+func (kb *KnowledgeBase) reserveQuota(ctx context.Context, userID, replaced string, more int) (release func(), err error) {
+	if ragCfg.MaxChunksPerUser <= 0 || userID == vector.SharedUserID {
+		return func() {}, nil
+	}
+	unlock, err := kb.quotaLocks.lock(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("rag: ingest: quota: %w", err)
+	}
+	if err := kb.checkQuota(ctx, userID, replaced, more); err != nil {
+		unlock()
+		return nil, err
+	}
+	return unlock, nil
+}
+
+// userLocks is a set of per-user mutexes that honour context cancellation.
+// Entries are dropped once nobody holds or waits for them, so the map only
+// grows with concurrent users. The zero value is ready to use.
+type userLocks struct {
+	mu    sync.Mutex
+	locks map[string]*userLock
+}
+
+type userLock struct {
+	held chan struct{} // one slot; full while locked
+	refs int           // holders plus waiters, guarded by userLocks.mu
+}
+
+// lock blocks until userID's lock is free or ctx ends, and returns the
+// function that unlocks it.
+func (l *userLocks) lock(ctx context.Context, userID string) (unlock func(), err error) {
+	l.mu.Lock()
+	if l.locks == nil {
+		l.locks = make(map[string]*userLock)
+	}
+	ul := l.locks[userID]
+	if ul == nil {
+		ul = &userLock{held: make(chan struct{}, 1)}
+		l.locks[userID] = ul
+	}
+	ul.refs++
+	l.mu.Unlock()
+
+	done := func() {
+		l.mu.Lock()
+		if ul.refs--; ul.refs == 0 {
+			delete(l.locks, userID)
+		}
+		l.mu.Unlock()
+	}
+	select {
+	case ul.held <- struct{}{}:
+		return func() {
+			<-ul.held
+			done()
+		}, nil
+	case <-ctx.Done():
+		done()
+		return nil, ctx.Err()
+	}
+}
+
+// checkQuota returns ErrQuotaExceeded if userID already has so many stored
+// chunks that adding more would pass RAG_MAX_CHUNKS_PER_USER. The shared
+// namespace is curated by admins and exempt. Near-duplicate chunks that
+// ingest later skips are still counted here, so the check errs towards
+// rejecting. Chunks of replaced, when non-empty, are about to be removed
+// and are left out of the count.
+func (kb *KnowledgeBase) checkQuota(ctx context.Context, userID, replaced string, more int) error {
+	if ragCfg.MaxChunksPerUser <= 0 || userID == vector.SharedUserID {
+		return nil
+	}
+	collections, err := kb.existingCollections(ctx, userID)
+	if err != nil {
+		return fmt.Errorf("rag: ingest: quota: %w", err)
+	}
+	stored := 0
+	for _, c := range collections {
+		n, err := kb.qdrant.CountPoints(ctx, c, vector.NewFilter().Must(vector.MatchValue("user_id", userID)))
+		if err != nil {
+			return fmt.Errorf("rag: ingest: quota: %w", err)
+		}
+		stored += n
+		if replaced == "" {
+			continue
+		}
+		old, err := kb.qdrant.CountPoints(ctx, c, vector.NewFilter().Must(
+			vector.MatchValue("user_id", userID),
+			vector.MatchValue("source", replaced),
+		))
+		if err != nil {
+			return fmt.Errorf("rag: ingest: quota: %w", err)
+		}
+		stored -= old
+	}
+	if stored+more > ragCfg.MaxChunksPerUser {
+		return fmt.Errorf("%w: %d stored + %d new chunks exceeds the limit of %d",
+			ErrQuotaExceeded, stored, more, ragCfg.MaxChunksPerUser)
+	}
+	return nil
+}
+
+// DeleteAllForUser removes every chunk ingested by userID. The shared
+// namespace, vector.SharedUserID, is refused so a user purge can never wipe the common
+// knowledge base.
+func (kb *KnowledgeBase) DeleteAllForUser(ctx context.Context, userID string) error {
+	if userID == "" || userID == vector.SharedUserID {
+		return fmt.Errorf("rag: delete all for user: refusing to purge %q", userID)
+	}
+	if err := kb.qdrant.DeleteByUser(ctx, ragCollection, userID); err != nil {
+		return fmt.Errorf("rag: delete all for user: %w", err)
+	}
+	if own := kb.writeCollection(userID); own != ragCollection {
+		if err := kb.qdrant.DeleteCollection(ctx, own); err != nil {
+			return fmt.Errorf("rag: delete all for user: %w", err)
+		}
+		kb.rememberCollection(own, false)
+	}
+	return nil
+}
+
+// UserUsage is how much of the knowledge base one user_id occupies.
+type UserUsage struct {
+	UserID  string `json:"user_id"`
+	Chunks  int    `json:"chunks"`
+	Sources int    `json:"sources"` // distinct source labels
+}
+
+// ListUsers scrolls every chunk and aggregates them by user_id, largest
+// first. Chunks without a user_id are reported under "". It reads the whole
+// collection, so it is meant for operators, not request paths.
+func (kb *KnowledgeBase) ListUsers(ctx context.Context) ([]UserUsage, error) {
+	collections, err := kb.allCollections(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("rag: list users: %w", err)
+	}
+
+	usage := map[string]*UserUsage{}
+	sources := map[[2]string]bool{}
+	for _, c := range collections {
+		points, err := kb.qdrant.ScrollPoints(ctx, c, nil)
+		if err != nil {
+			return nil, fmt.Errorf("rag: list users: %w", err)
+		}
+		for _, p := range points {
+			userID, _ := p.Payload["user_id"].(string)
+			source, _ := p.Payload["source"].(string)
+			u, ok := usage[userID]
+			if !ok {
+				u = &UserUsage{UserID: userID}
+				usage[userID] = u
+			}
+			u.Chunks++
+			if key := [2]string{userID, source}; !sources[key] {
+				sources[key] = true
+				u.Sources++
+			}
+		}
+	}
+
+	out := make([]UserUsage, 0, len(usage))
+	for _, u := range usage {
+		out = append(out, *u)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Chunks != out[j].Chunks {
+			return out[i].Chunks > out[j].Chunks
+		}
+		return out[i].UserID < out[j].UserID
+	})
+	return out, nil
+}
+
+// DeleteDocument removes the chunks of one ingested document — those whose
+// payload carries documentID (see IngestOptions.DocumentID) and userID.
+func (kb *KnowledgeBase) DeleteDocument(ctx context.Context, userID string, documentID int64) error {
+	collections, err := kb.existingCollections(ctx, userID)
+	if err != nil {
+		return fmt.Errorf("rag: delete document %d: %w", documentID, err)
+	}
+	for _, c := range collections {
+		if err := kb.qdrant.DeleteByDocument(ctx, c, userID, documentID); err != nil {
+			return fmt.Errorf("rag: delete document %d: %w", documentID, err)
+		}
+	}
+	return nil
+}
+
+// AdminPoints returns every chunk of the shared knowledge base, from each
+// collection that may hold it, with chunk text kept outside Qdrant loaded.
+func (kb *KnowledgeBase) AdminPoints(ctx context.Context) ([]vector.AdminPoint, error) {
+	collections, err := kb.existingCollections(ctx, vector.SharedUserID)
+	if err != nil {
+		return nil, fmt.Errorf("rag: admin points: %w", err)
+	}
+	var all []vector.AdminPoint
+	for _, c := range collections {
+		points, err := kb.qdrant.ScrollAdminPoints(ctx, c)
+		if err != nil {
+			return nil, fmt.Errorf("rag: admin points: %w", err)
+		}
+		all = append(all, points...)
+	}
+	if err := kb.LoadAdminText(ctx, all); err != nil {
+		return nil, fmt.Errorf("rag: admin points: %w", err)
 	}
-	return len(points), nil
+	return all, nil
+}
+
+// DeleteSharedSource removes the shared knowledge base's chunks labelled
+// source from each collection that may hold them.
+func (kb *KnowledgeBase) DeleteSharedSource(ctx context.Context, source string) error {
+	collections, err := kb.existingCollections(ctx, vector.SharedUserID)
+	if err != nil {
+		return fmt.Errorf("rag: delete source %q: %w", source, err)
+	}
+	for _, c := range collections {
+		if err := kb.qdrant.DeleteBySource(ctx, c, source); err != nil {
+			return fmt.Errorf("rag: delete source %q: %w", source, err)
+		}
+	}
+	return nil
+}
+
+// ReplaceSource removes userID's older chunks of source once the document
+// ingested as documentID has replaced them; documentID's own chunks stay.
+func (kb *KnowledgeBase) ReplaceSource(ctx context.Context, userID, source string, documentID int64) error {
+	collections, err := kb.existingCollections(ctx, userID)
+	if err != nil {
+		return fmt.Errorf("rag: replace source %q: %w", source, err)
+	}
+	for _, c := range collections {
+		if err := kb.qdrant.DeleteSourceExcept(ctx, c, userID, source, documentID); err != nil {
+			return fmt.Errorf("rag: replace source %q: %w", source, err)
+		}
+	}
+	return nil
+}
+
+// StaleSource is a document whose chunks were embedded with a model other
+// than the one currently configured and therefore need re-embedding.
+type StaleSource struct {
+	Source         string `json:"source"`
+	UserID         string `json:"user_id"`
+	EmbeddingModel string `json:"embedding_model"` // "" for chunks ingested before the field existed
+	Chunks         int    `json:"chunks"`
+}
+
+// FindStaleSources lists every (source, user_id) pair with chunks whose
+// embedding_model payload differs from currentModel. Chunks predating the
+// embedding_model field are reported with an empty model.
+func (kb *KnowledgeBase) FindStaleSources(ctx context.Context, currentModel string) ([]StaleSource, error) {
+	collections, err := kb.allCollections(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("rag: find stale sources: %w", err)
+	}
+	filter := vector.NewFilter().MustNot(vector.MatchValue("embedding_model", currentModel))
+	var points []vector.StoredPoint
+	for _, c := range collections {
+		found, err := kb.qdrant.ScrollPoints(ctx, c, filter)
+		if err != nil {
+			return nil, fmt.Errorf("rag: find stale sources: %w", err)
+		}
+		points = append(points, found...)
+	}
+
+	type key struct{ source, userID, model string }
+	counts := map[key]int{}
+	for _, p := range points {
+		var k key
+		k.source, _ = p.Payload["source"].(string)
+		k.userID, _ = p.Payload["user_id"].(string)
+		k.model, _ = p.Payload["embedding_model"].(string)
+		counts[k]++
+	}
+
+	stale := make([]StaleSource, 0, len(counts))
+	for k, n := range counts {
+		stale = append(stale, StaleSource{Source: k.source, UserID: k.userID, EmbeddingModel: k.model, Chunks: n})
+	}
+	sort.Slice(stale, func(i, j int) bool {
+		if stale[i].UserID != stale[j].UserID {
+			return stale[i].UserID < stale[j].UserID
+		}
+		return stale[i].Source < stale[j].Source
+	})
+	return stale, nil
+}
+
+// ReembedProgress reports how far ReembedAll has got. Skipped counts points
+// that could not be re-embedded because they have no stored text or a
+// non-UUID ID.
+type ReembedProgress struct {
+	Total      int `json:"total"`
+	Reembedded int `json:"reembedded"`
+	Skipped    int `json:"skipped"`
+}
+
+// ReembedAll re-embeds the stored text of every point in the collection (in
+// per-user mode, every collection) with
+// the currently configured embedding model and upserts it back under the
+// same point ID, so payloads and IDs survive a model switch. progress, when
+// non-nil, is called after each batch is written.
+//
+// The collection's vector size is not changed: switching to a model with a
+// different dimension still requires recreating the collection and
+// re-ingesting. On failure the returned progress covers the batches already
+// written.
+func (kb *KnowledgeBase) ReembedAll(ctx context.Context, progress func(ReembedProgress)) (ReembedProgress, error) {
+	collections, err := kb.allCollections(ctx)
+	if err != nil {
+		return ReembedProgress{}, fmt.Errorf("rag: reembed: %w", err)
+	}
+	// Scroll everything first so Total is known before the first batch.
+	points := make([][]vector.StoredPoint, len(collections))
+	var p ReembedProgress
+	for i, c := range collections {
+		if points[i], err = kb.qdrant.ScrollPoints(ctx, c, nil); err != nil {
+			return ReembedProgress{}, fmt.Errorf("rag: reembed: %w", err)
+		}
+		p.Total += len(points[i])
+	}
+	for i, c := range collections {
+		if err := kb.reembedCollection(ctx, c, points[i], &p, progress); err != nil {
+			return p, err
+		}
+	}
+	return p, nil
+}
+
+// reembedCollection is ReembedAll for the points of one collection,
+// accumulating into p.
+func (kb *KnowledgeBase) reembedCollection(ctx context.Context, collection string, points []vector.StoredPoint, p *ReembedProgress, progress func(ReembedProgress)) error {
+	payloads := make([]map[string]any, len(points))
+	for i, sp := range points {
+		payloads[i] = sp.Payload
+	}
+	if err := kb.chunks.LoadText(ctx, payloads); err != nil {
+		return fmt.Errorf("rag: reembed: %w", err)
+	}
+
+	model := llm.EmbeddingModel()
+	batch := make([]vector.PointInput, 0, ingestBatchSize)
+
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		if err := kb.storeChunkText(ctx, batch); err != nil {
+			return fmt.Errorf("rag: reembed: store text after %d points: %w", p.Reembedded, err)
+		}
+		if err := kb.qdrant.UpsertPoints(ctx, collection, batch); err != nil {
+			return fmt.Errorf("rag: reembed: upsert after %d points: %w", p.Reembedded, err)
+		}
+		p.Reembedded += len(batch)
+		batch = batch[:0]
+		if progress != nil {
+			progress(*p)
+		}
+		return nil
+	}
+
+	for _, sp := range points {
+		id, _ := sp.ID.(string)
+		text, _ := sp.Payload["text"].(string)
+		if id == "" || strings.TrimSpace(text) == "" {
+			p.Skipped++
+			continue
+		}
+		vec, err := kb.embedder.Embed(ctx, text)
+		if err != nil {
+			return fmt.Errorf("rag: reembed: point %s: %w", id, err)
+		}
+		sp.Payload["embedding_model"] = model
+		batch = append(batch, vector.PointInput{ID: id, Vector: vec, Payload: sp.Payload})
+		if len(batch) >= ingestBatchSize {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+	}
+	if err := flush(); err != nil {
+		return err
+	}
+	return nil
 }
 
 // ReconstructText rebuilds the original document text from an ordered slice
@@ -547,6 +1613,12 @@ func (kb *KnowledgeBase) IngestText(ctx context.Context, text, source, userID st
 // The result is a close approximation of the original; minor whitespace
 // differences may exist because chunkText applies TrimSpace to each chunk.
 func ReconstructText(chunks []string) string {
+	return ReconstructTextOverlap(chunks, chunkOverlap)
+}
+
+// ReconstructTextOverlap is ReconstructText for a document ingested with a
+// custom IngestOptions.ChunkOverlap.
+func ReconstructTextOverlap(chunks []string, overlap int) string {
 	if len(chunks) == 0 {
 		return ""
 	}
@@ -554,7 +1626,7 @@ func ReconstructText(chunks []string) string {
 	sb.WriteString(chunks[0])
 	for _, c := range chunks[1:] {
 		runes := []rune(c)
-		skip := chunkOverlap
+		skip := overlap
 		if skip > len(runes) {
 			skip = len(runes)
 		}
@@ -565,28 +1637,66 @@ func ReconstructText(chunks []string) string {
 	return sb.String()
 }
 
+// Chunk is one window of a document produced by chunkText. Start and End
+// are rune offsets into the original text (End exclusive), so
+// []rune(text)[Start:End] is exactly Text.
+type Chunk struct {
+	Text  string
+	Start int
+	End   int
+}
+
+// ChunkText splits text with the same window size and overlap IngestText
+// uses. Exposed so callers can preview how a document will be chunked
+// without embedding or upserting anything.
+func ChunkText(text string) []Chunk {
+	return chunkText(text, chunkSize, chunkOverlap)
+}
+
+// chunkTextChecked is chunkText for caller-supplied windows: it returns
+// ErrInvalidChunking instead of chunking when overlap >= size (which would
+// leave no forward step) or either value is out of range.
+func chunkTextChecked(text string, size, overlap int) ([]Chunk, error) {
+	if err := validateChunking(size, overlap); err != nil {
+		return nil, err
+	}
+	return chunkText(text, size, overlap), nil
+}
+
 // chunkText splits text into overlapping windows of size code points with
 // overlap code points of shared context between adjacent chunks.
 // It operates on Unicode code points (runes) so multibyte characters are
-// never split mid-sequence.
-func chunkText(text string, size, overlap int) []string {
+// never split mid-sequence. Windows are cut from the whitespace-trimmed text
+// and each chunk is trimmed again, but the reported offsets always refer to
+// the untrimmed input. size and overlap are trusted; anything
+// caller-supplied goes through chunkTextChecked.
+func chunkText(text string, size, overlap int) []Chunk {
+	all := []rune(text)
+	lead := len(all) - len([]rune(strings.TrimLeftFunc(text, unicode.IsSpace)))
 	runes := []rune(strings.TrimSpace(text))
 	if len(runes) == 0 {
 		return nil
 	}
 	step := size - overlap
 	if step <= 0 {
-		step = 1 // guard against misconfiguration
+		step = 1 // unreachable via chunkTextChecked; avoid an endless loop
 	}
-	var chunks []string
+	var chunks []Chunk
 	for start := 0; start < len(runes); start += step {
 		end := start + size
 		if end > len(runes) {
 			end = len(runes)
 		}
-		chunk := strings.TrimSpace(string(runes[start:end]))
+		window := runes[start:end]
+		trimmedLeft := []rune(strings.TrimLeftFunc(string(window), unicode.IsSpace))
+		chunk := strings.TrimRightFunc(string(trimmedLeft), unicode.IsSpace)
 		if chunk != "" {
-			chunks = append(chunks, chunk)
+			chunkStart := lead + start + len(window) - len(trimmedLeft)
+			chunks = append(chunks, Chunk{
+				Text:  chunk,
+				Start: chunkStart,
+				End:   chunkStart + len([]rune(chunk)),
+			})
 		}
 		if end >= len(runes) {
 			break
@@ -595,9 +1705,60 @@ func chunkText(text string, size, overlap int) []string {
 	return chunks
 }
 
+// fitContextBudget keeps the best-ranked points whose combined text fits in
+// maxChars runes (including the "[n] " labels and separators that
+// buildSystemPrompt adds) and returns how many lower-ranked points were
+// dropped. points must be ordered best-first. The top point is always kept —
+// truncated if it alone exceeds the budget — so a relevant answer is never
+// reduced to an empty context. maxChars <= 0 disables the budget.
+func fitContextBudget(points []vector.ScoredPoint, maxChars int) ([]vector.ScoredPoint, int) {
+	if maxChars <= 0 || len(points) == 0 {
+		return points, 0
+	}
+
+	used := 0
+	for i, p := range points {
+		text, _ := p.Payload["text"].(string)
+		cost := utf8.RuneCountInString(text) + len(fmt.Sprintf("[%d] ", i+1))
+		if i > 0 {
+			cost += 2 // "\n\n" separator
+		}
+		if used+cost <= maxChars {
+			used += cost
+			continue
+		}
+		if i == 0 {
+			room := maxChars - len("[1] ")
+			if room < 1 {
+				room = 1
+			}
+			return []vector.ScoredPoint{truncatePointText(p, room)}, len(points) - 1
+		}
+		return points[:i], len(points) - i
+	}
+	return points, 0
+}
+
+// truncatePointText returns a copy of p whose payload text is cut to at most
+// maxChars runes. The original payload map is left untouched.
+func truncatePointText(p vector.ScoredPoint, maxChars int) vector.ScoredPoint {
+	text, _ := p.Payload["text"].(string)
+	runes := []rune(text)
+	if len(runes) <= maxChars {
+		return p
+	}
+	payload := make(map[string]any, len(p.Payload))
+	for k, v := range p.Payload {
+		payload[k] = v
+	}
+	payload["text"] = string(runes[:maxChars])
+	p.Payload = payload
+	return p
+}
+
 // buildSystemPrompt formats the retrieved ScoredPoints into the strict
 // system prompt template. Each chunk is numbered [1]–[N].
-func buildSystemPrompt(points []vector.ScoredPoint) string {
+func buildSystemPrompt(tmpl string, points []vector.ScoredPoint) string {
 	var sb strings.Builder
 	idx := 1
 
@@ -621,5 +1782,5 @@ func buildSystemPrompt(points []vector.ScoredPoint) string {
 		sb.WriteString("(no relevant context found)")
 	}
 
-	return fmt.Sprintf(systemPromptTmpl, sb.String())
+	return fmt.Sprintf(tmpl, sb.String())
 }
@@ -0,0 +1,1363 @@
+package agent
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"core-go/internal/llm"
+	"core-go/internal/vector"
+	"core-go/internal/vector/qdranttest"
+)
+
+// newTestKB returns a KnowledgeBase on an in-memory Qdrant with the base
+// collection created, using the deterministic fake embedder and chat.
+func newTestKB(t *testing.T) (*KnowledgeBase, *qdranttest.Server) {
+	t.Helper()
+	srv := qdranttest.NewServer()
+	t.Cleanup(srv.Close)
+
+	q := vector.NewQdrantClient(srv.URL)
+	dim, err := CollectionDim()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := q.EnsureCollection(context.Background(), ragCollection, dim, vector.DistanceCosine); err != nil {
+		t.Fatal(err)
+	}
+	return NewKnowledgeBase(q, llm.NewFakeEmbedder(), llm.FakeChatProvider{}), srv
+}
+
+// setRAGConfig applies change to ragCfg for the duration of the test.
+func setRAGConfig(t *testing.T, change func(*ragRuntimeConfig)) {
+	t.Helper()
+	saved := ragCfg
+	change(&ragCfg)
+	t.Cleanup(func() { ragCfg = saved })
+}
+
+// storedChunks returns the payloads in collection ordered by chunk_index.
+func storedChunks(srv *qdranttest.Server, collection string) []qdranttest.Point {
+	points := srv.Points(collection)
+	sort.Slice(points, func(i, j int) bool {
+		a, _ := payloadInt(points[i].Payload["chunk_index"])
+		b, _ := payloadInt(points[j].Payload["chunk_index"])
+		return a < b
+	})
+	return points
+}
+
+func intPtr(n int) *int { return &n }
+
+func TestIngestTextDedupKeepsChunkIndexContiguous(t *testing.T) {
+	// Four exact 20-rune windows; the third repeats the first.
+	const (
+		first  = "alpha beta gamma del"
+		second = "zeta theta iota kapp"
+		fourth = "omega sigma tau phi."
+	)
+	text := first + second + first + fourth
+
+	tests := []struct {
+		name          string
+		deterministic bool
+	}{
+		{"random ids", false},
+		{"deterministic ids", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setRAGConfig(t, func(c *ragRuntimeConfig) { c.DedupThreshold = 0.99 })
+			kb, srv := newTestKB(t)
+
+			opts := IngestOptions{ChunkSize: 20, ChunkOverlap: intPtr(0), DeterministicIDs: tt.deterministic}
+			n, err := kb.IngestText(context.Background(), text, "greek.txt", "u1", opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if n != 3 {
+				t.Fatalf("IngestText() stored %d chunks, want 3", n)
+			}
+
+			points := storedChunks(srv, ragCollection)
+			var texts []string
+			for i, p := range points {
+				index, _ := payloadInt(p.Payload["chunk_index"])
+				if int(index) != i {
+					t.Errorf("chunk %d has chunk_index %d, want contiguous indexes", i, index)
+				}
+				if tt.deterministic {
+					if want := vector.DeterministicPointID("greek.txt", i, "u1"); p.ID != want {
+						t.Errorf("chunk %d id = %s, want %s", i, p.ID, want)
+					}
+				}
+				text, _ := p.Payload["text"].(string)
+				texts = append(texts, text)
+			}
+			if got, want := ReconstructTextOverlap(texts, 0), first+second+fourth; got != want {
+				t.Errorf("reconstructed %q, want %q", got, want)
+			}
+		})
+	}
+}
+
+// cancellingEmbedder cancels a context once it has embedded n texts, as a
+// client disconnecting mid-ingest would.
+type cancellingEmbedder struct {
+	llm.Embedder
+	n      int
+	cancel context.CancelFunc
+	calls  int
+}
+
+func (e *cancellingEmbedder) Embed(ctx context.Context, text string) ([]float64, error) {
+	e.calls++
+	vec, err := e.Embedder.Embed(ctx, text)
+	if e.n--; e.n == 0 {
+		e.cancel()
+	}
+	return vec, err
+}
+
+func TestIngestTextFlushesPendingBatchOnCancel(t *testing.T) {
+	const text = "alpha beta gamma delzeta theta iota kappomega sigma tau phi.lambda mu nu xi omi"
+	tests := []struct {
+		name        string
+		cancelAfter int // embeds before the context ends; 0 never
+		want        int
+	}{
+		{"not cancelled", 0, 4},
+		{"cancelled after one chunk", 1, 1},
+		{"cancelled after three chunks", 3, 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			kb, srv := newTestKB(t)
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			kb.embedder = &cancellingEmbedder{Embedder: kb.embedder, n: tt.cancelAfter, cancel: cancel}
+
+			opts := IngestOptions{ChunkSize: 20, ChunkOverlap: intPtr(0)}
+			n, err := kb.IngestText(ctx, text, "greek.txt", "u1", opts)
+			if wantErr := tt.cancelAfter > 0; (err != nil) != wantErr {
+				t.Fatalf("IngestText() err = %v, wantErr %v", err, wantErr)
+			}
+			if err != nil && !errors.Is(err, context.Canceled) {
+				t.Errorf("IngestText() err = %v, want it to wrap context.Canceled", err)
+			}
+			if n != tt.want {
+				t.Errorf("IngestText() reported %d chunks, want %d", n, tt.want)
+			}
+			// Everything embedded before the cancel is stored and counted.
+			if got := len(srv.Points(ragCollection)); got != n {
+				t.Errorf("stored %d points, reported %d", got, n)
+			}
+		})
+	}
+}
+
+func TestIngestTextStopsEmbeddingOnCancel(t *testing.T) {
+	const text = "alpha beta gamma delzeta theta iota kappomega sigma tau phi.lambda mu nu xi omi"
+	tests := []struct {
+		name        string
+		cancelAfter int
+	}{
+		{"cancelled after the first chunk", 1},
+		{"cancelled after the second chunk", 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			kb, _ := newTestKB(t)
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			e := &cancellingEmbedder{Embedder: kb.embedder, n: tt.cancelAfter, cancel: cancel}
+			kb.embedder = e
+
+			opts := IngestOptions{ChunkSize: 20, ChunkOverlap: intPtr(0)}
+			if _, err := kb.IngestText(ctx, text, "greek.txt", "u1", opts); !errors.Is(err, context.Canceled) {
+				t.Fatalf("IngestText() err = %v, want context.Canceled", err)
+			}
+			if e.calls != tt.cancelAfter {
+				t.Errorf("embedded %d chunks, want no embeds after the cancel at %d", e.calls, tt.cancelAfter)
+			}
+		})
+	}
+}
+
+// slowEmbedder delays every embed so concurrent ingests overlap.
+type slowEmbedder struct {
+	llm.Embedder
+	delay time.Duration
+}
+
+func (e slowEmbedder) Embed(ctx context.Context, text string) ([]float64, error) {
+	time.Sleep(e.delay)
+	return e.Embedder.Embed(ctx, text)
+}
+
+func TestIngestTextQuotaHoldsUnderConcurrency(t *testing.T) {
+	const text = "alpha beta gamma delzeta theta iota kappomega sigma tau phi."
+	tests := []struct {
+		name        string
+		limit       int
+		uploads     int
+		wantSuccess int
+	}{
+		{"room for one", 5, 4, 1},
+		{"room for two", 6, 4, 2},
+		{"room for all", 12, 4, 4},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setRAGConfig(t, func(c *ragRuntimeConfig) { c.MaxChunksPerUser = tt.limit })
+			kb, srv := newTestKB(t)
+			kb.embedder = slowEmbedder{Embedder: kb.embedder, delay: 5 * time.Millisecond}
+
+			var (
+				wg        sync.WaitGroup
+				mu        sync.Mutex
+				successes int
+			)
+			for i := 0; i < tt.uploads; i++ {
+				wg.Add(1)
+				go func(i int) {
+					defer wg.Done()
+					opts := IngestOptions{ChunkSize: 20, ChunkOverlap: intPtr(0)}
+					_, err := kb.IngestText(context.Background(), text, fmt.Sprintf("doc%d.txt", i), "u1", opts)
+					switch {
+					case err == nil:
+						mu.Lock()
+						successes++
+						mu.Unlock()
+					case !errors.Is(err, ErrQuotaExceeded):
+						t.Errorf("IngestText() err = %v, want nil or ErrQuotaExceeded", err)
+					}
+				}(i)
+			}
+			wg.Wait()
+
+			if successes != tt.wantSuccess {
+				t.Errorf("%d uploads succeeded, want %d", successes, tt.wantSuccess)
+			}
+			if got := len(srv.Points(ragCollection)); got > tt.limit {
+				t.Errorf("stored %d chunks, over the limit of %d", got, tt.limit)
+			}
+			if len(kb.quotaLocks.locks) != 0 {
+				t.Errorf("%d user locks left behind", len(kb.quotaLocks.locks))
+			}
+		})
+	}
+}
+
+func TestIngestTextQuotaDiscountsReplacedSource(t *testing.T) {
+	const (
+		three = "alpha beta gamma delzeta theta iota kappomega sigma tau phi."
+		four  = three + "lambda mu nu xi omi"
+	)
+	tests := []struct {
+		name     string
+		source   string
+		replaces bool
+		wantErr  error
+	}{
+		{"rechunk in place", "a.txt", true, nil},
+		{"same source without replace", "a.txt", false, ErrQuotaExceeded},
+		{"replacing another source", "b.txt", true, ErrQuotaExceeded},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setRAGConfig(t, func(c *ragRuntimeConfig) { c.MaxChunksPerUser = 5 })
+			kb, _ := newTestKB(t)
+			opts := IngestOptions{ChunkSize: 20, ChunkOverlap: intPtr(0)}
+			if _, err := kb.IngestText(context.Background(), three, "a.txt", "u1", opts); err != nil {
+				t.Fatal(err)
+			}
+
+			opts.ReplacesSource = tt.replaces
+			_, err := kb.IngestText(context.Background(), four, tt.source, "u1", opts)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("IngestText() err = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestUserLocks(t *testing.T) {
+	var l userLocks
+	unlock, err := l.lock(context.Background(), "u1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		user    string
+		wantErr error
+	}{
+		{"other user is independent", "u2", nil},
+		{"same user waits until ctx ends", "u1", context.DeadlineExceeded},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+			defer cancel()
+			u, err := l.lock(ctx, tt.user)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("lock(%q) err = %v, want %v", tt.user, err, tt.wantErr)
+			}
+			if u != nil {
+				u()
+			}
+		})
+	}
+
+	unlock()
+	if len(l.locks) != 0 {
+		t.Errorf("%d locks left after every holder released", len(l.locks))
+	}
+}
+
+func TestKnowledgeBaseDeleteAllForUser(t *testing.T) {
+	tests := []struct {
+		name    string
+		userID  string
+		wantErr bool
+	}{
+		{"user chunks removed", "u1", false},
+		{"shared namespace refused", vector.SharedUserID, true},
+		{"empty user refused", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			kb, srv := newTestKB(t)
+			ctx := context.Background()
+			for _, owner := range []string{"u1", "u2", vector.SharedUserID} {
+				if _, err := kb.IngestText(ctx, "The Colosseum is an ancient amphitheatre in Rome.", "rome.md", owner, IngestOptions{}); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			err := kb.DeleteAllForUser(ctx, tt.userID)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("DeleteAllForUser(%q) err = %v, wantErr %v", tt.userID, err, tt.wantErr)
+			}
+			owners := map[any]int{}
+			for _, p := range storedChunks(srv, ragCollection) {
+				owners[p.Payload["user_id"]]++
+			}
+			for _, owner := range []string{"u1", "u2", vector.SharedUserID} {
+				if gone := owner == tt.userID && !tt.wantErr; gone != (owners[owner] == 0) {
+					t.Errorf("user %q has %d chunks after purge, want them gone = %v", owner, owners[owner], gone)
+				}
+			}
+		})
+	}
+}
+
+func TestFindStaleSources(t *testing.T) {
+	kb, srv := newTestKB(t)
+	ctx := context.Background()
+	if _, err := kb.IngestText(ctx, "The Colosseum is an ancient amphitheatre in Rome.", "rome.md", "u1", IngestOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range storedChunks(srv, ragCollection) {
+		if got := p.Payload["embedding_model"]; got != llm.EmbeddingModel() {
+			t.Fatalf("ingested chunk has embedding_model %v, want %q", got, llm.EmbeddingModel())
+		}
+	}
+	// Chunks written by an older model, and by a build that predates the
+	// embedding_model field.
+	vec, _ := kb.embedder.Embed(ctx, "old")
+	err := kb.qdrant.UpsertPoints(ctx, ragCollection, []vector.PointInput{
+		{ID: vector.NewPointID(), Vector: vec, Payload: map[string]any{"source": "old.md", "user_id": "u1", "embedding_model": "all-minilm"}},
+		{ID: vector.NewPointID(), Vector: vec, Payload: map[string]any{"source": "old.md", "user_id": "u1", "embedding_model": "all-minilm"}},
+		{ID: vector.NewPointID(), Vector: vec, Payload: map[string]any{"source": "legacy.md", "user_id": "u2"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		model string
+		want  []StaleSource
+	}{
+		{"current model", llm.EmbeddingModel(), []StaleSource{
+			{Source: "old.md", UserID: "u1", EmbeddingModel: "all-minilm", Chunks: 2},
+			{Source: "legacy.md", UserID: "u2", Chunks: 1},
+		}},
+		{"switching to the older model", "all-minilm", []StaleSource{
+			{Source: "rome.md", UserID: "u1", EmbeddingModel: llm.EmbeddingModel(), Chunks: 1},
+			{Source: "legacy.md", UserID: "u2", Chunks: 1},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := kb.FindStaleSources(ctx, tt.model)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+				t.Errorf("FindStaleSources(%q) = %+v, want %+v", tt.model, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFitContextBudget(t *testing.T) {
+	point := func(score float64, n int) vector.ScoredPoint {
+		return vector.ScoredPoint{Score: score, Payload: map[string]any{"text": strings.Repeat("x", n)}}
+	}
+	tests := []struct {
+		name        string
+		lengths     []int // text length of each point, best-ranked first
+		budget      int
+		wantLengths []int
+		wantDropped int
+	}{
+		{"budget disabled", []int{500, 500}, 0, []int{500, 500}, 0},
+		{"everything fits", []int{10, 10}, 100, []int{10, 10}, 0},
+		// 4 for "[1] " + 10, then 2 + 4 + 10.
+		{"exact fit", []int{10, 10}, 30, []int{10, 10}, 0},
+		{"one rune over", []int{10, 10}, 29, []int{10}, 1},
+		{"oversized chunk drops it and everything below", []int{20, 200, 20}, 60, []int{20}, 2},
+		{"oversized top chunk is truncated", []int{500, 10}, 50, []int{46}, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var points []vector.ScoredPoint
+			for i, n := range tt.lengths {
+				points = append(points, point(1-float64(i)/10, n))
+			}
+			kept, dropped := fitContextBudget(points, tt.budget)
+			var lengths []int
+			for i, p := range kept {
+				text, _ := p.Payload["text"].(string)
+				lengths = append(lengths, len(text))
+				if p.Score != points[i].Score {
+					t.Errorf("kept[%d] has score %v, want the best-ranked points in order", i, p.Score)
+				}
+			}
+			if fmt.Sprint(lengths) != fmt.Sprint(tt.wantLengths) || dropped != tt.wantDropped {
+				t.Errorf("fitContextBudget() kept %v dropped %d, want %v dropped %d", lengths, dropped, tt.wantLengths, tt.wantDropped)
+			}
+			if context := buildSystemPrompt("%s", kept); tt.budget > 0 && len(context) > tt.budget {
+				t.Errorf("assembled context is %d chars, over the budget of %d", len(context), tt.budget)
+			}
+			if text, _ := points[0].Payload["text"].(string); len(text) != tt.lengths[0] {
+				t.Error("truncating the top chunk modified the caller's payload")
+			}
+		})
+	}
+}
+
+func TestContextBudgetFromEnv(t *testing.T) {
+	// Three chunks that together exceed the default 8000-rune budget.
+	var points []vector.ScoredPoint
+	for range 3 {
+		points = append(points, vector.ScoredPoint{Payload: map[string]any{"text": strings.Repeat("x", 3000)}})
+	}
+	tests := []struct {
+		name        string
+		env         string
+		wantBudget  int
+		wantDropped int
+	}{
+		{"unset uses the default", "", 8000, 1},
+		{"zero disables the budget", "0", 0, 0},
+		{"custom budget", "4000", 4000, 2},
+		{"negative falls back to the default", "-5", 8000, 1},
+		{"invalid falls back to the default", "lots", 8000, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("RAG_MAX_CONTEXT_CHARS", tt.env)
+			cfg := loadRAGConfig()
+			if cfg.MaxContextChars != tt.wantBudget {
+				t.Errorf("MaxContextChars = %d, want %d", cfg.MaxContextChars, tt.wantBudget)
+			}
+			if _, dropped := fitContextBudget(points, cfg.MaxContextChars); dropped != tt.wantDropped {
+				t.Errorf("fitContextBudget() dropped %d chunks, want %d", dropped, tt.wantDropped)
+			}
+		})
+	}
+}
+
+func TestContextSelectionCounts(t *testing.T) {
+	// Each candidate is {semantic, lexical, text length}; the config keeps
+	// semantic >= 0.5 or any lexical match, at most 3 chunks, 40 chars.
+	type candidate struct {
+		semantic, lexical float64
+		length            int
+	}
+	tests := []struct {
+		name         string
+		ranked       []candidate
+		wantAbove    int
+		wantInPrompt int
+		wantDropped  int
+	}{
+		{"all pass and fit", []candidate{{0.9, 0, 5}, {0.8, 0, 5}}, 2, 2, 0},
+		{"threshold filters", []candidate{{0.9, 0, 5}, {0.3, 0, 5}, {0.2, 0.4, 5}, {0.1, 0, 5}}, 2, 2, 0},
+		{"chunk limit cuts above-threshold points", []candidate{{0.9, 0, 5}, {0.8, 0, 5}, {0.7, 0, 5}, {0.6, 0, 5}, {0.55, 0, 5}}, 5, 3, 0},
+		// 4+10, then 2+4+10 = 30, then 2+4+10 would reach 46 > 40.
+		{"budget drops the tail", []candidate{{0.9, 0, 10}, {0.8, 0, 10}, {0.7, 0, 10}}, 3, 2, 1},
+		{"nothing above threshold keeps the top point", []candidate{{0.4, 0, 5}, {0.3, 0, 5}}, 0, 1, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setRAGConfig(t, func(c *ragRuntimeConfig) {
+				c.MinSemanticFloor = 0.5
+				c.MaxContextChunks = 3
+				c.MaxContextChars = 40
+			})
+			var ranked []rankedPoint
+			for _, c := range tt.ranked {
+				p := vector.ScoredPoint{Score: c.semantic, Payload: map[string]any{"text": strings.Repeat("x", c.length)}}
+				ranked = append(ranked, rankedPoint{Point: p, Semantic: c.semantic, Lexical: c.lexical})
+			}
+
+			selected, above := selectContextPoints(ranked)
+			inPrompt, dropped := fitContextBudget(selected, ragCfg.MaxContextChars)
+			if above != tt.wantAbove || len(inPrompt) != tt.wantInPrompt || dropped != tt.wantDropped {
+				t.Errorf("retrieved %d: above_threshold = %d, in_prompt = %d, dropped_for_budget = %d; want %d, %d, %d",
+					len(ranked), above, len(inPrompt), dropped, tt.wantAbove, tt.wantInPrompt, tt.wantDropped)
+			}
+		})
+	}
+}
+
+func TestIngestTextStoresOffsets(t *testing.T) {
+	const letters = "abcdefghijklmnopqrstuvwxyz0123456789ABCDEFGHIJ" // 46 runes
+	tests := []struct {
+		name    string
+		text    string
+		size    int
+		overlap int
+		want    [][2]int64 // [start_offset, end_offset) per chunk, in runes
+	}{
+		{"no overlap", letters, 20, 0, [][2]int64{{0, 20}, {20, 40}, {40, 46}}},
+		{"overlapping windows", letters, 20, 5, [][2]int64{{0, 20}, {15, 35}, {30, 46}}},
+		{"leading space shifts offsets", "  " + letters, 20, 5, [][2]int64{{2, 22}, {17, 37}, {32, 48}}},
+		{"multi-byte runes", strings.Repeat("日本語のテキスト", 3), 12, 4, [][2]int64{{0, 12}, {8, 20}, {16, 24}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			kb, srv := newTestKB(t)
+			ctx := context.Background()
+			if _, err := kb.IngestText(ctx, tt.text, "doc.md", "u1", IngestOptions{ChunkSize: tt.size, ChunkOverlap: intPtr(tt.overlap)}); err != nil {
+				t.Fatal(err)
+			}
+
+			runes := []rune(tt.text)
+			var got [][2]int64
+			for _, p := range storedChunks(srv, ragCollection) {
+				start, _ := payloadInt(p.Payload["start_offset"])
+				end, _ := payloadInt(p.Payload["end_offset"])
+				got = append(got, [2]int64{start, end})
+				if text := string(runes[start:end]); text != p.Payload["text"] {
+					t.Errorf("offsets [%d,%d) cover %q, chunk text is %q", start, end, text, p.Payload["text"])
+				}
+			}
+			if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+				t.Errorf("stored offsets = %v, want %v", got, tt.want)
+			}
+
+			// Search results carry the same offsets.
+			hits, err := kb.SearchDocuments(ctx, tt.text, len(tt.want), vector.SearchOptions{UserIDs: []string{"u1"}})
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, h := range hits {
+				start, okStart := payloadInt(h.Payload["start_offset"])
+				end, okEnd := payloadInt(h.Payload["end_offset"])
+				if !okStart || !okEnd || string(runes[start:end]) != h.Payload["text"] {
+					t.Errorf("search hit %s has offsets %v-%v for %q", h.ID, h.Payload["start_offset"], h.Payload["end_offset"], h.Payload["text"])
+				}
+			}
+		})
+	}
+}
+
+func TestCollectionDistance(t *testing.T) {
+	tests := []struct {
+		env     string
+		want    string
+		wantErr bool
+	}{
+		{"", vector.DistanceCosine, false},
+		{" Dot ", vector.DistanceDot, false},
+		{"Euclid", vector.DistanceEuclid, false},
+		{"cosine", "", true}, // Qdrant's names are case-sensitive
+		{"Manhattan", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.env, func(t *testing.T) {
+			t.Setenv("QDRANT_DISTANCE", tt.env)
+			got, err := CollectionDistance()
+			if got != tt.want || (err != nil) != tt.wantErr {
+				t.Errorf("CollectionDistance() = (%q, %v), want %q, wantErr %v", got, err, tt.want, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestIngestTextMaxChunksPerDocument(t *testing.T) {
+	tests := []struct {
+		name    string
+		limit   int
+		words   int // 20-rune words, one chunk each at size 20
+		wantErr bool
+	}{
+		{"under the limit", 5, 4, false},
+		{"at the limit", 5, 5, false},
+		{"over the limit", 5, 6, true},
+		{"far over the limit", 5, 5000, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setRAGConfig(t, func(c *ragRuntimeConfig) { c.MaxChunksPerDoc = tt.limit })
+			kb, srv := newTestKB(t)
+			emb := &recordingEmbedder{Embedder: llm.NewFakeEmbedder()}
+			kb.embedder = emb
+
+			var text strings.Builder
+			for i := 0; i < tt.words; i++ {
+				fmt.Fprintf(&text, "%019d ", i)
+			}
+			n, err := kb.IngestText(context.Background(), text.String(), "big.md", "u1", IngestOptions{ChunkSize: 20, ChunkOverlap: intPtr(0)})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("IngestText() = (%d, %v), wantErr %v", n, err, tt.wantErr)
+			}
+			if !tt.wantErr {
+				if n != tt.words {
+					t.Errorf("IngestText() stored %d chunks, want %d", n, tt.words)
+				}
+				return
+			}
+			if !errors.Is(err, ErrDocumentTooLarge) {
+				t.Errorf("IngestText() err = %v, want ErrDocumentTooLarge", err)
+			}
+			// Rejected before any embedding or upsert work.
+			if len(emb.texts) != 0 || srv.Upserts() != 0 {
+				t.Errorf("rejected document made %d embed and %d upsert call(s)", len(emb.texts), srv.Upserts())
+			}
+		})
+	}
+}
+
+// recordingEmbedder wraps an Embedder and keeps every text it embeds.
+type recordingEmbedder struct {
+	llm.Embedder
+	mu    sync.Mutex
+	texts []string
+}
+
+func (e *recordingEmbedder) Embed(ctx context.Context, text string) ([]float64, error) {
+	e.mu.Lock()
+	e.texts = append(e.texts, text)
+	e.mu.Unlock()
+	return e.Embedder.Embed(ctx, text)
+}
+
+func TestKnowledgeBaseUsesItsEmbedder(t *testing.T) {
+	const doc = "The Colosseum is an ancient amphitheatre in Rome."
+	tests := []struct {
+		name string
+		run  func(ctx context.Context, kb *KnowledgeBase) error
+		want []string
+	}{
+		{"ingest embeds each chunk", func(ctx context.Context, kb *KnowledgeBase) error {
+			_, err := kb.IngestText(ctx, doc, "rome.md", "u1", IngestOptions{})
+			return err
+		}, []string{doc}},
+		{"ask embeds the query", func(ctx context.Context, kb *KnowledgeBase) error {
+			answer, err := kb.AskKnowledgeBase(ctx, "Where is the Colosseum?", "u1", AskOptions{})
+			if err == nil {
+				for range answer.Stream {
+				}
+			}
+			return err
+		}, []string{"Where is the Colosseum?"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			kb, _ := newTestKB(t)
+			emb := &recordingEmbedder{Embedder: llm.NewFakeEmbedder()}
+			kb.embedder = emb
+			if err := tt.run(context.Background(), kb); err != nil {
+				t.Fatal(err)
+			}
+			if strings.Join(emb.texts, "|") != strings.Join(tt.want, "|") {
+				t.Errorf("embedded %q, want %q", emb.texts, tt.want)
+			}
+		})
+	}
+}
+
+// prefixEmbedder embeds prefix+text, standing in for a different model.
+type prefixEmbedder struct {
+	llm.Embedder
+	prefix string
+}
+
+func (e prefixEmbedder) Embed(ctx context.Context, text string) ([]float64, error) {
+	return e.Embedder.Embed(ctx, e.prefix+text)
+}
+
+func TestReembedAll(t *testing.T) {
+	tests := []struct {
+		name string
+		mode string
+	}{
+		{"filter mode", CollectionModeFilter},
+		{"per-user mode", CollectionModePerUser},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			kb, srv := newTestKB(t)
+			kb.SetCollectionMode(tt.mode)
+			ctx := context.Background()
+
+			// Ingest with the "old" model, then add a point without text.
+			kb.embedder = prefixEmbedder{llm.NewFakeEmbedder(), "old model: "}
+			for _, d := range []struct{ user, text string }{
+				{"u1", "The Colosseum is an ancient amphitheatre in Rome."},
+				{"u2", "The Parthenon is a former temple on the Athenian Acropolis."},
+				{vector.SharedUserID, "The Pantheon has the largest unreinforced concrete dome."},
+			} {
+				if _, err := kb.IngestText(ctx, d.text, "doc.md", d.user, IngestOptions{}); err != nil {
+					t.Fatal(err)
+				}
+			}
+			vec, _ := kb.embedder.Embed(ctx, "x")
+			if err := kb.qdrant.UpsertPoints(ctx, ragCollection, []vector.PointInput{{ID: vector.NewPointID(), Vector: vec, Payload: map[string]any{"user_id": "u1"}}}); err != nil {
+				t.Fatal(err)
+			}
+			before := map[string]bool{}
+			for _, c := range srv.Collections() {
+				for _, p := range srv.Points(c) {
+					before[p.ID] = true
+				}
+			}
+
+			emb := &recordingEmbedder{Embedder: llm.NewFakeEmbedder()}
+			kb.embedder = emb
+			var reports []ReembedProgress
+			got, err := kb.ReembedAll(ctx, func(p ReembedProgress) { reports = append(reports, p) })
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := (ReembedProgress{Total: 4, Reembedded: 3, Skipped: 1}); got != want {
+				t.Errorf("ReembedAll() = %+v, want %+v", got, want)
+			}
+			if len(reports) == 0 || reports[len(reports)-1].Reembedded != got.Reembedded {
+				t.Errorf("progress reports = %+v, want the last to match %+v", reports, got)
+			}
+			if len(emb.texts) != 3 {
+				t.Errorf("embedded %d texts, want 3", len(emb.texts))
+			}
+
+			after := 0
+			for _, c := range srv.Collections() {
+				for _, p := range srv.Points(c) {
+					after++
+					if !before[p.ID] {
+						t.Errorf("point %s was not there before the re-embed", p.ID)
+					}
+					text, _ := p.Payload["text"].(string)
+					if text == "" {
+						continue
+					}
+					want, _ := llm.NewFakeEmbedder().Embed(ctx, text)
+					if fmt.Sprint(p.Vector) != fmt.Sprint(want) {
+						t.Errorf("point %s still has its old vector", p.ID)
+					}
+					if p.Payload["embedding_model"] != llm.EmbeddingModel() {
+						t.Errorf("point %s embedding_model = %v", p.ID, p.Payload["embedding_model"])
+					}
+				}
+			}
+			if after != len(before) {
+				t.Errorf("%d points after the re-embed, want %d", after, len(before))
+			}
+		})
+	}
+}
+
+// recordingChat wraps a ChatProvider and keeps the system prompt of every
+// StreamChat call.
+type recordingChat struct {
+	llm.ChatProvider
+	systems []string
+}
+
+func (r *recordingChat) StreamChat(ctx context.Context, messages []llm.Message, tools []llm.Tool, opts llm.Options) (<-chan llm.Chunk, error) {
+	for _, m := range messages {
+		if m.Role == "system" {
+			r.systems = append(r.systems, m.Content)
+		}
+	}
+	return r.ChatProvider.StreamChat(ctx, messages, tools, opts)
+}
+
+func TestAskKnowledgeBaseFallback(t *testing.T) {
+	const question = "Where is the Colosseum amphitheatre?"
+	tests := []struct {
+		name       string
+		ingest     bool
+		opts       AskOptions
+		wantErr    error
+		wantPrefix bool   // answer starts with fallbackPrefix
+		wantPrompt string // system prompt sent to the model; "" expects no call
+		wantSource bool
+	}{
+		{"strict mode answers with the boundary message", false, AskOptions{}, nil, false, "", false},
+		{"strict mode can report an error", false, AskOptions{ErrorOutsideKnowledge: true}, ErrOutsideKnowledge, false, "", false},
+		{"fallback answers from general knowledge", false, AskOptions{AllowFallback: true}, nil, true, fallbackSystemPrompt, false},
+		{"fallback wins over the error option", false, AskOptions{AllowFallback: true, ErrorOutsideKnowledge: true}, nil, true, fallbackSystemPrompt, false},
+		{"fallback unused when documents match", true, AskOptions{AllowFallback: true}, nil, false, "CONTEXT", true},
+		{"error option unused when documents match", true, AskOptions{ErrorOutsideKnowledge: true}, nil, false, "CONTEXT", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			kb, _ := newTestKB(t)
+			chat := &recordingChat{ChatProvider: llm.FakeChatProvider{}}
+			kb.chat = chat
+			ctx := context.Background()
+			if tt.ingest {
+				if _, err := kb.IngestText(ctx, "The Colosseum is an ancient amphitheatre in Rome.", "rome.md", "u1", IngestOptions{}); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			answer, err := kb.AskKnowledgeBase(ctx, question, "u1", tt.opts)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("AskKnowledgeBase() err = %v, want %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			var text strings.Builder
+			for c := range answer.Stream {
+				text.WriteString(c.Text)
+			}
+			if got := strings.HasPrefix(text.String(), fallbackPrefix); got != tt.wantPrefix {
+				t.Errorf("answer %q: fallback prefix = %v, want %v", text.String(), got, tt.wantPrefix)
+			}
+			switch {
+			case tt.wantPrompt == "" && len(chat.systems) != 0:
+				t.Errorf("model called with %q, want no call", chat.systems)
+			case tt.wantPrompt != "" && (len(chat.systems) != 1 || !strings.Contains(chat.systems[0], tt.wantPrompt)):
+				t.Errorf("system prompts = %q, want one containing %q", chat.systems, tt.wantPrompt)
+			}
+			if (len(answer.Sources) > 0) != tt.wantSource {
+				t.Errorf("sources = %v, want some = %v", answer.Sources, tt.wantSource)
+			}
+		})
+	}
+}
+
+func TestChunkTextOffsets(t *testing.T) {
+	tests := []struct {
+		name    string
+		text    string
+		size    int
+		overlap int
+		want    []string
+	}{
+		{"blank", "   ", 10, 2, nil},
+		{"single window", "  hello  ", 10, 2, []string{"hello"}},
+		{"overlapping", "abcdefghij", 4, 1, []string{"abcd", "defg", "ghij"}},
+		{"multi-byte", "日本語のテキスト", 3, 0, []string{"日本語", "のテキ", "スト"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			chunks := chunkText(tt.text, tt.size, tt.overlap)
+			var got []string
+			runes := []rune(tt.text)
+			for _, c := range chunks {
+				got = append(got, c.Text)
+				if string(runes[c.Start:c.End]) != c.Text {
+					t.Errorf("offsets [%d,%d) give %q, want %q", c.Start, c.End, string(runes[c.Start:c.End]), c.Text)
+				}
+			}
+			if strings.Join(got, "|") != strings.Join(tt.want, "|") {
+				t.Errorf("chunkText() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestChunkTextChecked(t *testing.T) {
+	tests := []struct {
+		name       string
+		size       int
+		overlap    int
+		wantChunks int
+		wantErr    string
+	}{
+		{"valid window", 4, 1, 3, ""},
+		{"no overlap", 5, 0, 2, ""},
+		{"overlap one below size", 4, 3, 7, ""},
+		{"overlap equal to size", 4, 4, 0, "must be smaller than chunk size"},
+		{"overlap above size", 4, 9, 0, "must be smaller than chunk size"},
+		{"negative overlap", 4, -1, 0, "must not be negative"},
+		{"zero size", 0, 0, 0, "must be between 1"},
+		{"size above maximum", MaxChunkSize + 1, 0, 0, "must be between 1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			chunks, err := chunkTextChecked("abcdefghij", tt.size, tt.overlap)
+			if tt.wantErr == "" && err != nil {
+				t.Fatalf("chunkTextChecked() err = %v", err)
+			}
+			if tt.wantErr != "" && (!errors.Is(err, ErrInvalidChunking) || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Fatalf("chunkTextChecked() err = %v, want ErrInvalidChunking containing %q", err, tt.wantErr)
+			}
+			if len(chunks) != tt.wantChunks {
+				t.Errorf("chunkTextChecked() returned %d chunks, want %d", len(chunks), tt.wantChunks)
+			}
+		})
+	}
+}
+
+func TestLanguageFilteredRetrieval(t *testing.T) {
+	const (
+		english = "The Colosseum is an amphitheatre in the centre of Rome."
+		spanish = "El Coliseo es un anfiteatro que está en el centro de Roma."
+	)
+	tests := []struct {
+		name        string
+		detect      bool
+		filter      bool
+		query       string
+		wantTags    map[string]string // source → stored language payload
+		wantSources []string
+	}{
+		{"detection off stores no tags", false, false, "¿Dónde está el Coliseo de Roma?",
+			map[string]string{"en.md": "", "es.md": ""}, []string{"en.md", "es.md"}},
+		{"tags without filtering", true, false, "¿Dónde está el Coliseo de Roma?",
+			map[string]string{"en.md": "en", "es.md": "es"}, []string{"en.md", "es.md"}},
+		{"spanish query keeps spanish chunks", true, true, "¿Dónde está el Coliseo de Roma?",
+			map[string]string{"en.md": "en", "es.md": "es"}, []string{"es.md"}},
+		{"english query keeps english chunks", true, true, "Where is the Colosseum in Rome?",
+			map[string]string{"en.md": "en", "es.md": "es"}, []string{"en.md"}},
+		{"undetected query is not filtered", true, true, "Colosseum Coliseo Rome Roma",
+			map[string]string{"en.md": "en", "es.md": "es"}, []string{"en.md", "es.md"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setRAGConfig(t, func(c *ragRuntimeConfig) {
+				c.DetectLanguage, c.FilterByLanguage = tt.detect, tt.filter
+				c.MinTopSemanticScore, c.MinSemanticFloor, c.MinLexicalScore = -1, -1, -1
+			})
+			kb, srv := newTestKB(t)
+			ctx := context.Background()
+			for source, text := range map[string]string{"en.md": english, "es.md": spanish} {
+				if _, err := kb.IngestText(ctx, text, source, "u1", IngestOptions{}); err != nil {
+					t.Fatal(err)
+				}
+			}
+			for _, p := range srv.Points(ragCollection) {
+				source, _ := p.Payload["source"].(string)
+				lang, _ := p.Payload["language"].(string)
+				if lang != tt.wantTags[source] {
+					t.Errorf("%s stored language %q, want %q", source, lang, tt.wantTags[source])
+				}
+			}
+
+			answer, err := kb.AskKnowledgeBase(ctx, tt.query, "u1", AskOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			for range answer.Stream {
+			}
+			var sources []string
+			for _, s := range answer.Sources {
+				sources = append(sources, s.Source)
+			}
+			sort.Strings(sources)
+			if fmt.Sprint(sources) != fmt.Sprint(tt.wantSources) {
+				t.Errorf("sources = %v, want %v", sources, tt.wantSources)
+			}
+		})
+	}
+}
+
+func TestIngestOptionsChunking(t *testing.T) {
+	tests := []struct {
+		name        string
+		opts        IngestOptions
+		wantSize    int
+		wantOverlap int
+		wantErr     bool
+	}{
+		{"defaults", IngestOptions{}, chunkSize, chunkOverlap, false},
+		{"custom size keeps default overlap", IngestOptions{ChunkSize: 200}, 200, chunkOverlap, false},
+		{"zero overlap", IngestOptions{ChunkSize: 100, ChunkOverlap: intPtr(0)}, 100, 0, false},
+		{"maximum size", IngestOptions{ChunkSize: MaxChunkSize}, MaxChunkSize, chunkOverlap, false},
+		{"size above maximum", IngestOptions{ChunkSize: MaxChunkSize + 1}, 0, 0, true},
+		{"negative size", IngestOptions{ChunkSize: -1}, 0, 0, true},
+		{"negative overlap", IngestOptions{ChunkOverlap: intPtr(-1)}, 0, 0, true},
+		{"overlap equal to size", IngestOptions{ChunkSize: 50, ChunkOverlap: intPtr(50)}, 0, 0, true},
+		{"default overlap above small size", IngestOptions{ChunkSize: 40}, 0, 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			size, overlap, err := tt.opts.Chunking()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Chunking() err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil && !errors.Is(err, ErrInvalidChunking) {
+				t.Errorf("Chunking() err = %v, want ErrInvalidChunking", err)
+			}
+			if size != tt.wantSize || overlap != tt.wantOverlap {
+				t.Errorf("Chunking() = (%d, %d), want (%d, %d)", size, overlap, tt.wantSize, tt.wantOverlap)
+			}
+		})
+	}
+}
+
+func TestIngestTextCustomChunking(t *testing.T) {
+	text := strings.Repeat("abcdefghij", 10) // 100 runes
+	tests := []struct {
+		name        string
+		opts        IngestOptions
+		wantChunks  int
+		wantOverlap int64
+		wantErr     bool
+	}{
+		{"one default chunk", IngestOptions{}, 1, chunkOverlap, false},
+		{"size 25 without overlap", IngestOptions{ChunkSize: 25, ChunkOverlap: intPtr(0)}, 4, 0, false},
+		{"size 40 overlap 10", IngestOptions{ChunkSize: 40, ChunkOverlap: intPtr(10)}, 3, 10, false},
+		{"invalid window stores nothing", IngestOptions{ChunkSize: 10, ChunkOverlap: intPtr(10)}, 0, 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			kb, srv := newTestKB(t)
+			n, err := kb.IngestText(context.Background(), text, "letters.txt", "u1", tt.opts)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("IngestText() err = %v, wantErr %v", err, tt.wantErr)
+			}
+			points := storedChunks(srv, ragCollection)
+			if n != tt.wantChunks || len(points) != tt.wantChunks {
+				t.Fatalf("IngestText() = %d, stored %d, want %d", n, len(points), tt.wantChunks)
+			}
+			for _, p := range points {
+				if got, _ := payloadInt(p.Payload["chunk_overlap"]); got != tt.wantOverlap {
+					t.Errorf("chunk_overlap = %d, want %d", got, tt.wantOverlap)
+				}
+			}
+		})
+	}
+}
+
+func TestIsLowConfidence(t *testing.T) {
+	scored := func(scores ...float64) []vector.ScoredPoint {
+		var points []vector.ScoredPoint
+		for _, s := range scores {
+			points = append(points, vector.ScoredPoint{Score: s})
+		}
+		return points
+	}
+	tests := []struct {
+		name      string
+		points    []vector.ScoredPoint
+		threshold float64
+		want      bool
+	}{
+		{"high scores", scored(0.82, 0.61), 0.45, false},
+		{"borderline scores", scored(0.31, 0.44, 0.22), 0.45, true},
+		{"best exactly at threshold", scored(0.45, 0.2), 0.45, false},
+		{"best not first", scored(0.2, 0.9), 0.45, false},
+		{"disabled", scored(0.1), 0, false},
+		{"no points", nil, 0.45, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isLowConfidence(tt.points, tt.threshold); got != tt.want {
+				t.Errorf("isLowConfidence() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAskKnowledgeBaseLowConfidence(t *testing.T) {
+	tests := []struct {
+		name      string
+		threshold float64
+		want      bool
+	}{
+		{"match clears the threshold", 0.01, false},
+		{"match below the threshold", 1.01, true},
+		{"check disabled", 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setRAGConfig(t, func(c *ragRuntimeConfig) { c.LowConfidenceScore = tt.threshold })
+			kb, _ := newTestKB(t)
+			ctx := context.Background()
+			if _, err := kb.IngestText(ctx, "The Colosseum is an ancient amphitheatre in Rome.", "rome.md", "u1", IngestOptions{}); err != nil {
+				t.Fatal(err)
+			}
+			answer, err := kb.AskKnowledgeBase(ctx, "Where is the Colosseum amphitheatre?", "u1", AskOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			for range answer.Stream {
+			}
+			if len(answer.Sources) == 0 {
+				t.Fatal("answer has no sources, want a document-grounded answer")
+			}
+			if answer.LowConfidence != tt.want {
+				t.Errorf("LowConfidence = %v, want %v", answer.LowConfidence, tt.want)
+			}
+		})
+	}
+}
+
+func TestAskKnowledgeBaseRetrievalError(t *testing.T) {
+	tests := []struct {
+		name       string
+		collection bool // create the collection; without it every search fails
+		wantErr    error
+		wantText   string
+	}{
+		{"empty result is the boundary message", true, nil, outOfScopeMsg},
+		{"search failure is ErrRetrieval", false, ErrRetrieval, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var kb *KnowledgeBase
+			if tt.collection {
+				kb, _ = newTestKB(t)
+			} else {
+				srv := qdranttest.NewServer()
+				t.Cleanup(srv.Close)
+				kb = NewKnowledgeBase(vector.NewQdrantClient(srv.URL), llm.NewFakeEmbedder(), llm.FakeChatProvider{})
+			}
+			answer, err := kb.AskKnowledgeBase(context.Background(), "Where is the Colosseum?", "u1", AskOptions{})
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("AskKnowledgeBase() err = %v, want %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			var text strings.Builder
+			for c := range answer.Stream {
+				text.WriteString(c.Text)
+			}
+			if text.String() != tt.wantText {
+				t.Errorf("answer = %q, want %q", text.String(), tt.wantText)
+			}
+		})
+	}
+}
+
+func TestAskKnowledgeBaseSources(t *testing.T) {
+	tests := []struct {
+		name        string
+		sources     []string
+		wantSources []string
+	}{
+		{"no filter", nil, []string{"handbook.pdf", "notes.md", "shared.md"}},
+		{"single source", []string{"handbook.pdf"}, []string{"handbook.pdf"}},
+		{"several sources", []string{"notes.md", "shared.md"}, []string{"notes.md", "shared.md"}},
+		{"another user's source stays hidden", []string{"private.md"}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setRAGConfig(t, func(c *ragRuntimeConfig) {
+				c.MinTopSemanticScore, c.MinSemanticFloor, c.MinLexicalScore = -1, -1, -1
+			})
+			kb, _ := newTestKB(t)
+			ctx := context.Background()
+			for _, doc := range []struct{ source, user string }{
+				{"handbook.pdf", "u1"}, {"notes.md", "u1"}, {"shared.md", vector.SharedUserID}, {"private.md", "u2"},
+			} {
+				if _, err := kb.IngestText(ctx, "The office opens at nine in "+doc.source, doc.source, doc.user, IngestOptions{}); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			answer, err := kb.AskKnowledgeBase(ctx, "When does the office open?", "u1", AskOptions{Sources: tt.sources})
+			if err != nil {
+				t.Fatal(err)
+			}
+			for range answer.Stream {
+			}
+			var got []string
+			for _, s := range answer.Sources {
+				got = append(got, s.Source)
+			}
+			sort.Strings(got)
+			if fmt.Sprint(got) != fmt.Sprint(tt.wantSources) {
+				t.Errorf("sources = %v, want %v", got, tt.wantSources)
+			}
+		})
+	}
+}
+
+func TestAskKnowledgeBaseGenerationOptions(t *testing.T) {
+	cool := 0.3
+	tests := []struct {
+		name           string
+		gen            llm.Options
+		wantTemp       *float64
+		wantNumPredict int
+		wantNumCtx     int
+	}{
+		{"model defaults", llm.Options{}, nil, 0, 0},
+		{"configured values passed through", llm.Options{Temperature: &cool, NumPredict: 200}, &cool, 200, 0},
+		{"configured num_ctx passed through", llm.Options{NumCtx: 4096}, nil, 0, 4096},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			kb, _ := newTestKB(t)
+			chat := &optionsChat{ChatProvider: llm.FakeChatProvider{}}
+			kb.chat = chat
+			ctx := context.Background()
+			if _, err := kb.IngestText(ctx, "The Colosseum is an ancient amphitheatre in Rome.", "rome.md", "u1", IngestOptions{}); err != nil {
+				t.Fatal(err)
+			}
+			answer, err := kb.AskKnowledgeBase(ctx, "Where is the Colosseum amphitheatre?", "u1", AskOptions{Generation: tt.gen})
+			if err != nil {
+				t.Fatal(err)
+			}
+			for range answer.Stream {
+			}
+			if len(chat.opts) != 1 {
+				t.Fatalf("model called %d times, want 1", len(chat.opts))
+			}
+			got := chat.opts[0]
+			if fmt.Sprint(deref(got.Temperature)) != fmt.Sprint(deref(tt.wantTemp)) || got.NumPredict != tt.wantNumPredict || got.NumCtx != tt.wantNumCtx {
+				t.Errorf("options = %+v, want temperature %v, num_predict %d and num_ctx %d", got, deref(tt.wantTemp), tt.wantNumPredict, tt.wantNumCtx)
+			}
+		})
+	}
+}
+
+// captureLogs routes the default logger, debug level included, into the
+// returned buffer for the duration of the test.
+func captureLogs(t *testing.T) *bytes.Buffer {
+	t.Helper()
+	var buf bytes.Buffer
+	saved := slog.Default()
+	slog.SetDefault(slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug})))
+	t.Cleanup(func() { slog.SetDefault(saved) })
+	return &buf
+}
+
+func TestFitNumCtx(t *testing.T) {
+	tests := []struct {
+		name         string
+		maxNumCtx    int
+		gen          llm.Options
+		promptTokens int
+		wantNumCtx   int
+		wantWarn     bool
+	}{
+		{"fits the default window", 8192, llm.Options{}, 1000, 0, false},
+		{"raised in steps", 8192, llm.Options{}, 3000, 4096, false},
+		{"reply reserve counts", 8192, llm.Options{}, 1800, 3072, false},
+		{"num_predict replaces the reserve", 8192, llm.Options{NumPredict: 100}, 1800, 0, false},
+		{"capped at the maximum", 8192, llm.Options{}, 10000, 8192, true},
+		{"maximum below the default", 1024, llm.Options{}, 3000, 0, true},
+		{"auto-sizing disabled", 0, llm.Options{}, 3000, 0, false},
+		{"configured window checked with auto-sizing disabled", 0, llm.Options{NumCtx: 2048}, 3000, 2048, true},
+		{"configured window kept", 8192, llm.Options{NumCtx: 4096}, 1000, 4096, false},
+		{"configured window too small", 8192, llm.Options{NumCtx: 2048}, 3000, 2048, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setRAGConfig(t, func(c *ragRuntimeConfig) { c.MaxNumCtx = tt.maxNumCtx })
+			logs := captureLogs(t)
+
+			got := fitNumCtx(context.Background(), tt.gen, tt.promptTokens)
+			if got.NumCtx != tt.wantNumCtx {
+				t.Errorf("NumCtx = %d, want %d", got.NumCtx, tt.wantNumCtx)
+			}
+			if warned := strings.Contains(logs.String(), "level=WARN"); warned != tt.wantWarn {
+				t.Errorf("warned = %v, want %v: %s", warned, tt.wantWarn, logs)
+			}
+		})
+	}
+}
+
+func TestMaxNumCtxFromEnv(t *testing.T) {
+	tests := []struct {
+		name       string
+		env        string
+		wantMax    int
+		wantNumCtx int // for a prompt that outgrows the default window
+		wantWarn   bool
+	}{
+		{"unset uses the default", "", 8192, 4096, false},
+		{"zero disables auto-sizing", "0", 0, 0, false},
+		{"custom maximum", "3072", 3072, 3072, true},
+		{"negative falls back to the default", "-1", 8192, 4096, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("RAG_MAX_NUM_CTX", tt.env)
+			cfg := loadRAGConfig()
+			if cfg.MaxNumCtx != tt.wantMax {
+				t.Errorf("MaxNumCtx = %d, want %d", cfg.MaxNumCtx, tt.wantMax)
+			}
+			setRAGConfig(t, func(c *ragRuntimeConfig) { *c = cfg })
+			logs := captureLogs(t)
+
+			if got := fitNumCtx(context.Background(), llm.Options{}, 3000); got.NumCtx != tt.wantNumCtx {
+				t.Errorf("NumCtx = %d, want %d", got.NumCtx, tt.wantNumCtx)
+			}
+			if warned := strings.Contains(logs.String(), "level=WARN"); warned != tt.wantWarn {
+				t.Errorf("warned = %v, want %v: %s", warned, tt.wantWarn, logs)
+			}
+		})
+	}
+}
+
+// deref returns *p, or nil when p is nil, for printing optional fields.
+func deref[T any](p *T) any {
+	if p == nil {
+		return nil
+	}
+	return *p
+}
+
+func TestIngestTextMinimumLength(t *testing.T) {
+	tests := []struct {
+		name       string
+		text       string
+		wantErr    error
+		wantChunks int
+	}{
+		{"one rune short", "abcdefghi", ErrContentTooShort, 0},
+		{"exactly the minimum", "abcdefghij", nil, 1},
+		{"runes not bytes", "ééééééééé", ErrContentTooShort, 0},
+		{"multi-byte at the minimum", "éééééééééé", nil, 1},
+		{"surrounding whitespace ignored", "   abcdefghi \n", ErrContentTooShort, 0},
+		{"blank stores nothing", "  \n\t ", nil, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setRAGConfig(t, func(c *ragRuntimeConfig) { c.MinContentRunes = 10 })
+			kb, srv := newTestKB(t)
+			n, err := kb.IngestText(context.Background(), tt.text, "short.txt", "u1", IngestOptions{})
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("IngestText() err = %v, want %v", err, tt.wantErr)
+			}
+			if n != tt.wantChunks || len(srv.Points(CollectionName())) != tt.wantChunks {
+				t.Errorf("IngestText() = %d, stored %d points; want %d", n, len(srv.Points(CollectionName())), tt.wantChunks)
+			}
+		})
+	}
+}
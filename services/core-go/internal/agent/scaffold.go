@@ -0,0 +1,189 @@
+package agent
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+// pythonTag is the marker llama3.1 prints before a tool call it failed to
+// emit through the structured tool_calls field.
+const pythonTag = "<|python_tag|>"
+
+// maxHeldScaffold caps how much text scaffoldFilter holds back while
+// deciding. Anything longer is released as prose: real tool-call JSON is
+// small, and holding an unbounded paragraph would stall the stream.
+const maxHeldScaffold = 2048
+
+// scaffoldFilter removes tool-call scaffolding that the model sometimes
+// writes into its prose — a bare tool name, the python tag, or the tool-call
+// JSON itself — before it reaches the user.
+//
+// Text streams in token-sized pieces, so the filter works per line: a line
+// that starts like prose is passed through immediately, while a line that
+// starts like scaffolding ("{", "<|", or a tool name) is held until it can
+// be classified. JSON is held across newlines until its braces balance.
+// The zero value is not usable; construct with newScaffoldFilter.
+type scaffoldFilter struct {
+	toolNames   []string
+	held        strings.Builder
+	atLineStart bool
+}
+
+func newScaffoldFilter(toolNames ...string) *scaffoldFilter {
+	return &scaffoldFilter{toolNames: toolNames, atLineStart: true}
+}
+
+// Write feeds one text chunk and returns the part that is safe to show now.
+func (f *scaffoldFilter) Write(text string) string {
+	var out strings.Builder
+	for text != "" {
+		if f.held.Len() == 0 && !f.atLineStart {
+			// Mid-line prose: pass through to the end of the line.
+			i := strings.IndexByte(text, '\n')
+			if i < 0 {
+				out.WriteString(text)
+				return out.String()
+			}
+			out.WriteString(text[:i+1])
+			text = text[i+1:]
+			f.atLineStart = true
+			continue
+		}
+
+		i := strings.IndexByte(text, '\n')
+		piece := text
+		if i >= 0 {
+			piece = text[:i+1]
+		}
+		text = text[len(piece):]
+		f.held.WriteString(piece)
+		out.WriteString(f.decide(i >= 0))
+	}
+	return out.String()
+}
+
+// Flush classifies whatever is still held and returns it unless it is
+// scaffolding. Call it when the stream ends or is interrupted by a tool call.
+func (f *scaffoldFilter) Flush() string {
+	held := f.held.String()
+	f.held.Reset()
+	f.atLineStart = true
+	if f.isScaffolding(held) {
+		return ""
+	}
+	return held
+}
+
+// decide inspects the held text after a piece was added. It returns the
+// text to release (possibly empty) and updates the line state.
+func (f *scaffoldFilter) decide(lineEnded bool) string {
+	held := f.held.String()
+	trimmed := strings.TrimSpace(held)
+
+	release := func(s string) string {
+		f.held.Reset()
+		f.atLineStart = lineEnded
+		return s
+	}
+
+	switch {
+	case trimmed == "":
+		if lineEnded {
+			return release(held)
+		}
+		return "" // only whitespace so far; can't tell yet
+
+	case len(held) > maxHeldScaffold:
+		return release(held)
+
+	case strings.HasPrefix(trimmed, "{") || strings.HasPrefix(strings.TrimPrefix(trimmed, pythonTag), "{"):
+		if !jsonBalanced(trimmed) {
+			return "" // keep holding, across newlines if needed
+		}
+		if f.isScaffolding(held) {
+			return release("")
+		}
+		return release(held)
+
+	case f.mayBeScaffolding(trimmed):
+		if !lineEnded {
+			return ""
+		}
+		if f.isScaffolding(held) {
+			return release("")
+		}
+		return release(held)
+
+	default:
+		return release(held)
+	}
+}
+
+// mayBeScaffolding reports whether s, an incomplete line, could still turn
+// out to be the python tag or a bare tool name.
+func (f *scaffoldFilter) mayBeScaffolding(s string) bool {
+	if strings.HasPrefix(s, pythonTag) || strings.HasPrefix(pythonTag, s) {
+		return true
+	}
+	for _, name := range f.toolNames {
+		if strings.HasPrefix(s, name) || strings.HasPrefix(name, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// isScaffolding reports whether s is tool-call scaffolding rather than prose.
+func (f *scaffoldFilter) isScaffolding(s string) bool {
+	s = strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(s), pythonTag))
+	if s == "" {
+		return true // the python tag on its own
+	}
+	for _, name := range f.toolNames {
+		if s == name || strings.HasPrefix(s, name+"(") {
+			return true
+		}
+	}
+
+	var obj map[string]json.RawMessage
+	if json.Unmarshal([]byte(s), &obj) != nil {
+		return false
+	}
+	if _, ok := obj["function"]; ok {
+		return true
+	}
+	var name string
+	if raw, ok := obj["name"]; ok && json.Unmarshal(raw, &name) == nil {
+		for _, n := range f.toolNames {
+			if name == n {
+				return true
+			}
+		}
+	}
+	_, hasParams := obj["parameters"]
+	_, hasArgs := obj["arguments"]
+	return hasParams || hasArgs
+}
+
+// jsonBalanced reports whether every "{" in s outside string literals has
+// been closed.
+func jsonBalanced(s string) bool {
+	depth := 0
+	inString, escaped := false, false
+	for _, r := range s {
+		switch {
+		case escaped:
+			escaped = false
+		case inString && r == '\\':
+			escaped = true
+		case r == '"':
+			inString = !inString
+		case inString:
+		case r == '{':
+			depth++
+		case r == '}':
+			depth--
+		}
+	}
+	return depth <= 0
+}
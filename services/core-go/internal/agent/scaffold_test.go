@@ -0,0 +1,84 @@
+package agent
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"core-go/internal/llm"
+)
+
+func TestScaffoldFilter(t *testing.T) {
+	tests := []struct {
+		name   string
+		chunks []string
+		want   string
+	}{
+		{"plain prose", []string{"I added ", "the task."}, "I added the task."},
+		{"tool json dropped", []string{`{"name": "create_task", `, `"parameters": {"title": "x"}}`}, ""},
+		{"python tag and json dropped", []string{"<|python", "_tag|>", `{"name":"create_task","arguments":{}}`}, ""},
+		{"bare tool name line dropped", []string{"create_", "task\n", "Done!"}, "Done!"},
+		{"json then prose", []string{`{"function": "create_task"}`, "\nI've added it."}, "\nI've added it."},
+		{"prose then json", []string{"Sure.\n", `{"name":"create_task",`, "\n", `"parameters":{}}`}, "Sure.\n"},
+		{"prose mentioning the tool kept", []string{"I used create_task to add it."}, "I used create_task to add it."},
+		{"tool name prefix of prose kept", []string{"create", " a plan first\n"}, "create a plan first\n"},
+		{"non-tool json kept", []string{`{"total": 3}`}, `{"total": 3}`},
+		{"unfinished json flushed as prose", []string{"{ this is not", " json"}, "{ this is not json"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newScaffoldFilter("create_task")
+			var got strings.Builder
+			for _, c := range tt.chunks {
+				got.WriteString(f.Write(c))
+			}
+			got.WriteString(f.Flush())
+			if got.String() != tt.want {
+				t.Errorf("filtered = %q, want %q", got.String(), tt.want)
+			}
+		})
+	}
+}
+
+func TestHandleAgentTaskFiltersScaffolding(t *testing.T) {
+	tests := []struct {
+		name  string
+		turns [][]llm.Chunk
+		want  string
+	}{
+		{
+			"scaffolding before the tool call",
+			[][]llm.Chunk{
+				{{Kind: llm.KindText, Text: "<|python_tag|>"}, {Kind: llm.KindText, Text: `{"name":"create_task"}`}, toolCallChunk("buy milk")},
+				{{Kind: llm.KindText, Text: "Added buy milk."}},
+			},
+			"Added buy milk.",
+		},
+		{
+			"scaffolding in the summary",
+			[][]llm.Chunk{
+				{toolCallChunk("buy milk")},
+				{{Kind: llm.KindText, Text: "create_task\n"}, {Kind: llm.KindText, Text: "Added buy milk."}},
+			},
+			"Added buy milk.",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ta := NewTaskAgent(&memTasks{}, &scriptedChat{turns: tt.turns})
+			ch, err := ta.HandleAgentTask(context.Background(), "add buy milk", "u1", AgentOptions{ForceTask: true})
+			if err != nil {
+				t.Fatal(err)
+			}
+			var text strings.Builder
+			for ev := range ch {
+				if ev.Kind == EventText {
+					text.WriteString(ev.Text)
+				}
+			}
+			if text.String() != tt.want {
+				t.Errorf("streamed text = %q, want %q", text.String(), tt.want)
+			}
+		})
+	}
+}
@@ -2,12 +2,16 @@ package agent
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
+	"strconv"
 	"strings"
 
 	"core-go/internal/db"
 	"core-go/internal/llm"
+	"core-go/internal/logging"
 )
 
 // --- Agent event types (map 1:1 to sse_payloads.json) ---
@@ -20,30 +24,83 @@ const (
 	EventToolCall                  // model requested create_task (UI shows loading)
 	EventToolDone                  // task persisted successfully
 	EventError                     // validation or DB failure
+	EventStats                     // token usage summed over the loop's model calls; always last
 )
 
+// String returns the event's SSE-style name, for logs.
+func (k EventKind) String() string {
+	switch k {
+	case EventText:
+		return "text"
+	case EventToolCall:
+		return "tool_call"
+	case EventToolDone:
+		return "tool_done"
+	case EventError:
+		return "error"
+	case EventStats:
+		return "stats"
+	}
+	return fmt.Sprintf("EventKind(%d)", int(k))
+}
+
 // AgentEvent is one emission from the HandleAgentTask channel.
 type AgentEvent struct {
 	Kind   EventKind
 	Text   string         // EventText: prose token
 	Tool   string         // EventToolCall / EventToolDone: tool name
-	Args   map[string]any // EventToolCall: validated args (shown in UI)
+	Args   map[string]any // EventToolCall / EventToolDone: validated args (shown in UI)
 	TaskID int64          // EventToolDone: Postgres-generated ID
+	Task   *db.Task       // EventToolDone: the task as stored
 	ErrMsg string         // EventError: human-readable message
+	Stats  *llm.Stats     // EventStats: accumulated usage
 }
 
 // --- Schema validation ---
 
 // createTaskArgs mirrors the arguments schema in shared/tools/create_task.json.
-// Priority is a string enum — never an integer.
+// Priority is an integer 0–3; nil means the model omitted it.
 type createTaskArgs struct {
-	Title       string `json:"title"`
-	Description string `json:"description"`
-	Priority    string `json:"priority"`
+	Title       string       `json:"title"`
+	Description string       `json:"description"`
+	Priority    *priorityArg `json:"priority"`
+	Recurrence  string       `json:"recurrence"`
 }
 
-// validPriorities is the canonical set from shared/tools/create_task.json.
-var validPriorities = map[string]bool{"low": true, "medium": true, "high": true}
+// priorityArg is the tool's integer priority. Small models still emit names
+// ("high") or quoted numbers ("2") despite the schema, so both are accepted
+// and normalised here rather than failing the whole tool call.
+type priorityArg int
+
+func (p *priorityArg) UnmarshalJSON(data []byte) error {
+	var n int
+	if err := json.Unmarshal(data, &n); err == nil {
+		*p = priorityArg(n)
+		return nil
+	}
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return fmt.Errorf("'priority' must be an integer 0-3, got %s", data)
+	}
+	if n, err := strconv.Atoi(strings.TrimSpace(s)); err == nil {
+		*p = priorityArg(n)
+		return nil
+	}
+	n, err := db.PriorityFromString(s)
+	if err != nil {
+		return fmt.Errorf("'priority' must be an integer 0-3, got %q", s)
+	}
+	*p = priorityArg(n)
+	return nil
+}
+
+// priority returns the validated priority, applying the schema default.
+func (a createTaskArgs) priority() int {
+	if a.Priority == nil {
+		return db.DefaultPriority
+	}
+	return int(*a.Priority)
+}
 
 var taskIntentHints = []string{
 	"create a task",
@@ -198,11 +255,11 @@ func validateCreateTaskArgs(raw json.RawMessage) (createTaskArgs, error) {
 	if strings.TrimSpace(args.Title) == "" {
 		return args, fmt.Errorf("'title' is required and must be non-empty")
 	}
-	if args.Priority == "" {
-		args.Priority = "medium" // schema default
+	if !db.ValidPriority(args.priority()) {
+		return args, fmt.Errorf("'priority' must be an integer 0-3, got %d", args.priority())
 	}
-	if !validPriorities[args.Priority] {
-		return args, fmt.Errorf("'priority' must be one of low|medium|high, got %q", args.Priority)
+	if !db.ValidRecurrence(args.Recurrence) {
+		return args, fmt.Errorf("'recurrence' must be one of daily|weekly|monthly, got %q", args.Recurrence)
 	}
 	return args, nil
 }
@@ -212,20 +269,92 @@ func validateCreateTaskArgs(raw json.RawMessage) (createTaskArgs, error) {
 const agentSystemPrompt = `You are a personal task management assistant.
 When the user wants to create, add, or record a task, use the create_task tool.
 Extract the task title (required), description (if mentioned), and priority
-(if mentioned; must be "low", "medium", or "high"; default "medium").
+(an integer: 0 = low, 1 = medium, 2 = high, 3 = urgent; default 1), and
+recurrence (only if the task repeats; "daily", "weekly", or "monthly").
 If the user's intent is not to create a task, respond conversationally without using a tool.`
 
+// MaxSystemInstructionRunes caps the combined length of
+// AgentOptions.SystemInstructions, so client text cannot crowd the core
+// prompt out of the model's context.
+const MaxSystemInstructionRunes = 4000
+
+// clientInstructionsHeader introduces client-supplied instructions after the
+// agent's own prompt. They come last and are framed as subordinate, so a
+// deployment can add business rules but not switch off tool use.
+const clientInstructionsHeader = `
+
+Additional instructions from this deployment follow. Apply them when they
+do not conflict with the rules above; the rules above always take precedence.
+`
+
+// withClientInstructions returns prompt with instructions appended under
+// clientInstructionsHeader, or prompt unchanged when there are none.
+func withClientInstructions(prompt string, instructions []string) string {
+	var b strings.Builder
+	for _, text := range instructions {
+		if text = strings.TrimSpace(text); text != "" {
+			b.WriteString("\n")
+			b.WriteString(text)
+		}
+	}
+	if b.Len() == 0 {
+		return prompt
+	}
+	return prompt + clientInstructionsHeader + b.String()
+}
+
 // --- TaskAgent ---
 
 // TaskAgent runs the agentic loop that detects task-creation intent,
 // executes the tool, and generates a final summary for the user.
 type TaskAgent struct {
-	repo db.TaskRepository
+	repo         db.TaskRepository
+	chat         llm.ChatProvider
+	systemPrompt string
+}
+
+// NewTaskAgent returns a TaskAgent backed by the given repository that talks
+// to the model through chat.
+func NewTaskAgent(repo db.TaskRepository, chat llm.ChatProvider) *TaskAgent {
+	return &TaskAgent{repo: repo, chat: chat, systemPrompt: agentSystemPrompt}
+}
+
+// SetSystemPrompt replaces the built-in agent system prompt. An empty
+// prompt restores the default.
+func (ta *TaskAgent) SetSystemPrompt(prompt string) {
+	if strings.TrimSpace(prompt) == "" {
+		prompt = agentSystemPrompt
+	}
+	ta.systemPrompt = prompt
+}
+
+// agentTemperature is the agent's default sampling temperature. Tool calls
+// must be well-formed and their arguments faithful to the user's words, so
+// the agent samples near-deterministically unless a request overrides it.
+const agentTemperature = 0.0
+
+// agentGeneration returns the agent's default generation options.
+func agentGeneration() llm.Options {
+	t := agentTemperature
+	return llm.Options{Temperature: &t}
 }
 
-// NewTaskAgent returns a TaskAgent backed by the given repository.
-func NewTaskAgent(repo db.TaskRepository) *TaskAgent {
-	return &TaskAgent{repo: repo}
+// AgentOptions tunes a single HandleAgentTask call.
+type AgentOptions struct {
+	// ForceTask attaches the create_task tool even when the message does not
+	// look like task intent (the UI's explicit task mode).
+	ForceTask bool
+	// IdempotencyKey, when set, is recorded with any task created so a
+	// retried request returns the original task instead of a duplicate.
+	IdempotencyKey string
+	// Generation is passed to the chat model on both turns, over the
+	// agent's low-temperature defaults.
+	Generation llm.Options
+	// SystemInstructions are the client's system messages. They are
+	// appended to the agent's system prompt rather than replacing it, so
+	// the core task-handling rules always stay in force. Callers enforce
+	// MaxSystemInstructionRunes.
+	SystemInstructions []string
 }
 
 // HandleAgentTask runs the full agentic loop for userMessage and returns a
@@ -233,40 +362,42 @@ func NewTaskAgent(repo db.TaskRepository) *TaskAgent {
 // completes or ctx is cancelled.
 //
 // userID is the device-generated UUID of the requesting user. It is stored
-// alongside the task so tasks are per-user. Pass "admin" for system tasks.
+// alongside the task so tasks are per-user. Pass the shared user ID for system tasks.
 //
 //  1. Checks whether userMessage is explicit task intent.
 //  2. If yes, sends userMessage to Ollama with the create_task tool attached.
 //     If not, sends userMessage without tools for normal conversational chat.
-//  2. If Ollama returns a ToolCall chunk:
+//  2. For each ToolCall chunk Ollama returns:
 //     a. Validates the extracted args (title required, priority enum).
 //     b. Emits EventToolCall so the UI can show a loading state.
-//     c. Calls TaskRepository.CreateTask with userID.
-//     d. Emits EventToolDone with the generated task ID.
-//     e. Sends a tool-result confirmation back to Ollama for a final summary.
+//     c. Once the turn ends, calls TaskRepository.CreateTask for every call
+//     inside one WithTx transaction, with userID and opts.IdempotencyKey.
+//     d. Emits EventToolDone with each created task after commit.
+//     e. Sends the tool-result confirmations back to Ollama for a final summary.
 //  3. Streams all LLM text tokens as EventText.
-func (ta *TaskAgent) HandleAgentTask(ctx context.Context, userMessage, userID string, forceTask bool) (<-chan AgentEvent, error) {
-	if looksLikeTaskQuery(userMessage) && !forceTask {
+func (ta *TaskAgent) HandleAgentTask(ctx context.Context, userMessage, userID string, opts AgentOptions) (<-chan AgentEvent, error) {
+	if looksLikeTaskQuery(userMessage) && !opts.ForceTask {
 		return ta.handleTaskListQuery(ctx, userID)
 	}
 
+	opts.Generation = agentGeneration().Merge(opts.Generation)
 	messages := []llm.Message{
-		{Role: "system", Content: agentSystemPrompt},
+		{Role: "system", Content: withClientInstructions(ta.systemPrompt, opts.SystemInstructions)},
 		{Role: "user", Content: userMessage},
 	}
 
 	var tools []llm.Tool
-	if forceTask || looksLikeTaskIntent(userMessage) {
+	if opts.ForceTask || looksLikeTaskIntent(userMessage) {
 		tools = []llm.Tool{llm.CreateTaskTool}
 	}
 
-	ch, err := llm.StreamChat(ctx, messages, tools)
+	ch, err := ta.chat.StreamChat(ctx, messages, tools, opts.Generation)
 	if err != nil {
 		return nil, fmt.Errorf("agent: start stream: %w", err)
 	}
 
 	out := make(chan AgentEvent, 16)
-	go ta.runLoop(ctx, ch, messages, userID, out)
+	go ta.runLoop(ctx, ch, messages, userID, opts, out)
 	return out, nil
 }
 
@@ -287,7 +418,7 @@ func (ta *TaskAgent) handleTaskListQuery(ctx context.Context, userID string) (<-
 		lines = append(lines, "Here are your tasks:")
 		for i := 0; i < limit; i++ {
 			t := tasks[i]
-			lines = append(lines, fmt.Sprintf("%d) %s [%s | %s]", i+1, t.Title, t.Status, t.Priority))
+			lines = append(lines, fmt.Sprintf("%d) %s [%s | %s]", i+1, t.Title, t.Status, db.PriorityString(t.Priority)))
 		}
 		if len(tasks) > limit {
 			lines = append(lines, fmt.Sprintf("...and %d more.", len(tasks)-limit))
@@ -304,29 +435,67 @@ func (ta *TaskAgent) handleTaskListQuery(ctx context.Context, userID string) (<-
 	return out, nil
 }
 
+// toolExecution is one validated create_task call from the first turn and,
+// once persisted, the ID of the task it created.
+type toolExecution struct {
+	name   string
+	args   createTaskArgs
+	shown  map[string]any // validated args as reported to the UI and model
+	taskID db.TaskID
+	task   db.Task // the stored row, read back inside the transaction
+}
+
 // runLoop reads from the first-turn Chunk channel and orchestrates the
-// validation → DB write → second-turn summary flow.
+// validation → DB write → second-turn summary flow. Every tool call of the
+// turn is validated first and then persisted in one transaction, so a
+// failure on any of them leaves no tasks behind.
 func (ta *TaskAgent) runLoop(
 	ctx context.Context,
 	ch <-chan llm.Chunk,
 	firstTurnMessages []llm.Message,
 	userID string,
+	opts AgentOptions,
 	out chan<- AgentEvent,
 ) {
 	defer close(out)
 
+	var stats *llm.Stats
+	defer func() {
+		if stats != nil {
+			emit(ctx, out, AgentEvent{Kind: EventStats, Stats: stats})
+		}
+	}()
+
+	// Prose is passed through scaffold so tool-call JSON or a bare tool name
+	// the model wrote as text never reaches the user.
+	scaffold := newScaffoldFilter(llm.CreateTaskTool.Function.Name)
+	emitText := func(text string) {
+		if text != "" {
+			emit(ctx, out, AgentEvent{Kind: EventText, Text: text})
+		}
+	}
+	defer func() { emitText(scaffold.Flush()) }()
+
+	var calls []toolExecution
 	for chunk := range ch {
 		switch chunk.Kind {
 
+		case llm.KindStats:
+			// The first turn's stats frame follows its tool calls, so the
+			// reported usage covers both model calls.
+			stats = addStats(stats, chunk.Stats)
+
 		case llm.KindText:
-			emit(ctx, out, AgentEvent{Kind: EventText, Text: chunk.Text})
+			emitText(scaffold.Write(chunk.Text))
 
 		case llm.KindToolCall:
 			tc := chunk.ToolCall
+			emitText(scaffold.Flush())
 
 			// Step 2a — validate args against the create_task schema.
 			args, err := validateCreateTaskArgs(tc.Arguments)
 			if err != nil {
+				logging.FromContext(ctx).Warn("agent: invalid tool args", "tool", tc.Name, "err", err)
 				emit(ctx, out, AgentEvent{
 					Kind:   EventError,
 					ErrMsg: fmt.Sprintf("tool arg validation: %v", err),
@@ -337,7 +506,10 @@ func (ta *TaskAgent) runLoop(
 			validatedArgs := map[string]any{
 				"title":       args.Title,
 				"description": args.Description,
-				"priority":    args.Priority,
+				"priority":    args.priority(),
+			}
+			if args.Recurrence != "" {
+				validatedArgs["recurrence"] = args.Recurrence
 			}
 
 			// Step 2b — emit tool_call so the UI shows a loading state.
@@ -346,88 +518,211 @@ func (ta *TaskAgent) runLoop(
 				Tool: tc.Name,
 				Args: validatedArgs,
 			})
+			calls = append(calls, toolExecution{name: tc.Name, args: args, shown: validatedArgs})
+		}
+	}
+
+	emitText(scaffold.Flush())
+
+	// The client may have disconnected while the model was streaming;
+	// don't persist tasks nobody is waiting for.
+	if len(calls) == 0 || ctx.Err() != nil {
+		return
+	}
 
-			// Step 2c — execute TaskRepository.CreateTask, scoped to the requesting user.
-			taskID, err := ta.repo.CreateTask(ctx, args.Title, args.Description, args.Priority, userID)
+	// Step 2c — execute TaskRepository.CreateTask for every call inside one
+	// transaction, scoped to the requesting user. A retried request carrying
+	// the same idempotency key gets the original tasks' IDs back instead of
+	// second rows.
+	err := ta.repo.WithTx(ctx, func(tx db.TaskRepository) error {
+		for i := range calls {
+			id, err := tx.CreateTask(ctx, db.NewTask{
+				Title:          calls[i].args.Title,
+				Description:    calls[i].args.Description,
+				Priority:       calls[i].args.priority(),
+				Recurrence:     calls[i].args.Recurrence,
+				UserID:         userID,
+				IdempotencyKey: callIdempotencyKey(opts.IdempotencyKey, i),
+			})
 			if err != nil {
-				emit(ctx, out, AgentEvent{
-					Kind:   EventError,
-					ErrMsg: fmt.Sprintf("create task: %v", err),
-				})
-				return
+				return err
 			}
+			// Read the row back so the UI's confirmation shows what was
+			// stored, including for an idempotent replay.
+			task, err := tx.GetTask(ctx, id, userID)
+			if err != nil {
+				return err
+			}
+			calls[i].taskID = id
+			calls[i].task = task
+		}
+		return nil
+	})
+	if err != nil {
+		logging.FromContext(ctx).Error("agent: create task", "user_id", userID, "tool_calls", len(calls), "err", err)
+		emit(ctx, out, AgentEvent{
+			Kind:   EventError,
+			ErrMsg: fmt.Sprintf("create task: %v", err),
+		})
+		return
+	}
 
-			// Step 2d — emit tool_done with the Postgres-generated ID.
-			emit(ctx, out, AgentEvent{
-				Kind:   EventToolDone,
-				Tool:   tc.Name,
-				TaskID: int64(taskID),
-			})
+	// Step 2d — emit tool_done with each created task, only now that the
+	// transaction has committed.
+	for _, c := range calls {
+		logging.FromContext(ctx).Info("agent: task created", "user_id", userID, "task_id", int64(c.taskID))
+		emit(ctx, out, AgentEvent{
+			Kind:   EventToolDone,
+			Tool:   c.name,
+			Args:   c.shown,
+			TaskID: int64(c.taskID),
+			Task:   &c.task,
+		})
+	}
 
-			// Step 2e — build second-turn history and stream the final summary.
-			ta.streamSummary(ctx, firstTurnMessages, tc.Name, validatedArgs, int64(taskID), out)
-			return // agentic loop ends after one tool execution
-		}
+	// Step 2e — build second-turn history and stream the final summary.
+	// Skip the second turn entirely when the client has gone away.
+	if ctx.Err() != nil {
+		return
+	}
+	stats = addStats(stats, ta.streamSummary(ctx, firstTurnMessages, calls, opts.Generation, out))
+}
+
+// MaxIdempotencyKeyLen is the longest idempotency key the tasks table
+// stores (VARCHAR(255)). Callers reject longer client keys.
+const MaxIdempotencyKeyLen = 255
+
+// callIdempotencyKey derives the key for the i-th tool call of a turn. The
+// first call keeps the request's key unchanged; later ones get a suffix so
+// they are not mistaken for a retry of the first. When the suffix would
+// push a long key past MaxIdempotencyKeyLen, the key is replaced by its
+// SHA-256 before suffixing, which is still stable across retries.
+func callIdempotencyKey(key string, i int) string {
+	if key == "" || i == 0 {
+		return key
+	}
+	derived := fmt.Sprintf("%s#%d", key, i)
+	if len(derived) <= MaxIdempotencyKeyLen {
+		return derived
 	}
+	sum := sha256.Sum256([]byte(key))
+	return fmt.Sprintf("sha256:%s#%d", hex.EncodeToString(sum[:]), i)
 }
 
 // streamSummary reconstructs the full message history including the tool
-// result and streams Ollama's final natural-language confirmation.
+// results and streams Ollama's final natural-language confirmation. It
+// returns the summary call's usage stats, or nil when none were reported.
 func (ta *TaskAgent) streamSummary(
 	ctx context.Context,
 	firstTurnMessages []llm.Message,
-	toolName string,
-	validatedArgs map[string]any,
-	taskID int64,
+	calls []toolExecution,
+	gen llm.Options,
 	out chan<- AgentEvent,
-) {
-	fallbackText := fmt.Sprintf("Task created successfully (ID: %d).", taskID)
-
-	// Reconstruct the assistant's tool-call message for Ollama's history.
-	toolCallsJSON, _ := json.Marshal([]map[string]any{{
-		"function": map[string]any{
-			"name":      toolName,
-			"arguments": validatedArgs,
-		},
-	}})
-
-	// Tool result sent back to the model as the "tool" role message.
-	toolResult, _ := json.Marshal(map[string]any{
-		"status":  "success",
-		"task_id": taskID,
-		"title":   validatedArgs["title"],
-	})
+) *llm.Stats {
+	ids := make([]string, len(calls))
+	for i, c := range calls {
+		ids[i] = strconv.FormatInt(int64(c.taskID), 10)
+	}
+	fallbackText := fmt.Sprintf("Task created successfully (ID: %s).", ids[0])
+	if len(calls) > 1 {
+		fallbackText = fmt.Sprintf("%d tasks created successfully (IDs: %s).", len(calls), strings.Join(ids, ", "))
+	}
+
+	// Reconstruct the assistant's tool-call message for Ollama's history,
+	// followed by one "tool" role result per call.
+	toolCalls := make([]map[string]any, len(calls))
+	results := make([]llm.Message, len(calls))
+	for i, c := range calls {
+		toolCalls[i] = map[string]any{
+			"function": map[string]any{
+				"name":      c.name,
+				"arguments": c.shown,
+			},
+		}
+		toolResult, _ := json.Marshal(map[string]any{
+			"status":  "success",
+			"task_id": int64(c.taskID),
+			"title":   c.args.Title,
+		})
+		results[i] = llm.Message{Role: "tool", Content: string(toolResult)}
+	}
+	toolCallsJSON, _ := json.Marshal(toolCalls)
 
 	// Build a fresh slice to avoid mutating the original firstTurnMessages.
 	followUp := append(
 		append([]llm.Message{}, firstTurnMessages...),
 		llm.Message{Role: "assistant", Content: "", ToolCalls: toolCallsJSON},
-		llm.Message{Role: "tool", Content: string(toolResult)},
 	)
+	followUp = append(followUp, results...)
 
-	summaryCh, err := llm.StreamChat(ctx, followUp, nil)
+	summaryCh, err := ta.chat.StreamChat(ctx, followUp, nil, gen)
 	if err != nil {
 		emit(ctx, out, AgentEvent{Kind: EventText, Text: fallbackText})
-		return
+		return nil
 	}
 
+	var stats *llm.Stats
 	emittedText := false
-	for sc := range summaryCh {
-		if sc.Kind == llm.KindText {
+	scaffold := newScaffoldFilter(llm.CreateTaskTool.Function.Name)
+	emitText := func(text string) {
+		if text != "" {
 			emittedText = true
-			emit(ctx, out, AgentEvent{Kind: EventText, Text: sc.Text})
+			emit(ctx, out, AgentEvent{Kind: EventText, Text: text})
+		}
+	}
+	for sc := range summaryCh {
+		switch sc.Kind {
+		case llm.KindText:
+			emitText(scaffold.Write(sc.Text))
+		case llm.KindStats:
+			stats = sc.Stats
 		}
 	}
+	emitText(scaffold.Flush())
 
 	if !emittedText {
 		emit(ctx, out, AgentEvent{Kind: EventText, Text: fallbackText})
 	}
+	return stats
+}
+
+// addStats accumulates s into total, allocating total on first use. Either
+// may be nil.
+func addStats(total, s *llm.Stats) *llm.Stats {
+	if s == nil {
+		return total
+	}
+	if total == nil {
+		total = &llm.Stats{}
+	}
+	total.Add(*s)
+	return total
 }
 
-// emit sends e to ch while respecting ctx cancellation.
+// emit sends e to ch while respecting ctx cancellation. An event dropped
+// because ctx ended is logged: a lost tool_done means a task was created
+// that the client never heard about, so it is a warning carrying the task
+// ID for reconciliation; lost text and stats only matter when debugging.
 func emit(ctx context.Context, ch chan<- AgentEvent, e AgentEvent) {
 	select {
 	case ch <- e:
 	case <-ctx.Done():
+		logDroppedEvent(ctx, e)
+	}
+}
+
+// logDroppedEvent records an event emit could not deliver.
+func logDroppedEvent(ctx context.Context, e AgentEvent) {
+	logger := logging.FromContext(ctx)
+	cause := context.Cause(ctx)
+	switch e.Kind {
+	case EventToolDone:
+		logger.Warn("agent: dropped event after cancellation",
+			"kind", e.Kind.String(), "tool", e.Tool, "task_id", e.TaskID, "cause", cause)
+	case EventToolCall, EventError:
+		logger.Warn("agent: dropped event after cancellation",
+			"kind", e.Kind.String(), "tool", e.Tool, "error_msg", e.ErrMsg, "cause", cause)
+	default:
+		logger.Debug("agent: dropped event after cancellation", "kind", e.Kind.String(), "cause", cause)
 	}
 }
@@ -0,0 +1,375 @@
+package agent
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+
+	"core-go/internal/db"
+	"core-go/internal/llm"
+)
+
+// scriptedChat is an llm.ChatProvider that replays one scripted turn per
+// StreamChat call, then an empty turn once the script runs out.
+type scriptedChat struct {
+	mu    sync.Mutex
+	turns [][]llm.Chunk
+}
+
+func (s *scriptedChat) StreamChat(context.Context, []llm.Message, []llm.Tool, llm.Options) (<-chan llm.Chunk, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var turn []llm.Chunk
+	if len(s.turns) > 0 {
+		turn, s.turns = s.turns[0], s.turns[1:]
+	}
+	ch := make(chan llm.Chunk, len(turn))
+	for _, c := range turn {
+		ch <- c
+	}
+	close(ch)
+	return ch, nil
+}
+
+// cancellingChat wraps a ChatProvider and cancels a context once the n-th
+// StreamChat call has returned, as a client disconnecting mid-reply would.
+type cancellingChat struct {
+	llm.ChatProvider
+	n      int
+	cancel context.CancelFunc
+	calls  int
+}
+
+func (c *cancellingChat) StreamChat(ctx context.Context, messages []llm.Message, tools []llm.Tool, opts llm.Options) (<-chan llm.Chunk, error) {
+	c.calls++
+	ch, err := c.ChatProvider.StreamChat(ctx, messages, tools, opts)
+	if c.calls == c.n {
+		c.cancel()
+	}
+	return ch, err
+}
+
+// memTasks is an in-memory db.TaskRepository covering what the agent loop
+// calls. Like the tasks table, it rejects idempotency keys longer than
+// VARCHAR(255) and returns the existing ID for a repeated key.
+type memTasks struct {
+	db.TaskRepository // unimplemented methods panic
+
+	mu    sync.Mutex
+	tasks []db.Task
+	keys  map[string]db.TaskID
+}
+
+func (m *memTasks) CreateTask(_ context.Context, t db.NewTask) (db.TaskID, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if len(t.IdempotencyKey) > 255 {
+		return 0, fmt.Errorf("task_repository: create: value too long for type character varying(255)")
+	}
+	if id, ok := m.keys[t.IdempotencyKey]; ok && t.IdempotencyKey != "" {
+		return id, nil
+	}
+	id := db.TaskID(len(m.tasks) + 1)
+	m.tasks = append(m.tasks, db.Task{ID: id, Title: t.Title, UserID: t.UserID, Status: "pending"})
+	if m.keys == nil {
+		m.keys = map[string]db.TaskID{}
+	}
+	if t.IdempotencyKey != "" {
+		m.keys[t.IdempotencyKey] = id
+	}
+	return id, nil
+}
+
+func (m *memTasks) GetTask(_ context.Context, id db.TaskID, _ string) (db.Task, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if id < 1 || int(id) > len(m.tasks) {
+		return db.Task{}, db.ErrTaskNotFound
+	}
+	return m.tasks[id-1], nil
+}
+
+func (m *memTasks) WithTx(_ context.Context, fn func(db.TaskRepository) error) error {
+	return fn(m)
+}
+
+// optionsChat wraps a ChatProvider and records the generation options of
+// every StreamChat call.
+type optionsChat struct {
+	llm.ChatProvider
+	opts []llm.Options
+}
+
+func (o *optionsChat) StreamChat(ctx context.Context, messages []llm.Message, tools []llm.Tool, opts llm.Options) (<-chan llm.Chunk, error) {
+	o.opts = append(o.opts, opts)
+	return o.ChatProvider.StreamChat(ctx, messages, tools, opts)
+}
+
+func toolCallChunk(title string) llm.Chunk {
+	args, _ := json.Marshal(map[string]any{"title": title, "priority": 1})
+	return llm.Chunk{Kind: llm.KindToolCall, ToolCall: &llm.ToolCall{Name: "create_task", Arguments: args}}
+}
+
+func sha256Hex(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+func TestCallIdempotencyKey(t *testing.T) {
+	long := strings.Repeat("k", MaxIdempotencyKeyLen)
+	tests := []struct {
+		name string
+		key  string
+		i    int
+		want string
+	}{
+		{"no key", "", 3, ""},
+		{"first call unchanged", "abc", 0, "abc"},
+		{"later call suffixed", "abc", 2, "abc#2"},
+		{"max-length first call unchanged", long, 0, long},
+		{"max-length later call hashed", long, 1, "sha256:" + sha256Hex(long) + "#1"},
+		{"suffix just fits", strings.Repeat("k", MaxIdempotencyKeyLen-2), 1, strings.Repeat("k", MaxIdempotencyKeyLen-2) + "#1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := callIdempotencyKey(tt.key, tt.i)
+			if got != tt.want {
+				t.Errorf("callIdempotencyKey() = %q, want %q", got, tt.want)
+			}
+			if len(got) > MaxIdempotencyKeyLen {
+				t.Errorf("callIdempotencyKey() length %d exceeds %d", len(got), MaxIdempotencyKeyLen)
+			}
+		})
+	}
+}
+
+func TestHandleAgentTaskMaxLengthKeyManyToolCalls(t *testing.T) {
+	const calls = 12
+	key := strings.Repeat("x", MaxIdempotencyKeyLen)
+	newTurn := func() [][]llm.Chunk {
+		var first []llm.Chunk
+		for i := 0; i < calls; i++ {
+			first = append(first, toolCallChunk(fmt.Sprintf("task %d", i)))
+		}
+		return [][]llm.Chunk{first, {{Kind: llm.KindText, Text: "Done."}}}
+	}
+
+	repo := &memTasks{}
+	run := func() []int64 {
+		ta := NewTaskAgent(repo, &scriptedChat{turns: newTurn()})
+		ch, err := ta.HandleAgentTask(context.Background(), "add these tasks", "u1", AgentOptions{ForceTask: true, IdempotencyKey: key})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var ids []int64
+		for ev := range ch {
+			switch ev.Kind {
+			case EventError:
+				t.Fatalf("agent error: %s", ev.ErrMsg)
+			case EventToolDone:
+				ids = append(ids, ev.TaskID)
+			}
+		}
+		return ids
+	}
+
+	first := run()
+	if len(first) != calls {
+		t.Fatalf("created %d tasks, want %d", len(first), calls)
+	}
+	// A retry with the same key must replay the same tasks, not add more.
+	retry := run()
+	if fmt.Sprint(retry) != fmt.Sprint(first) {
+		t.Errorf("retry task IDs = %v, want %v", retry, first)
+	}
+	if len(repo.tasks) != calls {
+		t.Errorf("repository holds %d tasks, want %d", len(repo.tasks), calls)
+	}
+}
+
+func TestHandleAgentTaskStopsOnCancel(t *testing.T) {
+	tests := []struct {
+		name      string
+		cancelOn  int // StreamChat call after which ctx ends; 0 never
+		wantTasks int
+		wantTurns int
+	}{
+		{"not cancelled", 0, 1, 2},
+		{"cancelled during the tool-call turn", 1, 0, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			repo := &memTasks{}
+			chat := &cancellingChat{
+				ChatProvider: &scriptedChat{turns: [][]llm.Chunk{{toolCallChunk("buy milk")}, {{Kind: llm.KindText, Text: "Added."}}}},
+				n:            tt.cancelOn,
+				cancel:       cancel,
+			}
+			ch, err := NewTaskAgent(repo, chat).HandleAgentTask(ctx, "add buy milk", "u1", AgentOptions{ForceTask: true})
+			if err != nil {
+				t.Fatal(err)
+			}
+			for range ch {
+			}
+			if len(repo.tasks) != tt.wantTasks {
+				t.Errorf("created %d tasks, want %d", len(repo.tasks), tt.wantTasks)
+			}
+			if chat.calls != tt.wantTurns {
+				t.Errorf("model called %d times, want %d", chat.calls, tt.wantTurns)
+			}
+		})
+	}
+}
+
+func TestEmitLogsDroppedEvents(t *testing.T) {
+	tests := []struct {
+		name      string
+		event     AgentEvent
+		delivered bool     // a reader takes the event before the cancel
+		wantLog   []string // substrings of the log output; nil expects none
+	}{
+		{"delivered event", AgentEvent{Kind: EventToolDone, Tool: "create_task", TaskID: 42}, true, nil},
+		{"dropped tool_done", AgentEvent{Kind: EventToolDone, Tool: "create_task", TaskID: 42},
+			false, []string{"level=WARN", "kind=tool_done", "task_id=42", "cause=\"context canceled\""}},
+		{"dropped error", AgentEvent{Kind: EventError, ErrMsg: "db down"},
+			false, []string{"level=WARN", "kind=error", "error_msg=\"db down\""}},
+		{"dropped text", AgentEvent{Kind: EventText, Text: "Added."}, false, []string{"level=DEBUG", "kind=text"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logs := captureLogs(t)
+			ctx, cancel := context.WithCancel(context.Background())
+			ch := make(chan AgentEvent)
+
+			done := make(chan struct{})
+			go func() {
+				emit(ctx, ch, tt.event)
+				close(done)
+			}()
+			if tt.delivered {
+				<-ch
+			}
+			cancel()
+			<-done
+
+			out := logs.String()
+			if tt.wantLog == nil && out != "" {
+				t.Errorf("logged %q, want nothing", out)
+			}
+			for _, want := range tt.wantLog {
+				if !strings.Contains(out, want) {
+					t.Errorf("log %q does not contain %q", out, want)
+				}
+			}
+		})
+	}
+}
+
+func TestValidateCreateTaskArgsPriority(t *testing.T) {
+	tests := []struct {
+		name    string
+		args    string
+		want    int
+		wantErr bool
+	}{
+		{"integer", `{"title":"t","priority":2}`, db.PriorityHigh, false},
+		{"omitted uses default", `{"title":"t"}`, db.DefaultPriority, false},
+		{"quoted number", `{"title":"t","priority":"3"}`, db.PriorityUrgent, false},
+		{"name", `{"title":"t","priority":"Low"}`, db.PriorityLow, false},
+		{"above range", `{"title":"t","priority":4}`, 0, true},
+		{"below range", `{"title":"t","priority":-1}`, 0, true},
+		{"quoted out of range", `{"title":"t","priority":"9"}`, 0, true},
+		{"unknown name", `{"title":"t","priority":"whenever"}`, 0, true},
+		{"wrong type", `{"title":"t","priority":true}`, 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			args, err := validateCreateTaskArgs(json.RawMessage(tt.args))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("validateCreateTaskArgs(%s) err = %v, wantErr %v", tt.args, err, tt.wantErr)
+			}
+			if err == nil && args.priority() != tt.want {
+				t.Errorf("priority = %d, want %d", args.priority(), tt.want)
+			}
+		})
+	}
+}
+
+func TestHandleAgentTaskGenerationOptions(t *testing.T) {
+	warm := 0.8
+	tests := []struct {
+		name string
+		opts llm.Options
+		want string // JSON of the options sent on every turn
+	}{
+		{"deterministic by default", llm.Options{}, `{"temperature":0}`},
+		{"request temperature overrides", llm.Options{Temperature: &warm}, `{"temperature":0.8}`},
+		{"other fields kept alongside the default", llm.Options{NumPredict: 128}, `{"temperature":0,"num_predict":128}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			chat := &optionsChat{ChatProvider: &scriptedChat{turns: [][]llm.Chunk{{toolCallChunk("buy milk")}, {{Kind: llm.KindText, Text: "Added."}}}}}
+			ch, err := NewTaskAgent(&memTasks{}, chat).HandleAgentTask(context.Background(), "add buy milk", "u1", AgentOptions{ForceTask: true, Generation: tt.opts})
+			if err != nil {
+				t.Fatal(err)
+			}
+			for range ch {
+			}
+			if len(chat.opts) != 2 {
+				t.Fatalf("model called %d times, want 2", len(chat.opts))
+			}
+			for i, o := range chat.opts {
+				got, _ := json.Marshal(o)
+				if string(got) != tt.want {
+					t.Errorf("turn %d options = %s, want %s", i+1, got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestHandleAgentTaskSystemInstructions(t *testing.T) {
+	tests := []struct {
+		name         string
+		base         string // SetSystemPrompt value; "" keeps the default
+		instructions []string
+		want         string
+	}{
+		{"no instructions", "", nil, agentSystemPrompt},
+		{"blank instructions ignored", "", []string{"  ", ""}, agentSystemPrompt},
+		{"appended after the core prompt", "", []string{"Always reply in French."},
+			agentSystemPrompt + clientInstructionsHeader + "\nAlways reply in French."},
+		{"kept in order and trimmed", "", []string{" Use metric units. ", "Sign off as Ada."},
+			agentSystemPrompt + clientInstructionsHeader + "\nUse metric units.\nSign off as Ada."},
+		{"custom base prompt kept", "Be brief.", []string{"Reply in French."},
+			"Be brief." + clientInstructionsHeader + "\nReply in French."},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			chat := &recordingChat{ChatProvider: &scriptedChat{turns: [][]llm.Chunk{{{Kind: llm.KindText, Text: "Hello."}}}}}
+			ta := NewTaskAgent(&memTasks{}, chat)
+			if tt.base != "" {
+				ta.SetSystemPrompt(tt.base)
+			}
+			ch, err := ta.HandleAgentTask(context.Background(), "hello", "u1", AgentOptions{SystemInstructions: tt.instructions})
+			if err != nil {
+				t.Fatal(err)
+			}
+			for range ch {
+			}
+			if len(chat.systems) != 1 {
+				t.Fatalf("sent %d system messages, want 1", len(chat.systems))
+			}
+			if chat.systems[0] != tt.want {
+				t.Errorf("system prompt = %q, want %q", chat.systems[0], tt.want)
+			}
+		})
+	}
+}
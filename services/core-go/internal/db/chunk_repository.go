@@ -0,0 +1,105 @@
+package db
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// ChunkKey identifies one chunk of an ingested document.
+type ChunkKey struct {
+	DocumentID DocumentID
+	ChunkIndex int
+}
+
+// ChunkText is the text of one chunk, stored in the document_chunks table
+// when chunk text is kept in Postgres rather than in the Qdrant payload.
+type ChunkText struct {
+	ChunkKey
+	Text string
+}
+
+// ChunkRepository defines all operations on the document_chunks table.
+// Rows belong to a documents row and are removed with it (ON DELETE
+// CASCADE), so there is no delete method.
+type ChunkRepository interface {
+	// SaveChunks inserts chunks, replacing the text of any that already
+	// exist.
+	SaveChunks(ctx context.Context, chunks []ChunkText) error
+
+	// GetChunks returns the text of each key that exists. Missing keys are
+	// absent from the map rather than an error.
+	GetChunks(ctx context.Context, keys []ChunkKey) (map[ChunkKey]string, error)
+}
+
+type pgxChunkRepository struct {
+	pool *pgxpool.Pool
+}
+
+// NewChunkRepository returns a ChunkRepository backed by pool.
+func NewChunkRepository(pool *pgxpool.Pool) ChunkRepository {
+	return &pgxChunkRepository{pool: pool}
+}
+
+// SaveChunks writes every chunk in one statement by unnesting parallel
+// arrays.
+func (r *pgxChunkRepository) SaveChunks(ctx context.Context, chunks []ChunkText) error {
+	if len(chunks) == 0 {
+		return nil
+	}
+	const query = `
+		INSERT INTO document_chunks (document_id, chunk_index, text)
+		SELECT * FROM unnest($1::bigint[], $2::int[], $3::text[])
+		ON CONFLICT (document_id, chunk_index) DO UPDATE SET text = EXCLUDED.text`
+
+	ids := make([]int64, len(chunks))
+	indexes := make([]int32, len(chunks))
+	texts := make([]string, len(chunks))
+	for i, c := range chunks {
+		ids[i], indexes[i], texts[i] = int64(c.DocumentID), int32(c.ChunkIndex), c.Text
+	}
+	if _, err := r.pool.Exec(ctx, query, ids, indexes, texts); err != nil {
+		return fmt.Errorf("chunk_repository: save: %w", err)
+	}
+	return nil
+}
+
+// GetChunks reads every requested chunk in one query.
+func (r *pgxChunkRepository) GetChunks(ctx context.Context, keys []ChunkKey) (map[ChunkKey]string, error) {
+	out := make(map[ChunkKey]string, len(keys))
+	if len(keys) == 0 {
+		return out, nil
+	}
+	const query = `
+		SELECT c.document_id, c.chunk_index, c.text
+		FROM document_chunks c
+		JOIN unnest($1::bigint[], $2::int[]) AS k(document_id, chunk_index)
+		  ON c.document_id = k.document_id AND c.chunk_index = k.chunk_index`
+
+	ids := make([]int64, len(keys))
+	indexes := make([]int32, len(keys))
+	for i, k := range keys {
+		ids[i], indexes[i] = int64(k.DocumentID), int32(k.ChunkIndex)
+	}
+	rows, err := r.pool.Query(ctx, query, ids, indexes)
+	if err != nil {
+		return nil, fmt.Errorf("chunk_repository: get: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			k    ChunkKey
+			text string
+		)
+		if err := rows.Scan(&k.DocumentID, &k.ChunkIndex, &text); err != nil {
+			return nil, fmt.Errorf("chunk_repository: get scan: %w", err)
+		}
+		out[k] = text
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("chunk_repository: get rows: %w", err)
+	}
+	return out, nil
+}
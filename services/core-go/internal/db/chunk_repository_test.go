@@ -0,0 +1,64 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"testing"
+)
+
+func TestChunkRepository(t *testing.T) {
+	pool := newTestPool(t)
+	repo := NewChunkRepository(pool)
+	docs := NewDocumentRepository(pool)
+	ctx := context.Background()
+
+	doc, err := docs.RecordDocument(ctx, NewDocument{Source: "rome.md", UserID: "u1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := func(i int) ChunkKey { return ChunkKey{DocumentID: doc, ChunkIndex: i} }
+	if err := repo.SaveChunks(ctx, []ChunkText{
+		{ChunkKey: key(0), Text: "The Colosseum"},
+		{ChunkKey: key(1), Text: "is in Rome."},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	// Saving an existing chunk replaces its text.
+	if err := repo.SaveChunks(ctx, []ChunkText{{ChunkKey: key(1), Text: "is in Rome, Italy."}}); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		keys []ChunkKey
+		want map[ChunkKey]string
+	}{
+		{"no keys", nil, map[ChunkKey]string{}},
+		{"both chunks", []ChunkKey{key(0), key(1)}, map[ChunkKey]string{key(0): "The Colosseum", key(1): "is in Rome, Italy."}},
+		{"missing keys are absent", []ChunkKey{key(0), key(7), {DocumentID: doc + 100}}, map[ChunkKey]string{key(0): "The Colosseum"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := repo.GetChunks(ctx, tt.keys)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+				t.Errorf("GetChunks() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	t.Run("deleting the document removes its chunks", func(t *testing.T) {
+		if err := docs.DeleteDocument(ctx, doc, "u1"); err != nil {
+			t.Fatal(err)
+		}
+		got, err := repo.GetChunks(ctx, []ChunkKey{key(0), key(1)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(got) != 0 {
+			t.Errorf("GetChunks() after delete = %v, want none", got)
+		}
+	})
+}
@@ -0,0 +1,191 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// ErrConversationNotFound is returned when a conversation id does not exist
+// or is owned by a different user; like ErrTaskNotFound, the two cases are
+// indistinguishable.
+var ErrConversationNotFound = errors.New("conversation_repository: conversation not found")
+
+// ConversationID is the primary key type for the conversations table.
+type ConversationID int64
+
+// Conversation is a full row from the conversations table.
+type Conversation struct {
+	ID        ConversationID `json:"id"`
+	UserID    string         `json:"user_id"`
+	Title     string         `json:"title"`
+	CreatedAt time.Time      `json:"created_at"`
+	UpdatedAt time.Time      `json:"updated_at"`
+}
+
+// ChatMessage is a full row from the messages table.
+type ChatMessage struct {
+	ID             int64          `json:"id"`
+	ConversationID ConversationID `json:"conversation_id"`
+	Role           string         `json:"role"`
+	Content        string         `json:"content"`
+	CreatedAt      time.Time      `json:"created_at"`
+}
+
+// ConversationRepository defines all operations on the conversations and
+// messages tables. Every method is scoped to userID so users can only see
+// and extend their own conversations.
+type ConversationRepository interface {
+	// CreateConversation inserts a new conversation for userID and returns its ID.
+	CreateConversation(ctx context.Context, userID, title string) (ConversationID, error)
+
+	// ListConversations returns all conversations owned by userID, most
+	// recently active first.
+	ListConversations(ctx context.Context, userID string) ([]Conversation, error)
+
+	// AppendMessage adds one message to conversation id and bumps its
+	// updated_at. Returns ErrConversationNotFound if the conversation does
+	// not exist or userID does not match.
+	AppendMessage(ctx context.Context, id ConversationID, userID, role, content string) error
+
+	// ListMessages returns the messages of conversation id in the order they
+	// were written. Returns ErrConversationNotFound if the conversation does
+	// not exist or userID does not match.
+	ListMessages(ctx context.Context, id ConversationID, userID string) ([]ChatMessage, error)
+
+	// DeleteAllForUser removes every conversation owned by userID (messages
+	// cascade) and returns how many conversations were deleted.
+	DeleteAllForUser(ctx context.Context, userID string) (int64, error)
+}
+
+type pgxConversationRepository struct {
+	pool *pgxpool.Pool
+}
+
+// NewConversationRepository returns a ConversationRepository backed by a
+// pgxpool connection pool.
+func NewConversationRepository(pool *pgxpool.Pool) ConversationRepository {
+	return &pgxConversationRepository{pool: pool}
+}
+
+// CreateConversation inserts a new conversation row and returns its generated ID.
+func (r *pgxConversationRepository) CreateConversation(ctx context.Context, userID, title string) (ConversationID, error) {
+	const query = `
+		INSERT INTO conversations (user_id, title)
+		VALUES ($1, $2)
+		RETURNING id`
+
+	var id ConversationID
+	if err := r.pool.QueryRow(ctx, query, userID, title).Scan(&id); err != nil {
+		return 0, fmt.Errorf("conversation_repository: create: %w", err)
+	}
+	return id, nil
+}
+
+// ListConversations returns the user's conversations ordered by updated_at
+// descending so the most recently active appear first.
+func (r *pgxConversationRepository) ListConversations(ctx context.Context, userID string) ([]Conversation, error) {
+	const query = `
+		SELECT id, user_id, title, created_at, updated_at
+		FROM conversations
+		WHERE user_id = $1
+		ORDER BY updated_at DESC`
+
+	rows, err := r.pool.Query(ctx, query, userID)
+	if err != nil {
+		return nil, fmt.Errorf("conversation_repository: list: %w", err)
+	}
+	defer rows.Close()
+
+	var conversations []Conversation
+	for rows.Next() {
+		var c Conversation
+		if err := rows.Scan(&c.ID, &c.UserID, &c.Title, &c.CreatedAt, &c.UpdatedAt); err != nil {
+			return nil, fmt.Errorf("conversation_repository: list scan: %w", err)
+		}
+		conversations = append(conversations, c)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("conversation_repository: list rows: %w", err)
+	}
+	return conversations, nil
+}
+
+// AppendMessage inserts a message only when conversation id is owned by
+// userID. The ownership check, the updated_at bump, and the insert happen in
+// one statement so a foreign conversation ID can never be written to.
+func (r *pgxConversationRepository) AppendMessage(ctx context.Context, id ConversationID, userID, role, content string) error {
+	const query = `
+		WITH conv AS (
+			UPDATE conversations
+			SET    updated_at = NOW()
+			WHERE  id = $1 AND user_id = $2
+			RETURNING id
+		)
+		INSERT INTO messages (conversation_id, role, content)
+		SELECT id, $3, $4 FROM conv`
+
+	tag, err := r.pool.Exec(ctx, query, id, userID, role, content)
+	if err != nil {
+		return fmt.Errorf("conversation_repository: append: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return fmt.Errorf("conversation_repository: append: conversation %d: %w", id, ErrConversationNotFound)
+	}
+	return nil
+}
+
+// ListMessages returns the conversation's messages ordered oldest-first.
+// An existence check runs first so an unknown or foreign conversation is an
+// error rather than an empty list.
+func (r *pgxConversationRepository) ListMessages(ctx context.Context, id ConversationID, userID string) ([]ChatMessage, error) {
+	const ownerQuery = `SELECT EXISTS (SELECT 1 FROM conversations WHERE id = $1 AND user_id = $2)`
+
+	var owned bool
+	if err := r.pool.QueryRow(ctx, ownerQuery, id, userID).Scan(&owned); err != nil {
+		return nil, fmt.Errorf("conversation_repository: list_messages: %w", err)
+	}
+	if !owned {
+		return nil, fmt.Errorf("conversation_repository: list_messages: conversation %d: %w", id, ErrConversationNotFound)
+	}
+
+	const query = `
+		SELECT id, conversation_id, role, content, created_at
+		FROM messages
+		WHERE conversation_id = $1
+		ORDER BY id ASC`
+
+	rows, err := r.pool.Query(ctx, query, id)
+	if err != nil {
+		return nil, fmt.Errorf("conversation_repository: list_messages: %w", err)
+	}
+	defer rows.Close()
+
+	var messages []ChatMessage
+	for rows.Next() {
+		var m ChatMessage
+		if err := rows.Scan(&m.ID, &m.ConversationID, &m.Role, &m.Content, &m.CreatedAt); err != nil {
+			return nil, fmt.Errorf("conversation_repository: list_messages scan: %w", err)
+		}
+		messages = append(messages, m)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("conversation_repository: list_messages rows: %w", err)
+	}
+	return messages, nil
+}
+
+// DeleteAllForUser removes all of the user's conversations. Their messages
+// are removed by the ON DELETE CASCADE foreign key.
+func (r *pgxConversationRepository) DeleteAllForUser(ctx context.Context, userID string) (int64, error) {
+	const query = `DELETE FROM conversations WHERE user_id = $1`
+
+	tag, err := r.pool.Exec(ctx, query, userID)
+	if err != nil {
+		return 0, fmt.Errorf("conversation_repository: delete_all_for_user: %w", err)
+	}
+	return tag.RowsAffected(), nil
+}
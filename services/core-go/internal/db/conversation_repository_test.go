@@ -0,0 +1,69 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestConversationRepository(t *testing.T) {
+	repo := NewConversationRepository(newTestPool(t))
+	ctx := context.Background()
+
+	id, err := repo.CreateConversation(ctx, "u1", "Trip to Rome")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, m := range []struct{ role, content string }{{"user", "Where is the Colosseum?"}, {"assistant", "In Rome."}} {
+		if err := repo.AppendMessage(ctx, id, "u1", m.role, m.content); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name      string
+		id        ConversationID
+		userID    string
+		wantRoles []string
+		wantErr   error
+	}{
+		{"owner reads messages in order", id, "u1", []string{"user", "assistant"}, nil},
+		{"other user", id, "u2", nil, ErrConversationNotFound},
+		{"unknown conversation", id + 100, "u1", nil, ErrConversationNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := repo.AppendMessage(ctx, tt.id, tt.userID, "user", "again"); tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+				t.Errorf("AppendMessage() err = %v, want %v", err, tt.wantErr)
+			}
+			msgs, err := repo.ListMessages(ctx, tt.id, tt.userID)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("ListMessages() err = %v, want %v", err, tt.wantErr)
+			}
+			if tt.wantErr != nil {
+				return
+			}
+			// The turn appended above comes last.
+			wantRoles := append(tt.wantRoles, "user")
+			var roles []string
+			for _, m := range msgs {
+				roles = append(roles, m.Role)
+			}
+			if fmt.Sprint(roles) != fmt.Sprint(wantRoles) {
+				t.Errorf("message roles = %v, want %v", roles, wantRoles)
+			}
+		})
+	}
+
+	convs, err := repo.ListConversations(ctx, "u1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(convs) != 1 || convs[0].Title != "Trip to Rome" {
+		t.Errorf("ListConversations() = %+v, want the one conversation", convs)
+	}
+	if n, err := repo.DeleteAllForUser(ctx, "u1"); err != nil || n != 1 {
+		t.Errorf("DeleteAllForUser() = %d, %v; want 1", n, err)
+	}
+}
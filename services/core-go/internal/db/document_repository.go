@@ -0,0 +1,237 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// ErrDocumentNotFound is returned when a document id does not exist or is
+// owned by a different user, indistinguishably, as with ErrTaskNotFound.
+var ErrDocumentNotFound = errors.New("document_repository: document not found")
+
+// ErrNoStoredText is returned by GetSourceText when the document exists but
+// was recorded before ingested text was stored, so it cannot be re-chunked.
+var ErrNoStoredText = errors.New("document_repository: document text not stored")
+
+// DocumentID is the primary key type for the documents table.
+type DocumentID int64
+
+// Document is a full row from the documents table: the record of one
+// ingest. The chunks themselves live in Qdrant; ChunkCount is how many were
+// stored and ByteSize the length of the ingested text in bytes.
+type Document struct {
+	ID         DocumentID `json:"id"`
+	Source     string     `json:"source"`
+	UserID     string     `json:"user_id"`
+	ChunkCount int        `json:"chunk_count"`
+	ByteSize   int        `json:"byte_size"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+// NewDocument holds the fields for RecordDocument. ChunkCount may be 0 when
+// the row is recorded before ingest, so its ID can be stored on the chunks,
+// and filled in afterwards with SetChunkCount. Content, Title and URL are
+// kept so the document can be re-ingested later; they are not listed.
+type NewDocument struct {
+	Source     string
+	UserID     string
+	ChunkCount int
+	ByteSize   int
+	Content    string
+	Title      string
+	URL        string
+}
+
+// SourceText is the stored text of the most recent document ingested under
+// a source, with the citation fields it was ingested with.
+type SourceText struct {
+	ID      DocumentID
+	Content string
+	Title   string
+	URL     string
+}
+
+// DocumentRepository defines all operations on the documents table. It is
+// the authoritative list of what was ingested, so listing documents does
+// not have to scroll every chunk in Qdrant.
+type DocumentRepository interface {
+	// RecordDocument inserts a row for an ingest and returns its ID.
+	RecordDocument(ctx context.Context, d NewDocument) (DocumentID, error)
+
+	// SetChunkCount records how many chunks document id ended up with.
+	// Returns ErrDocumentNotFound if the document does not exist.
+	SetChunkCount(ctx context.Context, id DocumentID, chunks int) error
+
+	// ListDocuments returns every document owned by userID, newest first.
+	ListDocuments(ctx context.Context, userID string) ([]Document, error)
+
+	// DeleteDocument removes document id owned by userID. Returns
+	// ErrDocumentNotFound if it does not exist or userID does not match.
+	DeleteDocument(ctx context.Context, id DocumentID, userID string) error
+
+	// GetSourceText returns the stored text of userID's newest document
+	// labelled source. Returns ErrDocumentNotFound if there is none, and
+	// ErrNoStoredText if it predates stored text.
+	GetSourceText(ctx context.Context, userID, source string) (SourceText, error)
+
+	// DeleteBySource removes userID's documents labelled source and returns
+	// how many rows were deleted.
+	DeleteBySource(ctx context.Context, userID, source string) (int64, error)
+
+	// DeleteSourceExcept removes userID's documents labelled source other
+	// than keep and returns how many rows were deleted.
+	DeleteSourceExcept(ctx context.Context, userID, source string, keep DocumentID) (int64, error)
+
+	// DeleteAllForUser removes every document owned by userID and returns
+	// how many rows were deleted.
+	DeleteAllForUser(ctx context.Context, userID string) (int64, error)
+}
+
+type pgxDocumentRepository struct {
+	pool *pgxpool.Pool
+}
+
+// NewDocumentRepository returns a DocumentRepository backed by a pgxpool
+// connection pool.
+func NewDocumentRepository(pool *pgxpool.Pool) DocumentRepository {
+	return &pgxDocumentRepository{pool: pool}
+}
+
+// RecordDocument inserts a new documents row and returns its generated ID.
+func (r *pgxDocumentRepository) RecordDocument(ctx context.Context, d NewDocument) (DocumentID, error) {
+	const query = `
+		INSERT INTO documents (source, user_id, chunk_count, byte_size, content, title, url)
+		VALUES ($1, $2, $3, $4, NULLIF($5, ''), $6, $7)
+		RETURNING id`
+
+	var id DocumentID
+	if err := r.pool.QueryRow(ctx, query, d.Source, d.UserID, d.ChunkCount, d.ByteSize, d.Content, d.Title, d.URL).Scan(&id); err != nil {
+		return 0, fmt.Errorf("document_repository: record: %w", err)
+	}
+	return id, nil
+}
+
+// SetChunkCount updates the chunk_count of an existing row.
+func (r *pgxDocumentRepository) SetChunkCount(ctx context.Context, id DocumentID, chunks int) error {
+	const query = `UPDATE documents SET chunk_count = $1 WHERE id = $2`
+
+	tag, err := r.pool.Exec(ctx, query, chunks, id)
+	if err != nil {
+		return fmt.Errorf("document_repository: set_chunk_count: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrDocumentNotFound
+	}
+	return nil
+}
+
+// ListDocuments returns the user's documents ordered by created_at
+// descending so the most recent ingests appear first.
+func (r *pgxDocumentRepository) ListDocuments(ctx context.Context, userID string) ([]Document, error) {
+	const query = `
+		SELECT id, source, user_id, chunk_count, byte_size, created_at
+		FROM documents
+		WHERE user_id = $1
+		ORDER BY created_at DESC, id DESC`
+
+	rows, err := r.pool.Query(ctx, query, userID)
+	if err != nil {
+		return nil, fmt.Errorf("document_repository: list: %w", err)
+	}
+	defer rows.Close()
+
+	var docs []Document
+	for rows.Next() {
+		var d Document
+		if err := rows.Scan(&d.ID, &d.Source, &d.UserID, &d.ChunkCount, &d.ByteSize, &d.CreatedAt); err != nil {
+			return nil, fmt.Errorf("document_repository: list scan: %w", err)
+		}
+		docs = append(docs, d)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("document_repository: list rows: %w", err)
+	}
+	return docs, nil
+}
+
+// DeleteDocument removes one row, scoped to userID so users can only delete
+// their own documents.
+func (r *pgxDocumentRepository) DeleteDocument(ctx context.Context, id DocumentID, userID string) error {
+	const query = `DELETE FROM documents WHERE id = $1 AND user_id = $2`
+
+	tag, err := r.pool.Exec(ctx, query, id, userID)
+	if err != nil {
+		return fmt.Errorf("document_repository: delete: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrDocumentNotFound
+	}
+	return nil
+}
+
+// GetSourceText reads the newest row for source, scoped to userID.
+func (r *pgxDocumentRepository) GetSourceText(ctx context.Context, userID, source string) (SourceText, error) {
+	const query = `
+		SELECT id, content, title, url
+		FROM documents
+		WHERE user_id = $1 AND source = $2
+		ORDER BY created_at DESC, id DESC
+		LIMIT 1`
+
+	var (
+		t       SourceText
+		content *string
+	)
+	err := r.pool.QueryRow(ctx, query, userID, source).Scan(&t.ID, &content, &t.Title, &t.URL)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return SourceText{}, ErrDocumentNotFound
+	}
+	if err != nil {
+		return SourceText{}, fmt.Errorf("document_repository: get_source_text: %w", err)
+	}
+	if content == nil {
+		return SourceText{}, ErrNoStoredText
+	}
+	t.Content = *content
+	return t, nil
+}
+
+// DeleteBySource removes the user's rows for source. Deleting zero rows is
+// not an error — documents ingested before the table existed have none.
+func (r *pgxDocumentRepository) DeleteBySource(ctx context.Context, userID, source string) (int64, error) {
+	const query = `DELETE FROM documents WHERE user_id = $1 AND source = $2`
+
+	tag, err := r.pool.Exec(ctx, query, userID, source)
+	if err != nil {
+		return 0, fmt.Errorf("document_repository: delete_by_source: %w", err)
+	}
+	return tag.RowsAffected(), nil
+}
+
+// DeleteSourceExcept removes the user's rows for source apart from keep.
+func (r *pgxDocumentRepository) DeleteSourceExcept(ctx context.Context, userID, source string, keep DocumentID) (int64, error) {
+	const query = `DELETE FROM documents WHERE user_id = $1 AND source = $2 AND id <> $3`
+
+	tag, err := r.pool.Exec(ctx, query, userID, source, keep)
+	if err != nil {
+		return 0, fmt.Errorf("document_repository: delete_source_except: %w", err)
+	}
+	return tag.RowsAffected(), nil
+}
+
+// DeleteAllForUser removes every document row owned by userID in one
+// statement.
+func (r *pgxDocumentRepository) DeleteAllForUser(ctx context.Context, userID string) (int64, error) {
+	const query = `DELETE FROM documents WHERE user_id = $1`
+
+	tag, err := r.pool.Exec(ctx, query, userID)
+	if err != nil {
+		return 0, fmt.Errorf("document_repository: delete_all_for_user: %w", err)
+	}
+	return tag.RowsAffected(), nil
+}
@@ -0,0 +1,75 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestDocumentRepository(t *testing.T) {
+	repo := NewDocumentRepository(newTestPool(t))
+	ctx := context.Background()
+
+	record := func(source, userID string) DocumentID {
+		t.Helper()
+		id, err := repo.RecordDocument(ctx, NewDocument{Source: source, UserID: userID, ByteSize: len(source), Content: "text of " + source})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return id
+	}
+	older := record("notes.md", "u1")
+	newer := record("rome.md", "u1")
+	record("other.md", "u2")
+	if err := repo.SetChunkCount(ctx, newer, 3); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.SetChunkCount(ctx, newer+100, 3); !errors.Is(err, ErrDocumentNotFound) {
+		t.Errorf("SetChunkCount(unknown) err = %v, want ErrDocumentNotFound", err)
+	}
+
+	t.Run("list is scoped and newest first", func(t *testing.T) {
+		docs, err := repo.ListDocuments(ctx, "u1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for _, d := range docs {
+			got = append(got, fmt.Sprintf("%s:%d:%d", d.Source, d.ChunkCount, d.ByteSize))
+			if d.UserID != "u1" || d.CreatedAt.IsZero() {
+				t.Errorf("document %+v: want user u1 and a created_at", d)
+			}
+		}
+		if want := []string{"rome.md:3:7", "notes.md:0:8"}; fmt.Sprint(got) != fmt.Sprint(want) {
+			t.Errorf("ListDocuments() = %v, want %v", got, want)
+		}
+	})
+
+	tests := []struct {
+		name      string
+		id        DocumentID
+		userID    string
+		wantErr   error
+		wantCount int // u1's documents afterwards
+	}{
+		{"other user cannot delete", older, "u2", ErrDocumentNotFound, 2},
+		{"unknown document", newer + 100, "u1", ErrDocumentNotFound, 2},
+		{"owner deletes", older, "u1", nil, 1},
+		{"already deleted", older, "u1", ErrDocumentNotFound, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := repo.DeleteDocument(ctx, tt.id, tt.userID); !errors.Is(err, tt.wantErr) {
+				t.Fatalf("DeleteDocument() err = %v, want %v", err, tt.wantErr)
+			}
+			docs, err := repo.ListDocuments(ctx, "u1")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(docs) != tt.wantCount {
+				t.Errorf("u1 has %d documents, want %d", len(docs), tt.wantCount)
+			}
+		})
+	}
+}
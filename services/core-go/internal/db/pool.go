@@ -3,21 +3,29 @@ package db
 import (
 	"context"
 	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/jackc/pgx/v5/pgxpool"
 )
 
+const (
+	defaultMinConns = 2
+	defaultMaxConns = 10
+)
+
 // NewPool creates a pgxpool connection pool, verifies connectivity with Ping,
 // and returns the pool ready to use. Caller is responsible for calling pool.Close().
+//
+// Pool sizing is read from the environment (see PoolConfig).
 func NewPool(ctx context.Context, dsn string) (*pgxpool.Pool, error) {
-	cfg, err := pgxpool.ParseConfig(dsn)
+	cfg, err := PoolConfig(dsn)
 	if err != nil {
-		return nil, fmt.Errorf("db: parse dsn: %w", err)
+		return nil, err
 	}
 
-	cfg.MinConns = 2
-	cfg.MaxConns = 10
-
 	pool, err := pgxpool.NewWithConfig(ctx, cfg)
 	if err != nil {
 		return nil, fmt.Errorf("db: create pool: %w", err)
@@ -30,3 +38,108 @@ func NewPool(ctx context.Context, dsn string) (*pgxpool.Pool, error) {
 
 	return pool, nil
 }
+
+// PoolConfig parses dsn and applies pool tuning from the environment:
+//
+//	DB_MIN_CONNS          minimum idle connections (default 2)
+//	DB_MAX_CONNS          maximum open connections (default 10)
+//	DB_MAX_CONN_LIFETIME  Go duration after which a connection is recycled
+//	                      (default: pgx's own, one hour)
+//
+// Invalid values, or a minimum above the maximum, are an error rather than
+// silently falling back so a typo never ships an unintended pool size.
+func PoolConfig(dsn string) (*pgxpool.Config, error) {
+	cfg, err := pgxpool.ParseConfig(dsn)
+	if err != nil {
+		return nil, fmt.Errorf("db: parse dsn: %w", err)
+	}
+
+	minConns, err := envInt32("DB_MIN_CONNS", defaultMinConns)
+	if err != nil {
+		return nil, err
+	}
+	maxConns, err := envInt32("DB_MAX_CONNS", defaultMaxConns)
+	if err != nil {
+		return nil, err
+	}
+	if maxConns < 1 {
+		return nil, fmt.Errorf("db: DB_MAX_CONNS must be at least 1, got %d", maxConns)
+	}
+	if minConns > maxConns {
+		return nil, fmt.Errorf("db: DB_MIN_CONNS (%d) exceeds DB_MAX_CONNS (%d)", minConns, maxConns)
+	}
+	cfg.MinConns = minConns
+	cfg.MaxConns = maxConns
+
+	if raw := strings.TrimSpace(os.Getenv("DB_MAX_CONN_LIFETIME")); raw != "" {
+		d, err := time.ParseDuration(raw)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("db: invalid DB_MAX_CONN_LIFETIME %q", raw)
+		}
+		cfg.MaxConnLifetime = d
+	}
+
+	return cfg, nil
+}
+
+// envInt32 reads a non-negative int32 from key, returning fallback when unset.
+func envInt32(key string, fallback int32) (int32, error) {
+	raw := strings.TrimSpace(os.Getenv(key))
+	if raw == "" {
+		return fallback, nil
+	}
+	n, err := strconv.ParseInt(raw, 10, 32)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("db: invalid %s %q", key, raw)
+	}
+	return int32(n), nil
+}
+
+// Health is a point-in-time view of database reachability and pool usage.
+type Health struct {
+	Latency   time.Duration
+	OpenConns int32
+	IdleConns int32
+}
+
+// HealthSource is the part of a connection pool CheckHealth uses, so the
+// check can run against a fake in tests. PoolHealth adapts a *pgxpool.Pool.
+type HealthSource interface {
+	// Ping makes a round trip to the database.
+	Ping(ctx context.Context) error
+	// ConnCounts returns the pool's open and idle connection counts.
+	ConnCounts() (open, idle int32)
+}
+
+// PoolHealth returns pool as a HealthSource.
+func PoolHealth(pool *pgxpool.Pool) HealthSource {
+	return poolHealth{pool}
+}
+
+type poolHealth struct {
+	*pgxpool.Pool
+}
+
+func (p poolHealth) ConnCounts() (open, idle int32) {
+	stat := p.Stat()
+	return stat.TotalConns(), stat.IdleConns()
+}
+
+// CheckHealth pings the database and reports how long the round trip took
+// alongside the pool's connection counts. The counts are filled in even when
+// the ping fails, since they help explain the failure.
+func CheckHealth(ctx context.Context, pool HealthSource) (Health, error) {
+	start := time.Now()
+	err := pool.Ping(ctx)
+
+	open, idle := pool.ConnCounts()
+	h := Health{
+		Latency:   time.Since(start),
+		OpenConns: open,
+		IdleConns: idle,
+	}
+	if err != nil {
+		return h, fmt.Errorf("db: health: %w", err)
+	}
+	return h, nil
+}
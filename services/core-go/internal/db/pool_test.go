@@ -0,0 +1,86 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// fakePool is a HealthSource with a fixed ping result and connection counts.
+type fakePool struct {
+	pingErr    error
+	open, idle int32
+}
+
+func (f fakePool) Ping(context.Context) error     { return f.pingErr }
+func (f fakePool) ConnCounts() (open, idle int32) { return f.open, f.idle }
+
+func TestCheckHealth(t *testing.T) {
+	down := errors.New("connection refused")
+	tests := []struct {
+		name    string
+		pool    fakePool
+		wantErr error
+	}{
+		{"healthy", fakePool{open: 4, idle: 3}, nil},
+		{"ping fails", fakePool{pingErr: down, open: 10, idle: 0}, down},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h, err := CheckHealth(context.Background(), tt.pool)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("CheckHealth() err = %v, want %v", err, tt.wantErr)
+			}
+			// Counts are reported even when the ping fails.
+			if h.OpenConns != tt.pool.open || h.IdleConns != tt.pool.idle {
+				t.Errorf("CheckHealth() conns = %d open/%d idle, want %d/%d", h.OpenConns, h.IdleConns, tt.pool.open, tt.pool.idle)
+			}
+		})
+	}
+}
+
+func TestPoolConfig(t *testing.T) {
+	const dsn = "postgres://u:p@localhost:5432/db"
+	tests := []struct {
+		name         string
+		min, max     string
+		lifetime     string
+		wantMin      int32
+		wantMax      int32
+		wantLifetime time.Duration // 0 keeps pgx's default
+		wantErr      bool
+	}{
+		{"defaults", "", "", "", defaultMinConns, defaultMaxConns, 0, false},
+		{"raised for load", "5", "50", "30m", 5, 50, 30 * time.Minute, false},
+		{"lowered for tests", "0", "1", "", 0, 1, 0, false},
+		{"min equals max", "4", "4", "", 4, 4, 0, false},
+		{"min above max", "8", "4", "", 0, 0, 0, true},
+		{"min above default max", "20", "", "", 0, 0, 0, true},
+		{"zero max", "0", "0", "", 0, 0, 0, true},
+		{"negative", "-1", "", "", 0, 0, 0, true},
+		{"not a number", "", "lots", "", 0, 0, 0, true},
+		{"bad lifetime", "", "", "1 hour", 0, 0, 0, true},
+		{"non-positive lifetime", "", "", "0s", 0, 0, 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("DB_MIN_CONNS", tt.min)
+			t.Setenv("DB_MAX_CONNS", tt.max)
+			t.Setenv("DB_MAX_CONN_LIFETIME", tt.lifetime)
+			cfg, err := PoolConfig(dsn)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("PoolConfig() err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if cfg.MinConns != tt.wantMin || cfg.MaxConns != tt.wantMax {
+				t.Errorf("PoolConfig() conns = %d..%d, want %d..%d", cfg.MinConns, cfg.MaxConns, tt.wantMin, tt.wantMax)
+			}
+			if tt.wantLifetime != 0 && cfg.MaxConnLifetime != tt.wantLifetime {
+				t.Errorf("PoolConfig() MaxConnLifetime = %s, want %s", cfg.MaxConnLifetime, tt.wantLifetime)
+			}
+		})
+	}
+}
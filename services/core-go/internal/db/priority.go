@@ -0,0 +1,47 @@
+package db
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Task priorities, stored in tasks.priority as a SMALLINT. Higher is more
+// urgent.
+const (
+	PriorityLow    = 0
+	PriorityMedium = 1
+	PriorityHigh   = 2
+	PriorityUrgent = 3
+)
+
+// DefaultPriority is applied when a task is created without one.
+const DefaultPriority = PriorityMedium
+
+// priorityNames is indexed by priority value.
+var priorityNames = [...]string{"low", "medium", "high", "urgent"}
+
+// ValidPriority reports whether p is one of the known priorities.
+func ValidPriority(p int) bool {
+	return p >= PriorityLow && p <= PriorityUrgent
+}
+
+// PriorityFromString parses a priority name ("low" … "urgent"),
+// case-insensitively.
+func PriorityFromString(s string) (int, error) {
+	name := strings.ToLower(strings.TrimSpace(s))
+	for p, n := range priorityNames {
+		if n == name {
+			return p, nil
+		}
+	}
+	return 0, fmt.Errorf("task_repository: unknown priority %q", s)
+}
+
+// PriorityString returns the display name of p, or its number when p is out
+// of range.
+func PriorityString(p int) string {
+	if !ValidPriority(p) {
+		return fmt.Sprintf("priority(%d)", p)
+	}
+	return priorityNames[p]
+}
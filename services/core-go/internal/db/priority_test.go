@@ -0,0 +1,59 @@
+package db
+
+import "testing"
+
+func TestPriorityNames(t *testing.T) {
+	tests := []struct {
+		name     string
+		in       string
+		want     int
+		wantErr  bool
+		wantName string // PriorityString(want)
+	}{
+		{"low", "low", PriorityLow, false, "low"},
+		{"medium", "Medium", PriorityMedium, false, "medium"},
+		{"high", " HIGH ", PriorityHigh, false, "high"},
+		{"urgent", "urgent", PriorityUrgent, false, "urgent"},
+		{"unknown name", "critical", 0, true, ""},
+		{"number is not a name", "2", 0, true, ""},
+		{"empty", "", 0, true, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := PriorityFromString(tt.in)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("PriorityFromString(%q) err = %v, wantErr %v", tt.in, err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if got != tt.want {
+				t.Errorf("PriorityFromString(%q) = %d, want %d", tt.in, got, tt.want)
+			}
+			if name := PriorityString(got); name != tt.wantName {
+				t.Errorf("PriorityString(%d) = %q, want %q", got, name, tt.wantName)
+			}
+		})
+	}
+}
+
+func TestValidPriority(t *testing.T) {
+	tests := []struct {
+		p        int
+		want     bool
+		wantName string
+	}{
+		{-1, false, "priority(-1)"},
+		{0, true, "low"},
+		{3, true, "urgent"},
+		{4, false, "priority(4)"},
+	}
+	for _, tt := range tests {
+		if got := ValidPriority(tt.p); got != tt.want {
+			t.Errorf("ValidPriority(%d) = %v, want %v", tt.p, got, tt.want)
+		}
+		if got := PriorityString(tt.p); got != tt.wantName {
+			t.Errorf("PriorityString(%d) = %q, want %q", tt.p, got, tt.wantName)
+		}
+	}
+}
@@ -0,0 +1,50 @@
+package db
+
+import (
+	"fmt"
+	"time"
+)
+
+// Recurrence values accepted for tasks.recurrence. An empty recurrence means
+// the task is one-off.
+const (
+	RecurrenceDaily   = "daily"
+	RecurrenceWeekly  = "weekly"
+	RecurrenceMonthly = "monthly"
+)
+
+// ValidRecurrence reports whether r is "" or one of the known recurrences.
+func ValidRecurrence(r string) bool {
+	switch r {
+	case "", RecurrenceDaily, RecurrenceWeekly, RecurrenceMonthly:
+		return true
+	}
+	return false
+}
+
+// NextDueDate returns the first occurrence of recurrence after from that is
+// also after now. from is the previous due date (or completion time when the
+// task had none); stepping past now means a task completed late does not
+// spawn an occurrence that is already overdue.
+func NextDueDate(recurrence string, from, now time.Time) (time.Time, error) {
+	step := func(t time.Time) time.Time {
+		switch recurrence {
+		case RecurrenceDaily:
+			return t.AddDate(0, 0, 1)
+		case RecurrenceWeekly:
+			return t.AddDate(0, 0, 7)
+		default:
+			return t.AddDate(0, 1, 0)
+		}
+	}
+
+	if recurrence == "" || !ValidRecurrence(recurrence) {
+		return time.Time{}, fmt.Errorf("task_repository: unknown recurrence %q", recurrence)
+	}
+
+	next := step(from)
+	for !next.After(now) {
+		next = step(next)
+	}
+	return next, nil
+}
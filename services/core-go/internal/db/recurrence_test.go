@@ -0,0 +1,50 @@
+package db
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNextDueDate(t *testing.T) {
+	day := func(s string) time.Time {
+		d, err := time.Parse("2006-01-02", s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return d
+	}
+	now := day("2026-03-10")
+	tests := []struct {
+		name       string
+		recurrence string
+		from       string
+		want       string // "" expects an error
+	}{
+		{"daily", RecurrenceDaily, "2026-03-10", "2026-03-11"},
+		{"weekly", RecurrenceWeekly, "2026-03-09", "2026-03-16"},
+		{"monthly", RecurrenceMonthly, "2026-03-05", "2026-04-05"},
+		{"daily catches up to now", RecurrenceDaily, "2026-03-01", "2026-03-11"},
+		{"weekly catches up to now", RecurrenceWeekly, "2026-02-02", "2026-03-16"},
+		{"monthly catches up to now", RecurrenceMonthly, "2025-12-15", "2026-03-15"},
+		{"future due date steps once", RecurrenceWeekly, "2026-04-01", "2026-04-08"},
+		{"one-off task", "", "2026-03-10", ""},
+		{"unknown recurrence", "yearly", "2026-03-10", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := NextDueDate(tt.recurrence, day(tt.from), now)
+			if tt.want == "" {
+				if err == nil {
+					t.Fatalf("NextDueDate() = %s, want an error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !got.Equal(day(tt.want)) {
+				t.Errorf("NextDueDate(%q, %s) = %s, want %s", tt.recurrence, tt.from, got.Format("2006-01-02"), tt.want)
+			}
+		})
+	}
+}
@@ -2,79 +2,262 @@ package db
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"strings"
 	"time"
 
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/jackc/pgx/v5/pgxpool"
 )
 
+// ErrTaskNotFound is returned when a task id does not exist or is owned by a
+// different user. The two cases are deliberately indistinguishable so task
+// IDs cannot be probed across users.
+var ErrTaskNotFound = errors.New("task_repository: task not found")
+
+// ErrEmptyTaskUpdate is returned by UpdateTask when no field is set.
+var ErrEmptyTaskUpdate = errors.New("task_repository: update has no fields")
+
+// ErrNotRecurring is returned by NextOccurrence when the task has no
+// recurrence or is not yet done.
+var ErrNotRecurring = errors.New("task_repository: task is not a completed recurring task")
+
 // TaskID is the primary key type for the tasks table.
 type TaskID int64
 
 // Task is a full row from the tasks table, returned by ListTasks.
+// Recurrence is "" for one-off tasks; DueAt is nil when no due date is set.
 type Task struct {
-	ID          TaskID    `json:"id"`
-	Title       string    `json:"title"`
-	Description string    `json:"description"`
-	Priority    string    `json:"priority"`
-	Status      string    `json:"status"`
-	UserID      string    `json:"user_id"`
-	CreatedAt   time.Time `json:"created_at"`
+	ID          TaskID     `json:"id"`
+	Title       string     `json:"title"`
+	Description string     `json:"description"`
+	Priority    int        `json:"priority"`
+	Status      string     `json:"status"`
+	Recurrence  string     `json:"recurrence,omitempty"`
+	DueAt       *time.Time `json:"due_at,omitempty"`
+	UserID      string     `json:"user_id"`
+	CreatedAt   time.Time  `json:"created_at"`
+}
+
+// taskColumns is the SELECT/RETURNING list matching scanTask.
+const taskColumns = `id, title, COALESCE(description, ''), priority, status, COALESCE(recurrence, ''), due_at, user_id, created_at`
+
+// scanTask reads one row selected with taskColumns.
+func scanTask(row pgx.Row) (Task, error) {
+	var t Task
+	err := row.Scan(&t.ID, &t.Title, &t.Description, &t.Priority, &t.Status, &t.Recurrence, &t.DueAt, &t.UserID, &t.CreatedAt)
+	return t, err
+}
+
+// NewTask holds the fields for CreateTask. Recurrence is "" for a one-off
+// task or one of the Recurrence* values. IdempotencyKey is optional; when
+// set, a second CreateTask with the same key for the same user returns the
+// first task's ID instead of inserting. DueAt is optional. Status is ""
+// for a new pending task; imports set it to carry a task's state over.
+type NewTask struct {
+	Title          string
+	Description    string
+	Priority       int
+	Status         string
+	Recurrence     string
+	DueAt          *time.Time
+	UserID         string
+	IdempotencyKey string
+}
+
+// TaskUpdate is a partial update for UpdateTask. Only non-nil fields are
+// written; nil fields keep their current value.
+type TaskUpdate struct {
+	Title       *string
+	Description *string
+	Priority    *int
+	Status      *string
+}
+
+// empty reports whether u sets no fields.
+func (u TaskUpdate) empty() bool {
+	return u.Title == nil && u.Description == nil && u.Priority == nil && u.Status == nil
 }
 
 // TaskRepository defines all operations on the tasks table.
-// priority is a VARCHAR string ("low", "medium", "high") matching init.sql.
+// priority is a SMALLINT 0–3 (see the Priority* constants) matching init.sql.
 // status is a VARCHAR string ("pending", "in_progress", "done").
 type TaskRepository interface {
-	// CreateTask inserts a new task row for userID and returns its generated ID.
-	CreateTask(ctx context.Context, title, description, priority, userID string) (TaskID, error)
+	// CreateTask inserts a new task row for t.UserID and returns its
+	// generated ID, or the existing ID when t.IdempotencyKey was seen before.
+	CreateTask(ctx context.Context, t NewTask) (TaskID, error)
+
+	// CreateTasks inserts every task in one transaction and returns their
+	// IDs in input order. If any insert fails, none are kept.
+	CreateTasks(ctx context.Context, tasks []NewTask) ([]TaskID, error)
+
+	// GetTask returns task id owned by userID. Returns ErrTaskNotFound if the
+	// task does not exist or userID does not match.
+	GetTask(ctx context.Context, id TaskID, userID string) (Task, error)
 
 	// ListTasks returns all tasks owned by userID, ordered newest-first.
 	ListTasks(ctx context.Context, userID string) ([]Task, error)
 
+	// EachTask calls fn for every task owned by userID, oldest first, as rows
+	// arrive from the database, so the full set is never held in memory.
+	// Iteration stops at the first error from fn, which EachTask returns.
+	EachTask(ctx context.Context, userID string, fn func(Task) error) error
+
 	// UpdateTaskStatus changes the status of task id, scoped to userID.
 	// Returns an error if the task does not exist or userID does not match.
 	UpdateTaskStatus(ctx context.Context, id TaskID, userID, status string) error
 
+	// UpdateManyStatus sets status on every task in ids owned by userID, in
+	// one statement, and returns how many rows were updated. IDs that do not
+	// exist or belong to another user are skipped, not an error.
+	UpdateManyStatus(ctx context.Context, ids []TaskID, userID, status string) (int64, error)
+
+	// UpdateTask applies the non-nil fields of fields to task id, scoped to
+	// userID, and returns the updated row. Returns ErrEmptyTaskUpdate when no
+	// field is set and ErrTaskNotFound if the task does not exist or userID
+	// does not match.
+	UpdateTask(ctx context.Context, id TaskID, userID string, fields TaskUpdate) (Task, error)
+
 	// DeleteTask removes task id owned by userID.
 	// Returns an error if the task does not exist or userID does not match.
 	DeleteTask(ctx context.Context, id TaskID, userID string) error
+
+	// DeleteAllForUser removes every task owned by userID and returns how
+	// many rows were deleted.
+	DeleteAllForUser(ctx context.Context, userID string) (int64, error)
+
+	// NextOccurrence clones completed recurring task id into a new pending
+	// task due at the next occurrence and returns the clone. The recurrence
+	// moves to the clone so the same task is never cloned twice. Returns
+	// ErrTaskNotFound for unknown/foreign tasks and ErrNotRecurring when the
+	// task is not done or not recurring.
+	NextOccurrence(ctx context.Context, id TaskID, userID string) (Task, error)
+
+	// CountByStatus returns the number of tasks owned by userID per status.
+	// Every known status is present in the map, with 0 when it has no tasks.
+	CountByStatus(ctx context.Context, userID string) (map[string]int, error)
+
+	// WithTx runs fn with a repository whose operations all share one
+	// transaction. The transaction commits when fn returns nil and rolls
+	// back when it returns an error, which WithTx returns unchanged.
+	// Calling WithTx on the repository passed to fn opens a savepoint.
+	WithTx(ctx context.Context, fn func(TaskRepository) error) error
+}
+
+// taskStatuses is the full status lifecycle from init.sql. CountByStatus
+// seeds its result with these so the response shape is stable.
+var taskStatuses = []string{"pending", "in_progress", "done"}
+
+// dbtx is the subset of *pgxpool.Pool and pgx.Tx the repository uses, so
+// the same methods run either directly on the pool or inside WithTx. On a
+// pgx.Tx, Begin opens a savepoint, so methods that need their own
+// transaction still work when nested.
+type dbtx interface {
+	Begin(ctx context.Context) (pgx.Tx, error)
+	Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error)
+	Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error)
+	QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
 }
 
 type pgxTaskRepository struct {
-	pool *pgxpool.Pool
+	db dbtx
 }
 
 // NewTaskRepository returns a TaskRepository backed by a pgxpool connection pool.
 func NewTaskRepository(pool *pgxpool.Pool) TaskRepository {
-	return &pgxTaskRepository{pool: pool}
+	return &pgxTaskRepository{db: pool}
+}
+
+// WithTx begins a transaction (or a savepoint when r is already inside
+// one) and hands fn a repository bound to it. The deferred Rollback undoes
+// everything fn did if it fails; after Commit it is a no-op.
+func (r *pgxTaskRepository) WithTx(ctx context.Context, fn func(TaskRepository) error) error {
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("task_repository: with_tx: begin: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	if err := fn(&pgxTaskRepository{db: tx}); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("task_repository: with_tx: commit: %w", err)
+	}
+	return nil
 }
 
 // CreateTask inserts a new task row and returns its generated ID.
 // Uses a parameterized query with RETURNING to avoid a separate SELECT round-trip.
-func (r *pgxTaskRepository) CreateTask(ctx context.Context, title, description, priority, userID string) (TaskID, error) {
+// An idempotency-key conflict turns into a no-op update so RETURNING still
+// yields the original row's ID in the same round-trip.
+func (r *pgxTaskRepository) CreateTask(ctx context.Context, t NewTask) (TaskID, error) {
 	const query = `
-		INSERT INTO tasks (title, description, priority, user_id)
-		VALUES ($1, $2, $3, $4)
+		INSERT INTO tasks (title, description, priority, recurrence, due_at, user_id, idempotency_key, status)
+		VALUES ($1, $2, $3, NULLIF($4, ''), $5, $6, NULLIF($7, ''), COALESCE(NULLIF($8, ''), 'pending'))
+		ON CONFLICT (user_id, idempotency_key) WHERE idempotency_key IS NOT NULL
+		DO UPDATE SET idempotency_key = EXCLUDED.idempotency_key
 		RETURNING id`
 
 	var id TaskID
-	if err := r.pool.QueryRow(ctx, query, title, description, priority, userID).Scan(&id); err != nil {
+	err := r.db.QueryRow(ctx, query, t.Title, t.Description, t.Priority, t.Recurrence, t.DueAt, t.UserID, t.IdempotencyKey, t.Status).Scan(&id)
+	if err != nil {
 		return 0, fmt.Errorf("task_repository: create: %w", err)
 	}
 	return id, nil
 }
 
+// CreateTasks inserts the tasks inside a single transaction, so a failed
+// insert leaves none of the earlier ones behind.
+func (r *pgxTaskRepository) CreateTasks(ctx context.Context, tasks []NewTask) ([]TaskID, error) {
+	ids := make([]TaskID, 0, len(tasks))
+	err := r.WithTx(ctx, func(tx TaskRepository) error {
+		for i, t := range tasks {
+			id, err := tx.CreateTask(ctx, t)
+			if err != nil {
+				return fmt.Errorf("task_repository: create_batch: task %d: %w", i, err)
+			}
+			ids = append(ids, id)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return ids, nil
+}
+
+// GetTask fetches a single task, scoped to userID so users can only read
+// their own tasks.
+func (r *pgxTaskRepository) GetTask(ctx context.Context, id TaskID, userID string) (Task, error) {
+	const query = `
+		SELECT ` + taskColumns + `
+		FROM tasks
+		WHERE id = $1 AND user_id = $2`
+
+	t, err := scanTask(r.db.QueryRow(ctx, query, id, userID))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return Task{}, ErrTaskNotFound
+	}
+	if err != nil {
+		return Task{}, fmt.Errorf("task_repository: get: %w", err)
+	}
+	return t, nil
+}
+
 // ListTasks returns all tasks for userID ordered by created_at descending
 // so the most recently created tasks appear first.
 func (r *pgxTaskRepository) ListTasks(ctx context.Context, userID string) ([]Task, error) {
 	const query = `
-		SELECT id, title, description, priority, status, user_id, created_at
+		SELECT ` + taskColumns + `
 		FROM tasks
 		WHERE user_id = $1
 		ORDER BY created_at DESC`
 
-	rows, err := r.pool.Query(ctx, query, userID)
+	rows, err := r.db.Query(ctx, query, userID)
 	if err != nil {
 		return nil, fmt.Errorf("task_repository: list: %w", err)
 	}
@@ -82,8 +265,8 @@ func (r *pgxTaskRepository) ListTasks(ctx context.Context, userID string) ([]Tas
 
 	var tasks []Task
 	for rows.Next() {
-		var t Task
-		if err := rows.Scan(&t.ID, &t.Title, &t.Description, &t.Priority, &t.Status, &t.UserID, &t.CreatedAt); err != nil {
+		t, err := scanTask(rows)
+		if err != nil {
 			return nil, fmt.Errorf("task_repository: list scan: %w", err)
 		}
 		tasks = append(tasks, t)
@@ -94,6 +277,37 @@ func (r *pgxTaskRepository) ListTasks(ctx context.Context, userID string) ([]Tas
 	return tasks, nil
 }
 
+// EachTask streams the user's tasks ordered by id so an export lists them
+// in creation order. rows.Close in the defer releases the connection if fn
+// stops early.
+func (r *pgxTaskRepository) EachTask(ctx context.Context, userID string, fn func(Task) error) error {
+	const query = `
+		SELECT ` + taskColumns + `
+		FROM tasks
+		WHERE user_id = $1
+		ORDER BY id ASC`
+
+	rows, err := r.db.Query(ctx, query, userID)
+	if err != nil {
+		return fmt.Errorf("task_repository: each: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		t, err := scanTask(rows)
+		if err != nil {
+			return fmt.Errorf("task_repository: each scan: %w", err)
+		}
+		if err := fn(t); err != nil {
+			return err
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("task_repository: each rows: %w", err)
+	}
+	return nil
+}
+
 // UpdateTaskStatus updates the status column for the task identified by id,
 // scoped to userID so users can only modify their own tasks.
 // Returns an error if no row was affected (wrong id or userID mismatch).
@@ -103,7 +317,7 @@ func (r *pgxTaskRepository) UpdateTaskStatus(ctx context.Context, id TaskID, use
 		SET    status = $1
 		WHERE  id = $2 AND user_id = $3`
 
-	tag, err := r.pool.Exec(ctx, query, status, id, userID)
+	tag, err := r.db.Exec(ctx, query, status, id, userID)
 	if err != nil {
 		return fmt.Errorf("task_repository: update_status: %w", err)
 	}
@@ -113,13 +327,82 @@ func (r *pgxTaskRepository) UpdateTaskStatus(ctx context.Context, id TaskID, use
 	return nil
 }
 
+// UpdateManyStatus sets status on the tasks in ids in a single UPDATE,
+// scoped to userID so users can only modify their own tasks. IDs that do
+// not exist or belong to another user are skipped silently; the returned
+// count says how many rows changed. An empty ids updates nothing.
+func (r *pgxTaskRepository) UpdateManyStatus(ctx context.Context, ids []TaskID, userID, status string) (int64, error) {
+	if len(ids) == 0 {
+		return 0, nil
+	}
+	const query = `
+		UPDATE tasks
+		SET    status = $1
+		WHERE  id = ANY($2) AND user_id = $3`
+
+	raw := make([]int64, len(ids))
+	for i, id := range ids {
+		raw[i] = int64(id)
+	}
+	tag, err := r.db.Exec(ctx, query, status, raw, userID)
+	if err != nil {
+		return 0, fmt.Errorf("task_repository: update_many_status: %w", err)
+	}
+	return tag.RowsAffected(), nil
+}
+
+// UpdateTask builds the SET clause from only the provided fields so a
+// partial PATCH never clobbers columns the caller did not mention.
+func (r *pgxTaskRepository) UpdateTask(ctx context.Context, id TaskID, userID string, fields TaskUpdate) (Task, error) {
+	if fields.empty() {
+		return Task{}, ErrEmptyTaskUpdate
+	}
+
+	var (
+		sets []string
+		args []any
+	)
+	add := func(column string, value any) {
+		args = append(args, value)
+		sets = append(sets, fmt.Sprintf("%s = $%d", column, len(args)))
+	}
+	set := func(column string, value *string) {
+		if value != nil {
+			add(column, *value)
+		}
+	}
+	set("title", fields.Title)
+	set("description", fields.Description)
+	if fields.Priority != nil {
+		add("priority", *fields.Priority)
+	}
+	set("status", fields.Status)
+
+	args = append(args, id, userID)
+	query := fmt.Sprintf(`
+		UPDATE tasks
+		SET    %s
+		WHERE  id = $%d AND user_id = $%d
+		RETURNING %s`,
+		strings.Join(sets, ", "), len(args)-1, len(args), taskColumns)
+
+	t, err := scanTask(r.db.QueryRow(ctx, query, args...))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return Task{}, ErrTaskNotFound
+	}
+	if err != nil {
+		return Task{}, fmt.Errorf("task_repository: update: %w", err)
+	}
+	return t, nil
+}
+
 // DeleteTask removes the task identified by id, scoped to userID so users
 // can only delete their own tasks.
 // Returns an error if no row was affected (wrong id or userID mismatch).
 func (r *pgxTaskRepository) DeleteTask(ctx context.Context, id TaskID, userID string) error {
 	const query = `DELETE FROM tasks WHERE id = $1 AND user_id = $2`
 
-	tag, err := r.pool.Exec(ctx, query, id, userID)
+	tag, err := r.db.Exec(ctx, query, id, userID)
 	if err != nil {
 		return fmt.Errorf("task_repository: delete: %w", err)
 	}
@@ -128,3 +411,107 @@ func (r *pgxTaskRepository) DeleteTask(ctx context.Context, id TaskID, userID st
 	}
 	return nil
 }
+
+// DeleteAllForUser removes every task owned by userID in one statement.
+// Deleting zero rows is not an error — the user may simply have no tasks.
+func (r *pgxTaskRepository) DeleteAllForUser(ctx context.Context, userID string) (int64, error) {
+	const query = `DELETE FROM tasks WHERE user_id = $1`
+
+	tag, err := r.db.Exec(ctx, query, userID)
+	if err != nil {
+		return 0, fmt.Errorf("task_repository: delete_all_for_user: %w", err)
+	}
+	return tag.RowsAffected(), nil
+}
+
+// NextOccurrence runs in a transaction that locks the source row, so two
+// concurrent calls for the same task produce exactly one clone.
+func (r *pgxTaskRepository) NextOccurrence(ctx context.Context, id TaskID, userID string) (Task, error) {
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return Task{}, fmt.Errorf("task_repository: next_occurrence: begin: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	const selectQuery = `
+		SELECT ` + taskColumns + `
+		FROM tasks
+		WHERE id = $1 AND user_id = $2
+		FOR UPDATE`
+
+	src, err := scanTask(tx.QueryRow(ctx, selectQuery, id, userID))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return Task{}, ErrTaskNotFound
+	}
+	if err != nil {
+		return Task{}, fmt.Errorf("task_repository: next_occurrence: select: %w", err)
+	}
+	if src.Status != "done" || src.Recurrence == "" {
+		return Task{}, ErrNotRecurring
+	}
+
+	now := time.Now()
+	from := now
+	if src.DueAt != nil {
+		from = *src.DueAt
+	}
+	due, err := NextDueDate(src.Recurrence, from, now)
+	if err != nil {
+		return Task{}, err
+	}
+
+	const insertQuery = `
+		INSERT INTO tasks (title, description, priority, recurrence, due_at, user_id)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING ` + taskColumns
+
+	next, err := scanTask(tx.QueryRow(ctx, insertQuery, src.Title, src.Description, src.Priority, src.Recurrence, due, userID))
+	if err != nil {
+		return Task{}, fmt.Errorf("task_repository: next_occurrence: insert: %w", err)
+	}
+
+	const clearQuery = `UPDATE tasks SET recurrence = NULL WHERE id = $1`
+	if _, err := tx.Exec(ctx, clearQuery, id); err != nil {
+		return Task{}, fmt.Errorf("task_repository: next_occurrence: clear: %w", err)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return Task{}, fmt.Errorf("task_repository: next_occurrence: commit: %w", err)
+	}
+	return next, nil
+}
+
+// CountByStatus aggregates the user's tasks by status in a single GROUP BY
+// query. Statuses with no rows are reported as 0 rather than omitted.
+func (r *pgxTaskRepository) CountByStatus(ctx context.Context, userID string) (map[string]int, error) {
+	const query = `
+		SELECT status, COUNT(*)
+		FROM tasks
+		WHERE user_id = $1
+		GROUP BY status`
+
+	rows, err := r.db.Query(ctx, query, userID)
+	if err != nil {
+		return nil, fmt.Errorf("task_repository: count_by_status: %w", err)
+	}
+	defer rows.Close()
+
+	counts := make(map[string]int, len(taskStatuses))
+	for _, status := range taskStatuses {
+		counts[status] = 0
+	}
+	for rows.Next() {
+		var (
+			status string
+			n      int
+		)
+		if err := rows.Scan(&status, &n); err != nil {
+			return nil, fmt.Errorf("task_repository: count_by_status scan: %w", err)
+		}
+		counts[status] = n
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("task_repository: count_by_status rows: %w", err)
+	}
+	return counts, nil
+}
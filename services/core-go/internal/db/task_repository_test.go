@@ -0,0 +1,391 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCountByStatus(t *testing.T) {
+	repo := NewTaskRepository(newTestPool(t))
+	ctx := context.Background()
+
+	tests := []struct {
+		name     string
+		userID   string
+		statuses []string // one task per entry
+		want     map[string]int
+	}{
+		{"no tasks", "u-empty", nil, map[string]int{"pending": 0, "in_progress": 0, "done": 0}},
+		{"mixed statuses", "u-mixed", []string{"pending", "pending", "done", "in_progress", "done", "done"},
+			map[string]int{"pending": 2, "in_progress": 1, "done": 3}},
+		{"only done", "u-done", []string{"done"}, map[string]int{"pending": 0, "in_progress": 0, "done": 1}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, status := range tt.statuses {
+				mustCreateTask(t, repo, NewTask{Title: "t", UserID: tt.userID, Status: status})
+			}
+			// Another user's tasks never leak into the counts.
+			mustCreateTask(t, repo, NewTask{Title: "other", UserID: "someone-else", Status: "pending"})
+
+			got, err := repo.CountByStatus(ctx, tt.userID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(got) != len(tt.want) {
+				t.Errorf("CountByStatus() = %v, want %v", got, tt.want)
+			}
+			for status, n := range tt.want {
+				if got[status] != n {
+					t.Errorf("CountByStatus()[%q] = %d, want %d", status, got[status], n)
+				}
+			}
+		})
+	}
+}
+
+func TestDeleteAllForUser(t *testing.T) {
+	repo := NewTaskRepository(newTestPool(t))
+	ctx := context.Background()
+
+	tests := []struct {
+		name   string
+		userID string
+		tasks  int
+	}{
+		{"user with tasks", "u-purge", 3},
+		{"user without tasks", "u-none", 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for i := 0; i < tt.tasks; i++ {
+				mustCreateTask(t, repo, NewTask{Title: "t", UserID: tt.userID})
+			}
+			kept := mustCreateTask(t, repo, NewTask{Title: "other", UserID: "someone-else"})
+
+			n, err := repo.DeleteAllForUser(ctx, tt.userID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if n != int64(tt.tasks) {
+				t.Errorf("DeleteAllForUser() = %d, want %d", n, tt.tasks)
+			}
+			if left, err := repo.ListTasks(ctx, tt.userID); err != nil || len(left) != 0 {
+				t.Errorf("ListTasks() after purge = %v, %v; want none", left, err)
+			}
+			if _, err := repo.GetTask(ctx, kept, "someone-else"); err != nil {
+				t.Errorf("another user's task was removed: %v", err)
+			}
+		})
+	}
+}
+
+func TestGetTask(t *testing.T) {
+	repo := NewTaskRepository(newTestPool(t))
+	ctx := context.Background()
+	id := mustCreateTask(t, repo, NewTask{Title: "buy milk", UserID: "u-owner"})
+
+	tests := []struct {
+		name    string
+		id      TaskID
+		userID  string
+		wantErr error
+	}{
+		{"found", id, "u-owner", nil},
+		{"not found", id + 1000, "u-owner", ErrTaskNotFound},
+		{"wrong owner", id, "u-other", ErrTaskNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			task, err := repo.GetTask(ctx, tt.id, tt.userID)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("GetTask() err = %v, want %v", err, tt.wantErr)
+			}
+			if err == nil && (task.ID != id || task.Title != "buy milk" || task.UserID != "u-owner") {
+				t.Errorf("GetTask() = %+v", task)
+			}
+		})
+	}
+}
+
+func TestUpdateTask(t *testing.T) {
+	repo := NewTaskRepository(newTestPool(t))
+	ctx := context.Background()
+	str := func(s string) *string { return &s }
+	num := func(n int) *int { return &n }
+
+	tests := []struct {
+		name    string
+		fields  TaskUpdate
+		userID  string
+		want    Task // Title, Description, Priority, Status
+		wantErr error
+	}{
+		{"title only", TaskUpdate{Title: str("Buy oat milk")}, "u-owner",
+			Task{Title: "Buy oat milk", Description: "2 litres", Priority: 1, Status: "pending"}, nil},
+		{"description and priority", TaskUpdate{Description: str("3 litres"), Priority: num(3)}, "u-owner",
+			Task{Title: "Buy milk", Description: "3 litres", Priority: 3, Status: "pending"}, nil},
+		{"every field", TaskUpdate{Title: str("a"), Description: str("b"), Priority: num(0), Status: str("done")}, "u-owner",
+			Task{Title: "a", Description: "b", Priority: 0, Status: "done"}, nil},
+		{"empty update", TaskUpdate{}, "u-owner", Task{}, ErrEmptyTaskUpdate},
+		{"wrong owner", TaskUpdate{Title: str("x")}, "u-other", Task{}, ErrTaskNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			id := mustCreateTask(t, repo, NewTask{Title: "Buy milk", Description: "2 litres", Priority: 1, UserID: "u-owner"})
+
+			got, err := repo.UpdateTask(ctx, id, tt.userID, tt.fields)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("UpdateTask() err = %v, want %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			stored, err := repo.GetTask(ctx, id, "u-owner")
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, task := range []Task{got, stored} {
+				if task.Title != tt.want.Title || task.Description != tt.want.Description ||
+					task.Priority != tt.want.Priority || task.Status != tt.want.Status {
+					t.Errorf("task = %+v, want %+v", task, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestUpdateManyStatus(t *testing.T) {
+	repo := NewTaskRepository(newTestPool(t))
+	ctx := context.Background()
+
+	tests := []struct {
+		name  string
+		owned int  // the user's tasks listed in ids
+		other bool // also list another user's task
+		extra bool // also list an id that does not exist
+		want  int64
+	}{
+		{"owned tasks", 2, false, false, 2},
+		{"owned and unowned", 2, true, false, 2},
+		{"only unowned", 0, true, false, 0},
+		{"unknown id skipped", 1, false, true, 1},
+		{"no ids", 0, false, false, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var ids []TaskID
+			for i := 0; i < tt.owned; i++ {
+				ids = append(ids, mustCreateTask(t, repo, NewTask{Title: "t", UserID: "u-bulk"}))
+			}
+			theirs := mustCreateTask(t, repo, NewTask{Title: "other", UserID: "u-bulk-other"})
+			if tt.other {
+				ids = append(ids, theirs)
+			}
+			if tt.extra {
+				ids = append(ids, theirs+1000)
+			}
+
+			n, err := repo.UpdateManyStatus(ctx, ids, "u-bulk", "done")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if n != tt.want {
+				t.Errorf("UpdateManyStatus() = %d, want %d", n, tt.want)
+			}
+			for _, id := range ids[:tt.owned] {
+				if task, err := repo.GetTask(ctx, id, "u-bulk"); err != nil || task.Status != "done" {
+					t.Errorf("owned task %d = %+v, %v; want done", id, task, err)
+				}
+			}
+			if task, err := repo.GetTask(ctx, theirs, "u-bulk-other"); err != nil || task.Status != "pending" {
+				t.Errorf("another user's task = %+v, %v; want it left pending", task, err)
+			}
+		})
+	}
+}
+
+func TestNextOccurrence(t *testing.T) {
+	repo := NewTaskRepository(newTestPool(t))
+	ctx := context.Background()
+	due := time.Now().Add(-36 * time.Hour).Truncate(time.Second)
+
+	tests := []struct {
+		name       string
+		task       NewTask
+		userID     string
+		wantErr    error
+		wantWithin time.Duration // the clone's due date is at most this far ahead
+	}{
+		{"daily", NewTask{Recurrence: RecurrenceDaily, Status: "done", DueAt: &due}, "u-owner", nil, 24 * time.Hour},
+		{"weekly", NewTask{Recurrence: RecurrenceWeekly, Status: "done", DueAt: &due}, "u-owner", nil, 7 * 24 * time.Hour},
+		{"monthly", NewTask{Recurrence: RecurrenceMonthly, Status: "done", DueAt: &due}, "u-owner", nil, 31 * 24 * time.Hour},
+		{"no due date", NewTask{Recurrence: RecurrenceDaily, Status: "done"}, "u-owner", nil, 24 * time.Hour},
+		{"not completed", NewTask{Recurrence: RecurrenceDaily, Status: "pending", DueAt: &due}, "u-owner", ErrNotRecurring, 0},
+		{"one-off", NewTask{Status: "done", DueAt: &due}, "u-owner", ErrNotRecurring, 0},
+		{"wrong owner", NewTask{Recurrence: RecurrenceDaily, Status: "done", DueAt: &due}, "u-other", ErrTaskNotFound, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.task.Title, tt.task.Description, tt.task.Priority, tt.task.UserID = "weekly report", "for the team", 2, "u-owner"
+			id := mustCreateTask(t, repo, tt.task)
+
+			next, err := repo.NextOccurrence(ctx, id, tt.userID)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("NextOccurrence() err = %v, want %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if next.ID == id || next.Status != "pending" || next.Title != tt.task.Title ||
+				next.Description != tt.task.Description || next.Priority != tt.task.Priority || next.Recurrence != tt.task.Recurrence {
+				t.Errorf("clone = %+v, want a pending copy of %+v", next, tt.task)
+			}
+			now := time.Now()
+			if next.DueAt == nil || !next.DueAt.After(now) || next.DueAt.After(now.Add(tt.wantWithin)) {
+				t.Errorf("clone due %v, want within %s from now", next.DueAt, tt.wantWithin)
+			}
+			// The series moves to the clone, so the source cannot spawn again.
+			if _, err := repo.NextOccurrence(ctx, id, tt.userID); !errors.Is(err, ErrNotRecurring) {
+				t.Errorf("second NextOccurrence() err = %v, want ErrNotRecurring", err)
+			}
+		})
+	}
+}
+
+func TestCreateTaskIdempotencyKey(t *testing.T) {
+	repo := NewTaskRepository(newTestPool(t))
+	ctx := context.Background()
+
+	tests := []struct {
+		name      string
+		first     NewTask
+		retry     NewTask
+		wantSame  bool
+		wantCount int // tasks owned by first.UserID afterwards
+	}{
+		{"retry with the same key", NewTask{Title: "a", UserID: "u-retry", IdempotencyKey: "k1"},
+			NewTask{Title: "a", UserID: "u-retry", IdempotencyKey: "k1"}, true, 1},
+		{"retry with a different key", NewTask{Title: "a", UserID: "u-keys", IdempotencyKey: "k1"},
+			NewTask{Title: "a", UserID: "u-keys", IdempotencyKey: "k2"}, false, 2},
+		{"no key never deduplicates", NewTask{Title: "a", UserID: "u-nokey"},
+			NewTask{Title: "a", UserID: "u-nokey"}, false, 2},
+		{"same key for another user", NewTask{Title: "a", UserID: "u-first", IdempotencyKey: "shared"},
+			NewTask{Title: "a", UserID: "u-second", IdempotencyKey: "shared"}, false, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			first := mustCreateTask(t, repo, tt.first)
+			retry := mustCreateTask(t, repo, tt.retry)
+			if (first == retry) != tt.wantSame {
+				t.Errorf("CreateTask() IDs %d then %d, want same = %v", first, retry, tt.wantSame)
+			}
+			tasks, err := repo.ListTasks(ctx, tt.first.UserID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(tasks) != tt.wantCount {
+				t.Errorf("user has %d tasks, want %d", len(tasks), tt.wantCount)
+			}
+		})
+	}
+}
+
+func TestCreateTasks(t *testing.T) {
+	repo := NewTaskRepository(newTestPool(t))
+	ctx := context.Background()
+	tooLongKey := strings.Repeat("k", 256) // overflows VARCHAR(255)
+
+	tests := []struct {
+		name      string
+		user      string
+		titles    []string
+		failAt    int // index given tooLongKey; -1 for none
+		wantErr   bool
+		wantCount int
+	}{
+		{"all inserted in order", "u-batch-ok", []string{"first", "second", "third"}, -1, false, 3},
+		{"mid-batch failure rolls back", "u-batch-fail", []string{"first", "second", "third"}, 1, true, 0},
+		{"last item failure rolls back", "u-batch-last", []string{"first", "second"}, 1, true, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var batch []NewTask
+			for i, title := range tt.titles {
+				task := NewTask{Title: title, UserID: tt.user}
+				if i == tt.failAt {
+					task.IdempotencyKey = tooLongKey
+				}
+				batch = append(batch, task)
+			}
+			ids, err := repo.CreateTasks(ctx, batch)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("CreateTasks() err = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			tasks, err := repo.ListTasks(ctx, tt.user)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(tasks) != tt.wantCount {
+				t.Fatalf("user has %d tasks, want %d", len(tasks), tt.wantCount)
+			}
+			if tt.wantErr {
+				return
+			}
+			if len(ids) != len(tt.titles) {
+				t.Fatalf("CreateTasks() returned %d ids, want %d", len(ids), len(tt.titles))
+			}
+			for i, id := range ids {
+				got, err := repo.GetTask(ctx, id, tt.user)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if got.Title != tt.titles[i] {
+					t.Errorf("ids[%d] is %q, want %q", i, got.Title, tt.titles[i])
+				}
+			}
+		})
+	}
+}
+
+func TestEachTask(t *testing.T) {
+	repo := NewTaskRepository(newTestPool(t))
+	ctx := context.Background()
+	for _, title := range []string{"first", "second", "third"} {
+		mustCreateTask(t, repo, NewTask{Title: title, UserID: "u-each"})
+	}
+	mustCreateTask(t, repo, NewTask{Title: "other", UserID: "u-other"})
+	stop := errors.New("stop")
+
+	tests := []struct {
+		name    string
+		stopAt  int // fn returns stop on this call; 0 never
+		want    []string
+		wantErr error
+	}{
+		{"every task oldest first", 0, []string{"first", "second", "third"}, nil},
+		{"fn error stops iteration", 2, []string{"first", "second"}, stop},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			err := repo.EachTask(ctx, "u-each", func(task Task) error {
+				got = append(got, task.Title)
+				if len(got) == tt.stopAt {
+					return stop
+				}
+				return nil
+			})
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("EachTask() err = %v, want %v", err, tt.wantErr)
+			}
+			if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("EachTask() visited %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
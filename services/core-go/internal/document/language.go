@@ -0,0 +1,141 @@
+package document
+
+import (
+	"strings"
+	"unicode"
+)
+
+// minLatinStopwordHits is how many function words a Latin-script text must
+// contain before DetectLanguage commits to a language. Short queries often
+// fall below it and are reported as unknown, which callers treat as "don't
+// filter".
+const minLatinStopwordHits = 2
+
+// minScriptLetters is how many letters of a non-Latin script are enough to
+// name the language, since the script alone is strong evidence.
+const minScriptLetters = 3
+
+// scriptLanguages maps a Unicode script to the language it most likely
+// means. Languages sharing a script (e.g. Russian and Ukrainian) are
+// reported as the most common one.
+var scriptLanguages = []struct {
+	table *unicode.RangeTable
+	lang  string
+}{
+	{unicode.Cyrillic, "ru"},
+	{unicode.Greek, "el"},
+	{unicode.Arabic, "ar"},
+	{unicode.Hebrew, "he"},
+	{unicode.Devanagari, "hi"},
+	{unicode.Thai, "th"},
+	{unicode.Hangul, "ko"},
+	{unicode.Hiragana, "ja"},
+	{unicode.Katakana, "ja"},
+	{unicode.Han, "zh"},
+}
+
+// latinStopwords holds frequent function words for the Latin-script
+// languages DetectLanguage can tell apart. A word listed for several
+// languages votes for each of them.
+var latinStopwords = map[string][]string{
+	"en": {"the", "and", "is", "are", "was", "of", "to", "in", "that", "it", "with", "for", "this", "what", "who", "how", "you", "have"},
+	"es": {"el", "los", "las", "y", "es", "que", "del", "por", "con", "una", "para", "como", "qué", "quién", "cómo", "está"},
+	"fr": {"le", "les", "et", "est", "que", "des", "du", "une", "pour", "avec", "dans", "qui", "ce", "sont", "pas", "quoi"},
+	"de": {"der", "die", "das", "und", "ist", "nicht", "mit", "ein", "eine", "zu", "den", "von", "wer", "was", "wie", "sind"},
+	"it": {"il", "lo", "gli", "e", "è", "che", "di", "per", "con", "una", "sono", "chi", "cosa", "come", "della", "non"},
+	"pt": {"o", "os", "as", "e", "é", "que", "do", "da", "em", "um", "uma", "para", "com", "quem", "como", "não"},
+	"nl": {"de", "het", "een", "en", "is", "van", "niet", "met", "dat", "wie", "wat", "hoe", "zijn", "voor"},
+}
+
+// latinStopwordIndex inverts latinStopwords: word → languages using it.
+var latinStopwordIndex = func() map[string][]string {
+	idx := map[string][]string{}
+	for lang, words := range latinStopwords {
+		for _, w := range words {
+			idx[w] = append(idx[w], lang)
+		}
+	}
+	return idx
+}()
+
+// DetectLanguage returns the ISO 639-1 code of text's language, or "" when
+// it cannot tell. It is a cheap heuristic, not a classifier: non-Latin
+// scripts are identified by the dominant script, and Latin-script text by
+// counting common function words. Text that is too short or too mixed to
+// call is reported as unknown rather than guessed.
+func DetectLanguage(text string) string {
+	var latin, total int
+	scriptCounts := make([]int, len(scriptLanguages))
+	for _, r := range text {
+		if !unicode.IsLetter(r) {
+			continue
+		}
+		total++
+		if unicode.Is(unicode.Latin, r) {
+			latin++
+			continue
+		}
+		for i, s := range scriptLanguages {
+			if unicode.Is(s.table, r) {
+				scriptCounts[i]++
+				break
+			}
+		}
+	}
+	if total == 0 {
+		return ""
+	}
+
+	if latin*2 < total {
+		return dominantScriptLanguage(scriptCounts)
+	}
+	return latinLanguage(text)
+}
+
+// dominantScriptLanguage picks the language of the most frequent non-Latin
+// script. Any kana alongside Han means Japanese rather than Chinese.
+func dominantScriptLanguage(counts []int) string {
+	best, bestCount, kana := "", 0, 0
+	for i, s := range scriptLanguages {
+		if s.lang == "ja" {
+			kana += counts[i]
+		}
+		if counts[i] > bestCount {
+			best, bestCount = s.lang, counts[i]
+		}
+	}
+	if best == "zh" && kana > 0 {
+		best = "ja"
+	}
+	if bestCount < minScriptLetters {
+		return ""
+	}
+	return best
+}
+
+// latinLanguage votes on the language of Latin-script text by stopword
+// hits. The winner needs minLatinStopwordHits and a strict lead.
+func latinLanguage(text string) string {
+	votes := map[string]int{}
+	for _, word := range strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r)
+	}) {
+		for _, lang := range latinStopwordIndex[word] {
+			votes[lang]++
+		}
+	}
+
+	best, bestVotes, runnerUp := "", 0, 0
+	for lang, n := range votes {
+		switch {
+		case n > bestVotes:
+			best, bestVotes, runnerUp = lang, n, bestVotes
+		case n > runnerUp:
+			runnerUp = n
+		}
+	}
+	if bestVotes < minLatinStopwordHits || bestVotes == runnerUp {
+		return ""
+	}
+	return best
+}
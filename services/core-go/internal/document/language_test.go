@@ -0,0 +1,29 @@
+package document
+
+import "testing"
+
+func TestDetectLanguage(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want string
+	}{
+		{"english", "The Colosseum is an amphitheatre in the centre of Rome.", "en"},
+		{"spanish", "El Coliseo es un anfiteatro que está en el centro de Roma.", "es"},
+		{"german", "Das Kolosseum ist ein Amphitheater und steht in der Mitte von Rom.", "de"},
+		{"french", "Le Colisée est un amphithéâtre dans le centre de Rome et il est grand.", "fr"},
+		{"russian", "Колизей находится в Риме.", "ru"},
+		{"japanese kana with kanji", "コロッセオはローマにあります。", "ja"},
+		{"chinese", "罗马斗兽场位于罗马。", "zh"},
+		{"too few function words", "Colosseum Rome", ""},
+		{"no letters", "12345 !!!", ""},
+		{"empty", "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := DetectLanguage(tt.text); got != tt.want {
+				t.Errorf("DetectLanguage(%q) = %q, want %q", tt.text, got, tt.want)
+			}
+		})
+	}
+}
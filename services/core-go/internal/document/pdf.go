@@ -0,0 +1,56 @@
+// Package document converts uploaded file formats into the plain text the
+// knowledge base chunks and embeds.
+package document
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/ledongthuc/pdf"
+)
+
+// ErrEmptyPDF is returned when a PDF parses but contains no extractable
+// text (e.g. a scanned document without an OCR layer).
+var ErrEmptyPDF = errors.New("document: pdf contains no extractable text")
+
+// ErrEncryptedPDF is returned when a PDF is password-protected.
+var ErrEncryptedPDF = errors.New("document: pdf is encrypted")
+
+// ExtractPDFText returns the plain text of every page in data.
+// Encrypted PDFs yield ErrEncryptedPDF and text-less PDFs yield ErrEmptyPDF
+// so callers can report a clear reason instead of ingesting nothing.
+func ExtractPDFText(data []byte) (text string, err error) {
+	// The parser panics on some malformed inputs; surface that as an error
+	// rather than taking the request goroutine down.
+	defer func() {
+		if r := recover(); r != nil {
+			text, err = "", fmt.Errorf("document: malformed pdf: %v", r)
+		}
+	}()
+
+	reader, err := pdf.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		if errors.Is(err, pdf.ErrInvalidPassword) || strings.Contains(strings.ToLower(err.Error()), "encrypt") {
+			return "", ErrEncryptedPDF
+		}
+		return "", fmt.Errorf("document: open pdf: %w", err)
+	}
+
+	plain, err := reader.GetPlainText()
+	if err != nil {
+		return "", fmt.Errorf("document: extract pdf text: %w", err)
+	}
+	raw, err := io.ReadAll(plain)
+	if err != nil {
+		return "", fmt.Errorf("document: read pdf text: %w", err)
+	}
+
+	text = strings.TrimSpace(string(raw))
+	if text == "" {
+		return "", ErrEmptyPDF
+	}
+	return text, nil
+}
@@ -0,0 +1,44 @@
+package document
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestExtractPDFText(t *testing.T) {
+	tests := []struct {
+		name    string
+		fixture string // file under testdata; "" uses data
+		data    []byte
+		want    string
+		wantErr error
+	}{
+		{"text pdf", "sample.pdf", nil, "The Colosseum is in Rome.", nil},
+		{"page without text", "blank.pdf", nil, "", ErrEmptyPDF},
+		{"password protected", "encrypted.pdf", nil, "", ErrEncryptedPDF},
+		{"not a pdf", "", []byte("just some text"), "", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := tt.data
+			if tt.fixture != "" {
+				var err error
+				if data, err = os.ReadFile(filepath.Join("testdata", tt.fixture)); err != nil {
+					t.Fatal(err)
+				}
+			}
+			got, err := ExtractPDFText(data)
+			if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+				t.Fatalf("ExtractPDFText() err = %v, want %v", err, tt.wantErr)
+			}
+			if tt.want == "" && err == nil {
+				t.Fatalf("ExtractPDFText() = %q, want an error", got)
+			}
+			if got != tt.want {
+				t.Errorf("ExtractPDFText() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -5,8 +5,13 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"math"
 	"net/http"
+	"strings"
+	"time"
 )
 
 const (
@@ -42,14 +47,114 @@ type ToolFunction struct {
 	Parameters  json.RawMessage `json:"parameters"`
 }
 
+// Options are per-request generation parameters. Unset (nil or zero) fields
+// are left to the model's defaults; the zero Options changes nothing. It
+// serialises as Ollama's "options" object.
+type Options struct {
+	Temperature *float64 `json:"temperature,omitempty"`
+	TopP        *float64 `json:"top_p,omitempty"`
+	Seed        *int     `json:"seed,omitempty"`
+	// NumCtx is the context window in tokens; Ollama-only.
+	NumCtx int `json:"num_ctx,omitempty"`
+	// NumPredict caps the reply length in tokens.
+	NumPredict int      `json:"num_predict,omitempty"`
+	Stop       []string `json:"stop,omitempty"`
+}
+
+// Merge returns o with every field that is set in over replaced by over's
+// value, so per-request parameters override configured defaults.
+func (o Options) Merge(over Options) Options {
+	if over.Temperature != nil {
+		o.Temperature = over.Temperature
+	}
+	if over.TopP != nil {
+		o.TopP = over.TopP
+	}
+	if over.Seed != nil {
+		o.Seed = over.Seed
+	}
+	if over.NumCtx != 0 {
+		o.NumCtx = over.NumCtx
+	}
+	if over.NumPredict != 0 {
+		o.NumPredict = over.NumPredict
+	}
+	if over.Stop != nil {
+		o.Stop = over.Stop
+	}
+	return o
+}
+
+// IsZero reports whether no field of o is set.
+func (o Options) IsZero() bool {
+	return o.Temperature == nil && o.TopP == nil && o.Seed == nil &&
+		o.NumCtx == 0 && o.NumPredict == 0 && len(o.Stop) == 0
+}
+
+// MaxTemperature is the highest temperature Options.Validate accepts.
+const MaxTemperature = 2.0
+
+// Validate reports whether every set field is in range: temperature in
+// [0, MaxTemperature], top_p in (0, 1], seed, num_ctx and num_predict
+// non-negative.
+func (o Options) Validate() error {
+	if t := o.Temperature; t != nil && (math.IsNaN(*t) || *t < 0 || *t > MaxTemperature) {
+		return fmt.Errorf("temperature must be between 0 and %g", MaxTemperature)
+	}
+	if p := o.TopP; p != nil && (math.IsNaN(*p) || *p <= 0 || *p > 1) {
+		return errors.New("top_p must be greater than 0 and at most 1")
+	}
+	if s := o.Seed; s != nil && *s < 0 {
+		return errors.New("seed must be non-negative")
+	}
+	if o.NumCtx < 0 {
+		return errors.New("num_ctx must be non-negative")
+	}
+	if o.NumPredict < 0 {
+		return errors.New("num_predict must be non-negative")
+	}
+	return nil
+}
+
+// wire returns o for a request body's options field, or nil when nothing is
+// set so the field is omitted.
+func (o Options) wire() *Options {
+	if o.IsZero() {
+		return nil
+	}
+	return &o
+}
+
 // ChunkKind discriminates the two variants a stream can produce.
 type ChunkKind int
 
 const (
 	KindText     ChunkKind = iota // model is writing prose
 	KindToolCall                  // model decided to call a tool
+	KindStats                     // final usage/timing report; always last
 )
 
+// Stats is the token usage and timing Ollama reports on the final (done)
+// frame of a chat stream.
+type Stats struct {
+	PromptTokens       int
+	CompletionTokens   int
+	TotalDuration      time.Duration
+	LoadDuration       time.Duration
+	PromptEvalDuration time.Duration
+	EvalDuration       time.Duration
+}
+
+// Add accumulates o into s, for pipelines that make several model calls.
+func (s *Stats) Add(o Stats) {
+	s.PromptTokens += o.PromptTokens
+	s.CompletionTokens += o.CompletionTokens
+	s.TotalDuration += o.TotalDuration
+	s.LoadDuration += o.LoadDuration
+	s.PromptEvalDuration += o.PromptEvalDuration
+	s.EvalDuration += o.EvalDuration
+}
+
 // ToolCall carries a parsed tool invocation returned by the model.
 // Arguments is kept as raw JSON so callers unmarshal into their own structs.
 type ToolCall struct {
@@ -63,11 +168,12 @@ type Chunk struct {
 	Kind     ChunkKind
 	Text     string    // set when Kind == KindText
 	ToolCall *ToolCall // set when Kind == KindToolCall
+	Stats    *Stats    // set when Kind == KindStats
 }
 
 // CreateTaskTool is the Ollama tool schema for the create_task function.
-// Matches shared/tools/create_task.json exactly: priority is a string enum,
-// NOT an integer. Pass this (or a slice containing it) to StreamChat.
+// Matches shared/tools/create_task.json exactly: priority is an integer 0–3
+// (low, medium, high, urgent). Pass this (or a slice containing it) to StreamChat.
 var CreateTaskTool = Tool{
 	Type: "function",
 	Function: ToolFunction{
@@ -78,7 +184,8 @@ var CreateTaskTool = Tool{
 			"properties": {
 				"title":       {"type": "string", "description": "A concise, actionable title for the task (max 50 characters)."},
 				"description": {"type": "string", "description": "Detailed context or steps required to complete the task. Leave empty if not provided."},
-				"priority":    {"type": "string", "enum": ["low", "medium", "high"], "description": "The urgency of the task. Default to 'medium' unless the user implies urgency."}
+				"priority":    {"type": "integer", "enum": [0, 1, 2, 3], "description": "The urgency of the task: 0 = low, 1 = medium, 2 = high, 3 = urgent. Default to 1 unless the user implies urgency."},
+				"recurrence":  {"type": "string", "enum": ["daily", "weekly", "monthly"], "description": "How often the task repeats. Omit for one-off tasks."}
 			},
 			"required": ["title", "priority"]
 		}`),
@@ -92,6 +199,7 @@ type chatRequest struct {
 	Messages []Message `json:"messages"`
 	Tools    []Tool    `json:"tools,omitempty"`
 	Stream   bool      `json:"stream"`
+	Options  *Options  `json:"options,omitempty"`
 }
 
 type ollamaMessage struct {
@@ -104,14 +212,110 @@ type ollamaToolCall struct {
 	Function ollamaFunction `json:"function"`
 }
 
+// ollamaFunction is one tool invocation inside a frame. Arguments is usually
+// a complete JSON object, but some Ollama versions stream it as JSON-string
+// fragments spread over several frames; Index ties those fragments together.
 type ollamaFunction struct {
+	Index     *int            `json:"index,omitempty"`
 	Name      string          `json:"name"`
-	Arguments json.RawMessage `json:"arguments"` // object, not a string
+	Arguments json.RawMessage `json:"arguments"`
+}
+
+// toolCallAccumulator merges tool-call fragments across frames so a single,
+// well-formed ToolCall is emitted per call once the stream completes.
+type toolCallAccumulator struct {
+	order []int
+	calls map[int]*pendingToolCall
+}
+
+type pendingToolCall struct {
+	name      string
+	object    json.RawMessage // complete arguments object, when sent whole
+	fragments strings.Builder // concatenated string fragments otherwise
+}
+
+func newToolCallAccumulator() *toolCallAccumulator {
+	return &toolCallAccumulator{calls: map[int]*pendingToolCall{}}
+}
+
+// add folds one frame's tool call into the accumulator. Calls carrying an
+// index are keyed by it. Without an index, a call that names a function
+// starts a new entry and a nameless one continues the previous entry.
+func (a *toolCallAccumulator) add(fn ollamaFunction) {
+	key := len(a.order)
+	switch {
+	case fn.Index != nil:
+		key = *fn.Index
+	case fn.Name == "" && len(a.order) > 0:
+		key = a.order[len(a.order)-1]
+	}
+
+	call, ok := a.calls[key]
+	if !ok {
+		call = &pendingToolCall{}
+		a.calls[key] = call
+		a.order = append(a.order, key)
+	}
+	if call.name == "" {
+		call.name = fn.Name
+	}
+
+	raw := bytes.TrimSpace(fn.Arguments)
+	switch {
+	case len(raw) == 0 || bytes.Equal(raw, []byte("null")):
+	case raw[0] == '"':
+		var fragment string
+		if err := json.Unmarshal(raw, &fragment); err == nil {
+			call.fragments.WriteString(fragment)
+		}
+	default:
+		call.object = append(json.RawMessage(nil), raw...)
+	}
+}
+
+// drain returns the accumulated calls in first-seen order and resets state.
+func (a *toolCallAccumulator) drain() []ToolCall {
+	out := make([]ToolCall, 0, len(a.order))
+	for _, key := range a.order {
+		call := a.calls[key]
+		args := call.object
+		if args == nil {
+			args = json.RawMessage(call.fragments.String())
+		}
+		if len(bytes.TrimSpace(args)) == 0 {
+			args = json.RawMessage("{}")
+		}
+		out = append(out, ToolCall{Name: call.name, Arguments: args})
+	}
+	a.order = nil
+	a.calls = map[int]*pendingToolCall{}
+	return out
 }
 
+// ollamaChunk is one NDJSON frame of a streaming /api/chat response. The
+// count and duration fields (durations in nanoseconds) are only populated on
+// the final done=true frame.
 type ollamaChunk struct {
-	Message ollamaMessage `json:"message"`
-	Done    bool          `json:"done"`
+	Message            ollamaMessage `json:"message"`
+	Done               bool          `json:"done"`
+	TotalDuration      int64         `json:"total_duration"`
+	LoadDuration       int64         `json:"load_duration"`
+	PromptEvalCount    int           `json:"prompt_eval_count"`
+	PromptEvalDuration int64         `json:"prompt_eval_duration"`
+	EvalCount          int           `json:"eval_count"`
+	EvalDuration       int64         `json:"eval_duration"`
+}
+
+// stats converts the done frame's usage fields into a Stats.
+func (c ollamaChunk) stats() *Stats {
+	return &Stats{
+		PromptTokens:       c.PromptEvalCount,
+		CompletionTokens:   c.EvalCount,
+		TotalDuration:      time.Duration(c.TotalDuration),
+		LoadDuration:       time.Duration(c.LoadDuration),
+		PromptEvalDuration: time.Duration(c.PromptEvalDuration),
+		EvalDuration:       time.Duration(c.EvalDuration),
+	}
 }
 
 // --- Public API ---
@@ -119,18 +323,20 @@ type ollamaChunk struct {
 // StreamChat opens a streaming /api/chat request to the local Ollama instance.
 // It returns a read-only Chunk channel and an error for immediate failures
 // (JSON encoding, network dial). The channel is closed when the stream ends
-// or ctx is cancelled; the caller does not need to close it.
+// or ctx is cancelled; the caller does not need to close it. opts is sent
+// as the request's generation options.
 //
 // Timeout behaviour:
 //   - ctx cancellation / deadline is the primary mechanism — pass a context
 //     with a deadline from the HTTP handler to bound the full stream.
 //   - streamClient has no hard Timeout so long streams are not killed.
-func StreamChat(ctx context.Context, messages []Message, tools []Tool) (<-chan Chunk, error) {
+func StreamChat(ctx context.Context, messages []Message, tools []Tool, opts Options) (<-chan Chunk, error) {
 	body, err := json.Marshal(chatRequest{
 		Model:    chatModel,
 		Messages: messages,
 		Tools:    tools,
 		Stream:   true,
+		Options:  opts.wire(),
 	})
 	if err != nil {
 		return nil, fmt.Errorf("chat: marshal: %w", err)
@@ -147,8 +353,8 @@ func StreamChat(ctx context.Context, messages []Message, tools []Tool) (<-chan C
 		return nil, fmt.Errorf("chat: http: %w", err)
 	}
 	if resp.StatusCode != http.StatusOK {
-		resp.Body.Close()
-		return nil, fmt.Errorf("chat: ollama status %d", resp.StatusCode)
+		defer resp.Body.Close()
+		return nil, chatStatusError("ollama", chatModel, resp)
 	}
 
 	ch := make(chan Chunk, 16)
@@ -157,6 +363,9 @@ func StreamChat(ctx context.Context, messages []Message, tools []Tool) (<-chan C
 		defer close(ch)
 		defer resp.Body.Close()
 
+		toolCalls := newToolCallAccumulator()
+		var stats *Stats
+
 		scanner := bufio.NewScanner(resp.Body)
 		for scanner.Scan() {
 			line := scanner.Text()
@@ -169,19 +378,11 @@ func StreamChat(ctx context.Context, messages []Message, tools []Tool) (<-chan C
 				continue // skip malformed line, keep reading
 			}
 
-			// Tool call: one or more calls arrive before the final done=true frame.
+			// Tool calls: one or more calls (possibly fragmented) arrive before
+			// the final done=true frame. Buffer them until the stream ends so
+			// each call is emitted once with complete arguments.
 			for _, tc := range frame.Message.ToolCalls {
-				select {
-				case ch <- Chunk{
-					Kind: KindToolCall,
-					ToolCall: &ToolCall{
-						Name:      tc.Function.Name,
-						Arguments: tc.Function.Arguments,
-					},
-				}:
-				case <-ctx.Done():
-					return
-				}
+				toolCalls.add(tc.Function)
 			}
 
 			// Text chunk: non-empty content on done=false frames.
@@ -194,10 +395,107 @@ func StreamChat(ctx context.Context, messages []Message, tools []Tool) (<-chan C
 			}
 
 			if frame.Done {
+				stats = frame.stats()
+				break
+			}
+		}
+
+		// Emit buffered tool calls on done=true, or when the stream ends
+		// without one.
+		for _, tc := range toolCalls.drain() {
+			select {
+			case ch <- Chunk{Kind: KindToolCall, ToolCall: &tc}:
+			case <-ctx.Done():
 				return
 			}
 		}
+
+		// Usage stats close the stream; absent when it ended without done.
+		if stats != nil {
+			select {
+			case ch <- Chunk{Kind: KindStats, Stats: stats}:
+			case <-ctx.Done():
+			}
+		}
 	}()
 
 	return ch, nil
 }
+
+// --- Helpers ---
+
+// ErrModelNotFound matches (via errors.Is) the *ModelNotFoundError a chat
+// provider returns when the configured model does not exist on the server —
+// for Ollama, a model that was never pulled.
+var ErrModelNotFound = errors.New("chat: model not found")
+
+// ModelNotFoundError reports a chat request rejected with 404 because Model
+// is not available. Detail is the server's own message.
+type ModelNotFoundError struct {
+	Model  string
+	Detail string
+}
+
+func (e *ModelNotFoundError) Error() string {
+	return fmt.Sprintf("chat: model %q not found: %s", e.Model, e.Detail)
+}
+
+// Is makes errors.Is(err, ErrModelNotFound) true.
+func (e *ModelNotFoundError) Is(target error) bool {
+	return target == ErrModelNotFound
+}
+
+// chatStatusError turns a non-200 chat response into an error. Ollama
+// answers a missing model with 404, so a 404 from it becomes a
+// *ModelNotFoundError. Other providers also 404 for a wrong base URL, so
+// theirs only does when the body says the model is missing; anything else
+// keeps the generic status error.
+func chatStatusError(provider, model string, resp *http.Response) error {
+	detail := errorBody(resp.Body)
+	if resp.StatusCode == http.StatusNotFound && (provider == "ollama" || mentionsMissingModel(detail)) {
+		return &ModelNotFoundError{Model: model, Detail: detail}
+	}
+	return fmt.Errorf("chat: %s status %d: %s", provider, resp.StatusCode, detail)
+}
+
+// mentionsMissingModel reports whether an error body says the requested
+// model does not exist, as OpenAI-compatible servers do with a
+// "model_not_found" code or a "model ... does not exist" message.
+func mentionsMissingModel(detail string) bool {
+	lc := strings.ToLower(detail)
+	if strings.Contains(lc, "model_not_found") {
+		return true
+	}
+	return strings.Contains(lc, "model") &&
+		(strings.Contains(lc, "not found") || strings.Contains(lc, "does not exist"))
+}
+
+// maxErrorBody caps how much of a non-200 response body is read into an error.
+const maxErrorBody = 512
+
+// errorBody extracts a human-readable message from an Ollama error response.
+// Ollama reports failures as {"error": "..."}; when the body is not in that
+// shape the raw (truncated) text is returned instead.
+func errorBody(r io.Reader) string {
+	raw, _ := io.ReadAll(io.LimitReader(r, maxErrorBody+1))
+	truncated := len(raw) > maxErrorBody
+	if truncated {
+		raw = raw[:maxErrorBody]
+	}
+
+	var payload struct {
+		Error string `json:"error"`
+	}
+	if err := json.Unmarshal(raw, &payload); err == nil && payload.Error != "" {
+		return payload.Error
+	}
+
+	msg := strings.TrimSpace(string(raw))
+	if msg == "" {
+		return "(empty body)"
+	}
+	if truncated {
+		msg += "..."
+	}
+	return msg
+}
@@ -0,0 +1,274 @@
+package llm
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// ChatProvider streams a chat completion as Chunks: text deltas first, then
+// any tool calls, then (when the backend reports usage) one KindStats chunk.
+// The agent and RAG pipelines depend on this interface rather than on a
+// specific vendor. opts carries generation parameters; providers map them
+// onto their own request fields.
+type ChatProvider interface {
+	StreamChat(ctx context.Context, messages []Message, tools []Tool, opts Options) (<-chan Chunk, error)
+}
+
+// OllamaChatProvider streams from the local Ollama /api/chat endpoint. It is
+// the default provider.
+type OllamaChatProvider struct{}
+
+// StreamChat implements ChatProvider by delegating to the package-level
+// StreamChat.
+func (OllamaChatProvider) StreamChat(ctx context.Context, messages []Message, tools []Tool, opts Options) (<-chan Chunk, error) {
+	return StreamChat(ctx, messages, tools, opts)
+}
+
+// OpenAIChatProvider streams from an OpenAI-compatible
+// POST {baseURL}/chat/completions endpoint using SSE deltas.
+type OpenAIChatProvider struct {
+	baseURL string
+	apiKey  string
+	model   string
+	http    *http.Client
+}
+
+// NewOpenAIChatProvider returns an OpenAIChatProvider. apiKey may be empty
+// for servers that do not require authentication.
+func NewOpenAIChatProvider(baseURL, apiKey, model string) *OpenAIChatProvider {
+	return &OpenAIChatProvider{
+		baseURL: strings.TrimRight(baseURL, "/"),
+		apiKey:  apiKey,
+		model:   model,
+		http:    streamClient,
+	}
+}
+
+// --- OpenAI wire types ---
+
+type openAIChatRequest struct {
+	Model         string              `json:"model"`
+	Messages      []openAIChatMessage `json:"messages"`
+	Tools         []Tool              `json:"tools,omitempty"`
+	Stream        bool                `json:"stream"`
+	StreamOptions map[string]bool     `json:"stream_options,omitempty"`
+	Temperature   *float64            `json:"temperature,omitempty"`
+	TopP          *float64            `json:"top_p,omitempty"`
+	Seed          *int                `json:"seed,omitempty"`
+	MaxTokens     int                 `json:"max_tokens,omitempty"`
+	Stop          []string            `json:"stop,omitempty"`
+}
+
+type openAIChatMessage struct {
+	Role       string           `json:"role"`
+	Content    string           `json:"content"`
+	ToolCalls  []openAIToolCall `json:"tool_calls,omitempty"`
+	ToolCallID string           `json:"tool_call_id,omitempty"`
+}
+
+type openAIToolCall struct {
+	Index    *int   `json:"index,omitempty"`
+	ID       string `json:"id,omitempty"`
+	Type     string `json:"type,omitempty"`
+	Function struct {
+		Name      string `json:"name,omitempty"`
+		Arguments string `json:"arguments"`
+	} `json:"function"`
+}
+
+type openAIStreamChunk struct {
+	Choices []struct {
+		Delta struct {
+			Content   string           `json:"content"`
+			ToolCalls []openAIToolCall `json:"tool_calls"`
+		} `json:"delta"`
+	} `json:"choices"`
+	Usage *struct {
+		PromptTokens     int `json:"prompt_tokens"`
+		CompletionTokens int `json:"completion_tokens"`
+	} `json:"usage"`
+}
+
+// toOpenAIMessages converts the Ollama-shaped history into OpenAI messages.
+// Ollama tool calls carry no IDs and object arguments; OpenAI needs string
+// arguments and a tool_call_id linking each "tool" reply to its call, so
+// synthetic IDs are assigned in order.
+func toOpenAIMessages(messages []Message) []openAIChatMessage {
+	out := make([]openAIChatMessage, 0, len(messages))
+	var pendingIDs []string
+	next := 0
+	for _, m := range messages {
+		om := openAIChatMessage{Role: m.Role, Content: m.Content}
+		if len(m.ToolCalls) > 0 {
+			var calls []ollamaToolCall
+			if err := json.Unmarshal(m.ToolCalls, &calls); err == nil {
+				for _, c := range calls {
+					var tc openAIToolCall
+					tc.ID = fmt.Sprintf("call_%d", next)
+					tc.Type = "function"
+					tc.Function.Name = c.Function.Name
+					tc.Function.Arguments = string(c.Function.Arguments)
+					om.ToolCalls = append(om.ToolCalls, tc)
+					pendingIDs = append(pendingIDs, tc.ID)
+					next++
+				}
+			}
+		}
+		if m.Role == "tool" && len(pendingIDs) > 0 {
+			om.ToolCallID = pendingIDs[0]
+			pendingIDs = pendingIDs[1:]
+		}
+		out = append(out, om)
+	}
+	return out
+}
+
+// StreamChat implements ChatProvider. Tool-call argument fragments are
+// merged per index and emitted once the stream ends, matching the Ollama
+// provider's ordering. opts maps onto the top-level sampling fields, with
+// NumPredict as max_tokens; NumCtx has no equivalent and is ignored.
+func (p *OpenAIChatProvider) StreamChat(ctx context.Context, messages []Message, tools []Tool, opts Options) (<-chan Chunk, error) {
+	body, err := json.Marshal(openAIChatRequest{
+		Model:         p.model,
+		Messages:      toOpenAIMessages(messages),
+		Tools:         tools,
+		Stream:        true,
+		StreamOptions: map[string]bool{"include_usage": true},
+		Temperature:   opts.Temperature,
+		TopP:          opts.TopP,
+		Seed:          opts.Seed,
+		MaxTokens:     opts.NumPredict,
+		Stop:          opts.Stop,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("chat: marshal: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.baseURL+"/chat/completions", bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("chat: build request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "text/event-stream")
+	if p.apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+p.apiKey)
+	}
+
+	start := time.Now()
+	resp, err := p.http.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("chat: http: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		return nil, chatStatusError("openai", p.model, resp)
+	}
+
+	ch := make(chan Chunk, 16)
+
+	go func() {
+		defer close(ch)
+		defer resp.Body.Close()
+
+		toolCalls := newToolCallAccumulator()
+		var stats *Stats
+
+		scanner := bufio.NewScanner(resp.Body)
+		scanner.Buffer(make([]byte, 0, 64*1024), 1<<20)
+		for scanner.Scan() {
+			data, ok := strings.CutPrefix(scanner.Text(), "data:")
+			if !ok {
+				continue // blank separators, comments, event: lines
+			}
+			data = strings.TrimSpace(data)
+			if data == "[DONE]" {
+				break
+			}
+
+			var frame openAIStreamChunk
+			if err := json.Unmarshal([]byte(data), &frame); err != nil {
+				continue // skip malformed line, keep reading
+			}
+
+			if frame.Usage != nil {
+				stats = &Stats{
+					PromptTokens:     frame.Usage.PromptTokens,
+					CompletionTokens: frame.Usage.CompletionTokens,
+				}
+			}
+
+			for _, choice := range frame.Choices {
+				for _, tc := range choice.Delta.ToolCalls {
+					fragment, _ := json.Marshal(tc.Function.Arguments)
+					toolCalls.add(ollamaFunction{Index: tc.Index, Name: tc.Function.Name, Arguments: fragment})
+				}
+				if content := choice.Delta.Content; content != "" {
+					select {
+					case ch <- Chunk{Kind: KindText, Text: content}:
+					case <-ctx.Done():
+						return
+					}
+				}
+			}
+		}
+
+		for _, tc := range toolCalls.drain() {
+			select {
+			case ch <- Chunk{Kind: KindToolCall, ToolCall: &tc}:
+			case <-ctx.Done():
+				return
+			}
+		}
+
+		// OpenAI reports no server-side timings; wall time stands in for the
+		// total so the stats event is still useful.
+		if stats != nil {
+			stats.TotalDuration = time.Since(start)
+			select {
+			case ch <- Chunk{Kind: KindStats, Stats: stats}:
+			case <-ctx.Done():
+			}
+		}
+	}()
+
+	return ch, nil
+}
+
+// NewChatProviderFromEnv returns the ChatProvider selected by CHAT_PROVIDER:
+// "ollama" (default) or "openai". The OpenAI-compatible provider reads
+// CHAT_BASE_URL (default https://api.openai.com/v1), CHAT_API_KEY, and
+// CHAT_MODEL (required). LLM_FAKE=true overrides CHAT_PROVIDER with a
+// FakeChatProvider, except in production where it is an
+// ErrFakeInProduction.
+func NewChatProviderFromEnv() (ChatProvider, error) {
+	fake, err := fakeSelected()
+	if err != nil {
+		return nil, err
+	}
+	if fake {
+		return FakeChatProvider{}, nil
+	}
+	switch provider := strings.ToLower(strings.TrimSpace(os.Getenv("CHAT_PROVIDER"))); provider {
+	case "", "ollama":
+		return OllamaChatProvider{}, nil
+	case "openai":
+		model := strings.TrimSpace(os.Getenv("CHAT_MODEL"))
+		if model == "" {
+			return nil, fmt.Errorf("chat: CHAT_MODEL is required when CHAT_PROVIDER=openai")
+		}
+		baseURL := strings.TrimSpace(os.Getenv("CHAT_BASE_URL"))
+		if baseURL == "" {
+			baseURL = defaultOpenAIBaseURL
+		}
+		return NewOpenAIChatProvider(baseURL, os.Getenv("CHAT_API_KEY"), model), nil
+	default:
+		return nil, fmt.Errorf("chat: unknown CHAT_PROVIDER %q (want ollama or openai)", provider)
+	}
+}
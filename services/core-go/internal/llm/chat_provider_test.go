@@ -0,0 +1,240 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestOpenAIChatProviderStream(t *testing.T) {
+	tests := []struct {
+		name      string
+		frames    []string // SSE data payloads
+		wantText  string
+		wantCalls []string // "name args"
+		wantStats *Stats
+	}{
+		{"text deltas and usage", []string{
+			`{"choices":[{"delta":{"content":"Hello"}}]}`,
+			`{"choices":[{"delta":{"content":", world"}}]}`,
+			`{"choices":[],"usage":{"prompt_tokens":12,"completion_tokens":3}}`,
+			`[DONE]`,
+		}, "Hello, world", nil, &Stats{PromptTokens: 12, CompletionTokens: 3}},
+		{"tool call arguments split across deltas", []string{
+			`{"choices":[{"delta":{"tool_calls":[{"index":0,"id":"call_a","type":"function","function":{"name":"create_task","arguments":"{\"title\":"}}]}}]}`,
+			`{"choices":[{"delta":{"tool_calls":[{"index":0,"function":{"arguments":"\"buy milk\"}"}}]}}]}`,
+			`[DONE]`,
+		}, "", []string{`create_task {"title":"buy milk"}`}, nil},
+		{"malformed frame skipped", []string{
+			`{"choices":[{"delta":{"content":"a"}}]}`,
+			`{not json`,
+			`{"choices":[{"delta":{"content":"b"}}]}`,
+			`[DONE]`,
+		}, "ab", nil, nil},
+		{"nothing read after done", []string{
+			`{"choices":[{"delta":{"content":"a"}}]}`,
+			`[DONE]`,
+			`{"choices":[{"delta":{"content":"late"}}]}`,
+		}, "a", nil, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				var req openAIChatRequest
+				if err := json.NewDecoder(r.Body).Decode(&req); err != nil || !req.Stream || req.Model != "gpt-test" || r.URL.Path != "/v1/chat/completions" {
+					t.Errorf("request %s = %+v, %v", r.URL.Path, req, err)
+				}
+				w.Header().Set("Content-Type", "text/event-stream")
+				for _, f := range tt.frames {
+					fmt.Fprintf(w, "data: %s\n\n", f)
+				}
+			}))
+			defer srv.Close()
+
+			p := NewOpenAIChatProvider(srv.URL+"/v1", "", "gpt-test")
+			ch, err := p.StreamChat(context.Background(), []Message{{Role: "user", Content: "hi"}}, nil, Options{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			text, calls, stats := collectChunks(ch)
+			if text != tt.wantText {
+				t.Errorf("text = %q, want %q", text, tt.wantText)
+			}
+			var got []string
+			for _, c := range calls {
+				got = append(got, c.Name+" "+string(c.Arguments))
+			}
+			if fmt.Sprint(got) != fmt.Sprint(tt.wantCalls) {
+				t.Errorf("tool calls = %q, want %q", got, tt.wantCalls)
+			}
+			switch {
+			case (stats == nil) != (tt.wantStats == nil):
+				t.Errorf("stats = %+v, want %+v", stats, tt.wantStats)
+			case stats != nil && (stats.PromptTokens != tt.wantStats.PromptTokens || stats.CompletionTokens != tt.wantStats.CompletionTokens):
+				t.Errorf("stats = %+v, want %+v", stats, tt.wantStats)
+			}
+		})
+	}
+}
+
+func TestOpenAIChatProviderSamplingOptions(t *testing.T) {
+	temp, topP, seed := 1.2, 0.8, 7
+	tests := []struct {
+		name string
+		opts Options
+		want string // temperature, top_p and seed as sent
+	}{
+		{"all set", Options{Temperature: &temp, TopP: &topP, Seed: &seed}, "1.2 0.8 7"},
+		{"temperature only", Options{Temperature: &temp}, "1.2 <nil> <nil>"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				var req openAIChatRequest
+				if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+					t.Error(err)
+				}
+				if got := fmt.Sprint(deref(req.Temperature), deref(req.TopP), deref(req.Seed)); got != tt.want {
+					t.Errorf("temperature, top_p, seed = %s, want %s", got, tt.want)
+				}
+				fmt.Fprint(w, "data: [DONE]\n\n")
+			}))
+			defer srv.Close()
+
+			ch, err := NewOpenAIChatProvider(srv.URL, "", "gpt-test").StreamChat(context.Background(), nil, nil, tt.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for range ch {
+			}
+		})
+	}
+}
+
+func TestOpenAIChatProviderLengthOptions(t *testing.T) {
+	tests := []struct {
+		name string
+		opts Options
+		want map[string]string // top-level fields expected in the body
+		omit []string          // fields that must be absent
+	}{
+		{"unset", Options{}, nil, []string{"max_tokens", "stop", "temperature", "num_ctx", "options"}},
+		{"num_predict maps to max_tokens", Options{NumPredict: 256}, map[string]string{"max_tokens": "256"}, []string{"num_predict"}},
+		{"stop sequences", Options{Stop: []string{"###"}}, map[string]string{"stop": `["###"]`}, nil},
+		{"num_ctx is Ollama-only", Options{NumCtx: 8192}, nil, []string{"num_ctx", "options"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				var req map[string]json.RawMessage
+				if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+					t.Error(err)
+				}
+				for k, v := range tt.want {
+					if string(req[k]) != v {
+						t.Errorf("%s = %s, want %s", k, req[k], v)
+					}
+				}
+				for _, k := range tt.omit {
+					if v, ok := req[k]; ok {
+						t.Errorf("%s = %s, want it omitted", k, v)
+					}
+				}
+				fmt.Fprint(w, "data: [DONE]\n\n")
+			}))
+			defer srv.Close()
+
+			ch, err := NewOpenAIChatProvider(srv.URL, "", "gpt-test").StreamChat(context.Background(), nil, nil, tt.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for range ch {
+			}
+		})
+	}
+}
+
+func TestOpenAIChatProviderErrorStatus(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+		io.WriteString(w, `{"error":{"message":"The model gpt-test does not exist","code":"model_not_found"}}`)
+	}))
+	defer srv.Close()
+
+	_, err := NewOpenAIChatProvider(srv.URL, "", "gpt-test").StreamChat(context.Background(), nil, nil, Options{})
+	var notFound *ModelNotFoundError
+	if !errors.As(err, &notFound) {
+		t.Fatalf("StreamChat() err = %v, want a ModelNotFoundError", err)
+	}
+}
+
+func TestToOpenAIMessages(t *testing.T) {
+	calls := `[{"function":{"name":"create_task","arguments":{"title":"a"}}},{"function":{"name":"create_task","arguments":{"title":"b"}}}]`
+	got := toOpenAIMessages([]Message{
+		{Role: "user", Content: "add a and b"},
+		{Role: "assistant", ToolCalls: json.RawMessage(calls)},
+		{Role: "tool", Content: "created a"},
+		{Role: "tool", Content: "created b"},
+	})
+
+	tests := []struct {
+		i          int
+		role       string
+		callIDs    []string
+		callArgs   []string
+		toolCallID string
+	}{
+		{0, "user", nil, nil, ""},
+		{1, "assistant", []string{"call_0", "call_1"}, []string{`{"title":"a"}`, `{"title":"b"}`}, ""},
+		{2, "tool", nil, nil, "call_0"},
+		{3, "tool", nil, nil, "call_1"},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprint(tt.i), func(t *testing.T) {
+			m := got[tt.i]
+			var ids, args []string
+			for _, c := range m.ToolCalls {
+				ids = append(ids, c.ID)
+				args = append(args, c.Function.Arguments)
+			}
+			if m.Role != tt.role || fmt.Sprint(ids) != fmt.Sprint(tt.callIDs) ||
+				fmt.Sprint(args) != fmt.Sprint(tt.callArgs) || m.ToolCallID != tt.toolCallID {
+				t.Errorf("message %d = %+v", tt.i, m)
+			}
+		})
+	}
+}
+
+func TestNewChatProviderFromEnv(t *testing.T) {
+	tests := []struct {
+		name     string
+		provider string
+		model    string
+		want     string // %T of the result
+		wantErr  bool
+	}{
+		{"default is ollama", "", "", "llm.OllamaChatProvider", false},
+		{"openai", "openai", "gpt-test", "*llm.OpenAIChatProvider", false},
+		{"openai needs a model", "openai", "", "<nil>", true},
+		{"unknown provider", "anthropic", "", "<nil>", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("LLM_FAKE", "")
+			t.Setenv("CHAT_PROVIDER", tt.provider)
+			t.Setenv("CHAT_MODEL", tt.model)
+			got, err := NewChatProviderFromEnv()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("NewChatProviderFromEnv() err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if typ := fmt.Sprintf("%T", got); typ != tt.want {
+				t.Errorf("NewChatProviderFromEnv() = %s, want %s", typ, tt.want)
+			}
+		})
+	}
+}
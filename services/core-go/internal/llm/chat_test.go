@@ -0,0 +1,368 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// roundTripFunc adapts a function to http.RoundTripper.
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(r *http.Request) (*http.Response, error) { return f(r) }
+
+// stubOllama sends every request of the package's Ollama clients to
+// handler instead of localhost:11434 for the duration of the test.
+func stubOllama(t *testing.T, handler http.HandlerFunc) {
+	t.Helper()
+	srv := httptest.NewServer(handler)
+	t.Cleanup(srv.Close)
+	target, _ := url.Parse(srv.URL)
+	rt := roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		r = r.Clone(r.Context())
+		r.URL.Scheme, r.URL.Host = target.Scheme, target.Host
+		return http.DefaultTransport.RoundTrip(r)
+	})
+
+	savedHTTP, savedStream := httpClient, streamClient
+	httpClient = &http.Client{Transport: rt, Timeout: clientTimeout}
+	streamClient = &http.Client{Transport: rt}
+	t.Cleanup(func() { httpClient, streamClient = savedHTTP, savedStream })
+}
+
+func TestChatStatusError(t *testing.T) {
+	tests := []struct {
+		name          string
+		provider      string
+		status        int
+		body          string
+		wantNotFound  bool
+		wantSubstring string
+	}{
+		{"ollama missing model", "ollama", 404, `{"error":"model \"llama3\" not found, try pulling it first"}`, true, "try pulling"},
+		{"ollama 404 without detail", "ollama", 404, ``, true, "llama3"},
+		{"openai model_not_found code", "openai", 404, `{"error":{"message":"The model 'gpt-x' does not exist","code":"model_not_found"}}`, true, "model_not_found"},
+		{"openai wrong base url", "openai", 404, `404 page not found`, false, "status 404"},
+		{"openai html 404", "openai", 404, `<html><body>Not Found</body></html>`, false, "status 404"},
+		{"server error", "ollama", 500, `{"error":"out of memory"}`, false, "status 500: out of memory"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := &http.Response{StatusCode: tt.status, Body: io.NopCloser(strings.NewReader(tt.body))}
+			err := chatStatusError(tt.provider, "llama3", resp)
+			if got := errors.Is(err, ErrModelNotFound); got != tt.wantNotFound {
+				t.Errorf("errors.Is(ErrModelNotFound) = %v, want %v (err %v)", got, tt.wantNotFound, err)
+			}
+			if !strings.Contains(err.Error(), tt.wantSubstring) {
+				t.Errorf("err = %q, want it to contain %q", err, tt.wantSubstring)
+			}
+		})
+	}
+}
+
+func TestOllamaErrorBodies(t *testing.T) {
+	long := strings.Repeat("x", maxErrorBody+50)
+	tests := []struct {
+		name   string
+		status int
+		body   string
+		want   string
+	}{
+		{"json error field", 500, `{"error":"model requires more system memory (9.1 GiB) than is available"}`, "status 500: model requires more system memory (9.1 GiB) than is available"},
+		{"plain text", 502, "bad gateway\n", "status 502: bad gateway"},
+		{"empty body", 503, "", "status 503: (empty body)"},
+		{"truncated", 500, long, "status 500: " + long[:maxErrorBody] + "..."},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stubOllama(t, func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tt.status)
+				io.WriteString(w, tt.body)
+			})
+
+			_, err := Embed(context.Background(), "hello")
+			if err == nil || !strings.HasSuffix(err.Error(), "ollama "+tt.want) {
+				t.Errorf("Embed() err = %v, want it to end with %q", err, "ollama "+tt.want)
+			}
+			_, err = StreamChat(context.Background(), []Message{{Role: "user", Content: "hi"}}, nil, Options{})
+			if err == nil || !strings.HasSuffix(err.Error(), "ollama "+tt.want) {
+				t.Errorf("StreamChat() err = %v, want it to end with %q", err, "ollama "+tt.want)
+			}
+		})
+	}
+}
+
+// streamFrames serves frames as an NDJSON /api/chat response.
+func streamFrames(frames ...string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		for _, f := range frames {
+			io.WriteString(w, f+"\n")
+		}
+	}
+}
+
+// collectChunks drains ch into its text, tool calls and final stats.
+func collectChunks(ch <-chan Chunk) (text string, calls []ToolCall, stats *Stats) {
+	for c := range ch {
+		switch c.Kind {
+		case KindText:
+			text += c.Text
+		case KindToolCall:
+			calls = append(calls, *c.ToolCall)
+		case KindStats:
+			stats = c.Stats
+		}
+	}
+	return text, calls, stats
+}
+
+func TestStreamChatMergesToolCallFragments(t *testing.T) {
+	const done = `{"message":{"role":"assistant","content":""},"done":true}`
+	tests := []struct {
+		name   string
+		frames []string
+		want   []string // "name args" per emitted call
+	}{
+		{"whole arguments object", []string{
+			`{"message":{"tool_calls":[{"function":{"name":"create_task","arguments":{"title":"buy milk","priority":1}}}]},"done":false}`,
+			done,
+		}, []string{`create_task {"title":"buy milk","priority":1}`}},
+		{"indexed string fragments", []string{
+			`{"message":{"tool_calls":[{"function":{"index":0,"name":"create_task","arguments":"{\"title\":"}}]},"done":false}`,
+			`{"message":{"tool_calls":[{"function":{"index":0,"arguments":"\"buy milk\","}}]},"done":false}`,
+			`{"message":{"tool_calls":[{"function":{"index":0,"arguments":"\"priority\":1}"}}]},"done":false}`,
+			done,
+		}, []string{`create_task {"title":"buy milk","priority":1}`}},
+		{"interleaved calls by index", []string{
+			`{"message":{"tool_calls":[{"function":{"index":0,"name":"create_task","arguments":"{\"title\":\"a\","}}]},"done":false}`,
+			`{"message":{"tool_calls":[{"function":{"index":1,"name":"create_task","arguments":"{\"title\":\"b\","}}]},"done":false}`,
+			`{"message":{"tool_calls":[{"function":{"index":1,"arguments":"\"priority\":2}"}}]},"done":false}`,
+			`{"message":{"tool_calls":[{"function":{"index":0,"arguments":"\"priority\":0}"}}]},"done":false}`,
+			done,
+		}, []string{`create_task {"title":"a","priority":0}`, `create_task {"title":"b","priority":2}`}},
+		{"nameless fragments continue the last call", []string{
+			`{"message":{"tool_calls":[{"function":{"name":"create_task","arguments":"{\"title\":"}}]},"done":false}`,
+			`{"message":{"tool_calls":[{"function":{"arguments":"\"x\"}"}}]},"done":false}`,
+			done,
+		}, []string{`create_task {"title":"x"}`}},
+		{"stream ends without done", []string{
+			`{"message":{"tool_calls":[{"function":{"index":0,"name":"create_task","arguments":"{}"}}]},"done":false}`,
+		}, []string{`create_task {}`}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stubOllama(t, streamFrames(tt.frames...))
+			ch, err := StreamChat(context.Background(), []Message{{Role: "user", Content: "add tasks"}}, []Tool{CreateTaskTool}, Options{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, calls, _ := collectChunks(ch)
+			var got []string
+			for _, c := range calls {
+				if !json.Valid(c.Arguments) {
+					t.Errorf("call %s has malformed arguments %s", c.Name, c.Arguments)
+				}
+				got = append(got, c.Name+" "+string(c.Arguments))
+			}
+			if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+				t.Errorf("tool calls = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestStreamChatStats(t *testing.T) {
+	const text = `{"message":{"role":"assistant","content":"Hi"},"done":false}`
+	tests := []struct {
+		name   string
+		frames []string
+		want   *Stats
+	}{
+		{"done frame with usage", []string{text,
+			`{"message":{"role":"assistant","content":""},"done":true,"total_duration":2500000000,"load_duration":500000000,` +
+				`"prompt_eval_count":42,"prompt_eval_duration":300000000,"eval_count":7,"eval_duration":1200000000}`,
+		}, &Stats{
+			PromptTokens:       42,
+			CompletionTokens:   7,
+			TotalDuration:      2500 * time.Millisecond,
+			LoadDuration:       500 * time.Millisecond,
+			PromptEvalDuration: 300 * time.Millisecond,
+			EvalDuration:       1200 * time.Millisecond,
+		}},
+		{"done frame without usage", []string{text, `{"message":{"role":"assistant","content":""},"done":true}`}, &Stats{}},
+		{"stream cut before done", []string{text}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stubOllama(t, streamFrames(tt.frames...))
+			ch, err := StreamChat(context.Background(), []Message{{Role: "user", Content: "hello"}}, nil, Options{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			var kinds []ChunkKind
+			var stats *Stats
+			for c := range ch {
+				kinds = append(kinds, c.Kind)
+				if c.Kind == KindStats {
+					stats = c.Stats
+				}
+			}
+			if (stats == nil) != (tt.want == nil) || (stats != nil && *stats != *tt.want) {
+				t.Fatalf("stats = %+v, want %+v", stats, tt.want)
+			}
+			if tt.want != nil && kinds[len(kinds)-1] != KindStats {
+				t.Errorf("chunk kinds = %v, want stats last", kinds)
+			}
+		})
+	}
+}
+
+func TestOptionsValidate(t *testing.T) {
+	f := func(v float64) *float64 { return &v }
+	i := func(v int) *int { return &v }
+	tests := []struct {
+		name    string
+		opts    Options
+		wantErr string
+	}{
+		{"zero value", Options{}, ""},
+		{"all in range", Options{Temperature: f(0.7), TopP: f(0.9), Seed: i(42)}, ""},
+		{"temperature zero", Options{Temperature: f(0)}, ""},
+		{"temperature at maximum", Options{Temperature: f(MaxTemperature)}, ""},
+		{"temperature above maximum", Options{Temperature: f(MaxTemperature + 0.1)}, "temperature"},
+		{"negative temperature", Options{Temperature: f(-0.1)}, "temperature"},
+		{"NaN temperature", Options{Temperature: f(math.NaN())}, "temperature"},
+		{"top_p one", Options{TopP: f(1)}, ""},
+		{"top_p zero", Options{TopP: f(0)}, "top_p"},
+		{"top_p above one", Options{TopP: f(1.5)}, "top_p"},
+		{"seed zero", Options{Seed: i(0)}, ""},
+		{"negative seed", Options{Seed: i(-1)}, "seed"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.opts.Validate()
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("Validate() = %v, want nil", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Validate() = %v, want an error about %s", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestStreamChatSendsSamplingOptions(t *testing.T) {
+	temp, topP, seed := 1.2, 0.8, 7
+	tests := []struct {
+		name string
+		opts Options
+		want string // the request's "options" object
+	}{
+		{"temperature, top_p and seed", Options{Temperature: &temp, TopP: &topP, Seed: &seed}, `{"temperature":1.2,"top_p":0.8,"seed":7}`},
+		{"temperature only", Options{Temperature: &temp}, `{"temperature":1.2}`},
+		{"seed zero is still sent", Options{Seed: new(int)}, `{"seed":0}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stubOllama(t, func(w http.ResponseWriter, r *http.Request) {
+				var req struct {
+					Options json.RawMessage `json:"options"`
+				}
+				if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+					t.Error(err)
+				}
+				if string(req.Options) != tt.want {
+					t.Errorf("options = %s, want %s", req.Options, tt.want)
+				}
+				io.WriteString(w, `{"done":true}`+"\n")
+			})
+			ch, err := StreamChat(context.Background(), []Message{{Role: "user", Content: "hi"}}, nil, tt.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for range ch {
+			}
+		})
+	}
+}
+
+// deref returns *p, or nil when p is nil, for printing optional fields.
+func deref[T any](p *T) any {
+	if p == nil {
+		return nil
+	}
+	return *p
+}
+
+func TestOptionsMerge(t *testing.T) {
+	low, high, topP, seed := 0.1, 0.9, 0.5, 3
+	base := Options{Temperature: &low, NumCtx: 4096, Stop: []string{"###"}}
+	tests := []struct {
+		name string
+		over Options
+		want string
+	}{
+		{"zero override keeps the base", Options{}, `{"temperature":0.1,"num_ctx":4096,"stop":["###"]}`},
+		{"set fields win", Options{Temperature: &high, NumCtx: 8192}, `{"temperature":0.9,"num_ctx":8192,"stop":["###"]}`},
+		{"new fields added", Options{TopP: &topP, Seed: &seed, NumPredict: 256}, `{"temperature":0.1,"top_p":0.5,"seed":3,"num_ctx":4096,"num_predict":256,"stop":["###"]}`},
+		{"empty stop list clears", Options{Stop: []string{}}, `{"temperature":0.1,"num_ctx":4096}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := json.Marshal(base.Merge(tt.over))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("Merge() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestStreamChatOptionsOnlyWhenSet(t *testing.T) {
+	temp := 0.0
+	tests := []struct {
+		name string
+		opts Options
+		want string // the "options" object; "" when the key is absent
+	}{
+		{"zero options omitted", Options{}, ""},
+		{"empty stop list omitted", Options{Stop: []string{}}, ""},
+		{"zero temperature sent", Options{Temperature: &temp}, `{"temperature":0}`},
+		{"length limits", Options{NumCtx: 8192, NumPredict: 512}, `{"num_ctx":8192,"num_predict":512}`},
+		{"stop sequences", Options{Stop: []string{"\n\n", "User:"}}, `{"stop":["\n\n","User:"]}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stubOllama(t, func(w http.ResponseWriter, r *http.Request) {
+				var req map[string]json.RawMessage
+				if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+					t.Error(err)
+				}
+				if got := string(req["options"]); got != tt.want {
+					t.Errorf("options = %q, want %q", got, tt.want)
+				}
+				io.WriteString(w, `{"done":true}`+"\n")
+			})
+			ch, err := StreamChat(context.Background(), []Message{{Role: "user", Content: "hi"}}, nil, tt.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for range ch {
+			}
+		})
+	}
+}
@@ -0,0 +1,158 @@
+package llm
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Embedder turns text into an embedding vector. KnowledgeBase depends on
+// this interface rather than on a specific provider.
+type Embedder interface {
+	Embed(ctx context.Context, text string) ([]float64, error)
+}
+
+// OllamaEmbedder embeds via the local Ollama /api/embeddings endpoint using
+// the configured EMBEDDING_MODEL. It is the default provider.
+type OllamaEmbedder struct{}
+
+// Embed implements Embedder by delegating to the package-level Embed.
+func (OllamaEmbedder) Embed(ctx context.Context, text string) ([]float64, error) {
+	return Embed(ctx, text)
+}
+
+// NormalizingEmbedder wraps an Embedder and L2-normalizes every vector it
+// returns (see Normalize), so stored and query vectors all have unit length
+// whatever the model outputs.
+type NormalizingEmbedder struct {
+	Embedder
+}
+
+// Embed implements Embedder.
+func (e NormalizingEmbedder) Embed(ctx context.Context, text string) ([]float64, error) {
+	vec, err := e.Embedder.Embed(ctx, text)
+	if err != nil {
+		return nil, err
+	}
+	return Normalize(vec), nil
+}
+
+const defaultOpenAIBaseURL = "https://api.openai.com/v1"
+
+// OpenAIEmbedder embeds via an OpenAI-compatible POST {baseURL}/embeddings
+// endpoint (OpenAI itself, vLLM, LocalAI, LM Studio, ...).
+type OpenAIEmbedder struct {
+	baseURL string
+	apiKey  string
+	model   string
+	http    *http.Client
+}
+
+// NewOpenAIEmbedder returns an OpenAIEmbedder. apiKey may be empty for
+// servers that do not require authentication.
+func NewOpenAIEmbedder(baseURL, apiKey, model string) *OpenAIEmbedder {
+	return &OpenAIEmbedder{
+		baseURL: strings.TrimRight(baseURL, "/"),
+		apiKey:  apiKey,
+		model:   model,
+		http:    httpClient,
+	}
+}
+
+// openAIEmbedRequest is the JSON body sent to an OpenAI-compatible server.
+type openAIEmbedRequest struct {
+	Model string `json:"model"`
+	Input string `json:"input"`
+}
+
+// openAIEmbedResponse is the subset of the OpenAI embeddings response we use.
+type openAIEmbedResponse struct {
+	Data []struct {
+		Embedding []float64 `json:"embedding"`
+	} `json:"data"`
+}
+
+// Embed implements Embedder.
+func (e *OpenAIEmbedder) Embed(ctx context.Context, text string) ([]float64, error) {
+	body, err := json.Marshal(openAIEmbedRequest{Model: e.model, Input: text})
+	if err != nil {
+		return nil, fmt.Errorf("embed: marshal: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.baseURL+"/embeddings", bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("embed: build request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if e.apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+e.apiKey)
+	}
+
+	resp, err := e.http.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("embed: http: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("embed: openai status %d: %s", resp.StatusCode, errorBody(resp.Body))
+	}
+
+	var result openAIEmbedResponse
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("embed: decode: %w", err)
+	}
+	if len(result.Data) == 0 || len(result.Data[0].Embedding) == 0 {
+		return nil, fmt.Errorf("embed: empty vector returned by openai-compatible server")
+	}
+	if err := checkFinite(result.Data[0].Embedding); err != nil {
+		return nil, err
+	}
+	return result.Data[0].Embedding, nil
+}
+
+// NewEmbedderFromEnv returns the Embedder selected by EMBEDDING_PROVIDER:
+// "ollama" (default) or "openai". The OpenAI-compatible provider reads
+// EMBEDDING_BASE_URL (default https://api.openai.com/v1) and
+// EMBEDDING_API_KEY, and uses EMBEDDING_MODEL as the model name.
+// EMBEDDING_NORMALIZE=true wraps the provider in a NormalizingEmbedder.
+// LLM_FAKE=true overrides all of this with a FakeEmbedder, except in
+// production where it is an ErrFakeInProduction.
+func NewEmbedderFromEnv() (Embedder, error) {
+	fake, err := fakeSelected()
+	if err != nil {
+		return nil, err
+	}
+	if fake {
+		return NewFakeEmbedder(), nil
+	}
+	var embedder Embedder
+	switch provider := strings.ToLower(strings.TrimSpace(os.Getenv("EMBEDDING_PROVIDER"))); provider {
+	case "", "ollama":
+		embedder = OllamaEmbedder{}
+	case "openai":
+		baseURL := strings.TrimSpace(os.Getenv("EMBEDDING_BASE_URL"))
+		if baseURL == "" {
+			baseURL = defaultOpenAIBaseURL
+		}
+		embedder = NewOpenAIEmbedder(baseURL, os.Getenv("EMBEDDING_API_KEY"), embeddingModel)
+	default:
+		return nil, fmt.Errorf("embed: unknown EMBEDDING_PROVIDER %q (want ollama or openai)", provider)
+	}
+
+	if raw := strings.TrimSpace(os.Getenv("EMBEDDING_NORMALIZE")); raw != "" {
+		normalize, err := strconv.ParseBool(raw)
+		if err != nil {
+			return nil, fmt.Errorf("embed: invalid EMBEDDING_NORMALIZE %q", raw)
+		}
+		if normalize {
+			embedder = NormalizingEmbedder{embedder}
+		}
+	}
+	return embedder, nil
+}
@@ -0,0 +1,124 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestNewEmbedderFromEnv(t *testing.T) {
+	tests := []struct {
+		name      string
+		provider  string
+		normalize string
+		want      string // %T of the result
+		wantErr   bool
+	}{
+		{"default is ollama", "", "", "llm.OllamaEmbedder", false},
+		{"ollama", "ollama", "", "llm.OllamaEmbedder", false},
+		{"openai any case", " OpenAI ", "", "*llm.OpenAIEmbedder", false},
+		{"normalized", "ollama", "true", "llm.NormalizingEmbedder", false},
+		{"normalize off", "openai", "false", "*llm.OpenAIEmbedder", false},
+		{"unknown provider", "cohere", "", "<nil>", true},
+		{"bad normalize flag", "ollama", "maybe", "<nil>", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("LLM_FAKE", "")
+			t.Setenv("EMBEDDING_PROVIDER", tt.provider)
+			t.Setenv("EMBEDDING_NORMALIZE", tt.normalize)
+			got, err := NewEmbedderFromEnv()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("NewEmbedderFromEnv() err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if typ := fmt.Sprintf("%T", got); typ != tt.want {
+				t.Errorf("NewEmbedderFromEnv() = %s, want %s", typ, tt.want)
+			}
+		})
+	}
+}
+
+func TestOpenAIEmbedder(t *testing.T) {
+	tests := []struct {
+		name    string
+		apiKey  string
+		status  int
+		reply   string
+		want    []float64
+		wantErr string
+	}{
+		{"embedding returned", "sk-test", http.StatusOK, `{"data":[{"embedding":[0.5,-0.25]}]}`, []float64{0.5, -0.25}, ""},
+		{"no key sends no auth header", "", http.StatusOK, `{"data":[{"embedding":[1]}]}`, []float64{1}, ""},
+		{"empty data", "sk-test", http.StatusOK, `{"data":[]}`, nil, "empty vector"},
+		{"error status keeps the body", "sk-test", http.StatusUnauthorized, `{"error":"bad key"}`, nil, "openai status 401: bad key"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.Method != http.MethodPost || r.URL.Path != "/v1/embeddings" {
+					t.Errorf("request = %s %s, want POST /v1/embeddings", r.Method, r.URL.Path)
+				}
+				wantAuth := ""
+				if tt.apiKey != "" {
+					wantAuth = "Bearer " + tt.apiKey
+				}
+				if got := r.Header.Get("Authorization"); got != wantAuth {
+					t.Errorf("Authorization = %q, want %q", got, wantAuth)
+				}
+				var req openAIEmbedRequest
+				body, _ := io.ReadAll(r.Body)
+				if err := json.Unmarshal(body, &req); err != nil || req.Model != "text-embedding-3-small" || req.Input != "hello" {
+					t.Errorf("request body = %s", body)
+				}
+				w.WriteHeader(tt.status)
+				io.WriteString(w, tt.reply)
+			}))
+			defer srv.Close()
+
+			e := NewOpenAIEmbedder(srv.URL+"/v1/", tt.apiKey, "text-embedding-3-small")
+			got, err := e.Embed(context.Background(), "hello")
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Embed() err = %v, want it to contain %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+				t.Errorf("Embed() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNormalizingEmbedder(t *testing.T) {
+	tests := []struct {
+		name  string
+		reply string
+		want  []float64
+	}{
+		{"scaled to unit length", `{"embedding":[3,4]}`, []float64{0.6, 0.8}},
+		{"unit vector unchanged", `{"embedding":[0,-1]}`, []float64{0, -1}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stubOllama(t, func(w http.ResponseWriter, _ *http.Request) {
+				io.WriteString(w, tt.reply)
+			})
+			got, err := NormalizingEmbedder{OllamaEmbedder{}}.Embed(context.Background(), "hello")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if fmt.Sprintf("%.6f", got) != fmt.Sprintf("%.6f", tt.want) {
+				t.Errorf("Embed() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -4,26 +4,163 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"math"
 	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 )
 
 const (
-	ollamaEmbedURL  = "http://localhost:11434/api/embeddings"
-	embeddingModel  = "nomic-embed-text"
-	clientTimeout   = 30 * time.Second
+	ollamaEmbedURL        = "http://localhost:11434/api/embeddings"
+	defaultEmbeddingModel = "nomic-embed-text"
+	clientTimeout         = 30 * time.Second
 )
 
+// embeddingModel is the Ollama model used by Embed. Override with
+// EMBEDDING_MODEL; the Qdrant collection dimension follows from it.
+var embeddingModel = func() string {
+	if m := strings.TrimSpace(os.Getenv("EMBEDDING_MODEL")); m != "" {
+		return m
+	}
+	return defaultEmbeddingModel
+}()
+
+// knownEmbeddingDims maps embedding models to the vector length they output.
+// Tags (":latest", ":v1.5") are stripped before lookup.
+var knownEmbeddingDims = map[string]int{
+	"nomic-embed-text":       768,
+	"mxbai-embed-large":      1024,
+	"snowflake-arctic-embed": 1024,
+	"bge-m3":                 1024,
+	"bge-large":              1024,
+	"all-minilm":             384,
+	"text-embedding-3-small": 1536,
+	"text-embedding-3-large": 3072,
+	"text-embedding-ada-002": 1536,
+}
+
+// EmbeddingModel returns the configured embedding model name.
+func EmbeddingModel() string { return embeddingModel }
+
+// EmbeddingDim returns the vector dimension produced by the configured
+// embedding model. EMBEDDING_DIM takes precedence, then a dimension found by
+// ProbeEmbeddingDim, then the built-in table; an unknown model that was
+// neither configured nor probed is an error so the collection is never
+// created with a guessed size.
+func EmbeddingDim() (int, error) {
+	if dim, ok, err := configuredDim(); ok || err != nil {
+		return dim, err
+	}
+	probed.mu.Lock()
+	dim := probed.dim
+	probed.mu.Unlock()
+	if dim > 0 {
+		return dim, nil
+	}
+	name, _, _ := strings.Cut(embeddingModel, ":")
+	if dim, ok := knownEmbeddingDims[name]; ok {
+		return dim, nil
+	}
+	return 0, fmt.Errorf("embed: unknown dimension for model %q; set EMBEDDING_DIM", embeddingModel)
+}
+
+// configuredDim returns EMBEDDING_DIM and whether it is set.
+func configuredDim() (int, bool, error) {
+	raw := strings.TrimSpace(os.Getenv("EMBEDDING_DIM"))
+	if raw == "" {
+		return 0, false, nil
+	}
+	dim, err := strconv.Atoi(raw)
+	if err != nil || dim <= 0 {
+		return 0, false, fmt.Errorf("embed: invalid EMBEDDING_DIM %q", raw)
+	}
+	return dim, true, nil
+}
+
+// probed caches the first successful ProbeEmbeddingDim result for the
+// process; the embedding model cannot change without a restart.
+var probed struct {
+	mu  sync.Mutex
+	dim int
+}
+
+// ErrEmbeddingDimMismatch is returned by ProbeEmbeddingDim when
+// EMBEDDING_DIM disagrees with the vectors the model actually returns.
+var ErrEmbeddingDimMismatch = errors.New("embed: EMBEDDING_DIM does not match the model")
+
+// dimProbeText is what ProbeEmbeddingDim embeds; any short text will do.
+const dimProbeText = "dimension probe"
+
+// ProbeEmbeddingDim embeds a short string with embedder and returns the
+// vector's length, which EmbeddingDim then reports in place of the built-in
+// table. The result is cached, so only the first call reaches the model. If
+// EMBEDDING_DIM is set and disagrees with the probe, it returns an error:
+// a collection created with the configured size would reject every upsert.
+func ProbeEmbeddingDim(ctx context.Context, embedder Embedder) (int, error) {
+	probed.mu.Lock()
+	defer probed.mu.Unlock()
+	if probed.dim == 0 {
+		vec, err := embedder.Embed(ctx, dimProbeText)
+		if err != nil {
+			return 0, fmt.Errorf("embed: probe dimension: %w", err)
+		}
+		if len(vec) == 0 {
+			return 0, fmt.Errorf("embed: probe dimension: empty vector")
+		}
+		probed.dim = len(vec)
+	}
+	if dim, ok, err := configuredDim(); err != nil {
+		return 0, err
+	} else if ok && dim != probed.dim {
+		return 0, fmt.Errorf("%w: EMBEDDING_DIM is %d but %q returns %d-dimensional vectors", ErrEmbeddingDimMismatch, dim, embeddingModel, probed.dim)
+	}
+	return probed.dim, nil
+}
+
+// ErrNonFiniteEmbedding is returned when a provider answers with a vector
+// containing NaN or ±Inf, which would corrupt similarity search.
+var ErrNonFiniteEmbedding = errors.New("embed: non-finite value in embedding")
+
+// checkFinite returns ErrNonFiniteEmbedding, naming the first offending
+// index, if vec contains NaN or ±Inf.
+func checkFinite(vec []float64) error {
+	for i, v := range vec {
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			return fmt.Errorf("%w: index %d is %v", ErrNonFiniteEmbedding, i, v)
+		}
+	}
+	return nil
+}
+
 // embedRequest is the JSON body sent to Ollama.
 type embedRequest struct {
 	Model  string `json:"model"`
 	Prompt string `json:"prompt"`
 }
 
-// embedResponse is the JSON body returned by Ollama.
+// embedResponse is the JSON body returned by Ollama. Older servers answer
+// with a single "embedding"; newer ones (and /api/embed) with a batch under
+// "embeddings", of which we only ever request one.
 type embedResponse struct {
-	Embedding []float64 `json:"embedding"`
+	Embedding  []float64   `json:"embedding"`
+	Embeddings [][]float64 `json:"embeddings"`
+}
+
+// vector returns the embedding from whichever shape the server used, or nil
+// when neither holds one.
+func (r embedResponse) vector() []float64 {
+	if len(r.Embedding) > 0 {
+		return r.Embedding
+	}
+	if len(r.Embeddings) > 0 {
+		return r.Embeddings[0]
+	}
+	return nil
 }
 
 // httpClient is reused across calls for connection pooling.
@@ -32,13 +169,20 @@ type embedResponse struct {
 var httpClient = &http.Client{Timeout: clientTimeout}
 
 // Embed sends text to the local Ollama instance and returns the raw
-// embedding vector produced by nomic-embed-text (768 dimensions).
+// embedding vector produced by the configured embedding model
+// (nomic-embed-text, 768 dimensions, by default).
 //
 // Timeout behaviour:
 //   - ctx cancellation / deadline takes effect immediately via the request context.
 //   - The package-level http.Client.Timeout (30s) is a defensive backstop for
 //     callers that pass context.Background().
 func Embed(ctx context.Context, text string) ([]float64, error) {
+	return embed(ctx, httpClient, text)
+}
+
+// embed performs the Embed request using client, so WarmUp can bypass the
+// 30s backstop while a cold model loads.
+func embed(ctx context.Context, client *http.Client, text string) ([]float64, error) {
 	body, err := json.Marshal(embedRequest{Model: embeddingModel, Prompt: text})
 	if err != nil {
 		return nil, fmt.Errorf("embed: marshal: %w", err)
@@ -50,14 +194,14 @@ func Embed(ctx context.Context, text string) ([]float64, error) {
 	}
 	req.Header.Set("Content-Type", "application/json")
 
-	resp, err := httpClient.Do(req)
+	resp, err := client.Do(req)
 	if err != nil {
 		return nil, fmt.Errorf("embed: http: %w", err)
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("embed: ollama status %d", resp.StatusCode)
+		return nil, fmt.Errorf("embed: ollama status %d: %s", resp.StatusCode, errorBody(resp.Body))
 	}
 
 	var result embedResponse
@@ -65,9 +209,13 @@ func Embed(ctx context.Context, text string) ([]float64, error) {
 		return nil, fmt.Errorf("embed: decode: %w", err)
 	}
 
-	if len(result.Embedding) == 0 {
+	vec := result.vector()
+	if len(vec) == 0 {
 		return nil, fmt.Errorf("embed: empty vector returned by ollama")
 	}
+	if err := checkFinite(vec); err != nil {
+		return nil, err
+	}
 
-	return result.Embedding, nil
+	return vec, nil
 }
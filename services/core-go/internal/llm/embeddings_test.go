@@ -0,0 +1,213 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math"
+	"net/http"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// setEmbeddingModel swaps embeddingModel and clears the probe cache for the
+// duration of the test.
+func setEmbeddingModel(t *testing.T, model string) {
+	t.Helper()
+	saved := embeddingModel
+	embeddingModel = model
+	probed.mu.Lock()
+	savedDim := probed.dim
+	probed.dim = 0
+	probed.mu.Unlock()
+	t.Cleanup(func() {
+		embeddingModel = saved
+		probed.mu.Lock()
+		probed.dim = savedDim
+		probed.mu.Unlock()
+	})
+}
+
+func TestEmbeddingDim(t *testing.T) {
+	tests := []struct {
+		name    string
+		model   string
+		env     string
+		want    int
+		wantErr bool
+	}{
+		{"known model", "nomic-embed-text", "", 768, false},
+		{"tag stripped", "mxbai-embed-large:latest", "", 1024, false},
+		{"openai model", "text-embedding-3-large", "", 3072, false},
+		{"EMBEDDING_DIM overrides table", "nomic-embed-text", "512", 512, false},
+		{"EMBEDDING_DIM for unknown model", "my-embedder", "300", 300, false},
+		{"unknown model", "my-embedder", "", 0, true},
+		{"invalid EMBEDDING_DIM", "nomic-embed-text", "-4", 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setEmbeddingModel(t, tt.model)
+			t.Setenv("EMBEDDING_DIM", tt.env)
+			got, err := EmbeddingDim()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("EmbeddingDim() err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("EmbeddingDim() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+// sizedEmbedder returns vectors of a fixed length and counts its calls.
+type sizedEmbedder struct {
+	dim   int
+	err   error
+	calls int
+}
+
+func (e *sizedEmbedder) Embed(context.Context, string) ([]float64, error) {
+	e.calls++
+	return make([]float64, e.dim), e.err
+}
+
+func TestProbeEmbeddingDim(t *testing.T) {
+	tests := []struct {
+		name     string
+		model    string
+		env      string
+		embedder *sizedEmbedder
+		want     int   // ProbeEmbeddingDim result
+		wantErr  error // checked with errors.Is when set
+		wantDim  int   // EmbeddingDim afterwards; 0 expects an error
+	}{
+		{"probe overrides the table", "nomic-embed-text", "", &sizedEmbedder{dim: 384}, 384, nil, 384},
+		{"probe sizes an unknown model", "my-embedder", "", &sizedEmbedder{dim: 300}, 300, nil, 300},
+		{"EMBEDDING_DIM agrees", "my-embedder", "300", &sizedEmbedder{dim: 300}, 300, nil, 300},
+		{"EMBEDDING_DIM disagrees", "nomic-embed-text", "512", &sizedEmbedder{dim: 384}, 0, ErrEmbeddingDimMismatch, 512},
+		{"unreachable embedder falls back to the table", "nomic-embed-text", "", &sizedEmbedder{err: errors.New("connection refused")}, 0, nil, 768},
+		{"empty vector", "my-embedder", "", &sizedEmbedder{dim: 0}, 0, nil, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setEmbeddingModel(t, tt.model)
+			t.Setenv("EMBEDDING_DIM", tt.env)
+
+			got, err := ProbeEmbeddingDim(context.Background(), tt.embedder)
+			wantFail := tt.want == 0
+			if (err != nil) != wantFail || (tt.wantErr != nil && !errors.Is(err, tt.wantErr)) {
+				t.Fatalf("ProbeEmbeddingDim() err = %v, want error %v (%v)", err, wantFail, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("ProbeEmbeddingDim() = %d, want %d", got, tt.want)
+			}
+
+			dim, err := EmbeddingDim()
+			if (err != nil) != (tt.wantDim == 0) || dim != tt.wantDim {
+				t.Errorf("EmbeddingDim() = %d, %v; want %d", dim, err, tt.wantDim)
+			}
+
+			// Only a probe that found a dimension is cached.
+			cached := tt.embedder.err == nil && tt.embedder.dim > 0
+			ProbeEmbeddingDim(context.Background(), tt.embedder)
+			if again := tt.embedder.calls == 2; again == cached {
+				t.Errorf("second probe called the embedder = %v, want %v", again, !cached)
+			}
+		})
+	}
+}
+
+func TestCheckFinite(t *testing.T) {
+	tests := []struct {
+		name    string
+		vec     []float64
+		wantErr bool
+	}{
+		{"finite", []float64{0.5, -1, 0}, false},
+		{"empty", nil, false},
+		{"NaN", []float64{0.5, math.NaN()}, true},
+		{"+Inf", []float64{math.Inf(1)}, true},
+		{"-Inf", []float64{0, 0, math.Inf(-1)}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkFinite(tt.vec)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("checkFinite(%v) err = %v, wantErr %v", tt.vec, err, tt.wantErr)
+			}
+			if err != nil && !errors.Is(err, ErrNonFiniteEmbedding) {
+				t.Errorf("checkFinite() err = %v, want ErrNonFiniteEmbedding", err)
+			}
+		})
+	}
+}
+
+func TestEmbedRejectsNonFiniteReply(t *testing.T) {
+	// JSON has no NaN or Inf literal, so a corrupt server's reply fails to
+	// decode, and an out-of-range number fails to fit a float64; either way
+	// Embed must return an error rather than a vector.
+	tests := []struct {
+		name  string
+		reply string
+		want  []float64
+	}{
+		{"finite", `{"embedding":[0.5,-0.25]}`, []float64{0.5, -0.25}},
+		{"NaN", `{"embedding":[0.5,NaN]}`, nil},
+		{"Infinity", `{"embeddings":[[Infinity,0]]}`, nil},
+		{"overflows float64", `{"embedding":[1e999,0]}`, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stubOllama(t, func(w http.ResponseWriter, _ *http.Request) {
+				io.WriteString(w, tt.reply)
+			})
+			got, err := Embed(context.Background(), "hello")
+			if (err != nil) != (tt.want == nil) {
+				t.Fatalf("Embed() = %v, %v; want %v", got, err, tt.want)
+			}
+			for i, v := range got {
+				if v != tt.want[i] {
+					t.Errorf("Embed()[%d] = %v, want %v", i, v, tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestEmbedResponseShapes(t *testing.T) {
+	tests := []struct {
+		name    string
+		reply   string
+		want    []float64
+		wantErr string
+	}{
+		{"legacy embedding", `{"embedding":[0.5,-0.25]}`, []float64{0.5, -0.25}, ""},
+		{"embeddings batch", `{"model":"nomic-embed-text","embeddings":[[0.5,-0.25]]}`, []float64{0.5, -0.25}, ""},
+		{"batch keeps the first vector", `{"embeddings":[[1,2],[3,4]]}`, []float64{1, 2}, ""},
+		{"embedding wins over embeddings", `{"embedding":[1],"embeddings":[[2]]}`, []float64{1}, ""},
+		{"empty batch", `{"embeddings":[]}`, nil, "empty vector"},
+		{"empty inner vector", `{"embeddings":[[]]}`, nil, "empty vector"},
+		{"neither field", `{}`, nil, "empty vector"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stubOllama(t, func(w http.ResponseWriter, _ *http.Request) {
+				io.WriteString(w, tt.reply)
+			})
+			got, err := Embed(context.Background(), "hello")
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Embed() err = %v, want it to contain %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("Embed() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
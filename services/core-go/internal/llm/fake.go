@@ -0,0 +1,137 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"hash/fnv"
+	"os"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// fakeEmbeddingDim is the FakeEmbedder dimension when neither EMBEDDING_DIM
+// nor the model table gives one.
+const fakeEmbeddingDim = 768
+
+// fakeTitleRunes caps the task title FakeChatProvider derives from the
+// user's message, matching the create_task schema's 50-character hint.
+const fakeTitleRunes = 50
+
+// FakeEnabled reports whether LLM_FAKE selects the deterministic in-process
+// providers instead of Ollama or an OpenAI-compatible server.
+func FakeEnabled() bool {
+	enabled, _ := strconv.ParseBool(strings.TrimSpace(os.Getenv("LLM_FAKE")))
+	return enabled
+}
+
+// ErrFakeInProduction is returned by NewEmbedderFromEnv and
+// NewChatProviderFromEnv when LLM_FAKE is set with APP_ENV=production, so a
+// stray test setting cannot serve canned answers to real users.
+var ErrFakeInProduction = errors.New("llm: LLM_FAKE is not allowed when APP_ENV=production")
+
+// fakeSelected reports whether the fake providers should be used, refusing
+// them in production.
+func fakeSelected() (bool, error) {
+	if !FakeEnabled() {
+		return false, nil
+	}
+	if strings.EqualFold(strings.TrimSpace(os.Getenv("APP_ENV")), "production") {
+		return false, ErrFakeInProduction
+	}
+	return true, nil
+}
+
+// FakeEmbedder is a deterministic, network-free Embedder for tests and CI.
+// Each lowercased word is hashed into one of Dim buckets with a ±1 sign and
+// the result is L2-normalized, so texts sharing words have a high cosine
+// similarity and the same text always yields the same vector.
+type FakeEmbedder struct {
+	Dim int
+}
+
+// NewFakeEmbedder returns a FakeEmbedder sized to EmbeddingDim, or to 768
+// when the configured model has no known dimension.
+func NewFakeEmbedder() FakeEmbedder {
+	dim, err := EmbeddingDim()
+	if err != nil {
+		dim = fakeEmbeddingDim
+	}
+	return FakeEmbedder{Dim: dim}
+}
+
+// Embed implements Embedder.
+func (e FakeEmbedder) Embed(_ context.Context, text string) ([]float64, error) {
+	dim := e.Dim
+	if dim <= 0 {
+		dim = fakeEmbeddingDim
+	}
+	vec := make([]float64, dim)
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	for _, word := range words {
+		h := fnv.New64a()
+		h.Write([]byte(word))
+		sum := h.Sum64()
+		sign := 1.0
+		if sum>>63 == 1 {
+			sign = -1
+		}
+		vec[sum%uint64(dim)] += sign
+	}
+	if len(words) == 0 {
+		// A zero vector has no direction; give empty text a fixed one so
+		// cosine search still accepts it.
+		vec[0] = 1
+	}
+	return Normalize(vec), nil
+}
+
+// FakeChatProvider is a deterministic, network-free ChatProvider for tests
+// and CI. When tools are offered and no tool result is in the history yet,
+// it calls the first tool with the last user message as the title;
+// otherwise it streams a canned reply echoing that message. Every stream
+// ends with a Stats chunk, like the real providers.
+type FakeChatProvider struct{}
+
+// StreamChat implements ChatProvider. The channel is buffered and closed
+// before it is returned.
+func (FakeChatProvider) StreamChat(_ context.Context, messages []Message, tools []Tool, _ Options) (<-chan Chunk, error) {
+	var question string
+	answered := false
+	for _, m := range messages {
+		switch m.Role {
+		case "user":
+			question = strings.TrimSpace(m.Content)
+		case "tool":
+			answered = true
+		}
+	}
+
+	ch := make(chan Chunk, 4)
+	var completion int
+	if len(tools) > 0 && !answered {
+		title := []rune(question)
+		if len(title) > fakeTitleRunes {
+			title = title[:fakeTitleRunes]
+		}
+		args, _ := json.Marshal(map[string]any{"title": string(title), "priority": 1})
+		ch <- Chunk{Kind: KindToolCall, ToolCall: &ToolCall{Name: tools[0].Function.Name, Arguments: args}}
+		completion = 1
+	} else {
+		for _, text := range []string{"Fake answer: ", question} {
+			ch <- Chunk{Kind: KindText, Text: text}
+			completion += len(strings.Fields(text))
+		}
+	}
+
+	var prompt int
+	for _, m := range messages {
+		prompt += len(strings.Fields(m.Content))
+	}
+	ch <- Chunk{Kind: KindStats, Stats: &Stats{PromptTokens: prompt, CompletionTokens: completion}}
+	close(ch)
+	return ch, nil
+}
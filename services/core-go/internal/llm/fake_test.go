@@ -0,0 +1,125 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"math"
+	"testing"
+)
+
+func TestFromEnvFakeSelection(t *testing.T) {
+	tests := []struct {
+		name     string
+		fake     string
+		appEnv   string
+		wantFake bool
+		wantErr  error
+	}{
+		{"fake in development", "true", "development", true, nil},
+		{"fake without APP_ENV", "1", "", true, nil},
+		{"fake in production", "true", "production", false, ErrFakeInProduction},
+		{"fake in production any case", "true", " Production ", false, ErrFakeInProduction},
+		{"real providers in production", "false", "production", false, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("LLM_FAKE", tt.fake)
+			t.Setenv("APP_ENV", tt.appEnv)
+			t.Setenv("EMBEDDING_PROVIDER", "")
+			t.Setenv("CHAT_PROVIDER", "")
+
+			embedder, err := NewEmbedderFromEnv()
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("NewEmbedderFromEnv() err = %v, want %v", err, tt.wantErr)
+			}
+			if _, ok := embedder.(FakeEmbedder); ok != tt.wantFake {
+				t.Errorf("NewEmbedderFromEnv() = %T, fake = %v, want %v", embedder, ok, tt.wantFake)
+			}
+
+			chat, err := NewChatProviderFromEnv()
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("NewChatProviderFromEnv() err = %v, want %v", err, tt.wantErr)
+			}
+			if _, ok := chat.(FakeChatProvider); ok != tt.wantFake {
+				t.Errorf("NewChatProviderFromEnv() = %T, fake = %v, want %v", chat, ok, tt.wantFake)
+			}
+		})
+	}
+}
+
+func TestFakeEmbedderIsDeterministic(t *testing.T) {
+	e := FakeEmbedder{Dim: 64}
+	tests := []struct {
+		name    string
+		a, b    string
+		wantSim func(float64) bool
+	}{
+		{"same text", "The Colosseum is in Rome", "The Colosseum is in Rome", func(s float64) bool { return math.Abs(s-1) < 1e-9 }},
+		{"case and punctuation ignored", "Rome, Italy!", "rome italy", func(s float64) bool { return math.Abs(s-1) < 1e-9 }},
+		{"shared words are similar", "the colosseum in rome", "where is the colosseum", func(s float64) bool { return s > 0.3 }},
+		{"empty text has a direction", "", "", func(s float64) bool { return math.Abs(s-1) < 1e-9 }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, err := e.Embed(context.Background(), tt.a)
+			if err != nil {
+				t.Fatal(err)
+			}
+			b, err := e.Embed(context.Background(), tt.b)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(a) != 64 || len(b) != 64 {
+				t.Fatalf("dims = %d, %d, want 64", len(a), len(b))
+			}
+			var dot float64
+			for i := range a {
+				dot += a[i] * b[i]
+			}
+			if !tt.wantSim(dot) {
+				t.Errorf("cosine(%q, %q) = %f", tt.a, tt.b, dot)
+			}
+		})
+	}
+}
+
+func TestFakeChatProviderReplies(t *testing.T) {
+	tool := Tool{Type: "function", Function: ToolFunction{Name: "create_task"}}
+	tests := []struct {
+		name     string
+		messages []Message
+		tools    []Tool
+		wantText string
+		wantCall string
+	}{
+		{"echoes the question", []Message{{Role: "user", Content: " where is rome? "}}, nil, "Fake answer: where is rome?", ""},
+		{"calls the first tool", []Message{{Role: "user", Content: "buy milk"}}, []Tool{tool}, "", `{"priority":1,"title":"buy milk"}`},
+		{"answers after the tool result", []Message{{Role: "user", Content: "buy milk"}, {Role: "tool", Content: "ok"}}, []Tool{tool}, "Fake answer: buy milk", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ch, err := FakeChatProvider{}.StreamChat(context.Background(), tt.messages, tt.tools, Options{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			var text, call string
+			var stats *Stats
+			for c := range ch {
+				switch c.Kind {
+				case KindText:
+					text += c.Text
+				case KindToolCall:
+					call = string(c.ToolCall.Arguments)
+				case KindStats:
+					stats = c.Stats
+				}
+			}
+			if text != tt.wantText || call != tt.wantCall {
+				t.Errorf("reply = (%q, %s), want (%q, %s)", text, call, tt.wantText, tt.wantCall)
+			}
+			if stats == nil {
+				t.Error("stream ended without a Stats chunk")
+			}
+		})
+	}
+}
@@ -0,0 +1,46 @@
+package llm
+
+import (
+	"fmt"
+	"math"
+)
+
+// CosineSimilarity returns the cosine of the angle between a and b, in
+// [-1, 1]. Vectors of different lengths are an error; a zero vector has no
+// direction and yields 0.
+func CosineSimilarity(a, b []float64) (float64, error) {
+	if len(a) != len(b) {
+		return 0, fmt.Errorf("similarity: length mismatch: %d vs %d", len(a), len(b))
+	}
+	var dot, normA, normB float64
+	for i := range a {
+		dot += a[i] * b[i]
+		normA += a[i] * a[i]
+		normB += b[i] * b[i]
+	}
+	if normA == 0 || normB == 0 {
+		return 0, nil
+	}
+	return dot / (math.Sqrt(normA) * math.Sqrt(normB)), nil
+}
+
+// Normalize returns vec scaled to unit L2 length, as a new slice. A zero
+// vector has no direction and is returned as an unchanged copy. Scaling
+// each vector by a positive constant leaves cosine similarity, and so the
+// ranking of search results, unchanged; it makes dot products equal cosines.
+func Normalize(vec []float64) []float64 {
+	var norm float64
+	for _, v := range vec {
+		norm += v * v
+	}
+	out := make([]float64, len(vec))
+	if norm == 0 {
+		copy(out, vec)
+		return out
+	}
+	norm = math.Sqrt(norm)
+	for i, v := range vec {
+		out[i] = v / norm
+	}
+	return out
+}
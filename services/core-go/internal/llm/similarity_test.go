@@ -0,0 +1,104 @@
+package llm
+
+import (
+	"math"
+	"testing"
+)
+
+func TestCosineSimilarity(t *testing.T) {
+	tests := []struct {
+		name    string
+		a, b    []float64
+		want    float64
+		wantErr bool
+	}{
+		{"identical", []float64{1, 2, 3}, []float64{1, 2, 3}, 1, false},
+		{"same direction", []float64{1, 2, 3}, []float64{2, 4, 6}, 1, false},
+		{"orthogonal", []float64{1, 0}, []float64{0, 1}, 0, false},
+		{"opposite", []float64{1, -1}, []float64{-1, 1}, -1, false},
+		{"zero vector", []float64{0, 0}, []float64{1, 1}, 0, false},
+		{"length mismatch", []float64{1, 2}, []float64{1, 2, 3}, 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := CosineSimilarity(tt.a, tt.b)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("CosineSimilarity() err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if math.Abs(got-tt.want) > 1e-9 {
+				t.Errorf("CosineSimilarity() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNormalize(t *testing.T) {
+	tests := []struct {
+		name string
+		vec  []float64
+		want []float64
+	}{
+		{"already unit", []float64{0, 1}, []float64{0, 1}},
+		{"scaled down", []float64{3, 4}, []float64{0.6, 0.8}},
+		{"negative components", []float64{-3, 0, 4}, []float64{-0.6, 0, 0.8}},
+		{"zero vector unchanged", []float64{0, 0}, []float64{0, 0}},
+		{"empty", []float64{}, []float64{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			in := append([]float64(nil), tt.vec...)
+			got := Normalize(tt.vec)
+			if len(got) != len(tt.want) {
+				t.Fatalf("Normalize(%v) = %v, want %v", tt.vec, got, tt.want)
+			}
+			var norm float64
+			for i := range got {
+				if math.Abs(got[i]-tt.want[i]) > 1e-9 {
+					t.Errorf("Normalize(%v) = %v, want %v", tt.vec, got, tt.want)
+				}
+				norm += got[i] * got[i]
+			}
+			if norm != 0 && math.Abs(math.Sqrt(norm)-1) > 1e-9 {
+				t.Errorf("|Normalize(%v)| = %v, want 1", tt.vec, math.Sqrt(norm))
+			}
+			for i := range in {
+				if tt.vec[i] != in[i] {
+					t.Errorf("Normalize modified its input: %v, was %v", tt.vec, in)
+				}
+			}
+		})
+	}
+}
+
+func TestNormalizePreservesSimilarityOrdering(t *testing.T) {
+	query := []float64{1, 2, 0.5}
+	docs := [][]float64{{10, 1, 0}, {0.2, 0.5, 0.1}, {-3, 4, 8}, {5, 9, 2}}
+	rank := func(q []float64, docs [][]float64) []float64 {
+		scores := make([]float64, len(docs))
+		for i, d := range docs {
+			scores[i], _ = CosineSimilarity(q, d)
+		}
+		return scores
+	}
+
+	before := rank(query, docs)
+	normalized := make([][]float64, len(docs))
+	for i, d := range docs {
+		normalized[i] = Normalize(d)
+	}
+	after := rank(Normalize(query), normalized)
+	for i := range docs {
+		if math.Abs(before[i]-after[i]) > 1e-9 {
+			t.Errorf("doc %d similarity = %v after normalizing, %v before", i, after[i], before[i])
+		}
+		// On unit vectors the dot product is the cosine, which is what
+		// Qdrant's Dot distance would rank by.
+		var dot float64
+		for j := range query {
+			dot += Normalize(query)[j] * normalized[i][j]
+		}
+		if math.Abs(dot-before[i]) > 1e-9 {
+			t.Errorf("doc %d dot product = %v, want cosine %v", i, dot, before[i])
+		}
+	}
+}
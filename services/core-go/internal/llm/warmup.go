@@ -0,0 +1,73 @@
+package llm
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// WarmUp forces the embedding and chat models to load so the first real
+// request does not pay the model load time. It makes one tiny embedding call
+// through embedder and, when chat is the Ollama provider, one empty chat call
+// (which Ollama treats as "load the model"). Remote chat providers keep their
+// models loaded and are skipped.
+//
+// Ollama calls use streamClient, which has no Timeout, so a cold load is
+// bounded only by ctx; pass a context with a generous deadline. Errors from
+// the two calls are joined; the caller decides whether they matter.
+func WarmUp(ctx context.Context, embedder Embedder, chat ChatProvider) error {
+	var errs []error
+	if err := warmEmbedder(ctx, embedder); err != nil {
+		errs = append(errs, fmt.Errorf("warmup: %w", err))
+	}
+	if _, ok := chat.(OllamaChatProvider); ok {
+		if err := loadChatModel(ctx); err != nil {
+			errs = append(errs, fmt.Errorf("warmup: %w", err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// warmEmbedder makes one tiny embedding call. The Ollama embedder bypasses
+// the 30s client backstop, which a cold model load can exceed.
+func warmEmbedder(ctx context.Context, embedder Embedder) error {
+	if n, ok := embedder.(NormalizingEmbedder); ok {
+		embedder = n.Embedder
+	}
+	var err error
+	if _, ok := embedder.(OllamaEmbedder); ok {
+		_, err = embed(ctx, streamClient, "warm up")
+	} else {
+		_, err = embedder.Embed(ctx, "warm up")
+	}
+	return err
+}
+
+// loadChatModel sends a chat request with no messages, which makes Ollama
+// load chatModel into memory without generating anything.
+func loadChatModel(ctx context.Context) error {
+	body, err := json.Marshal(chatRequest{Model: chatModel, Messages: []Message{}, Stream: false})
+	if err != nil {
+		return fmt.Errorf("chat: marshal: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, ollamaChatURL, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("chat: build request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := streamClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("chat: http: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return chatStatusError("ollama", chatModel, resp)
+	}
+	return nil
+}
@@ -0,0 +1,69 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"testing"
+)
+
+func TestWarmUp(t *testing.T) {
+	tests := []struct {
+		name      string
+		embedder  Embedder
+		chat      ChatProvider
+		status    int
+		wantCalls []string // "path model" in request order
+		wantErr   bool
+	}{
+		{"ollama embedder and chat", OllamaEmbedder{}, OllamaChatProvider{}, http.StatusOK,
+			[]string{"/api/embeddings " + embeddingModel, "/api/chat " + chatModel}, false},
+		{"normalizing wrapper still warms ollama", NormalizingEmbedder{OllamaEmbedder{}}, FakeChatProvider{}, http.StatusOK,
+			[]string{"/api/embeddings " + embeddingModel}, false},
+		{"fakes make no requests", NewFakeEmbedder(), FakeChatProvider{}, http.StatusOK, nil, false},
+		{"ollama unavailable", OllamaEmbedder{}, OllamaChatProvider{}, http.StatusServiceUnavailable,
+			[]string{"/api/embeddings " + embeddingModel, "/api/chat " + chatModel}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var (
+				mu    sync.Mutex
+				calls []string
+			)
+			stubOllama(t, func(w http.ResponseWriter, r *http.Request) {
+				var req struct {
+					Model    string            `json:"model"`
+					Messages []json.RawMessage `json:"messages"`
+				}
+				body, _ := io.ReadAll(r.Body)
+				json.Unmarshal(body, &req)
+				mu.Lock()
+				calls = append(calls, r.URL.Path+" "+req.Model)
+				mu.Unlock()
+				if r.URL.Path == "/api/chat" && len(req.Messages) != 0 {
+					t.Errorf("warm-up chat sent %d message(s), want none", len(req.Messages))
+				}
+				if tt.status != http.StatusOK {
+					http.Error(w, "loading", tt.status)
+					return
+				}
+				if r.URL.Path == "/api/embeddings" {
+					io.WriteString(w, `{"embedding":[0.1,0.2]}`)
+					return
+				}
+				io.WriteString(w, `{"message":{"role":"assistant","content":""},"done":true}`)
+			})
+
+			err := WarmUp(context.Background(), tt.embedder, tt.chat)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("WarmUp() err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if fmt.Sprint(calls) != fmt.Sprint(tt.wantCalls) {
+				t.Errorf("requests = %q, want %q", calls, tt.wantCalls)
+			}
+		})
+	}
+}
@@ -0,0 +1,110 @@
+package vector
+
+import "encoding/json"
+
+// Filter is a Qdrant payload filter built from Must, Should and MustNot
+// clauses. It serialises to Qdrant's filter document:
+//
+//	NewFilter().
+//		Must(MatchAny("user_id", "admin", uid)).
+//		MustNot(MatchValue("embedding_model", "old-model"))
+//
+// encodes as
+//
+//	{"must":[{"key":"user_id","match":{"any":["admin","<uid>"]}}],
+//	 "must_not":[{"key":"embedding_model","match":{"value":"old-model"}}]}
+//
+// A Filter is itself a Condition, so clauses nest: a Should filter inside
+// Must expresses "and (a or b)". Methods append and return f for chaining.
+type Filter struct {
+	must    []Condition
+	should  []Condition
+	mustNot []Condition
+}
+
+// Condition is one clause of a Filter: a field condition from MatchValue,
+// MatchAny or IsEmpty, or a nested *Filter.
+type Condition interface {
+	condition()
+}
+
+// NewFilter returns an empty Filter, which matches every point.
+func NewFilter() *Filter { return &Filter{} }
+
+// Must adds conditions that every matching point satisfies.
+func (f *Filter) Must(conds ...Condition) *Filter {
+	f.must = append(f.must, conds...)
+	return f
+}
+
+// Should adds conditions of which a matching point satisfies at least one.
+func (f *Filter) Should(conds ...Condition) *Filter {
+	f.should = append(f.should, conds...)
+	return f
+}
+
+// MustNot adds conditions that no matching point satisfies.
+func (f *Filter) MustNot(conds ...Condition) *Filter {
+	f.mustNot = append(f.mustNot, conds...)
+	return f
+}
+
+// Empty reports whether f has no clauses. Callers send no filter at all
+// rather than an empty one.
+func (f *Filter) Empty() bool {
+	return f == nil || len(f.must)+len(f.should)+len(f.mustNot) == 0
+}
+
+func (*Filter) condition() {}
+
+// MarshalJSON encodes f as a Qdrant filter, omitting empty clauses.
+func (f *Filter) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Must    []Condition `json:"must,omitempty"`
+		Should  []Condition `json:"should,omitempty"`
+		MustNot []Condition `json:"must_not,omitempty"`
+	}{f.must, f.should, f.mustNot})
+}
+
+// fieldCondition is a Qdrant field condition on payload Key.
+type fieldCondition struct {
+	Key   string `json:"key"`
+	Match any    `json:"match"`
+}
+
+func (fieldCondition) condition() {}
+
+// MatchValue matches points whose payload key equals value. value is a
+// string, integer or bool, as stored in the payload.
+func MatchValue(key string, value any) Condition {
+	return fieldCondition{Key: key, Match: struct {
+		Value any `json:"value"`
+	}{value}}
+}
+
+// MatchAny matches points whose payload key equals any of values. With no
+// values it matches nothing.
+func MatchAny(key string, values ...string) Condition {
+	if values == nil {
+		values = []string{} // "any": [] rather than null
+	}
+	return fieldCondition{Key: key, Match: struct {
+		Any []string `json:"any"`
+	}{values}}
+}
+
+// isEmptyCondition matches points whose payload Key is missing or empty.
+type isEmptyCondition struct {
+	IsEmpty struct {
+		Key string `json:"key"`
+	} `json:"is_empty"`
+}
+
+func (isEmptyCondition) condition() {}
+
+// IsEmpty matches points whose payload key is missing, null or [].
+func IsEmpty(key string) Condition {
+	var c isEmptyCondition
+	c.IsEmpty.Key = key
+	return c
+}
@@ -0,0 +1,77 @@
+package vector
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestFilterJSON(t *testing.T) {
+	tests := []struct {
+		name   string
+		filter *Filter
+		want   string
+	}{
+		{"empty", NewFilter(), `{}`},
+		{"match value string", NewFilter().Must(MatchValue("source", "a.md")),
+			`{"must":[{"key":"source","match":{"value":"a.md"}}]}`},
+		{"match value integer", NewFilter().Must(MatchValue("document_id", 7)),
+			`{"must":[{"key":"document_id","match":{"value":7}}]}`},
+		{"match value bool", NewFilter().Must(MatchValue("archived", false)),
+			`{"must":[{"key":"archived","match":{"value":false}}]}`},
+		{"match any", NewFilter().Must(MatchAny("user_id", "admin", "u1")),
+			`{"must":[{"key":"user_id","match":{"any":["admin","u1"]}}]}`},
+		{"match any without values", NewFilter().Must(MatchAny("user_id")),
+			`{"must":[{"key":"user_id","match":{"any":[]}}]}`},
+		{"is empty", NewFilter().Must(IsEmpty("language")),
+			`{"must":[{"is_empty":{"key":"language"}}]}`},
+		{"should", NewFilter().Should(MatchValue("language", "en"), IsEmpty("language")),
+			`{"should":[{"key":"language","match":{"value":"en"}},{"is_empty":{"key":"language"}}]}`},
+		{"must not", NewFilter().MustNot(MatchValue("embedding_model", "old-model")),
+			`{"must_not":[{"key":"embedding_model","match":{"value":"old-model"}}]}`},
+		{"all clauses", NewFilter().
+			Must(MatchAny("user_id", "admin", "u1")).
+			Should(MatchValue("source", "a.md")).
+			MustNot(MatchValue("embedding_model", "old-model")),
+			`{"must":[{"key":"user_id","match":{"any":["admin","u1"]}}],` +
+				`"should":[{"key":"source","match":{"value":"a.md"}}],` +
+				`"must_not":[{"key":"embedding_model","match":{"value":"old-model"}}]}`},
+		{"nested should inside must", NewFilter().
+			Must(MatchAny("user_id", "u1"), NewFilter().Should(MatchValue("language", "en"), IsEmpty("language"))),
+			`{"must":[{"key":"user_id","match":{"any":["u1"]}},` +
+				`{"should":[{"key":"language","match":{"value":"en"}},{"is_empty":{"key":"language"}}]}]}`},
+		{"chained calls append", NewFilter().Must(MatchValue("a", "1")).Must(MatchValue("b", "2")),
+			`{"must":[{"key":"a","match":{"value":"1"}},{"key":"b","match":{"value":"2"}}]}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := json.Marshal(tt.filter)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("filter = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFilterEmpty(t *testing.T) {
+	tests := []struct {
+		name   string
+		filter *Filter
+		want   bool
+	}{
+		{"nil", nil, true},
+		{"new", NewFilter(), true},
+		{"must", NewFilter().Must(IsEmpty("language")), false},
+		{"should", NewFilter().Should(IsEmpty("language")), false},
+		{"must not", NewFilter().MustNot(IsEmpty("language")), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.filter.Empty(); got != tt.want {
+				t.Errorf("Empty() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
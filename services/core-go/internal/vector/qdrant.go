@@ -4,23 +4,52 @@ import (
 	"bytes"
 	"context"
 	"crypto/rand"
+	"crypto/sha1"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"math"
 	"net/http"
 	"net/url"
+	"os"
 	"sort"
+	"strings"
+	"sync"
 	"time"
 )
 
-const searchTimeout = 10 * time.Second
+const (
+	searchTimeout = 10 * time.Second
+
+	// defaultUpsertBatchSize bounds how many points go into one upsert
+	// request. 64 × 768-dim vectors is roughly 1 MB of JSON.
+	defaultUpsertBatchSize = 64
+)
+
+// SharedUserID is the payload user_id of the shared knowledge base: chunks
+// tagged with it are visible to every user, and it is the owner the admin
+// endpoints and CLI read and write. It is read from SHARED_USER_ID at
+// startup and defaults to "admin".
+var SharedUserID = sharedUserIDFromEnv()
+
+func sharedUserIDFromEnv() string {
+	if id := strings.TrimSpace(os.Getenv("SHARED_USER_ID")); id != "" {
+		return id
+	}
+	return "admin"
+}
 
 // ScoredPoint is one result returned by a Qdrant similarity search.
 // Payload keys depend on how documents were ingested; the RAG pipeline
-// expects at least a "text" key holding the raw chunk content.
+// expects at least a "text" key holding the raw chunk content. Chunks from
+// agent.IngestText also carry "start_offset"/"end_offset", the rune range the
+// chunk covers in its source document. Vector is only set when the search
+// asked for it with SearchOptions.WithVectors.
 type ScoredPoint struct {
 	ID      any            `json:"id"`
 	Score   float64        `json:"score"`
 	Payload map[string]any `json:"payload"`
+	Vector  []float64      `json:"vector,omitempty"`
 }
 
 // PointInput is a single vector point to upsert into a Qdrant collection.
@@ -43,11 +72,38 @@ func NewPointID() string {
 		b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
 }
 
+// DeterministicPointID returns a UUID derived from source, chunkIndex and
+// userID, so ingesting the same chunk position again yields the same ID and
+// the upsert overwrites the point in place instead of adding a duplicate.
+// It is a version-5 style UUID: the SHA-1 of the three fields with the
+// version and variant bits set.
+func DeterministicPointID(source string, chunkIndex int, userID string) string {
+	// NUL separators keep ("a", "b") and ("ab", "") from hashing alike.
+	sum := sha1.Sum(fmt.Appendf(nil, "%s\x00%d\x00%s", userID, chunkIndex, source))
+	b := sum[:16]
+	b[6] = (b[6] & 0x0f) | 0x50 // version 5
+	b[8] = (b[8] & 0x3f) | 0x80 // variant 10xx
+	return fmt.Sprintf("%08x-%04x-%04x-%04x-%012x",
+		b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
+
 // QdrantClient is a thin HTTP wrapper around the Qdrant REST API.
-// It is safe for concurrent use.
+// It is safe for concurrent use. CollectionInfo, EnsureCollection,
+// UpsertPoints and Search retry connection errors and 5xx responses with
+// backoff (see SetRetryPolicy); 4xx responses fail on the first attempt.
 type QdrantClient struct {
 	baseURL string
 	http    *http.Client
+
+	// dims caches each collection's vector size so UpsertPoints can validate
+	// vectors locally instead of relying on Qdrant's opaque 400.
+	dimsMu sync.RWMutex
+	dims   map[string]int
+
+	upsertBatchSize int
+
+	retryAttempts  int
+	retryBaseDelay time.Duration
 }
 
 // NewQdrantClient returns a QdrantClient pointed at baseURL
@@ -56,13 +112,228 @@ func NewQdrantClient(baseURL string) *QdrantClient {
 	return &QdrantClient{
 		baseURL: baseURL,
 		http:    &http.Client{Timeout: searchTimeout},
+		dims:    map[string]int{},
+
+		upsertBatchSize: defaultUpsertBatchSize,
+		retryAttempts:   defaultRetryAttempts,
+		retryBaseDelay:  defaultRetryBaseDelay,
+	}
+}
+
+// SetUpsertBatchSize changes how many points UpsertPoints sends per request.
+// Values <= 0 restore the default. Call before the client is shared.
+func (q *QdrantClient) SetUpsertBatchSize(n int) {
+	if n <= 0 {
+		n = defaultUpsertBatchSize
+	}
+	q.upsertBatchSize = n
+}
+
+// collectionDim returns the cached vector size for collection, fetching it
+// via CollectionInfo on first use.
+func (q *QdrantClient) collectionDim(ctx context.Context, collection string) (int, error) {
+	q.dimsMu.RLock()
+	dim, ok := q.dims[collection]
+	q.dimsMu.RUnlock()
+	if ok {
+		return dim, nil
+	}
+
+	info, err := q.CollectionInfo(ctx, collection)
+	if err != nil {
+		return 0, err
+	}
+	q.setCollectionDim(collection, info.VectorSize)
+	return info.VectorSize, nil
+}
+
+func (q *QdrantClient) setCollectionDim(collection string, dim int) {
+	q.dimsMu.Lock()
+	q.dims[collection] = dim
+	q.dimsMu.Unlock()
+}
+
+func (q *QdrantClient) forgetCollectionDim(collection string) {
+	q.dimsMu.Lock()
+	delete(q.dims, collection)
+	q.dimsMu.Unlock()
+}
+
+// validatePoints checks every point's vector length against dim, and
+// every component for NaN or ±Inf, and names the first offending point.
+func validatePoints(collection string, dim int, points []PointInput) error {
+	for i, p := range points {
+		if len(p.Vector) != dim {
+			return fmt.Errorf("%w: point %s (index %d) has %d dims, collection %q expects %d",
+				ErrDimensionMismatch, p.ID, i, len(p.Vector), collection, dim)
+		}
+		for j, v := range p.Vector {
+			if math.IsNaN(v) || math.IsInf(v, 0) {
+				return fmt.Errorf("%w: point %s (index %d) has %v at component %d",
+					ErrNonFiniteVector, p.ID, i, v, j)
+			}
+		}
+	}
+	return nil
+}
+
+// ErrCollectionNotFound is returned by CollectionInfo when the collection
+// does not exist.
+var ErrCollectionNotFound = errors.New("qdrant: collection not found")
+
+// ErrDimensionMismatch is returned when a vector's length does not match the
+// dimension the collection was created with.
+var ErrDimensionMismatch = errors.New("qdrant: vector dimension mismatch")
+
+// ErrNonFiniteVector is returned by UpsertPoints when a vector contains NaN
+// or ±Inf, which Qdrant would index and then score nonsensically.
+var ErrNonFiniteVector = errors.New("qdrant: non-finite vector component")
+
+// ErrDistanceMismatch is returned by EnsureCollection when an existing
+// collection was created with a different distance metric.
+var ErrDistanceMismatch = errors.New("qdrant: distance metric mismatch")
+
+// Distance metrics accepted by Qdrant for a collection's vectors.
+const (
+	DistanceCosine = "Cosine"
+	DistanceDot    = "Dot"
+	DistanceEuclid = "Euclid"
+)
+
+// ValidDistance reports whether d is one of the Distance* metrics.
+func ValidDistance(d string) bool {
+	return d == DistanceCosine || d == DistanceDot || d == DistanceEuclid
+}
+
+// CollectionInfo is the subset of a collection's configuration the pipeline
+// cares about.
+type CollectionInfo struct {
+	VectorSize  int
+	Distance    string
+	PointsCount int
+}
+
+// CollectionInfo fetches the vector configuration of an existing collection.
+// Returns ErrCollectionNotFound when Qdrant answers 404.
+func (q *QdrantClient) CollectionInfo(ctx context.Context, collection string) (CollectionInfo, error) {
+	endpoint := fmt.Sprintf("%s/collections/%s", q.baseURL, url.PathEscape(collection))
+	resp, err := q.doWithRetry(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return CollectionInfo{}, fmt.Errorf("qdrant: collection_info http: %w", err)
 	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return CollectionInfo{}, ErrCollectionNotFound
+	}
+	if resp.StatusCode != http.StatusOK {
+		return CollectionInfo{}, fmt.Errorf("qdrant: collection_info status %d", resp.StatusCode)
+	}
+
+	var result struct {
+		Result struct {
+			PointsCount int `json:"points_count"`
+			Config      struct {
+				Params struct {
+					Vectors struct {
+						Size     int    `json:"size"`
+						Distance string `json:"distance"`
+					} `json:"vectors"`
+				} `json:"params"`
+			} `json:"config"`
+		} `json:"result"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return CollectionInfo{}, fmt.Errorf("qdrant: collection_info decode: %w", err)
+	}
+
+	vectors := result.Result.Config.Params.Vectors
+	return CollectionInfo{
+		VectorSize:  vectors.Size,
+		Distance:    vectors.Distance,
+		PointsCount: result.Result.PointsCount,
+	}, nil
+}
+
+// ListCollections returns the names of every collection in Qdrant.
+func (q *QdrantClient) ListCollections(ctx context.Context) ([]string, error) {
+	resp, err := q.doWithRetry(ctx, http.MethodGet, q.baseURL+"/collections", nil)
+	if err != nil {
+		return nil, fmt.Errorf("qdrant: list_collections http: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("qdrant: list_collections status %d", resp.StatusCode)
+	}
+
+	var result struct {
+		Result struct {
+			Collections []struct {
+				Name string `json:"name"`
+			} `json:"collections"`
+		} `json:"result"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("qdrant: list_collections decode: %w", err)
+	}
+
+	names := make([]string, len(result.Result.Collections))
+	for i, c := range result.Result.Collections {
+		names[i] = c.Name
+	}
+	return names, nil
+}
+
+// DeleteCollection drops collection and every point in it. Deleting a
+// collection that does not exist is not an error.
+func (q *QdrantClient) DeleteCollection(ctx context.Context, collection string) error {
+	endpoint := fmt.Sprintf("%s/collections/%s", q.baseURL, url.PathEscape(collection))
+	resp, err := q.doWithRetry(ctx, http.MethodDelete, endpoint, nil)
+	if err != nil {
+		return fmt.Errorf("qdrant: delete_collection http: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNotFound {
+		return fmt.Errorf("qdrant: delete_collection status %d", resp.StatusCode)
+	}
+	q.forgetCollectionDim(collection)
+	return nil
 }
 
 // EnsureCollection creates the named Qdrant collection with dim-dimensional
-// vectors and Cosine distance if it does not already exist.
-// It is idempotent: a 200 (already exists) is treated as success.
-func (q *QdrantClient) EnsureCollection(ctx context.Context, collection string, dim int) error {
+// vectors and the given distance metric if it does not already exist.
+// When the collection already exists its stored vector size and distance are
+// compared with dim and distance; ErrDimensionMismatch or ErrDistanceMismatch
+// is returned on disagreement, so a changed embedding model or metric fails
+// at startup instead of silently mixing incompatible vectors. Qdrant cannot
+// change either on a live collection — it must be recreated and re-ingested.
+func (q *QdrantClient) EnsureCollection(ctx context.Context, collection string, dim int, distance string) error {
+	if !ValidDistance(distance) {
+		return fmt.Errorf("qdrant: ensure_collection: unknown distance %q (want %s, %s, or %s)",
+			distance, DistanceCosine, DistanceDot, DistanceEuclid)
+	}
+
+	info, err := q.CollectionInfo(ctx, collection)
+	switch {
+	case err == nil:
+		if info.VectorSize != dim {
+			return fmt.Errorf("%w: collection %q stores %d-dim vectors but %d were requested; "+
+				"delete the collection and re-ingest, or switch back to the embedding model it was built with",
+				ErrDimensionMismatch, collection, info.VectorSize, dim)
+		}
+		if info.Distance != distance {
+			return fmt.Errorf("%w: collection %q uses %s but %s was requested; "+
+				"delete the collection and re-ingest, or set QDRANT_DISTANCE back to %s",
+				ErrDistanceMismatch, collection, info.Distance, distance, info.Distance)
+		}
+		q.setCollectionDim(collection, dim)
+		return nil
+	case !errors.Is(err, ErrCollectionNotFound):
+		return fmt.Errorf("qdrant: ensure_collection: %w", err)
+	}
+
 	type vectorParams struct {
 		Size     int    `json:"size"`
 		Distance string `json:"distance"`
@@ -72,36 +343,66 @@ func (q *QdrantClient) EnsureCollection(ctx context.Context, collection string,
 	}
 
 	body, err := json.Marshal(createReq{
-		Vectors: vectorParams{Size: dim, Distance: "Cosine"},
+		Vectors: vectorParams{Size: dim, Distance: distance},
 	})
 	if err != nil {
 		return fmt.Errorf("qdrant: ensure_collection marshal: %w", err)
 	}
 
 	endpoint := fmt.Sprintf("%s/collections/%s", q.baseURL, url.PathEscape(collection))
-	req, err := http.NewRequestWithContext(ctx, http.MethodPut, endpoint, bytes.NewReader(body))
-	if err != nil {
-		return fmt.Errorf("qdrant: ensure_collection build request: %w", err)
-	}
-	req.Header.Set("Content-Type", "application/json")
-
-	resp, err := q.http.Do(req)
+	resp, err := q.doWithRetry(ctx, http.MethodPut, endpoint, body)
 	if err != nil {
 		return fmt.Errorf("qdrant: ensure_collection http: %w", err)
 	}
 	defer resp.Body.Close()
 
-	// 200 = created.  409 Conflict = already exists — both are success (idempotent).
+	// 200 = created.  409 Conflict = created concurrently by another
+	// process since the lookup above — both are success (idempotent).
 	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusConflict {
 		return fmt.Errorf("qdrant: ensure_collection status %d", resp.StatusCode)
 	}
+	q.setCollectionDim(collection, dim)
 	return nil
 }
 
 // UpsertPoints inserts or updates a batch of points in the named collection.
 // Each PointInput must have a unique ID, a vector matching the collection's
 // configured dimension, and an arbitrary payload map.
+//
+// Vector lengths are validated against the collection's dimension (cached
+// from EnsureCollection or fetched once via CollectionInfo) before any HTTP
+// call is made; a wrong-length vector yields ErrDimensionMismatch naming the
+// offending point, and a NaN or ±Inf component ErrNonFiniteVector.
+//
+// Points are sent in batches of upsertBatchSize so a large document does not
+// produce one oversized request. Every batch is attempted; failures are
+// joined into the returned error. Once ctx is done no further batch is sent:
+// the batches not yet attempted are reported with the context's error.
 func (q *QdrantClient) UpsertPoints(ctx context.Context, collection string, points []PointInput) error {
+	dim, err := q.collectionDim(ctx, collection)
+	if err != nil {
+		return fmt.Errorf("qdrant: upsert: %w", err)
+	}
+	if err := validatePoints(collection, dim, points); err != nil {
+		return err
+	}
+
+	var errs []error
+	for start := 0; start < len(points); start += q.upsertBatchSize {
+		if err := ctx.Err(); err != nil {
+			errs = append(errs, fmt.Errorf("qdrant: upsert: cancelled before points %d-%d: %w", start, len(points)-1, err))
+			break
+		}
+		end := min(start+q.upsertBatchSize, len(points))
+		if err := q.upsertBatch(ctx, collection, points[start:end]); err != nil {
+			errs = append(errs, fmt.Errorf("points %d-%d: %w", start, end-1, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// upsertBatch sends one PUT /points request.
+func (q *QdrantClient) upsertBatch(ctx context.Context, collection string, points []PointInput) error {
 	type upsertReq struct {
 		Points []PointInput `json:"points"`
 	}
@@ -116,13 +417,7 @@ func (q *QdrantClient) UpsertPoints(ctx context.Context, collection string, poin
 		q.baseURL,
 		url.PathEscape(collection),
 	)
-	req, err := http.NewRequestWithContext(ctx, http.MethodPut, endpoint, bytes.NewReader(body))
-	if err != nil {
-		return fmt.Errorf("qdrant: upsert build request: %w", err)
-	}
-	req.Header.Set("Content-Type", "application/json")
-
-	resp, err := q.http.Do(req)
+	resp, err := q.doWithRetry(ctx, http.MethodPut, endpoint, body)
 	if err != nil {
 		return fmt.Errorf("qdrant: upsert http: %w", err)
 	}
@@ -136,30 +431,25 @@ func (q *QdrantClient) UpsertPoints(ctx context.Context, collection string, poin
 
 // AdminPoint is one stored chunk retrieved from the admin knowledge base.
 type AdminPoint struct {
-	ID         string
-	Source     string
-	Text       string
-	ChunkIndex int
+	ID             string
+	Source         string
+	Text           string
+	ChunkIndex     int
+	EmbeddingModel string
+	ChunkOverlap   *int  // overlap the document was chunked with; nil if not recorded
+	DocumentID     int64 // documents row the chunk belongs to; 0 if not recorded
 }
 
 // ScrollAdminPoints pages through every point in collection whose payload
-// user_id == "admin" and returns them all. It follows the Qdrant scroll
+// user_id == SharedUserID and returns them all. It follows the Qdrant scroll
 // cursor until next_page_offset is null.
 func (q *QdrantClient) ScrollAdminPoints(ctx context.Context, collection string) ([]AdminPoint, error) {
-	type mustCond struct {
-		Key   string `json:"key"`
-		Match struct {
-			Value string `json:"value"`
-		} `json:"match"`
-	}
 	type scrollReq struct {
-		Filter struct {
-			Must []mustCond `json:"must"`
-		} `json:"filter"`
-		WithPayload bool `json:"with_payload"`
-		WithVector  bool `json:"with_vector"`
-		Limit       int  `json:"limit"`
-		Offset      any  `json:"offset,omitempty"` // PointId | null
+		Filter      *Filter `json:"filter"`
+		WithPayload bool    `json:"with_payload"`
+		WithVector  bool    `json:"with_vector"`
+		Limit       int     `json:"limit"`
+		Offset      any     `json:"offset,omitempty"` // PointId | null
 	}
 	type scrollPoint struct {
 		ID      any            `json:"id"`
@@ -181,16 +471,13 @@ func (q *QdrantClient) ScrollAdminPoints(ctx context.Context, collection string)
 	var offset any // nil = first page
 
 	for {
-		filter := mustCond{Key: "user_id"}
-		filter.Match.Value = "admin"
-
 		reqBody := scrollReq{
+			Filter:      NewFilter().Must(MatchValue("user_id", SharedUserID)),
 			WithPayload: true,
 			WithVector:  false,
 			Limit:       250,
 			Offset:      offset,
 		}
-		reqBody.Filter.Must = []mustCond{filter}
 
 		body, err := json.Marshal(reqBody)
 		if err != nil {
@@ -226,9 +513,17 @@ func (q *QdrantClient) ScrollAdminPoints(ctx context.Context, collection string)
 			}
 			ap.Source, _ = p.Payload["source"].(string)
 			ap.Text, _ = p.Payload["text"].(string)
+			ap.EmbeddingModel, _ = p.Payload["embedding_model"].(string)
 			if ci, ok := p.Payload["chunk_index"].(float64); ok {
 				ap.ChunkIndex = int(ci)
 			}
+			if co, ok := p.Payload["chunk_overlap"].(float64); ok {
+				overlap := int(co)
+				ap.ChunkOverlap = &overlap
+			}
+			if id, ok := p.Payload["document_id"].(float64); ok {
+				ap.DocumentID = int64(id)
+			}
 			all = append(all, ap)
 		}
 
@@ -242,28 +537,90 @@ func (q *QdrantClient) ScrollAdminPoints(ctx context.Context, collection string)
 	return all, nil
 }
 
-// DeleteBySource removes every point in collection where both
-// user_id == "admin" AND source == source match.
-func (q *QdrantClient) DeleteBySource(ctx context.Context, collection, source string) error {
-	type mustCond struct {
-		Key   string `json:"key"`
-		Match struct {
-			Value string `json:"value"`
-		} `json:"match"`
+// StoredPoint is one point returned by ScrollPoints, without its vector.
+type StoredPoint struct {
+	ID      any            `json:"id"`
+	Payload map[string]any `json:"payload"`
+}
+
+// ScrollPoints pages through every point in collection matching filter and
+// returns them all. Pass a nil filter to scroll the whole collection.
+func (q *QdrantClient) ScrollPoints(ctx context.Context, collection string, filter *Filter) ([]StoredPoint, error) {
+	type scrollReq struct {
+		Filter      *Filter `json:"filter,omitempty"`
+		WithPayload bool    `json:"with_payload"`
+		WithVector  bool    `json:"with_vector"`
+		Limit       int     `json:"limit"`
+		Offset      any     `json:"offset,omitempty"`
 	}
-	type deleteReq struct {
-		Filter struct {
-			Must []mustCond `json:"must"`
-		} `json:"filter"`
+	type scrollResult struct {
+		Result struct {
+			Points         []StoredPoint `json:"points"`
+			NextPageOffset any           `json:"next_page_offset"`
+		} `json:"result"`
 	}
 
-	adminCond := mustCond{Key: "user_id"}
-	adminCond.Match.Value = "admin"
-	sourceCond := mustCond{Key: "source"}
-	sourceCond.Match.Value = source
+	endpoint := fmt.Sprintf(
+		"%s/collections/%s/points/scroll",
+		q.baseURL, url.PathEscape(collection),
+	)
+
+	var all []StoredPoint
+	var offset any
+
+	for {
+		body, err := json.Marshal(scrollReq{
+			Filter:      filter,
+			WithPayload: true,
+			Limit:       250,
+			Offset:      offset,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("qdrant: scroll_points marshal: %w", err)
+		}
 
-	reqBody := deleteReq{}
-	reqBody.Filter.Must = []mustCond{adminCond, sourceCond}
+		httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(body))
+		if err != nil {
+			return nil, fmt.Errorf("qdrant: scroll_points build request: %w", err)
+		}
+		httpReq.Header.Set("Content-Type", "application/json")
+
+		resp, err := q.http.Do(httpReq)
+		if err != nil {
+			return nil, fmt.Errorf("qdrant: scroll_points http: %w", err)
+		}
+		if resp.StatusCode != http.StatusOK {
+			resp.Body.Close()
+			return nil, fmt.Errorf("qdrant: scroll_points status %d", resp.StatusCode)
+		}
+
+		var result scrollResult
+		err = json.NewDecoder(resp.Body).Decode(&result)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("qdrant: scroll_points decode: %w", err)
+		}
+
+		all = append(all, result.Result.Points...)
+
+		if result.Result.NextPageOffset == nil {
+			break
+		}
+		offset = result.Result.NextPageOffset
+	}
+
+	return all, nil
+}
+
+// DeleteBySource removes every point in collection where both
+// user_id == SharedUserID AND source == source match.
+func (q *QdrantClient) DeleteBySource(ctx context.Context, collection, source string) error {
+	reqBody := map[string]any{
+		"filter": NewFilter().Must(
+			MatchValue("user_id", SharedUserID),
+			MatchValue("source", source),
+		),
+	}
 
 	body, err := json.Marshal(reqBody)
 	if err != nil {
@@ -292,23 +649,213 @@ func (q *QdrantClient) DeleteBySource(ctx context.Context, collection, source st
 	return nil
 }
 
-// filterClause is a Qdrant "should" filter that matches points whose user_id
-// payload field equals any of the supplied values (logical OR).
-// Used to retrieve both admin documents and user-specific documents in one query.
-type filterClause struct {
-	Should []struct {
-		Key   string `json:"key"`
-		Match struct {
-			Value string `json:"value"`
-		} `json:"match"`
-	} `json:"should"`
+// DeleteSourceExcept removes every point in collection whose payload
+// user_id equals userID and source equals source, except those whose
+// document_id is keepDocumentID. It clears out older chunks of a source once
+// a replacement document has been ingested under keepDocumentID.
+func (q *QdrantClient) DeleteSourceExcept(ctx context.Context, collection, userID, source string, keepDocumentID int64) error {
+	reqBody := map[string]any{
+		"filter": NewFilter().
+			Must(MatchValue("user_id", userID), MatchValue("source", source)).
+			MustNot(MatchValue("document_id", keepDocumentID)),
+	}
+
+	body, err := json.Marshal(reqBody)
+	if err != nil {
+		return fmt.Errorf("qdrant: delete_source_except marshal: %w", err)
+	}
+
+	endpoint := fmt.Sprintf(
+		"%s/collections/%s/points/delete",
+		q.baseURL, url.PathEscape(collection),
+	)
+	resp, err := q.doWithRetry(ctx, http.MethodPost, endpoint, body)
+	if err != nil {
+		return fmt.Errorf("qdrant: delete_source_except http: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("qdrant: delete_source_except status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// DeleteByUser removes every point in collection whose payload user_id
+// equals userID.
+func (q *QdrantClient) DeleteByUser(ctx context.Context, collection, userID string) error {
+	reqBody := map[string]any{
+		"filter": NewFilter().Must(MatchValue("user_id", userID)),
+	}
+
+	body, err := json.Marshal(reqBody)
+	if err != nil {
+		return fmt.Errorf("qdrant: delete_by_user marshal: %w", err)
+	}
+
+	endpoint := fmt.Sprintf(
+		"%s/collections/%s/points/delete",
+		q.baseURL, url.PathEscape(collection),
+	)
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("qdrant: delete_by_user build request: %w", err)
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+
+	resp, err := q.http.Do(httpReq)
+	if err != nil {
+		return fmt.Errorf("qdrant: delete_by_user http: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("qdrant: delete_by_user status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// DeleteByDocument removes every point in collection whose payload
+// document_id equals documentID and user_id equals userID. Chunks ingested
+// before document IDs were recorded have no document_id and are untouched.
+func (q *QdrantClient) DeleteByDocument(ctx context.Context, collection, userID string, documentID int64) error {
+	reqBody := map[string]any{
+		"filter": NewFilter().Must(
+			MatchValue("document_id", documentID),
+			MatchValue("user_id", userID),
+		),
+	}
+
+	body, err := json.Marshal(reqBody)
+	if err != nil {
+		return fmt.Errorf("qdrant: delete_by_document marshal: %w", err)
+	}
+
+	endpoint := fmt.Sprintf(
+		"%s/collections/%s/points/delete",
+		q.baseURL, url.PathEscape(collection),
+	)
+	resp, err := q.doWithRetry(ctx, http.MethodPost, endpoint, body)
+	if err != nil {
+		return fmt.Errorf("qdrant: delete_by_document http: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("qdrant: delete_by_document status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// CountPoints returns the exact number of points in collection matching
+// filter; a nil filter counts the whole collection.
+func (q *QdrantClient) CountPoints(ctx context.Context, collection string, filter *Filter) (int, error) {
+	type countReq struct {
+		Filter *Filter `json:"filter,omitempty"`
+		Exact  bool    `json:"exact"`
+	}
+
+	body, err := json.Marshal(countReq{Filter: filter, Exact: true})
+	if err != nil {
+		return 0, fmt.Errorf("qdrant: count marshal: %w", err)
+	}
+
+	endpoint := fmt.Sprintf(
+		"%s/collections/%s/points/count",
+		q.baseURL, url.PathEscape(collection),
+	)
+	resp, err := q.doWithRetry(ctx, http.MethodPost, endpoint, body)
+	if err != nil {
+		return 0, fmt.Errorf("qdrant: count http: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("qdrant: count status %d", resp.StatusCode)
+	}
+
+	var result struct {
+		Result struct {
+			Count int `json:"count"`
+		} `json:"result"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return 0, fmt.Errorf("qdrant: count decode: %w", err)
+	}
+	return result.Result.Count, nil
+}
+
+// SearchOptions controls which owners' documents a search may return.
+// The zero value applies no ownership filter and searches every document.
+type SearchOptions struct {
+	// UserIDs restricts results to documents owned by any of these users.
+	UserIDs []string
+	// IncludeAdmin adds the shared namespace, SharedUserID, to UserIDs.
+	IncludeAdmin bool
+	// Language, when set, keeps only chunks whose "language" payload equals
+	// it or is absent, so untagged chunks ingested before language detection
+	// was enabled stay searchable.
+	Language string
+	// Sources, when non-empty, keeps only chunks whose "source" payload is
+	// one of these labels, on top of the ownership and language filters.
+	Sources []string
+	// Params tunes how Qdrant searches; nil uses the collection defaults.
+	Params *SearchParams
+	// WithVectors returns each hit's stored vector in ScoredPoint.Vector,
+	// e.g. for clustering. Off by default: a 768-dim vector is several KB
+	// of JSON per hit.
+	WithVectors bool
+}
+
+// SearchParams are Qdrant's per-request search parameters, sent as the
+// search request's "params" object. The zero value changes nothing.
+type SearchParams struct {
+	// HnswEf is the size of the HNSW candidate list; larger values raise
+	// recall at the cost of latency. 0 keeps the collection's ef.
+	HnswEf int `json:"hnsw_ef,omitempty"`
+	// Exact skips the HNSW index and scores every point, which is both
+	// exact and cheap enough on small collections.
+	Exact bool `json:"exact,omitempty"`
+}
+
+// params returns p for the request body, or nil when p is unset or zero so
+// that no "params" key is sent.
+func (p *SearchParams) params() *SearchParams {
+	if p == nil || *p == (SearchParams{}) {
+		return nil
+	}
+	return p
+}
+
+// filter returns the Qdrant filter for opts, or nil when opts selects every
+// document.
+func (opts SearchOptions) filter() *Filter {
+	ids := opts.UserIDs
+	if opts.IncludeAdmin {
+		ids = append([]string{SharedUserID}, ids...)
+	}
+
+	f := NewFilter()
+	if len(ids) > 0 {
+		f.Must(MatchAny("user_id", ids...))
+	}
+	if opts.Language != "" {
+		f.Must(NewFilter().Should(MatchValue("language", opts.Language), IsEmpty("language")))
+	}
+	if len(opts.Sources) > 0 {
+		f.Must(MatchAny("source", opts.Sources...))
+	}
+	if f.Empty() {
+		return nil
+	}
+	return f
 }
 
 // Search returns up to limit points from collection ranked by cosine similarity
 // to vector.
 //
 // userID scoping: when userID is non-empty the results are restricted to
-// documents whose payload user_id is either "admin" (shared knowledge) or
+// documents whose payload user_id is either SharedUserID (shared knowledge) or
 // the supplied userID (personal context). Pass an empty string to return all
 // documents regardless of ownership (used for admin ingestion checks).
 func (q *QdrantClient) Search(
@@ -317,36 +864,39 @@ func (q *QdrantClient) Search(
 	vector []float64,
 	limit int,
 	userID string,
+) ([]ScoredPoint, error) {
+	var opts SearchOptions
+	if userID != "" {
+		opts = SearchOptions{UserIDs: []string{userID}, IncludeAdmin: true}
+	}
+	return q.SearchWithOptions(ctx, collection, vector, limit, opts)
+}
+
+// SearchWithOptions is Search with explicit ownership scoping, for operator
+// tooling that must look at arbitrary users' documents or at all of them.
+func (q *QdrantClient) SearchWithOptions(
+	ctx context.Context,
+	collection string,
+	vector []float64,
+	limit int,
+	opts SearchOptions,
 ) ([]ScoredPoint, error) {
 	type searchReq struct {
 		Vector      []float64     `json:"vector"`
 		Limit       int           `json:"limit"`
 		WithPayload bool          `json:"with_payload"`
-		Filter      *filterClause `json:"filter,omitempty"`
+		WithVector  bool          `json:"with_vector"`
+		Filter      *Filter       `json:"filter,omitempty"`
+		Params      *SearchParams `json:"params,omitempty"`
 	}
 
 	searchBody := searchReq{
 		Vector:      vector,
 		Limit:       limit,
 		WithPayload: true,
-	}
-
-	// Attach a filter that returns admin docs + this user's docs.
-	// When userID is empty we skip the filter so all docs are eligible.
-	if userID != "" {
-		fc := &filterClause{}
-		for _, uid := range []string{"admin", userID} {
-			cond := struct {
-				Key   string `json:"key"`
-				Match struct {
-					Value string `json:"value"`
-				} `json:"match"`
-			}{}
-			cond.Key = "user_id"
-			cond.Match.Value = uid
-			fc.Should = append(fc.Should, cond)
-		}
-		searchBody.Filter = fc
+		WithVector:  opts.WithVectors,
+		Filter:      opts.filter(),
+		Params:      opts.Params.params(),
 	}
 
 	body, err := json.Marshal(searchBody)
@@ -360,13 +910,7 @@ func (q *QdrantClient) Search(
 		url.PathEscape(collection), // handles "Personal Context" → "Personal%20Context"
 	)
 
-	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(body))
-	if err != nil {
-		return nil, fmt.Errorf("qdrant: build request: %w", err)
-	}
-	httpReq.Header.Set("Content-Type", "application/json")
-
-	resp, err := q.http.Do(httpReq)
+	resp, err := q.doWithRetry(ctx, http.MethodPost, endpoint, body)
 	if err != nil {
 		return nil, fmt.Errorf("qdrant: http: %w", err)
 	}
@@ -390,21 +934,12 @@ func (q *QdrantClient) Search(
 // the provided user scope (admin + userID). Results are sorted ascending.
 // When userID is empty, only admin sources are returned.
 func (q *QdrantClient) ListSources(ctx context.Context, collection, userID string) ([]string, error) {
-	type mustCond struct {
-		Key   string `json:"key"`
-		Match struct {
-			Value string `json:"value"`
-		} `json:"match"`
-	}
-
 	type scrollReq struct {
-		Filter *struct {
-			Should []mustCond `json:"should"`
-		} `json:"filter,omitempty"`
-		WithPayload bool `json:"with_payload"`
-		WithVector  bool `json:"with_vector"`
-		Limit       int  `json:"limit"`
-		Offset      any  `json:"offset,omitempty"`
+		Filter      *Filter `json:"filter,omitempty"`
+		WithPayload bool    `json:"with_payload"`
+		WithVector  bool    `json:"with_vector"`
+		Limit       int     `json:"limit"`
+		Offset      any     `json:"offset,omitempty"`
 	}
 
 	type scrollPoint struct {
@@ -423,8 +958,8 @@ func (q *QdrantClient) ListSources(ctx context.Context, collection, userID strin
 		q.baseURL, url.PathEscape(collection),
 	)
 
-	visibleUsers := []string{"admin"}
-	if userID != "" && userID != "admin" {
+	visibleUsers := []string{SharedUserID}
+	if userID != "" && userID != SharedUserID {
 		visibleUsers = append(visibleUsers, userID)
 	}
 
@@ -433,22 +968,13 @@ func (q *QdrantClient) ListSources(ctx context.Context, collection, userID strin
 
 	for {
 		reqBody := scrollReq{
+			Filter:      NewFilter().Must(MatchAny("user_id", visibleUsers...)),
 			WithPayload: true,
 			WithVector:  false,
 			Limit:       250,
 			Offset:      offset,
 		}
 
-		should := make([]mustCond, 0, len(visibleUsers))
-		for _, uid := range visibleUsers {
-			cond := mustCond{Key: "user_id"}
-			cond.Match.Value = uid
-			should = append(should, cond)
-		}
-		reqBody.Filter = &struct {
-			Should []mustCond `json:"should"`
-		}{Should: should}
-
 		body, err := json.Marshal(reqBody)
 		if err != nil {
 			return nil, fmt.Errorf("qdrant: list_sources marshal: %w", err)
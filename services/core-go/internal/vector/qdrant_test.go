@@ -0,0 +1,579 @@
+package vector
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httputil"
+	"net/url"
+	"sort"
+	"strings"
+	"testing"
+
+	"core-go/internal/vector/qdranttest"
+)
+
+// cancelAfter is an http.RoundTripper that cancels a context once n
+// requests have completed, so a test can end ctx between two batches. sent
+// counts every request handed to it, including ones that fail because ctx
+// has already ended.
+type cancelAfter struct {
+	n      int
+	sent   int
+	cancel context.CancelFunc
+	next   http.RoundTripper
+}
+
+func (c *cancelAfter) RoundTrip(req *http.Request) (*http.Response, error) {
+	c.sent++
+	resp, err := c.next.RoundTrip(req)
+	if c.n--; c.n == 0 {
+		c.cancel()
+	}
+	return resp, err
+}
+
+func testPoints(n int) []PointInput {
+	points := make([]PointInput, n)
+	for i := range points {
+		points[i] = PointInput{ID: NewPointID(), Vector: []float64{1, float64(i)}, Payload: map[string]any{"i": i}}
+	}
+	return points
+}
+
+func TestUpsertPointsStopsWhenContextEnds(t *testing.T) {
+	tests := []struct {
+		name        string
+		cancelAfter int // requests completed before ctx is cancelled; 0 never
+		wantUpserts int
+		wantStored  int
+		wantErr     bool
+	}{
+		{"not cancelled", 0, 3, 5, false},
+		// The dimension lookup is the first request, then one per batch.
+		{"cancelled after first batch", 2, 1, 2, true},
+		{"cancelled after second batch", 3, 2, 4, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := qdranttest.NewServer()
+			defer srv.Close()
+
+			q := NewQdrantClient(srv.URL)
+			q.SetUpsertBatchSize(2)
+			if err := q.EnsureCollection(context.Background(), "c", 2, DistanceCosine); err != nil {
+				t.Fatal(err)
+			}
+			q.forgetCollectionDim("c")
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			rt := &cancelAfter{n: tt.cancelAfter, cancel: cancel, next: http.DefaultTransport}
+			q.http = &http.Client{Transport: rt}
+
+			err := q.UpsertPoints(ctx, "c", testPoints(5))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("UpsertPoints() err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr && !errors.Is(err, context.Canceled) {
+				t.Errorf("UpsertPoints() err = %v, want it to wrap context.Canceled", err)
+			}
+			if got := srv.Upserts(); got != tt.wantUpserts {
+				t.Errorf("upsert requests = %d, want %d", got, tt.wantUpserts)
+			}
+			// Nothing is even attempted after the context ends.
+			if want := 1 + tt.wantUpserts; rt.sent != want {
+				t.Errorf("requests sent = %d, want %d", rt.sent, want)
+			}
+			if got := len(srv.Points("c")); got != tt.wantStored {
+				t.Errorf("stored points = %d, want %d", got, tt.wantStored)
+			}
+		})
+	}
+}
+
+func TestEnsureCollectionDimension(t *testing.T) {
+	tests := []struct {
+		name     string
+		existing int // vector size of a pre-existing collection; 0 none
+		dim      int
+		wantErr  error
+		wantSize int
+	}{
+		{"created when missing", 0, 4, nil, 4},
+		{"existing with same dim", 4, 4, nil, 4},
+		{"existing with other dim", 4, 8, ErrDimensionMismatch, 4},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := qdranttest.NewServer()
+			defer srv.Close()
+			ctx := context.Background()
+			if tt.existing > 0 {
+				if err := NewQdrantClient(srv.URL).EnsureCollection(ctx, "c", tt.existing, DistanceCosine); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			q := NewQdrantClient(srv.URL)
+			err := q.EnsureCollection(ctx, "c", tt.dim, DistanceCosine)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("EnsureCollection() err = %v, want %v", err, tt.wantErr)
+			}
+			info, err := q.CollectionInfo(ctx, "c")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if info.VectorSize != tt.wantSize {
+				t.Errorf("collection vector size = %d, want %d", info.VectorSize, tt.wantSize)
+			}
+		})
+	}
+}
+
+func TestEnsureCollectionDriftMessage(t *testing.T) {
+	tests := []struct {
+		name     string
+		size     int
+		distance string
+		wantErr  error
+		wantHint string
+	}{
+		{"matching collection", 768, "Cosine", nil, ""},
+		{"dimension drift", 1024, "Cosine", ErrDimensionMismatch, "stores 1024-dim vectors but 768 were requested; delete the collection and re-ingest"},
+		{"distance drift", 768, "Dot", ErrDistanceMismatch, "set QDRANT_DISTANCE back to Dot"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var creates int
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.Method != http.MethodGet {
+					creates++
+					http.Error(w, "unexpected "+r.Method, http.StatusBadRequest)
+					return
+				}
+				fmt.Fprintf(w, `{"result":{"points_count":12,"config":{"params":{"vectors":{"size":%d,"distance":%q}}}}}`, tt.size, tt.distance)
+			}))
+			defer srv.Close()
+
+			err := NewQdrantClient(srv.URL).EnsureCollection(context.Background(), "docs", 768, DistanceCosine)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("EnsureCollection() err = %v, want %v", err, tt.wantErr)
+			}
+			if tt.wantHint != "" && !strings.Contains(err.Error(), tt.wantHint) {
+				t.Errorf("EnsureCollection() err = %q, want it to contain %q", err, tt.wantHint)
+			}
+			if creates != 0 {
+				t.Errorf("EnsureCollection sent %d write request(s) to an existing collection", creates)
+			}
+		})
+	}
+}
+
+func TestUpsertPointsDimensionCheck(t *testing.T) {
+	tests := []struct {
+		name       string
+		badIndex   int // point given a 3-dim vector; -1 none
+		wantStored int
+	}{
+		{"correct batch", -1, 4},
+		{"one wrong-length vector", 2, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := qdranttest.NewServer()
+			defer srv.Close()
+			q := NewQdrantClient(srv.URL)
+			if err := q.EnsureCollection(context.Background(), "c", 2, DistanceCosine); err != nil {
+				t.Fatal(err)
+			}
+			points := testPoints(4)
+			if tt.badIndex >= 0 {
+				points[tt.badIndex].Vector = []float64{1, 2, 3}
+			}
+
+			err := q.UpsertPoints(context.Background(), "c", points)
+			if tt.badIndex < 0 {
+				if err != nil {
+					t.Fatalf("UpsertPoints() err = %v", err)
+				}
+			} else {
+				if !errors.Is(err, ErrDimensionMismatch) {
+					t.Fatalf("UpsertPoints() err = %v, want ErrDimensionMismatch", err)
+				}
+				bad := points[tt.badIndex].ID
+				if msg := err.Error(); !strings.Contains(msg, bad) || !strings.Contains(msg, fmt.Sprintf("index %d", tt.badIndex)) {
+					t.Errorf("error %q does not name point %s at index %d", msg, bad, tt.badIndex)
+				}
+				if srv.Upserts() != 0 {
+					t.Errorf("%d upsert requests sent for an invalid batch", srv.Upserts())
+				}
+			}
+			if got := len(srv.Points("c")); got != tt.wantStored {
+				t.Errorf("stored %d points, want %d", got, tt.wantStored)
+			}
+		})
+	}
+}
+
+func TestUpsertPointsValidatesBeforeSending(t *testing.T) {
+	srv := qdranttest.NewServer()
+	defer srv.Close()
+	q := NewQdrantClient(srv.URL)
+	if err := q.EnsureCollection(context.Background(), "c", 2, DistanceCosine); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		vector []float64
+		want   error
+	}{
+		{"wrong dimension", []float64{1, 2, 3}, ErrDimensionMismatch},
+		{"non-finite", []float64{1, nanValue()}, ErrNonFiniteVector},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := q.UpsertPoints(context.Background(), "c", []PointInput{{ID: NewPointID(), Vector: tt.vector}})
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("UpsertPoints() err = %v, want %v", err, tt.want)
+			}
+		})
+	}
+	if srv.Upserts() != 0 {
+		t.Errorf("invalid points reached the server %d time(s)", srv.Upserts())
+	}
+}
+
+func nanValue() float64 {
+	var zero float64
+	return zero / zero
+}
+
+func TestDeterministicPointID(t *testing.T) {
+	a := DeterministicPointID("notes.md", 3, "u1")
+	tests := []struct {
+		name   string
+		source string
+		index  int
+		user   string
+		same   bool
+	}{
+		{"same inputs", "notes.md", 3, "u1", true},
+		{"other index", "notes.md", 4, "u1", false},
+		{"other user", "notes.md", 3, "u2", false},
+		{"other source", "notes.txt", 3, "u1", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := DeterministicPointID(tt.source, tt.index, tt.user)
+			if (a == b) != tt.same {
+				t.Errorf("DeterministicPointID(%q, %d, %q) = %s vs %s, same = %v", tt.source, tt.index, tt.user, b, a, tt.same)
+			}
+			if len(b) != 36 || b[14] != '5' {
+				t.Errorf("DeterministicPointID() = %s, want a version-5 UUID", b)
+			}
+		})
+	}
+}
+
+func TestSearchOptionsFilter(t *testing.T) {
+	tests := []struct {
+		name string
+		opts SearchOptions
+		want string
+	}{
+		{"zero value", SearchOptions{}, "null"},
+		{"user and shared", SearchOptions{UserIDs: []string{"u1"}, IncludeAdmin: true},
+			fmt.Sprintf(`{"must":[{"key":"user_id","match":{"any":["%s","u1"]}}]}`, SharedUserID)},
+		{"sources", SearchOptions{Sources: []string{"a.md"}}, `{"must":[{"key":"source","match":{"any":["a.md"]}}]}`},
+		{"language keeps untagged chunks", SearchOptions{Language: "en"},
+			`{"must":[{"should":[{"key":"language","match":{"value":"en"}},{"is_empty":{"key":"language"}}]}]}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := json.Marshal(tt.opts.filter())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("filter = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSearchScoping(t *testing.T) {
+	srv := qdranttest.NewServer()
+	defer srv.Close()
+	q := NewQdrantClient(srv.URL)
+	ctx := context.Background()
+	if err := q.EnsureCollection(ctx, "c", 2, DistanceCosine); err != nil {
+		t.Fatal(err)
+	}
+	var points []PointInput
+	for i, owner := range []string{SharedUserID, "u1", "u2", "u3"} {
+		points = append(points, PointInput{ID: NewPointID(), Vector: []float64{1, float64(i)}, Payload: map[string]any{"user_id": owner}})
+	}
+	if err := q.UpsertPoints(ctx, "c", points); err != nil {
+		t.Fatal(err)
+	}
+
+	owners := func(hits []ScoredPoint) string {
+		var got []string
+		for _, h := range hits {
+			got = append(got, fmt.Sprint(h.Payload["user_id"]))
+		}
+		sort.Strings(got)
+		return strings.Join(got, ",")
+	}
+	sorted := func(ids ...string) string {
+		sort.Strings(ids)
+		return strings.Join(ids, ",")
+	}
+
+	tests := []struct {
+		name   string
+		search func() ([]ScoredPoint, error)
+		want   string
+	}{
+		{"default RAG scope is user plus shared", func() ([]ScoredPoint, error) {
+			return q.Search(ctx, "c", []float64{1, 1}, 10, "u1")
+		}, sorted(SharedUserID, "u1")},
+		{"single user without shared", func() ([]ScoredPoint, error) {
+			return q.SearchWithOptions(ctx, "c", []float64{1, 1}, 10, SearchOptions{UserIDs: []string{"u2"}})
+		}, "u2"},
+		{"several users", func() ([]ScoredPoint, error) {
+			return q.SearchWithOptions(ctx, "c", []float64{1, 1}, 10, SearchOptions{UserIDs: []string{"u1", "u3"}})
+		}, "u1,u3"},
+		{"several users and shared", func() ([]ScoredPoint, error) {
+			return q.SearchWithOptions(ctx, "c", []float64{1, 1}, 10, SearchOptions{UserIDs: []string{"u2", "u3"}, IncludeAdmin: true})
+		}, sorted(SharedUserID, "u2", "u3")},
+		{"unfiltered", func() ([]ScoredPoint, error) {
+			return q.SearchWithOptions(ctx, "c", []float64{1, 1}, 10, SearchOptions{})
+		}, sorted(SharedUserID, "u1", "u2", "u3")},
+		{"empty user id is unfiltered", func() ([]ScoredPoint, error) {
+			return q.Search(ctx, "c", []float64{1, 1}, 10, "")
+		}, sorted(SharedUserID, "u1", "u2", "u3")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hits, err := tt.search()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := owners(hits); got != tt.want {
+				t.Errorf("hit owners = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEnsureCollectionDistance(t *testing.T) {
+	tests := []struct {
+		name     string
+		existing string // distance of a pre-existing collection; "" none
+		distance string
+		wantErr  bool
+		wantIs   error // checked with errors.Is when set
+	}{
+		{"cosine", "", DistanceCosine, false, nil},
+		{"dot", "", DistanceDot, false, nil},
+		{"euclid", "", DistanceEuclid, false, nil},
+		{"unknown metric", "", "Manhattan", true, nil},
+		{"existing with same metric", DistanceDot, DistanceDot, false, nil},
+		{"existing with other metric", DistanceCosine, DistanceEuclid, true, ErrDistanceMismatch},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			backend := qdranttest.NewServer()
+			defer backend.Close()
+			ctx := context.Background()
+			if tt.existing != "" {
+				if err := NewQdrantClient(backend.URL).EnsureCollection(ctx, "c", 2, tt.existing); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			// Record create request bodies on their way to the in-memory server.
+			target, _ := url.Parse(backend.URL)
+			proxy := httputil.NewSingleHostReverseProxy(target)
+			var creates []string
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.Method == http.MethodPut && r.URL.Path == "/collections/c" {
+					body, _ := io.ReadAll(r.Body)
+					creates = append(creates, string(body))
+					r.Body = io.NopCloser(bytes.NewReader(body))
+				}
+				proxy.ServeHTTP(w, r)
+			}))
+			defer srv.Close()
+
+			err := NewQdrantClient(srv.URL).EnsureCollection(ctx, "c", 2, tt.distance)
+			if (err != nil) != tt.wantErr || (tt.wantIs != nil && !errors.Is(err, tt.wantIs)) {
+				t.Fatalf("EnsureCollection() err = %v, wantErr %v (%v)", err, tt.wantErr, tt.wantIs)
+			}
+
+			wantCreates := 0
+			if tt.existing == "" && err == nil {
+				wantCreates = 1
+			}
+			if len(creates) != wantCreates {
+				t.Fatalf("sent %d create request(s), want %d", len(creates), wantCreates)
+			}
+			if wantCreates == 1 {
+				var req struct {
+					Vectors struct {
+						Size     int    `json:"size"`
+						Distance string `json:"distance"`
+					} `json:"vectors"`
+				}
+				if err := json.Unmarshal([]byte(creates[0]), &req); err != nil {
+					t.Fatal(err)
+				}
+				if req.Vectors.Distance != tt.distance || req.Vectors.Size != 2 {
+					t.Errorf("create body = %s, want distance %q and size 2", creates[0], tt.distance)
+				}
+			}
+		})
+	}
+}
+
+func TestSharedUserIDFromEnv(t *testing.T) {
+	tests := []struct {
+		name string
+		env  string
+		want string
+	}{
+		{"unset defaults to admin", "", "admin"},
+		{"configured", "team-kb", "team-kb"},
+		{"trimmed", "  team-kb ", "team-kb"},
+		{"blank defaults to admin", "   ", "admin"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("SHARED_USER_ID", tt.env)
+			if got := sharedUserIDFromEnv(); got != tt.want {
+				t.Errorf("sharedUserIDFromEnv() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSharedScopeUsesConfiguredID(t *testing.T) {
+	saved := SharedUserID
+	SharedUserID = "team-kb"
+	t.Cleanup(func() { SharedUserID = saved })
+
+	srv := qdranttest.NewServer()
+	defer srv.Close()
+	q := NewQdrantClient(srv.URL)
+	ctx := context.Background()
+	if err := q.EnsureCollection(ctx, "c", 2, DistanceCosine); err != nil {
+		t.Fatal(err)
+	}
+	var points []PointInput
+	for i, owner := range []string{"team-kb", "admin", "u1"} {
+		points = append(points, PointInput{ID: NewPointID(), Vector: []float64{1, float64(i)}, Payload: map[string]any{"user_id": owner, "source": "notes.md", "text": owner}})
+	}
+	if err := q.UpsertPoints(ctx, "c", points); err != nil {
+		t.Fatal(err)
+	}
+
+	owners := func(payloads []map[string]any) string {
+		var got []string
+		for _, p := range payloads {
+			got = append(got, fmt.Sprint(p["user_id"]))
+		}
+		sort.Strings(got)
+		return strings.Join(got, ",")
+	}
+	tests := []struct {
+		name string
+		read func() ([]map[string]any, error)
+		want string
+	}{
+		{"search includes the configured shared owner", func() ([]map[string]any, error) {
+			hits, err := q.Search(ctx, "c", []float64{1, 1}, 10, "u1")
+			var payloads []map[string]any
+			for _, h := range hits {
+				payloads = append(payloads, h.Payload)
+			}
+			return payloads, err
+		}, "team-kb,u1"},
+		{"admin scroll reads the configured shared owner", func() ([]map[string]any, error) {
+			admin, err := q.ScrollAdminPoints(ctx, "c")
+			var payloads []map[string]any
+			for _, p := range admin {
+				payloads = append(payloads, map[string]any{"user_id": p.Text}) // text holds the owner
+			}
+			return payloads, err
+		}, "team-kb"},
+		{"delete by source only touches the configured shared owner", func() ([]map[string]any, error) {
+			if err := q.DeleteBySource(ctx, "c", "notes.md"); err != nil {
+				return nil, err
+			}
+			var payloads []map[string]any
+			for _, p := range srv.Points("c") {
+				payloads = append(payloads, p.Payload)
+			}
+			return payloads, nil
+		}, "admin,u1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			payloads, err := tt.read()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := owners(payloads); got != tt.want {
+				t.Errorf("owners = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSearchWithVectors(t *testing.T) {
+	tests := []struct {
+		name        string
+		withVectors bool
+		reply       string
+		wantVector  []float64
+	}{
+		{"vectors off", false, `{"result":[{"id":"a","score":0.9,"payload":{"text":"Rome"}}]}`, nil},
+		{"vectors on", true, `{"result":[{"id":"a","score":0.9,"payload":{"text":"Rome"},"vector":[0.25,-0.5,1]}]}`, []float64{0.25, -0.5, 1}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var sent struct {
+				WithVector *bool `json:"with_vector"`
+			}
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				json.NewDecoder(r.Body).Decode(&sent)
+				io.WriteString(w, tt.reply)
+			}))
+			defer srv.Close()
+
+			got, err := NewQdrantClient(srv.URL).SearchWithOptions(context.Background(), "c", []float64{1, 0, 0}, 5, SearchOptions{WithVectors: tt.withVectors})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if sent.WithVector == nil {
+				t.Error("request has no with_vector")
+			} else if *sent.WithVector != tt.withVectors {
+				t.Errorf("request with_vector = %v, want %v", *sent.WithVector, tt.withVectors)
+			}
+			if len(got) != 1 || got[0].Payload["text"] != "Rome" {
+				t.Fatalf("SearchWithOptions() = %+v, want one hit with its payload", got)
+			}
+			if fmt.Sprint(got[0].Vector) != fmt.Sprint(tt.wantVector) || (got[0].Vector == nil) != (tt.wantVector == nil) {
+				t.Errorf("Vector = %v, want %v", got[0].Vector, tt.wantVector)
+			}
+		})
+	}
+}
@@ -0,0 +1,455 @@
+// Package qdranttest provides an in-memory Qdrant REST server for tests.
+//
+// It implements the subset of the API that vector.QdrantClient calls —
+// collections, upsert, search, scroll, count and delete by filter — with
+// payload filters evaluated the way Qdrant does for must/should/must_not,
+// match value/any and is_empty. Search scores by cosine similarity.
+package qdranttest
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Point is a stored point as the server holds it.
+type Point struct {
+	ID      string         `json:"id"`
+	Vector  []float64      `json:"vector"`
+	Payload map[string]any `json:"payload"`
+}
+
+type collection struct {
+	size     int
+	distance string
+	points   map[string]Point
+}
+
+// Server is an in-memory Qdrant. Create it with NewServer and point a
+// vector.QdrantClient at Server.URL.
+type Server struct {
+	*httptest.Server
+
+	mu          sync.Mutex
+	collections map[string]*collection
+	upserts     int
+
+	// BeforeUpsert, when set, is called with the collection name before
+	// each upsert request is applied. A non-nil error fails the request
+	// with 500 and stores nothing.
+	BeforeUpsert func(collection string, points []Point) error
+}
+
+// NewServer starts a Server. Callers must Close it.
+func NewServer() *Server {
+	s := &Server{collections: map[string]*collection{}}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serve))
+	return s
+}
+
+// Points returns a copy of every point in name, ordered by ID.
+func (s *Server) Points(name string) []Point {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c, ok := s.collections[name]
+	if !ok {
+		return nil
+	}
+	out := make([]Point, 0, len(c.points))
+	for _, p := range c.points {
+		out = append(out, p)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].ID < out[j].ID })
+	return out
+}
+
+// Collections returns the names of every collection, sorted.
+func (s *Server) Collections() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	names := make([]string, 0, len(s.collections))
+	for name := range s.collections {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Upserts returns how many upsert requests the server has accepted.
+func (s *Server) Upserts() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.upserts
+}
+
+func (s *Server) serve(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if len(parts) == 0 || parts[0] != "collections" {
+		http.NotFound(w, r)
+		return
+	}
+	if len(parts) == 1 {
+		s.listCollections(w)
+		return
+	}
+	name := parts[1]
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(parts) == 2 {
+		switch r.Method {
+		case http.MethodGet:
+			s.getCollection(w, name)
+		case http.MethodPut:
+			s.createCollection(w, r, name)
+		case http.MethodDelete:
+			delete(s.collections, name)
+			writeResult(w, true)
+		default:
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		}
+		return
+	}
+
+	c, ok := s.collections[name]
+	if !ok {
+		http.Error(w, `{"status":{"error":"Not found: collection"}}`, http.StatusNotFound)
+		return
+	}
+	op := strings.Join(parts[2:], "/")
+	switch {
+	case op == "points" && r.Method == http.MethodPut:
+		s.upsert(w, r, name, c)
+	case op == "points/search":
+		search(w, r, c)
+	case op == "points/scroll":
+		scroll(w, r, c)
+	case op == "points/count":
+		count(w, r, c)
+	case op == "points/delete":
+		deletePoints(w, r, c)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (s *Server) listCollections(w http.ResponseWriter) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	type named struct {
+		Name string `json:"name"`
+	}
+	list := []named{}
+	for name := range s.collections {
+		list = append(list, named{name})
+	}
+	writeResult(w, map[string]any{"collections": list})
+}
+
+func (s *Server) getCollection(w http.ResponseWriter, name string) {
+	c, ok := s.collections[name]
+	if !ok {
+		http.Error(w, `{"status":{"error":"Not found: collection"}}`, http.StatusNotFound)
+		return
+	}
+	writeResult(w, map[string]any{
+		"points_count": len(c.points),
+		"config": map[string]any{
+			"params": map[string]any{
+				"vectors": map[string]any{"size": c.size, "distance": c.distance},
+			},
+		},
+	})
+}
+
+func (s *Server) createCollection(w http.ResponseWriter, r *http.Request, name string) {
+	if _, ok := s.collections[name]; ok {
+		http.Error(w, `{"status":{"error":"already exists"}}`, http.StatusConflict)
+		return
+	}
+	var req struct {
+		Vectors struct {
+			Size     int    `json:"size"`
+			Distance string `json:"distance"`
+		} `json:"vectors"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Vectors.Size <= 0 {
+		http.Error(w, "bad collection config", http.StatusBadRequest)
+		return
+	}
+	s.collections[name] = &collection{size: req.Vectors.Size, distance: req.Vectors.Distance, points: map[string]Point{}}
+	writeResult(w, true)
+}
+
+func (s *Server) upsert(w http.ResponseWriter, r *http.Request, name string, c *collection) {
+	var req struct {
+		Points []Point `json:"points"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "bad upsert body", http.StatusBadRequest)
+		return
+	}
+	if s.BeforeUpsert != nil {
+		if err := s.BeforeUpsert(name, req.Points); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+	for _, p := range req.Points {
+		if len(p.Vector) != c.size {
+			http.Error(w, fmt.Sprintf("wrong vector size %d", len(p.Vector)), http.StatusBadRequest)
+			return
+		}
+	}
+	for _, p := range req.Points {
+		// Round-trip the payload so stored values have JSON types
+		// (float64 numbers), as a real Qdrant would return them.
+		raw, _ := json.Marshal(p.Payload)
+		p.Payload = nil
+		_ = json.Unmarshal(raw, &p.Payload)
+		c.points[p.ID] = p
+	}
+	s.upserts++
+	writeResult(w, map[string]any{"status": "completed"})
+}
+
+type scoredPoint struct {
+	ID      string         `json:"id"`
+	Score   float64        `json:"score"`
+	Payload map[string]any `json:"payload"`
+	Vector  []float64      `json:"vector,omitempty"`
+}
+
+func search(w http.ResponseWriter, r *http.Request, c *collection) {
+	var req struct {
+		Vector     []float64       `json:"vector"`
+		Limit      int             `json:"limit"`
+		WithVector bool            `json:"with_vector"`
+		Filter     json.RawMessage `json:"filter"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "bad search body", http.StatusBadRequest)
+		return
+	}
+	f, err := parseFilter(req.Filter)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	hits := []scoredPoint{}
+	for _, p := range c.points {
+		if !f.match(p.Payload) {
+			continue
+		}
+		hit := scoredPoint{ID: p.ID, Score: cosine(req.Vector, p.Vector), Payload: p.Payload}
+		if req.WithVector {
+			hit.Vector = p.Vector
+		}
+		hits = append(hits, hit)
+	}
+	sort.Slice(hits, func(i, j int) bool {
+		if hits[i].Score != hits[j].Score {
+			return hits[i].Score > hits[j].Score
+		}
+		return hits[i].ID < hits[j].ID
+	})
+	if req.Limit > 0 && len(hits) > req.Limit {
+		hits = hits[:req.Limit]
+	}
+	writeResult(w, hits)
+}
+
+func scroll(w http.ResponseWriter, r *http.Request, c *collection) {
+	var req struct {
+		Filter json.RawMessage `json:"filter"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "bad scroll body", http.StatusBadRequest)
+		return
+	}
+	f, err := parseFilter(req.Filter)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	points := []map[string]any{}
+	for _, p := range sortedPoints(c) {
+		if f.match(p.Payload) {
+			points = append(points, map[string]any{"id": p.ID, "payload": p.Payload})
+		}
+	}
+	// Everything fits on one page, so there is never a next offset.
+	writeResult(w, map[string]any{"points": points, "next_page_offset": nil})
+}
+
+func count(w http.ResponseWriter, r *http.Request, c *collection) {
+	var req struct {
+		Filter json.RawMessage `json:"filter"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "bad count body", http.StatusBadRequest)
+		return
+	}
+	f, err := parseFilter(req.Filter)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	n := 0
+	for _, p := range c.points {
+		if f.match(p.Payload) {
+			n++
+		}
+	}
+	writeResult(w, map[string]any{"count": n})
+}
+
+func deletePoints(w http.ResponseWriter, r *http.Request, c *collection) {
+	var req struct {
+		Filter json.RawMessage `json:"filter"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || len(req.Filter) == 0 {
+		http.Error(w, "delete needs a filter", http.StatusBadRequest)
+		return
+	}
+	f, err := parseFilter(req.Filter)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	for id, p := range c.points {
+		if f.match(p.Payload) {
+			delete(c.points, id)
+		}
+	}
+	writeResult(w, map[string]any{"status": "completed"})
+}
+
+func sortedPoints(c *collection) []Point {
+	out := make([]Point, 0, len(c.points))
+	for _, p := range c.points {
+		out = append(out, p)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].ID < out[j].ID })
+	return out
+}
+
+func writeResult(w http.ResponseWriter, result any) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{"result": result, "status": "ok"})
+}
+
+func cosine(a, b []float64) float64 {
+	if len(a) != len(b) {
+		return 0
+	}
+	var dot, na, nb float64
+	for i := range a {
+		dot += a[i] * b[i]
+		na += a[i] * a[i]
+		nb += b[i] * b[i]
+	}
+	if na == 0 || nb == 0 {
+		return 0
+	}
+	return dot / (math.Sqrt(na) * math.Sqrt(nb))
+}
+
+// ── Filters ───────────────────────────────────────────────────────────────────
+
+// filter is a decoded Qdrant filter. A nil *filter matches everything.
+type filter struct {
+	Must    []condition `json:"must"`
+	Should  []condition `json:"should"`
+	MustNot []condition `json:"must_not"`
+}
+
+// condition is a field condition, an is_empty condition, or a nested
+// filter, told apart by which fields are set.
+type condition struct {
+	Key   string `json:"key"`
+	Match *struct {
+		Value any   `json:"value"`
+		Any   []any `json:"any"`
+	} `json:"match"`
+	IsEmpty *struct {
+		Key string `json:"key"`
+	} `json:"is_empty"`
+
+	filter
+}
+
+func parseFilter(raw json.RawMessage) (*filter, error) {
+	if len(raw) == 0 || string(raw) == "null" {
+		return nil, nil
+	}
+	var f filter
+	if err := json.Unmarshal(raw, &f); err != nil {
+		return nil, fmt.Errorf("bad filter: %w", err)
+	}
+	return &f, nil
+}
+
+func (f *filter) match(payload map[string]any) bool {
+	if f == nil {
+		return true
+	}
+	for _, c := range f.Must {
+		if !c.match(payload) {
+			return false
+		}
+	}
+	for _, c := range f.MustNot {
+		if c.match(payload) {
+			return false
+		}
+	}
+	if len(f.Should) == 0 {
+		return true
+	}
+	for _, c := range f.Should {
+		if c.match(payload) {
+			return true
+		}
+	}
+	return false
+}
+
+func (c condition) match(payload map[string]any) bool {
+	switch {
+	case c.IsEmpty != nil:
+		v, ok := payload[c.IsEmpty.Key]
+		if !ok || v == nil {
+			return true
+		}
+		arr, isArr := v.([]any)
+		return isArr && len(arr) == 0
+	case c.Key != "" && c.Match != nil:
+		v, ok := payload[c.Key]
+		if !ok {
+			return false
+		}
+		if c.Match.Any != nil {
+			for _, want := range c.Match.Any {
+				if equal(v, want) {
+					return true
+				}
+			}
+			return false
+		}
+		return equal(v, c.Match.Value)
+	default:
+		return c.filter.match(payload)
+	}
+}
+
+// equal compares JSON-decoded values; numbers are all float64.
+func equal(a, b any) bool {
+	return fmt.Sprint(a) == fmt.Sprint(b)
+}
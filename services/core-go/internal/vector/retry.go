@@ -0,0 +1,85 @@
+package vector
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"time"
+)
+
+const (
+	// defaultRetryAttempts is how many times a retryable request is sent
+	// before its last failure is returned. With the default base delay the
+	// waits are 250ms, 500ms and 1s — enough to ride out Qdrant starting a
+	// second or two after the API in a compose stack.
+	defaultRetryAttempts = 4
+
+	defaultRetryBaseDelay = 250 * time.Millisecond
+)
+
+// SetRetryPolicy changes how often a request that failed with a connection
+// error or a 5xx status is retried, and the delay before the first retry
+// (doubled for each one after). attempts counts the first try, so 1
+// disables retries; values <= 0 restore the defaults. Call before the
+// client is shared.
+func (q *QdrantClient) SetRetryPolicy(attempts int, baseDelay time.Duration) {
+	if attempts <= 0 {
+		attempts = defaultRetryAttempts
+	}
+	if baseDelay <= 0 {
+		baseDelay = defaultRetryBaseDelay
+	}
+	q.retryAttempts = attempts
+	q.retryBaseDelay = baseDelay
+}
+
+// doWithRetry sends method endpoint with body (nil for none), retrying
+// transport errors and 5xx responses with exponential backoff. Any other
+// status, 4xx included, is returned on the first attempt for the caller
+// to interpret. After the last attempt the final response or error is
+// returned as-is; a cancelled ctx stops the retries immediately.
+func (q *QdrantClient) doWithRetry(ctx context.Context, method, endpoint string, body []byte) (*http.Response, error) {
+	delay := q.retryBaseDelay
+	for attempt := 1; ; attempt++ {
+		var reader io.Reader
+		if body != nil {
+			reader = bytes.NewReader(body)
+		}
+		req, err := http.NewRequestWithContext(ctx, method, endpoint, reader)
+		if err != nil {
+			return nil, err
+		}
+		if body != nil {
+			req.Header.Set("Content-Type", "application/json")
+		}
+
+		resp, err := q.http.Do(req)
+		if attempt >= q.retryAttempts || !retryable(ctx, resp, err) {
+			return resp, err
+		}
+		if resp != nil {
+			resp.Body.Close()
+		}
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		case <-timer.C:
+		}
+		delay *= 2
+	}
+}
+
+// retryable reports whether a request that produced resp or err is worth
+// sending again: a transport failure (refused connection, reset, timeout)
+// that was not caused by ctx ending, or a 5xx from Qdrant.
+func retryable(ctx context.Context, resp *http.Response, err error) bool {
+	if err != nil {
+		return ctx.Err() == nil && !errors.Is(err, context.Canceled)
+	}
+	return resp.StatusCode >= http.StatusInternalServerError
+}
@@ -0,0 +1,125 @@
+package vector
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httputil"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"core-go/internal/vector/qdranttest"
+)
+
+// flakyServer answers the first fails requests with status and proxies the
+// rest to an in-memory Qdrant.
+type flakyServer struct {
+	mu       sync.Mutex
+	fails    int
+	status   int
+	requests int
+}
+
+func (f *flakyServer) handler(backend string) http.Handler {
+	target, _ := url.Parse(backend)
+	proxy := httputil.NewSingleHostReverseProxy(target)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		f.mu.Lock()
+		f.requests++
+		fail := f.requests <= f.fails
+		f.mu.Unlock()
+		if fail {
+			http.Error(w, "flaky", f.status)
+			return
+		}
+		proxy.ServeHTTP(w, r)
+	})
+}
+
+func TestQdrantRetries(t *testing.T) {
+	const attempts = 4
+	ops := []struct {
+		name string
+		call func(context.Context, *QdrantClient) error
+	}{
+		{"EnsureCollection", func(ctx context.Context, q *QdrantClient) error {
+			return q.EnsureCollection(ctx, "c", 2, DistanceCosine)
+		}},
+		{"UpsertPoints", func(ctx context.Context, q *QdrantClient) error {
+			return q.UpsertPoints(ctx, "c", []PointInput{{ID: NewPointID(), Vector: []float64{1, 0}, Payload: map[string]any{}}})
+		}},
+		{"Search", func(ctx context.Context, q *QdrantClient) error {
+			_, err := q.Search(ctx, "c", []float64{1, 0}, 3, "")
+			return err
+		}},
+	}
+	tests := []struct {
+		name         string
+		fails        int
+		status       int
+		wantErr      bool
+		wantRequests int // requests sent by the call; 0 skips the check
+	}{
+		{"succeeds on the third attempt", 2, http.StatusServiceUnavailable, false, 0},
+		{"gives up after the last attempt", 100, http.StatusInternalServerError, true, attempts},
+		{"4xx fails fast", 100, http.StatusBadRequest, true, 1},
+	}
+	for _, op := range ops {
+		for _, tt := range tests {
+			t.Run(op.name+"/"+tt.name, func(t *testing.T) {
+				backend := qdranttest.NewServer()
+				defer backend.Close()
+				ctx := context.Background()
+				if err := NewQdrantClient(backend.URL).EnsureCollection(ctx, "c", 2, DistanceCosine); err != nil {
+					t.Fatal(err)
+				}
+
+				flaky := &flakyServer{fails: tt.fails, status: tt.status}
+				srv := httptest.NewServer(flaky.handler(backend.URL))
+				defer srv.Close()
+				q := NewQdrantClient(srv.URL)
+				q.SetRetryPolicy(attempts, time.Millisecond)
+
+				err := op.call(ctx, q)
+				if (err != nil) != tt.wantErr {
+					t.Fatalf("%s() err = %v, wantErr %v", op.name, err, tt.wantErr)
+				}
+				if tt.wantRequests != 0 && flaky.requests != tt.wantRequests {
+					t.Errorf("%s() sent %d requests, want %d", op.name, flaky.requests, tt.wantRequests)
+				}
+				if !tt.wantErr && flaky.requests <= tt.fails {
+					t.Errorf("%s() sent %d requests, want more than the %d failures", op.name, flaky.requests, tt.fails)
+				}
+			})
+		}
+	}
+}
+
+func TestQdrantRetriesConnectionRefused(t *testing.T) {
+	tests := []struct {
+		name     string
+		attempts int
+		wantMin  time.Duration // backoff waited before giving up
+	}{
+		{"no retries", 1, 0},
+		{"retried with backoff", 3, 15 * time.Millisecond}, // 5ms then 10ms
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.NotFoundHandler())
+			srv.Close() // nothing listens on the address any more
+			q := NewQdrantClient(srv.URL)
+			q.SetRetryPolicy(tt.attempts, 5*time.Millisecond)
+
+			start := time.Now()
+			if _, err := q.Search(context.Background(), "c", []float64{1, 0}, 3, ""); err == nil {
+				t.Fatal("Search() err = nil, want a connection error")
+			}
+			if waited := time.Since(start); waited < tt.wantMin {
+				t.Errorf("gave up after %v, want at least %v of backoff", waited, tt.wantMin)
+			}
+		})
+	}
+}